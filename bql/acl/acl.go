@@ -0,0 +1,214 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package acl provides per graph access control lists for BQL statements.
+// Each user is granted a permission on each graph. Permissions are ordered:
+// Write implies Read, and Admin, needed to create and drop graphs, implies
+// Write.
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/badwolf/bql/semantic"
+)
+
+// Permission describes the operations a user can run on a graph.
+type Permission uint8
+
+const (
+	// None grants no access to the graph.
+	None Permission = iota
+	// Read allows querying the triples of the graph.
+	Read
+	// Write allows adding and removing triples from the graph.
+	Write
+	// Admin allows creating and dropping the graph.
+	Admin
+)
+
+// String returns the name of the permission.
+func (p Permission) String() string {
+	switch p {
+	case None:
+		return "none"
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	case Admin:
+		return "admin"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParsePermission returns the permission for the provided name.
+func ParsePermission(s string) (Permission, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "none":
+		return None, nil
+	case "read":
+		return Read, nil
+	case "write":
+		return Write, nil
+	case "admin":
+		return Admin, nil
+	default:
+		return None, fmt.Errorf("acl.ParsePermission: unknown permission %q", s)
+	}
+}
+
+// Any matches any user or graph in a rule.
+const Any = "*"
+
+// Rule grants a permission on a graph to a user.
+type Rule struct {
+	User       string
+	Graph      string
+	Permission Permission
+}
+
+// matches returns true if the rule applies to the user and graph.
+func (r *Rule) matches(user, graph string) bool {
+	return (r.User == Any || r.User == user) && (r.Graph == Any || r.Graph == graph)
+}
+
+// ACL contains the rules granting permissions to users. The permission of a
+// user on a graph is the highest permission granted by the rules matching
+// them. A nil ACL grants Admin on every graph to everyone.
+type ACL struct {
+	rules []*Rule
+}
+
+// New returns an ACL with the provided rules.
+func New(rules []*Rule) *ACL {
+	return &ACL{rules: rules}
+}
+
+// Parse reads the ACL rules on the provided reader. Each line contains a
+// user, a graph, and a permission separated by spaces; * matches any user or
+// graph. Empty lines and lines starting with # are ignored. For instance:
+//
+//	alice ?family admin
+//	bob   ?family read
+//	*     ?public read
+func Parse(r io.Reader) (*ACL, error) {
+	var rules []*Rule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fs := strings.Fields(text)
+		if len(fs) != 3 {
+			return nil, fmt.Errorf("acl.Parse: line %d: expected user, graph, and permission; got %q", line, text)
+		}
+		p, err := ParsePermission(fs[2])
+		if err != nil {
+			return nil, fmt.Errorf("acl.Parse: line %d: %v", line, err)
+		}
+		rules = append(rules, &Rule{User: fs[0], Graph: fs[1], Permission: p})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return New(rules), nil
+}
+
+// Permission returns the permission granted to the user on the graph.
+func (a *ACL) Permission(user, graph string) Permission {
+	if a == nil {
+		return Admin
+	}
+	p := None
+	for _, r := range a.rules {
+		if r.matches(user, graph) && r.Permission > p {
+			p = r.Permission
+		}
+	}
+	return p
+}
+
+// Allowed returns true if the user has at least the provided permission on
+// the graph.
+func (a *ACL) Allowed(user, graph string, p Permission) bool {
+	return a.Permission(user, graph) >= p
+}
+
+// Required returns the permission the statement needs on each of the graphs
+// it uses. Queries read their input graphs. Inserts write their output
+// graphs and deletes their input graphs. Constructs and deconstructs read
+// their input graphs and write their output graphs. Creating and dropping
+// graphs requires Admin.
+func Required(stm *semantic.Statement) map[string]Permission {
+	req := make(map[string]Permission)
+	need := func(gs []string, p Permission) {
+		for _, g := range gs {
+			if p > req[g] {
+				req[g] = p
+			}
+		}
+	}
+	switch stm.Type() {
+	case semantic.Query:
+		need(stm.InputGraphNames(), Read)
+	case semantic.Insert:
+		need(stm.OutputGraphNames(), Write)
+	case semantic.Delete:
+		need(stm.InputGraphNames(), Write)
+	case semantic.Construct, semantic.Deconstruct:
+		need(stm.InputGraphNames(), Read)
+		need(stm.OutputGraphNames(), Write)
+	case semantic.Create, semantic.Drop:
+		need(stm.GraphNames(), Admin)
+	}
+	return req
+}
+
+// DeniedError is returned when a user lacks the permission a statement
+// needs on a graph.
+type DeniedError struct {
+	User       string
+	Graph      string
+	Permission Permission
+}
+
+// Error returns the error message.
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("user %q lacks %s permission on graph %s", e.User, e.Permission, e.Graph)
+}
+
+// Check returns a *DeniedError if the user lacks any of the permissions the
+// statement requires. Graphs are checked in order so the error reported is
+// stable.
+func (a *ACL) Check(user string, stm *semantic.Statement) error {
+	req := Required(stm)
+	var gs []string
+	for g := range req {
+		gs = append(gs, g)
+	}
+	sort.Strings(gs)
+	for _, g := range gs {
+		if !a.Allowed(user, g, req[g]) {
+			return &DeniedError{User: user, Graph: g, Permission: req[g]}
+		}
+	}
+	return nil
+}
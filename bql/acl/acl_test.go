@@ -0,0 +1,155 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/badwolf/bql/grammar"
+	"github.com/google/badwolf/bql/semantic"
+)
+
+func parseStatement(t *testing.T, bql string) *semantic.Statement {
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		t.Fatalf("grammar.NewParser: should have produced a valid BQL parser, %v", err)
+	}
+	st := &semantic.Statement{}
+	if err := p.Parse(grammar.NewLLk(bql, 1), st); err != nil {
+		t.Fatalf("Parser.consume: failed to parse query %q with error %v", bql, err)
+	}
+	return st
+}
+
+func TestParse(t *testing.T) {
+	a, err := Parse(strings.NewReader(`
+		# Comments and empty lines are ignored.
+		alice ?family admin
+		bob   ?family read
+		*     ?public read
+		bob   *       write`))
+	if err != nil {
+		t.Fatalf("acl.Parse failed with error %v", err)
+	}
+	table := []struct {
+		user, graph string
+		want        Permission
+	}{
+		{"alice", "?family", Admin},
+		{"alice", "?public", Read},
+		{"alice", "?other", None},
+		{"bob", "?family", Write},
+		{"bob", "?other", Write},
+		{"carol", "?public", Read},
+		{"carol", "?family", None},
+	}
+	for _, entry := range table {
+		if got := a.Permission(entry.user, entry.graph); got != entry.want {
+			t.Errorf("acl.Permission(%q, %q) returned %v; want %v", entry.user, entry.graph, got, entry.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		"alice ?family",
+		"alice ?family owner",
+		"alice ?family read extra",
+	} {
+		if _, err := Parse(strings.NewReader(in)); err == nil {
+			t.Errorf("acl.Parse(%q) should have failed", in)
+		}
+	}
+}
+
+func TestRequired(t *testing.T) {
+	table := []struct {
+		bql  string
+		want map[string]Permission
+	}{
+		{
+			bql:  `select ?s from ?a, ?b where {?s ?p ?o};`,
+			want: map[string]Permission{"?a": Read, "?b": Read},
+		},
+		{
+			bql:  `insert data into ?a {/u<joe> "knows"@[] /u<mary>};`,
+			want: map[string]Permission{"?a": Write},
+		},
+		{
+			bql:  `delete data from ?a {/u<joe> "knows"@[] /u<mary>};`,
+			want: map[string]Permission{"?a": Write},
+		},
+		{
+			bql:  `construct {?s "knows"@[] ?o} into ?b from ?a where {?s "knows"@[] ?o};`,
+			want: map[string]Permission{"?a": Read, "?b": Write},
+		},
+		{
+			bql:  `construct {?s "knows"@[] ?o} into ?a from ?a where {?s "knows"@[] ?o};`,
+			want: map[string]Permission{"?a": Write},
+		},
+		{
+			bql:  `create graph ?a, ?b;`,
+			want: map[string]Permission{"?a": Admin, "?b": Admin},
+		},
+		{
+			bql:  `drop graph ?a;`,
+			want: map[string]Permission{"?a": Admin},
+		},
+		{
+			bql:  `show graphs;`,
+			want: map[string]Permission{},
+		},
+	}
+	for _, entry := range table {
+		if got := Required(parseStatement(t, entry.bql)); !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("acl.Required(%q) returned %v; want %v", entry.bql, got, entry.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	a := New([]*Rule{
+		{User: "alice", Graph: "?a", Permission: Write},
+		{User: "alice", Graph: "?b", Permission: Read},
+	})
+	table := []struct {
+		bql  string
+		want *DeniedError
+	}{
+		{`select ?s from ?a, ?b where {?s ?p ?o};`, nil},
+		{`insert data into ?a {/u<joe> "knows"@[] /u<mary>};`, nil},
+		{`insert data into ?b {/u<joe> "knows"@[] /u<mary>};`, &DeniedError{User: "alice", Graph: "?b", Permission: Write}},
+		{`select ?s from ?c where {?s ?p ?o};`, &DeniedError{User: "alice", Graph: "?c", Permission: Read}},
+		{`drop graph ?a;`, &DeniedError{User: "alice", Graph: "?a", Permission: Admin}},
+	}
+	for _, entry := range table {
+		err := a.Check("alice", parseStatement(t, entry.bql))
+		if entry.want == nil {
+			if err != nil {
+				t.Errorf("acl.Check(%q) failed with error %v", entry.bql, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(err, entry.want) {
+			t.Errorf("acl.Check(%q) returned %v; want %v", entry.bql, err, entry.want)
+		}
+	}
+	var open *ACL
+	if err := open.Check("anyone", parseStatement(t, `drop graph ?a;`)); err != nil {
+		t.Errorf("nil ACL should allow every statement; got %v", err)
+	}
+}
@@ -267,18 +267,40 @@ func BQL() *Grammar {
 					NewSymbol("SUBJECT_EXTRACT"),
 					NewSymbol("PREDICATE"),
 					NewSymbol("OBJECT"),
+					NewSymbol("REIFIED"),
 					NewSymbol("MORE_CLAUSES"),
 				},
 			},
 			{
 				Elements: []Element{
 					NewTokenType(lexer.ItemBinding),
+					NewSymbol("SUBJECT_COVARIANT"),
 					NewSymbol("SUBJECT_EXTRACT"),
 					NewSymbol("PREDICATE"),
 					NewSymbol("OBJECT"),
+					NewSymbol("REIFIED"),
 					NewSymbol("MORE_CLAUSES"),
 				},
 			},
+			{
+				Elements: []Element{
+					NewTokenType(lexer.ItemGraph),
+					NewTokenType(lexer.ItemBinding),
+					NewTokenType(lexer.ItemLBracket),
+					NewSymbol("CLAUSES"),
+					NewTokenType(lexer.ItemRBracket),
+					NewSymbol("MORE_CLAUSES"),
+				},
+			},
+		},
+		"SUBJECT_COVARIANT": []*Clause{
+			{
+				Elements: []Element{
+					NewTokenType(lexer.ItemCovariant),
+					NewTokenType(lexer.ItemNodeType),
+				},
+			},
+			{},
 		},
 		"SUBJECT_EXTRACT": []*Clause{
 			{
@@ -434,6 +456,7 @@ func BQL() *Grammar {
 			{
 				Elements: []Element{
 					NewTokenType(lexer.ItemBinding),
+					NewSymbol("OBJECT_COVARIANT"),
 					NewSymbol("OBJECT_LITERAL_BINDING_AS"),
 					NewSymbol("OBJECT_LITERAL_BINDING_TYPE"),
 					NewSymbol("OBJECT_LITERAL_BINDING_ID"),
@@ -441,6 +464,15 @@ func BQL() *Grammar {
 				},
 			},
 		},
+		"OBJECT_COVARIANT": []*Clause{
+			{
+				Elements: []Element{
+					NewTokenType(lexer.ItemCovariant),
+					NewTokenType(lexer.ItemNodeType),
+				},
+			},
+			{},
+		},
 		"OBJECT_SUBJECT_EXTRACT": []*Clause{
 			{
 				Elements: []Element{
@@ -582,6 +614,16 @@ func BQL() *Grammar {
 			},
 			{},
 		},
+		"REIFIED": []*Clause{
+			{
+				Elements: []Element{
+					NewTokenType(lexer.ItemReified),
+					NewTokenType(lexer.ItemAs),
+					NewTokenType(lexer.ItemBinding),
+				},
+			},
+			{},
+		},
 		"MORE_CLAUSES": []*Clause{
 			{
 				Elements: []Element{
@@ -1004,7 +1046,7 @@ func SemanticBQL() *Grammar {
 	setClauseHook(semanticBQL, clauseSymbols, semantic.WhereNextWorkingClauseHook(), semantic.WhereNextWorkingClauseHook())
 
 	subSymbols := []semantic.Symbol{
-		"CLAUSES", "SUBJECT_EXTRACT", "SUBJECT_TYPE", "SUBJECT_ID",
+		"CLAUSES", "SUBJECT_COVARIANT", "SUBJECT_EXTRACT", "SUBJECT_TYPE", "SUBJECT_ID",
 	}
 	setElementHook(semanticBQL, subSymbols, semantic.WhereSubjectClauseHook(), nil)
 
@@ -1015,7 +1057,7 @@ func SemanticBQL() *Grammar {
 	setElementHook(semanticBQL, predSymbols, semantic.WherePredicateClauseHook(), nil)
 
 	objSymbols := []semantic.Symbol{
-		"OBJECT", "OBJECT_SUBJECT_EXTRACT", "OBJECT_SUBJECT_TYPE", "OBJECT_SUBJECT_ID",
+		"OBJECT", "OBJECT_COVARIANT", "OBJECT_SUBJECT_EXTRACT", "OBJECT_SUBJECT_TYPE", "OBJECT_SUBJECT_ID",
 		"OBJECT_PREDICATE_AS", "OBJECT_PREDICATE_ID", "OBJECT_PREDICATE_AT",
 		"OBJECT_PREDICATE_BOUND_AT", "OBJECT_PREDICATE_BOUND_AT_BINDINGS",
 		"OBJECT_PREDICATE_BOUND_AT_BINDINGS_END", "OBJECT_LITERAL_AS",
@@ -1024,6 +1066,15 @@ func SemanticBQL() *Grammar {
 	}
 	setElementHook(semanticBQL, objSymbols, semantic.WhereObjectClauseHook(), nil)
 
+	// Clauses retrieved from the graph bound by GRAPH blocks.
+	setElementHook(semanticBQL, []semantic.Symbol{"CLAUSES"}, semantic.WhereGraphClauseHook(),
+		func(cls *Clause) bool {
+			return cls.Elements[0].Token() == lexer.ItemGraph
+		})
+
+	// Clauses about reified statements.
+	setElementHook(semanticBQL, []semantic.Symbol{"REIFIED"}, semantic.WhereReifiedClauseHook(), nil)
+
 	// Collect binding variables variables.
 	varSymbols := []semantic.Symbol{
 		"VARS", "VARS_AS", "MORE_VARS", "COUNT_DISTINCT",
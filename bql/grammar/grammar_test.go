@@ -48,6 +48,19 @@ func TestAcceptByParse(t *testing.T) {
 		`select ?a from ?b where{?s ?p ?o as ?x type ?y};`,
 		`select ?a from ?b where{?s ?p ?o as ?x type ?y id ?z};`,
 		`select ?a from ?b where{?s ?p ?o as ?x type ?y id ?z at ?t};`,
+		// Test clause with type covariance.
+		`select ?a from ?b where{?s covariant /foo ?p ?o};`,
+		`select ?a from ?b where{?s covariant /foo as ?x type ?y ?p ?o};`,
+		`select ?a from ?b where{?s ?p ?o covariant /foo/bar};`,
+		`select ?a from ?b where{?s ?p ?o covariant /foo as ?x id ?y};`,
+		`select ?a from ?b where{?s covariant /foo ?p ?o covariant /bar . ?s ?p ?o};`,
+		// Test clauses about reified statements.
+		`select ?a from ?b where{?s ?p ?o reified as ?st};`,
+		`select ?a from ?b where{/u<a> "foo"@[?t] ?o as ?x reified as ?st . ?st ?p ?o};`,
+		`select ?a from ?b where{?s "foo"@[,] "bar"@[] reified as ?st . ?st "baz"@[] ?o reified as ?ost};`,
+		`select ?g from ?a, ?b where{graph ?g {?s ?p ?o}};`,
+		`select ?g from ?a, ?b where{graph ?g {?s ?p ?o . ?o ?q ?x} . graph ?h {?x ?p ?y}};`,
+		`select ?g from ?a, ?b where{?s ?p ?o . graph ?g {/u<a> "foo"@[] ?o reified as ?st}};`,
 		// Test clause with predicate bounds.
 		`select ?a from ?b where{?s "foo"@[,] ?o};`,
 		`select ?a from ?b where{?s "foo"@[,] as ?x id ?y at ?z ?o};`,
@@ -185,6 +198,18 @@ func TestRejectByParse(t *testing.T) {
 		`select ?a from ?b,;`,
 		// Reject empty where clause.
 		`select ?a from ?b where{};`,
+		// Reject type covariance without a type or on non binding subjects.
+		`select ?a from ?b where{?s covariant ?p ?o};`,
+		`select ?a from ?b where{/foo<bar> covariant /foo ?p ?o};`,
+		`select ?a from ?b where{?s ?p "foo"@[] covariant /foo};`,
+		// Reject reified statements without binding.
+		`select ?a from ?b where{?s ?p ?o reified};`,
+		`select ?a from ?b where{?s ?p ?o reified as /u<st>};`,
+		// Reject invalid graph blocks.
+		`select ?a from ?b where{graph {?s ?p ?o}};`,
+		`select ?a from ?b where{graph ?g {}};`,
+		`select ?a from ?b where{graph ?g ?s ?p ?o};`,
+		`select ?a from ?b where{graph ?g {?s ?p ?o} ?s ?p ?o};`,
 		// Reject incomplete empty where clause.
 		`select ?a from ?b where {;`,
 		`select ?a from ?b where };`,
@@ -456,6 +481,8 @@ func TestAcceptQueryBySemanticParse(t *testing.T) {
 		`select ?s from ?g where{/_<foo> as ?s "id"@[?foo, 2016-07-19T13:12:04.669618843-07:00] ?o};`,
 		`select ?s from ?g where{/_<foo> as ?s  ?p "id"@[2015-07-19T13:12:04.669618843-07:00, ?bar] as ?o};`,
 		`select ?s from ?g where{/_<foo> as ?s  ?p "id"@[?foo, ?bar] as ?o};`,
+		// Test type covariance is accepted.
+		`select ?s from ?g where{?s covariant /foo ?p ?o covariant /bar/baz};`,
 		// Test group by acceptance.
 		`select ?s from ?g where{/_<foo> as ?s  ?p "id"@[?foo, ?bar] as ?o} group by ?s;`,
 		`select count(?s) as ?a, sum(?o) as ?b, ?o as ?c from ?g where{?s ?p ?o} group by ?c;`,
@@ -524,6 +551,14 @@ func TestSemanticStatementGraphClausesLengthCorrectness(t *testing.T) {
 			query: `SELECT ?o,?l FROM ?bbacl WHERE { ?o "some_id"@[,] ?x . ?x "some_id"@[,] ?y . ?y "some_id"@[,] ?l } LIMIT "20"^^type:int64;`,
 			want:  3,
 		},
+		{
+			query: `SELECT ?o,?l FROM ?bbacl WHERE { ?o "some_id"@[,] ?x REIFIED AS ?st . ?st "source"@[] ?l };`,
+			want:  4,
+		},
+		{
+			query: `SELECT ?g,?l FROM ?bbacl WHERE { GRAPH ?g { ?o "some_id"@[,] ?x . ?x "some_id"@[,] ?y } . ?y "some_id"@[,] ?l };`,
+			want:  3,
+		},
 	}
 	p, err := NewParser(SemanticBQL())
 	if err != nil {
@@ -0,0 +1,364 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inference
+
+import (
+	"errors"
+	"reflect"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/semantic"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// term is a position of a triple pattern. It either holds a constant or the
+// name of a binding.
+type term struct {
+	value   *triple.Object
+	binding string
+}
+
+// pattern is a triple pattern; its terms describe the subject, the
+// predicate, and the object of the matching triples.
+type pattern [3]term
+
+// bindings maps binding names to their values. Nodes and predicates are
+// boxed as objects so the same binding can join subjects, predicates, and
+// objects.
+type bindings map[string]*triple.Object
+
+// program is the compiled version of a rule whose clauses only contain plain
+// triple patterns. Programs are evaluated directly against the storage
+// lookups, which allows deriving only the consequences of a change instead of
+// running the whole rule again.
+type program struct {
+	where     []pattern
+	construct []pattern
+}
+
+// compile returns the program for the provided rule statement. It returns
+// nil if the statement uses features that programs do not support, such as
+// temporal anchors, aliases, type covariance, or GRAPH blocks, in which case
+// the rule is always run in full by the planner.
+func compile(stm *semantic.Statement) *program {
+	if stm.HasHavingClause() || stm.IsLimitSet() || !reflect.DeepEqual(*stm.GlobalLookupOptions(), storage.LookupOptions{}) {
+		return nil
+	}
+	p := &program{}
+	for _, cls := range stm.GraphPatternClauses() {
+		c := *cls
+		pt := pattern{
+			{binding: c.SBinding},
+			{binding: c.PBinding},
+			{value: c.O, binding: c.OBinding},
+		}
+		if c.S != nil {
+			pt[0].value = triple.NewNodeObject(c.S)
+		}
+		if c.P != nil {
+			pt[1].value = triple.NewPredicateObject(c.P)
+		}
+		c.S, c.SBinding, c.P, c.PBinding, c.O, c.OBinding = nil, "", nil, "", nil, ""
+		if !reflect.DeepEqual(c, semantic.GraphClause{}) || !pt.valid() {
+			return nil
+		}
+		p.where = append(p.where, pt)
+	}
+	for _, cc := range stm.ConstructClauses() {
+		for _, pop := range cc.PredicateObjectPairs() {
+			pt := pattern{
+				{binding: cc.SBinding},
+				{binding: pop.PBinding},
+				{value: pop.O, binding: pop.OBinding},
+			}
+			if cc.S != nil {
+				pt[0].value = triple.NewNodeObject(cc.S)
+			}
+			if pop.P != nil {
+				pt[1].value = triple.NewPredicateObject(pop.P)
+			}
+			c := *pop
+			c.P, c.PBinding, c.O, c.OBinding = nil, "", nil, ""
+			if !reflect.DeepEqual(c, semantic.ConstructPredicateObjectPair{}) || !pt.valid() {
+				return nil
+			}
+			p.construct = append(p.construct, pt)
+		}
+	}
+	if len(p.where) == 0 || len(p.construct) == 0 {
+		return nil
+	}
+	return p
+}
+
+// valid returns true if every term holds either a constant or a binding.
+func (p pattern) valid() bool {
+	for _, t := range p {
+		if (t.value == nil) == (t.binding == "") {
+			return false
+		}
+	}
+	return true
+}
+
+// components returns the subject, predicate, and object of the triple boxed
+// as objects.
+func components(t *triple.Triple) [3]*triple.Object {
+	return [3]*triple.Object{
+		triple.NewNodeObject(t.Subject()),
+		triple.NewPredicateObject(t.Predicate()),
+		t.Object(),
+	}
+}
+
+// resolve returns the values of the pattern terms given the bindings. Unbound
+// terms are nil.
+func (p pattern) resolve(b bindings) [3]*triple.Object {
+	var res [3]*triple.Object
+	for i, t := range p {
+		if res[i] = t.value; res[i] == nil {
+			res[i] = b[t.binding]
+		}
+	}
+	return res
+}
+
+// unify returns the bindings extended with the values the triple provides to
+// the pattern, or false if the triple does not match the pattern.
+func (p pattern) unify(t *triple.Triple, b bindings) (bindings, bool) {
+	res := b
+	for i, v := range components(t) {
+		tm := p[i]
+		if tm.value != nil {
+			if tm.value.String() != v.String() {
+				return nil, false
+			}
+			continue
+		}
+		if bv, ok := res[tm.binding]; ok {
+			if bv.String() != v.String() {
+				return nil, false
+			}
+			continue
+		}
+		if len(res) == len(b) {
+			res = make(bindings, len(b)+3)
+			for k, v := range b {
+				res[k] = v
+			}
+		}
+		res[tm.binding] = v
+	}
+	return res, true
+}
+
+// instantiate returns the triple described by the pattern given the
+// bindings, or false if the bound values do not form a triple.
+func (p pattern) instantiate(b bindings) (*triple.Triple, bool) {
+	vs := p.resolve(b)
+	if vs[0] == nil || vs[1] == nil || vs[2] == nil {
+		return nil, false
+	}
+	s, err := vs[0].Node()
+	if err != nil {
+		return nil, false
+	}
+	pr, err := vs[1].Predicate()
+	if err != nil {
+		return nil, false
+	}
+	t, err := triple.New(s, pr, vs[2])
+	if err != nil {
+		return nil, false
+	}
+	return t, true
+}
+
+// lookup returns the triples of the graph that may match the pattern given
+// the bindings, using the most specific lookup available.
+func (p pattern) lookup(ctx context.Context, g storage.Graph, b bindings) ([]*triple.Triple, error) {
+	vs := p.resolve(b)
+	s, err := nodeOf(vs[0])
+	if err != nil {
+		return nil, nil
+	}
+	pr, err := predicateOf(vs[1])
+	if err != nil {
+		return nil, nil
+	}
+	o, lo := vs[2], storage.DefaultLookup
+	switch {
+	case s != nil && pr != nil && o != nil:
+		t, err := triple.New(s, pr, o)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := g.Exist(ctx, t)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return []*triple.Triple{t}, nil
+	case s != nil && pr != nil:
+		return collect(func(ts chan<- *triple.Triple) error {
+			return g.TriplesForSubjectAndPredicate(ctx, s, pr, lo, ts)
+		})
+	case pr != nil && o != nil:
+		return collect(func(ts chan<- *triple.Triple) error {
+			return g.TriplesForPredicateAndObject(ctx, pr, o, lo, ts)
+		})
+	case s != nil:
+		return collect(func(ts chan<- *triple.Triple) error {
+			return g.TriplesForSubject(ctx, s, lo, ts)
+		})
+	case o != nil:
+		return collect(func(ts chan<- *triple.Triple) error {
+			return g.TriplesForObject(ctx, o, lo, ts)
+		})
+	case pr != nil:
+		return collect(func(ts chan<- *triple.Triple) error {
+			return g.TriplesForPredicate(ctx, pr, lo, ts)
+		})
+	default:
+		return collect(func(ts chan<- *triple.Triple) error {
+			return g.Triples(ctx, lo, ts)
+		})
+	}
+}
+
+// nodeOf returns the node boxed in the object, if any.
+func nodeOf(o *triple.Object) (*node.Node, error) {
+	if o == nil {
+		return nil, nil
+	}
+	return o.Node()
+}
+
+// predicateOf returns the predicate boxed in the object, if any.
+func predicateOf(o *triple.Object) (*predicate.Predicate, error) {
+	if o == nil {
+		return nil, nil
+	}
+	return o.Predicate()
+}
+
+// bound returns the number of terms of the pattern that are constants or
+// bound.
+func (p pattern) bound(b bindings) int {
+	n := 0
+	for _, v := range p.resolve(b) {
+		if v != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// match calls emit for every extension of the bindings satisfying all the
+// patterns in the graph. Patterns are matched starting with the most bound
+// one.
+func match(ctx context.Context, g storage.Graph, ps []pattern, b bindings, emit func(bindings) error) error {
+	if len(ps) == 0 {
+		return emit(b)
+	}
+	idx := 0
+	for i, p := range ps {
+		if p.bound(b) > ps[idx].bound(b) {
+			idx = i
+		}
+	}
+	rest := make([]pattern, 0, len(ps)-1)
+	rest = append(append(rest, ps[:idx]...), ps[idx+1:]...)
+	ts, err := ps[idx].lookup(ctx, g, b)
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		nb, ok := ps[idx].unify(t, b)
+		if !ok {
+			continue
+		}
+		if err := match(ctx, g, rest, nb, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// derive returns the triples derived by the program from bindings in which
+// at least one of the WHERE patterns is matched by a triple of the delta.
+// The delta triples are expected to be in the graph. This is the semi-naive
+// evaluation step: only the consequences of the delta are computed.
+func (p *program) derive(ctx context.Context, g storage.Graph, delta []*triple.Triple) ([]*triple.Triple, error) {
+	var res []*triple.Triple
+	seen := make(map[string]bool)
+	emit := func(b bindings) error {
+		for _, c := range p.construct {
+			t, ok := c.instantiate(b)
+			if !ok {
+				continue
+			}
+			if k := t.UUID().String(); !seen[k] {
+				seen[k] = true
+				res = append(res, t)
+			}
+		}
+		return nil
+	}
+	for _, t := range delta {
+		for i, w := range p.where {
+			b, ok := w.unify(t, bindings{})
+			if !ok {
+				continue
+			}
+			rest := make([]pattern, 0, len(p.where)-1)
+			rest = append(append(rest, p.where[:i]...), p.where[i+1:]...)
+			if err := match(ctx, g, rest, b, emit); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// errDerivable stops the search for a derivation once one is found.
+var errDerivable = errors.New("derivable")
+
+// derivable returns true if the program derives the triple from the
+// current contents of the graph.
+func (p *program) derivable(ctx context.Context, g storage.Graph, t *triple.Triple) (bool, error) {
+	for _, c := range p.construct {
+		b, ok := c.unify(t, bindings{})
+		if !ok {
+			continue
+		}
+		err := match(ctx, g, p.where, b, func(b bindings) error {
+			if d, ok := c.instantiate(b); ok && d.Equal(t) {
+				return errDerivable
+			}
+			return nil
+		})
+		if err == errDerivable {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
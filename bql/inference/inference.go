@@ -0,0 +1,641 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inference provides a forward chaining rule engine for BadWolf
+// graphs. Rules are expressed as BQL CONSTRUCT templates and run to a fixpoint
+// every time the data they depend on changes.
+//
+// Rules whose clauses only contain plain triple patterns are evaluated
+// incrementally. Adding triples only derives the consequences of the added
+// triples and of the triples derived from them (semi-naive evaluation).
+// Removing triples retracts the derived triples that may depend on them and
+// adds back the ones that can still be derived from the remaining triples
+// (delete and rederive). Rules using other BQL features, such as temporal
+// anchors, aliases, or GRAPH blocks, are run again over the whole graph
+// every time the predicate IDs they reference change, and removing a premise
+// of such a rule retracts all the derived triples of the graph and runs all
+// its rules again. Mutations of a graph with rules wait for its rules to run,
+// but graphs do not block each other, and graphs without rules are not
+// affected.
+package inference
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/grammar"
+	"github.com/google/badwolf/bql/planner"
+	"github.com/google/badwolf/bql/semantic"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// scratchGraph is the name of the graph used to collect the output of a rule.
+const scratchGraph = "?__inference_scratch"
+
+// Rule describes a forward chaining rule. A rule is a BQL CONSTRUCT statement
+// without its INTO and FROM sections; those are provided by the graph the rule
+// is attached to.
+type Rule struct {
+	// ID uniquely identifies the rule in a graph.
+	ID string
+	// Construct contains the construct template of the rule, for instance
+	// {?x "ancestor_of"@[] ?z}.
+	Construct string
+	// Where contains the graph pattern the rule matches, for instance
+	// {?x "parent_of"@[] ?y . ?y "ancestor_of"@[] ?z}.
+	Where string
+
+	// Predicate IDs that trigger the rule. If any is set, any predicate does.
+	triggers map[predicate.ID]bool
+	any      bool
+	// prog is the compiled version of the rule, or nil if the rule cannot
+	// be evaluated incrementally.
+	prog *program
+}
+
+// NewRule returns a new rule after checking that it is a valid BQL construct
+// template. Rules can only contain one predicate object pair per construct
+// clause, since reifying blank nodes would never reach a fixpoint.
+func NewRule(id, construct, where string) (*Rule, error) {
+	if id == "" {
+		return nil, fmt.Errorf("inference.NewRule: rules require a non empty ID")
+	}
+	r := &Rule{
+		ID:        id,
+		Construct: construct,
+		Where:     where,
+		triggers:  make(map[predicate.ID]bool),
+	}
+	stm, err := r.statement("?__inference_source")
+	if err != nil {
+		return nil, fmt.Errorf("inference.NewRule(%q): %v", id, err)
+	}
+	for _, cc := range stm.ConstructClauses() {
+		if len(cc.PredicateObjectPairs()) > 1 {
+			return nil, fmt.Errorf("inference.NewRule(%q): construct clauses with more than one predicate object pair are not supported", id)
+		}
+	}
+	for _, cls := range stm.GraphPatternClauses() {
+		switch {
+		case cls.P != nil:
+			r.triggers[cls.P.ID()] = true
+		case cls.PID != "":
+			r.triggers[predicate.ID(cls.PID)] = true
+		default:
+			r.any = true
+		}
+	}
+	r.prog = compile(stm)
+	return r, nil
+}
+
+// String returns the BQL representation of the rule.
+func (r *Rule) String() string {
+	return fmt.Sprintf("CONSTRUCT %s WHERE %s", r.Construct, r.Where)
+}
+
+// statement returns the parsed CONSTRUCT statement for the rule reading from
+// the provided graph into the scratch graph.
+func (r *Rule) statement(graph string) (*semantic.Statement, error) {
+	bql := fmt.Sprintf("construct %s into %s from %s where %s;", r.Construct, scratchGraph, graph, r.Where)
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		return nil, err
+	}
+	stm := &semantic.Statement{}
+	if err := p.Parse(grammar.NewLLk(bql, 1), stm); err != nil {
+		return nil, err
+	}
+	return stm, nil
+}
+
+// triggeredBy returns true if the rule depends on any of the provided
+// predicate IDs.
+func (r *Rule) triggeredBy(ids map[predicate.ID]bool) bool {
+	if r.any && len(ids) > 0 {
+		return true
+	}
+	for id := range ids {
+		if r.triggers[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// graphRules keeps the rules and the derived triples of a graph.
+type graphRules struct {
+	// mu serializes the mutations of the graph and the runs of its rules.
+	mu      sync.Mutex
+	rules   []*Rule
+	derived map[string]*triple.Triple
+}
+
+// Store wraps a storage.Store and keeps the triples derived by the rules
+// attached to its graphs up to date. Derived triples are stored in the same
+// graph as the data they were derived from, but they are tracked separately
+// so they can be retracted when their premises are removed.
+type Store struct {
+	storage.Store
+
+	// mu only guards graphs; rules run holding the lock of their graph.
+	mu       sync.RWMutex
+	graphs   map[string]*graphRules
+	chanSize int
+	bulkSize int
+}
+
+// NewStore returns a new rule aware store wrapping the provided one.
+func NewStore(s storage.Store, chanSize, bulkSize int) *Store {
+	return &Store{
+		Store:    s,
+		graphs:   make(map[string]*graphRules),
+		chanSize: chanSize,
+		bulkSize: bulkSize,
+	}
+}
+
+// wrap returns the version of the graph keeping its derived triples up to
+// date.
+func (s *Store) wrap(g storage.Graph) storage.Graph {
+	ig := &graph{Graph: g, s: s}
+	if tg, ok := g.(storage.TypeGraph); ok {
+		return &typeGraph{graph: ig, tg: tg}
+	}
+	return ig
+}
+
+// NewGraph creates a new graph.
+func (s *Store) NewGraph(ctx context.Context, id string) (storage.Graph, error) {
+	g, err := s.Store.NewGraph(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(g), nil
+}
+
+// Graph returns an existing graph if available.
+func (s *Store) Graph(ctx context.Context, id string) (storage.Graph, error) {
+	g, err := s.Store.Graph(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(g), nil
+}
+
+// DeleteGraph deletes an existing graph and all the rules attached to it.
+func (s *Store) DeleteGraph(ctx context.Context, id string) error {
+	return s.mutate(id, func(gr *graphRules) error {
+		if err := s.Store.DeleteGraph(ctx, id); err != nil {
+			return err
+		}
+		if gr != nil {
+			s.mu.Lock()
+			delete(s.graphs, id)
+			s.mu.Unlock()
+		}
+		return nil
+	})
+}
+
+// rules returns the rules of the provided graph, creating an empty set if
+// the graph has none.
+func (s *Store) rules(id string) *graphRules {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gr, ok := s.graphs[id]
+	if !ok {
+		gr = &graphRules{derived: make(map[string]*triple.Triple)}
+		s.graphs[id] = gr
+	}
+	return gr
+}
+
+// lookup returns the rules of the provided graph if it has any.
+func (s *Store) lookup(id string) (*graphRules, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	gr, ok := s.graphs[id]
+	return gr, ok
+}
+
+// mutate calls f with the rules of the provided graph, or nil if the graph
+// has no rules. Graphs with rules are mutated holding their lock. Graphs
+// without rules are mutated holding a read lock on the store instead, so
+// rules cannot be attached to them while they are mutated.
+func (s *Store) mutate(id string, f func(*graphRules) error) error {
+	s.mu.RLock()
+	gr, ok := s.graphs[id]
+	if !ok {
+		defer s.mu.RUnlock()
+		return f(nil)
+	}
+	s.mu.RUnlock()
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	return f(gr)
+}
+
+// AddRule attaches the rule to the provided graph and runs all the graph
+// rules to a fixpoint.
+func (s *Store) AddRule(ctx context.Context, id string, r *Rule) error {
+	g, err := s.Store.Graph(ctx, id)
+	if err != nil {
+		return err
+	}
+	gr := s.rules(id)
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	for _, or := range gr.rules {
+		if or.ID == r.ID {
+			return fmt.Errorf("inference.AddRule: rule %q already exists in graph %q", r.ID, id)
+		}
+	}
+	gr.rules = append(gr.rules, r)
+	return s.run(ctx, g, gr, []*Rule{r})
+}
+
+// RemoveRule detaches the rule from the provided graph. All derived triples
+// are retracted and the remaining rules are run again to a fixpoint.
+func (s *Store) RemoveRule(ctx context.Context, id, ruleID string) error {
+	g, err := s.Store.Graph(ctx, id)
+	if err != nil {
+		return err
+	}
+	gr := s.rules(id)
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	for i, r := range gr.rules {
+		if r.ID == ruleID {
+			gr.rules = append(gr.rules[:i], gr.rules[i+1:]...)
+			return s.recompute(ctx, g, gr)
+		}
+	}
+	return fmt.Errorf("inference.RemoveRule: rule %q does not exist in graph %q", ruleID, id)
+}
+
+// Rules returns the rules attached to the provided graph.
+func (s *Store) Rules(id string) []*Rule {
+	gr, ok := s.lookup(id)
+	if !ok {
+		return nil
+	}
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	return append([]*Rule{}, gr.rules...)
+}
+
+// Derived returns the triples currently derived by the rules of the provided
+// graph.
+func (s *Store) Derived(id string) []*triple.Triple {
+	gr, ok := s.lookup(id)
+	if !ok {
+		return nil
+	}
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	var res []*triple.Triple
+	for _, t := range gr.derived {
+		res = append(res, t)
+	}
+	return res
+}
+
+// IsDerived returns true if the provided triple was derived by the rules of
+// the provided graph.
+func (s *Store) IsDerived(id string, t *triple.Triple) bool {
+	gr, ok := s.lookup(id)
+	if !ok {
+		return false
+	}
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	_, ok = gr.derived[t.UUID().String()]
+	return ok
+}
+
+// recompute retracts all the derived triples and runs all the rules again to
+// a fixpoint.
+func (s *Store) recompute(ctx context.Context, g storage.Graph, gr *graphRules) error {
+	var ts []*triple.Triple
+	for _, t := range gr.derived {
+		ts = append(ts, t)
+	}
+	if len(ts) > 0 {
+		if err := g.RemoveTriples(ctx, ts); err != nil {
+			return err
+		}
+	}
+	gr.derived = make(map[string]*triple.Triple)
+	return s.run(ctx, g, gr, gr.rules)
+}
+
+// run runs the provided rules in full against the graph and propagates the
+// triples they derive.
+func (s *Store) run(ctx context.Context, g storage.Graph, gr *graphRules, rules []*Rule) error {
+	var delta []*triple.Triple
+	for _, r := range rules {
+		ts, err := s.apply(ctx, g, r)
+		if err != nil {
+			return err
+		}
+		nts, err := s.add(ctx, g, gr, ts)
+		if err != nil {
+			return err
+		}
+		delta = append(delta, nts...)
+	}
+	return s.propagate(ctx, g, gr, delta)
+}
+
+// propagate runs the rules triggered by the delta triples, which are
+// expected to be in the graph, until no new triples are derived. Compiled
+// rules only derive the consequences of the delta; other rules are run in
+// full.
+func (s *Store) propagate(ctx context.Context, g storage.Graph, gr *graphRules, delta []*triple.Triple) error {
+	for len(delta) > 0 {
+		ids := make(map[predicate.ID]bool)
+		for _, t := range delta {
+			ids[t.Predicate().ID()] = true
+		}
+		var next []*triple.Triple
+		for _, r := range triggered(gr.rules, ids) {
+			var (
+				ts  []*triple.Triple
+				err error
+			)
+			if r.prog != nil {
+				ts, err = r.prog.derive(ctx, g, delta)
+			} else {
+				ts, err = s.apply(ctx, g, r)
+			}
+			if err != nil {
+				return err
+			}
+			nts, err := s.add(ctx, g, gr, ts)
+			if err != nil {
+				return err
+			}
+			next = append(next, nts...)
+		}
+		delta = next
+	}
+	return nil
+}
+
+// add adds the derived triples that are not in the graph yet and returns
+// them.
+func (s *Store) add(ctx context.Context, g storage.Graph, gr *graphRules, ts []*triple.Triple) ([]*triple.Triple, error) {
+	var nts []*triple.Triple
+	seen := make(map[string]bool)
+	for _, t := range ts {
+		k := t.UUID().String()
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		b, err := g.Exist(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			continue
+		}
+		nts = append(nts, t)
+		gr.derived[k] = t
+	}
+	if len(nts) > 0 {
+		if err := g.AddTriples(ctx, nts); err != nil {
+			return nil, err
+		}
+	}
+	return nts, nil
+}
+
+// remove removes the triples from the graph and retracts the triples derived
+// from them. If all the rules of the graph are compiled, the derived triples
+// that may depend on the removed ones are retracted, and then the removed
+// and retracted triples that can still be derived from the remaining ones
+// are added back. Otherwise, all the derived triples are retracted and the
+// rules are run again.
+func (s *Store) remove(ctx context.Context, g storage.Graph, gr *graphRules, ts []*triple.Triple) error {
+	ids := make(map[predicate.ID]bool)
+	for _, t := range ts {
+		ids[t.Predicate().ID()] = true
+	}
+	incremental := true
+	for _, r := range gr.rules {
+		incremental = incremental && r.prog != nil
+	}
+	if len(triggered(gr.rules, ids)) == 0 || !incremental {
+		if err := g.RemoveTriples(ctx, ts); err != nil {
+			return err
+		}
+		for _, t := range ts {
+			delete(gr.derived, t.UUID().String())
+		}
+		if len(triggered(gr.rules, ids)) == 0 {
+			return nil
+		}
+		return s.recompute(ctx, g, gr)
+	}
+
+	// Collect the derived triples that may depend on the removed ones while
+	// they are still in the graph.
+	candidates, rm := make(map[string]*triple.Triple), append([]*triple.Triple{}, ts...)
+	for _, t := range ts {
+		candidates[t.UUID().String()] = t
+	}
+	for delta := ts; len(delta) > 0; {
+		ids := make(map[predicate.ID]bool)
+		for _, t := range delta {
+			ids[t.Predicate().ID()] = true
+		}
+		var next []*triple.Triple
+		for _, r := range triggered(gr.rules, ids) {
+			ds, err := r.prog.derive(ctx, g, delta)
+			if err != nil {
+				return err
+			}
+			for _, d := range ds {
+				k := d.UUID().String()
+				if _, ok := gr.derived[k]; !ok || candidates[k] != nil {
+					continue
+				}
+				candidates[k] = d
+				rm = append(rm, d)
+				next = append(next, d)
+			}
+		}
+		delta = next
+	}
+	if err := g.RemoveTriples(ctx, rm); err != nil {
+		return err
+	}
+	for k := range candidates {
+		delete(gr.derived, k)
+	}
+
+	// Add back the triples that can still be derived.
+	var back []*triple.Triple
+	for _, t := range rm {
+		for _, r := range gr.rules {
+			ok, err := r.prog.derivable(ctx, g, t)
+			if err != nil {
+				return err
+			}
+			if ok {
+				back = append(back, t)
+				break
+			}
+		}
+	}
+	nts, err := s.add(ctx, g, gr, back)
+	if err != nil {
+		return err
+	}
+	return s.propagate(ctx, g, gr, nts)
+}
+
+// apply runs the rule against the graph and returns the constructed triples.
+func (s *Store) apply(ctx context.Context, g storage.Graph, r *Rule) ([]*triple.Triple, error) {
+	stm, err := r.statement(g.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	sg, err := memory.NewStore().NewGraph(ctx, scratchGraph)
+	if err != nil {
+		return nil, err
+	}
+	pln, err := planner.New(ctx, &scratchStore{Store: s.Store, g: sg}, stm, s.chanSize, s.bulkSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := pln.Execute(ctx); err != nil {
+		return nil, fmt.Errorf("inference: rule %q failed with error %v", r.ID, err)
+	}
+	return collect(func(ts chan<- *triple.Triple) error {
+		return sg.Triples(ctx, storage.DefaultLookup, ts)
+	})
+}
+
+// triggered returns the rules that depend on the provided predicate IDs.
+func triggered(rules []*Rule, ids map[predicate.ID]bool) []*Rule {
+	var res []*Rule
+	for _, r := range rules {
+		if r.triggeredBy(ids) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// collect returns all the triples pushed by the provided lookup.
+func collect(lookup func(chan<- *triple.Triple) error) ([]*triple.Triple, error) {
+	var (
+		err error
+		wg  sync.WaitGroup
+		res []*triple.Triple
+	)
+	ts := make(chan *triple.Triple)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err = lookup(ts)
+	}()
+	for t := range ts {
+		res = append(res, t)
+	}
+	wg.Wait()
+	return res, err
+}
+
+// scratchStore resolves the scratch graph to a volatile graph and any other
+// graph to the wrapped store.
+type scratchStore struct {
+	storage.Store
+	g storage.Graph
+}
+
+// Graph returns the scratch graph or an existing graph of the wrapped store.
+func (s *scratchStore) Graph(ctx context.Context, id string) (storage.Graph, error) {
+	if id == scratchGraph {
+		return s.g, nil
+	}
+	return s.Store.Graph(ctx, id)
+}
+
+// graph wraps a storage.Graph and keeps its derived triples up to date on
+// every mutation.
+type graph struct {
+	storage.Graph
+	s *Store
+}
+
+// Unwrap returns the wrapped graph.
+func (g *graph) Unwrap() storage.Graph {
+	return g.Graph
+}
+
+// AddTriples adds the triples to the graph and derives their consequences.
+func (g *graph) AddTriples(ctx context.Context, ts []*triple.Triple) error {
+	return g.s.mutate(g.ID(ctx), func(gr *graphRules) error {
+		if err := g.Graph.AddTriples(ctx, ts); err != nil {
+			return err
+		}
+		if gr == nil {
+			return nil
+		}
+		for _, t := range ts {
+			// Explicitly added triples are not derived anymore.
+			delete(gr.derived, t.UUID().String())
+		}
+		return g.s.propagate(ctx, g.Graph, gr, ts)
+	})
+}
+
+// RemoveTriples removes the triples from the graph and retracts the triples
+// that cannot be derived anymore.
+func (g *graph) RemoveTriples(ctx context.Context, ts []*triple.Triple) error {
+	return g.s.mutate(g.ID(ctx), func(gr *graphRules) error {
+		if gr == nil {
+			return g.Graph.RemoveTriples(ctx, ts)
+		}
+		return g.s.remove(ctx, g.Graph, gr, ts)
+	})
+}
+
+// typeGraph wraps graphs that also provide the type index lookups.
+type typeGraph struct {
+	*graph
+	tg storage.TypeGraph
+}
+
+// TriplesForSubjectType pushes to the provided channel all triples whose
+// subject type is covariant with the provided type.
+func (g *typeGraph) TriplesForSubjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	return g.tg.TriplesForSubjectType(ctx, t, lo, trpls)
+}
+
+// TriplesForObjectType pushes to the provided channel all triples whose
+// object is a node with a type covariant with the provided type.
+func (g *typeGraph) TriplesForObjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	return g.tg.TriplesForObjectType(ctx, t, lo, trpls)
+}
@@ -0,0 +1,392 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inference
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/metrics"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func createTriples(t *testing.T, ss ...string) []*triple.Triple {
+	ts := []*triple.Triple{}
+	for _, s := range ss {
+		trpl, err := triple.Parse(s, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", s, err)
+		}
+		ts = append(ts, trpl)
+	}
+	return ts
+}
+
+func countPredicate(ctx context.Context, t *testing.T, g storage.Graph, id string) int {
+	ts, err := collect(func(ts chan<- *triple.Triple) error {
+		return g.Triples(ctx, storage.DefaultLookup, ts)
+	})
+	if err != nil {
+		t.Fatalf("g.Triples failed with error %v", err)
+	}
+	cnt := 0
+	for _, trpl := range ts {
+		if string(trpl.Predicate().ID()) == id {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func ancestorRules(t *testing.T) []*Rule {
+	base, err := NewRule("base", `{?x "ancestor_of"@[] ?y}`, `{?x "parent_of"@[] ?y}`)
+	if err != nil {
+		t.Fatalf("inference.NewRule failed to create a valid rule with error %v", err)
+	}
+	trans, err := NewRule("transitive", `{?x "ancestor_of"@[] ?z}`, `{?x "parent_of"@[] ?y . ?y "ancestor_of"@[] ?z}`)
+	if err != nil {
+		t.Fatalf("inference.NewRule failed to create a valid rule with error %v", err)
+	}
+	return []*Rule{base, trans}
+}
+
+func TestNewRule(t *testing.T) {
+	table := []struct {
+		construct, where string
+		valid            bool
+	}{
+		{`{?x "ancestor_of"@[] ?y}`, `{?x "parent_of"@[] ?y}`, true},
+		{`{?x ?p ?y}`, `{?x ?p ?y}`, true},
+		{`{?x "ancestor_of"@[] ?y; "since"@[] ?z}`, `{?x "parent_of"@[] ?y . ?y "born"@[] ?z}`, false},
+		{`{?x "ancestor_of"@[] ?y}`, `{}`, false},
+		{`{?x "ancestor_of"@[]}`, `{?x "parent_of"@[] ?y}`, false},
+	}
+	for _, entry := range table {
+		_, err := NewRule("test", entry.construct, entry.where)
+		if got, want := err == nil, entry.valid; got != want {
+			t.Errorf("inference.NewRule(%q, %q) returned error %v; want valid %v", entry.construct, entry.where, err, want)
+		}
+	}
+}
+
+func TestForwardChaining(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewStore(), 0, 10)
+	g, err := s.NewGraph(ctx, "?family")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	if err := g.AddTriples(ctx, createTriples(t,
+		`/u<a> "parent_of"@[] /u<b>`,
+		`/u<b> "parent_of"@[] /u<c>`,
+		`/u<c> "parent_of"@[] /u<d>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	for _, r := range ancestorRules(t) {
+		if err := s.AddRule(ctx, "?family", r); err != nil {
+			t.Fatalf("s.AddRule(%v) failed with error %v", r, err)
+		}
+	}
+	if got, want := countPredicate(ctx, t, g, "ancestor_of"), 6; got != want {
+		t.Errorf("rules failed to reach the fixpoint; got %d ancestors, want %d", got, want)
+	}
+	if got, want := len(s.Derived("?family")), 6; got != want {
+		t.Errorf("s.Derived returned the wrong number of derived triples; got %d, want %d", got, want)
+	}
+
+	// Adding matching data should derive new triples incrementally.
+	if err := g.AddTriples(ctx, createTriples(t, `/u<d> "parent_of"@[] /u<e>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	if got, want := countPredicate(ctx, t, g, "ancestor_of"), 10; got != want {
+		t.Errorf("rules failed to run incrementally; got %d ancestors, want %d", got, want)
+	}
+
+	// Explicitly asserted triples are not derived anymore and survive
+	// retraction.
+	explicit := createTriples(t, `/u<a> "ancestor_of"@[] /u<c>`)
+	if err := g.AddTriples(ctx, explicit); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	if s.IsDerived("?family", explicit[0]) {
+		t.Errorf("s.IsDerived(%v) should be false for explicitly added triples", explicit[0])
+	}
+
+	// Removing a premise retracts the triples derived from it.
+	if err := g.RemoveTriples(ctx, createTriples(t, `/u<b> "parent_of"@[] /u<c>`)); err != nil {
+		t.Fatalf("g.RemoveTriples failed with error %v", err)
+	}
+	// a->b, c->d, c->e, d->e, plus the explicit a->c.
+	if got, want := countPredicate(ctx, t, g, "ancestor_of"), 5; got != want {
+		t.Errorf("rules failed to retract derived triples; got %d ancestors, want %d", got, want)
+	}
+
+	// Removing a rule retracts everything it derived.
+	if err := s.RemoveRule(ctx, "?family", "transitive"); err != nil {
+		t.Fatalf("s.RemoveRule failed with error %v", err)
+	}
+	// a->b, c->d, d->e, plus the explicit a->c.
+	if got, want := countPredicate(ctx, t, g, "ancestor_of"), 4; got != want {
+		t.Errorf("s.RemoveRule failed to retract derived triples; got %d ancestors, want %d", got, want)
+	}
+	if got, want := len(s.Rules("?family")), 1; got != want {
+		t.Errorf("s.Rules returned the wrong number of rules; got %d, want %d", got, want)
+	}
+}
+
+func TestAddRuleErrors(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewStore(), 0, 10)
+	rs := ancestorRules(t)
+	if err := s.AddRule(ctx, "?missing", rs[0]); err == nil {
+		t.Errorf("s.AddRule should fail for graphs that do not exist")
+	}
+	if _, err := s.NewGraph(ctx, "?g"); err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	if err := s.AddRule(ctx, "?g", rs[0]); err != nil {
+		t.Errorf("s.AddRule failed with error %v", err)
+	}
+	if err := s.AddRule(ctx, "?g", rs[0]); err == nil {
+		t.Errorf("s.AddRule should reject duplicated rule IDs")
+	}
+	if err := s.RemoveRule(ctx, "?g", "unknown"); err == nil {
+		t.Errorf("s.RemoveRule should fail for unknown rules")
+	}
+}
+
+func TestGraphsDoNotBlockEachOther(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewStore(), 0, 10)
+	if _, err := s.NewGraph(ctx, "?busy"); err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	g, err := s.NewGraph(ctx, "?family")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	for _, r := range ancestorRules(t) {
+		if err := s.AddRule(ctx, "?family", r); err != nil {
+			t.Fatalf("s.AddRule(%v) failed with error %v", r, err)
+		}
+	}
+
+	// Simulate rules running on another graph.
+	busy := s.rules("?busy")
+	busy.mu.Lock()
+	defer busy.mu.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		done <- g.AddTriples(ctx, createTriples(t, `/u<a> "parent_of"@[] /u<b>`))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("g.AddTriples failed with error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("g.AddTriples should not wait for the rules of other graphs")
+	}
+	if got, want := len(s.Derived("?family")), 1; got != want {
+		t.Errorf("s.Derived returned the wrong number of derived triples; got %d, want %d", got, want)
+	}
+	if s.Rules("?missing") != nil || s.Derived("?missing") != nil {
+		t.Errorf("graphs without rules should have no rules or derived triples")
+	}
+	plain, err := s.NewGraph(ctx, "?plain")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	if err := plain.AddTriples(ctx, createTriples(t, `/u<a> "parent_of"@[] /u<b>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	if _, ok := s.lookup("?plain"); ok {
+		t.Errorf("mutating a graph without rules should not attach an empty rule set to it")
+	}
+}
+
+func TestTypeGraphIsPreserved(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewStore(), 0, 10)
+	g, err := s.NewGraph(ctx, "?family")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	tg, ok := g.(storage.TypeGraph)
+	if !ok {
+		t.Fatalf("rule aware graphs wrapping memory graphs should implement storage.TypeGraph")
+	}
+	for _, r := range ancestorRules(t) {
+		if err := s.AddRule(ctx, "?family", r); err != nil {
+			t.Fatalf("s.AddRule(%v) failed with error %v", r, err)
+		}
+	}
+	ts := createTriples(t, `/u<a> "parent_of"@[] /u<b>`)
+	if err := tg.AddTriples(ctx, ts); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	trpls := make(chan *triple.Triple, 10)
+	if err := tg.TriplesForSubjectType(ctx, ts[0].Subject().Type(), storage.DefaultLookup, trpls); err != nil {
+		t.Fatalf("g.TriplesForSubjectType failed with error %v", err)
+	}
+	// The added triple and the one derived from it.
+	if got := len(trpls); got != 2 {
+		t.Errorf("g.TriplesForSubjectType returned %d triples; want 2", got)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	table := []struct {
+		construct, where string
+		compiled         bool
+	}{
+		{`{?x "ancestor_of"@[] ?y}`, `{?x "parent_of"@[] ?y}`, true},
+		{`{?x ?p ?y}`, `{?y ?p ?x}`, true},
+		{`{/u<root> "has"@[] ?y}`, `{/u<root> "parent_of"@[] ?x . ?x "parent_of"@[] ?y}`, true},
+		{`{?x "ancestor_of"@[] ?y}`, `{?x "parent_of"@[?t] ?y}`, false},
+		{`{?x "ancestor_of"@[] ?y}`, `{?x "parent_of"@[] ?y TYPE ?t}`, false},
+	}
+	for _, entry := range table {
+		r, err := NewRule("test", entry.construct, entry.where)
+		if err != nil {
+			t.Fatalf("inference.NewRule(%q, %q) failed with error %v", entry.construct, entry.where, err)
+		}
+		if got, want := r.prog != nil, entry.compiled; got != want {
+			t.Errorf("inference.NewRule(%q, %q) compiled %v; want %v", entry.construct, entry.where, got, want)
+		}
+	}
+}
+
+// contents returns the sorted triples of the graph.
+func contents(ctx context.Context, t *testing.T, g storage.Graph) []string {
+	ts, err := collect(func(ts chan<- *triple.Triple) error {
+		return g.Triples(ctx, storage.DefaultLookup, ts)
+	})
+	if err != nil {
+		t.Fatalf("g.Triples failed with error %v", err)
+	}
+	var res []string
+	for _, trpl := range ts {
+		res = append(res, trpl.String())
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestIncrementalMatchesFullEvaluation(t *testing.T) {
+	ctx := context.Background()
+	symmetric, err := NewRule("symmetric", `{?y "related_to"@[] ?x}`, `{?x "related_to"@[] ?y}`)
+	if err != nil {
+		t.Fatalf("inference.NewRule failed to create a valid rule with error %v", err)
+	}
+	related, err := NewRule("related", `{?x "related_to"@[] ?y}`, `{?x "ancestor_of"@[] ?y}`)
+	if err != nil {
+		t.Fatalf("inference.NewRule failed to create a valid rule with error %v", err)
+	}
+	rules := append(ancestorRules(t), symmetric, related)
+	var gs []storage.Graph
+	for _, compiled := range []bool{true, false} {
+		s := NewStore(memory.NewStore(), 0, 10)
+		g, err := s.NewGraph(ctx, "?family")
+		if err != nil {
+			t.Fatalf("s.NewGraph failed with error %v", err)
+		}
+		for _, r := range rules {
+			if !compiled {
+				nr := *r
+				nr.prog, r = nil, &nr
+			}
+			if err := s.AddRule(ctx, "?family", r); err != nil {
+				t.Fatalf("s.AddRule(%v) failed with error %v", r, err)
+			}
+		}
+		gs = append(gs, g)
+	}
+
+	// The parent relation contains a cycle, so some triples have more than
+	// one derivation.
+	steps := []struct {
+		add    bool
+		triple string
+	}{
+		{true, `/u<a> "parent_of"@[] /u<b>`},
+		{true, `/u<b> "parent_of"@[] /u<c>`},
+		{true, `/u<c> "parent_of"@[] /u<a>`},
+		{true, `/u<c> "parent_of"@[] /u<d>`},
+		{true, `/u<a> "ancestor_of"@[] /u<d>`},
+		{false, `/u<b> "parent_of"@[] /u<c>`},
+		{false, `/u<a> "ancestor_of"@[] /u<d>`},
+		{false, `/u<c> "ancestor_of"@[] /u<a>`},
+		{true, `/u<b> "parent_of"@[] /u<c>`},
+		{false, `/u<c> "parent_of"@[] /u<a>`},
+		{false, `/u<c> "related_to"@[] /u<a>`},
+		{false, `/u<a> "parent_of"@[] /u<b>`},
+	}
+	for _, step := range steps {
+		ts := createTriples(t, step.triple)
+		for _, g := range gs {
+			if step.add {
+				err = g.AddTriples(ctx, ts)
+			} else {
+				err = g.RemoveTriples(ctx, ts)
+			}
+			if err != nil {
+				t.Fatalf("mutating the graph with %v failed with error %v", ts, err)
+			}
+		}
+		if got, want := contents(ctx, t, gs[0]), contents(ctx, t, gs[1]); !reflect.DeepEqual(got, want) {
+			t.Errorf("after %v %s the incrementally evaluated graph contains\n%v\nwant\n%v", map[bool]string{true: "adding", false: "removing"}[step.add], step.triple, got, want)
+		}
+	}
+}
+
+func TestIncrementalEvaluationDoesNotScan(t *testing.T) {
+	ctx, m := context.Background(), metrics.New()
+	s := NewStore(metrics.NewStore(memory.NewStore(), m), 0, 10)
+	g, err := s.NewGraph(ctx, "?family")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	for _, r := range ancestorRules(t) {
+		if err := s.AddRule(ctx, "?family", r); err != nil {
+			t.Fatalf("s.AddRule(%v) failed with error %v", r, err)
+		}
+	}
+	scans := func() uint64 {
+		return m.Calls("TriplesForPredicate") + m.Calls("Triples")
+	}
+	before := scans()
+	if err := g.AddTriples(ctx, createTriples(t,
+		`/u<a> "parent_of"@[] /u<b>`,
+		`/u<b> "parent_of"@[] /u<c>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	if err := g.RemoveTriples(ctx, createTriples(t, `/u<a> "parent_of"@[] /u<b>`)); err != nil {
+		t.Fatalf("g.RemoveTriples failed with error %v", err)
+	}
+	if got := scans() - before; got != 0 {
+		t.Errorf("mutating a graph with compiled rules scanned %d predicates or graphs; want 0", got)
+	}
+	if got, want := countPredicate(ctx, t, g, "ancestor_of"), 1; got != want {
+		t.Errorf("rules failed to reach the fixpoint; got %d ancestors, want %d", got, want)
+	}
+}
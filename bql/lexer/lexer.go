@@ -135,6 +135,12 @@ const (
 	ItemShow
 	// ItemGraphs represent the graphs keyword.
 	ItemGraphs
+	// ItemCovariant represents the covariant keyword in BQL.
+	ItemCovariant
+	// ItemNodeType represents a BadWolf node type in BQL.
+	ItemNodeType
+	// ItemReified represents the reified keyword in BQL.
+	ItemReified
 )
 
 func (tt TokenType) String() string {
@@ -245,6 +251,12 @@ func (tt TokenType) String() string {
 		return "SHOW"
 	case ItemGraphs:
 		return "GRAPHS"
+	case ItemCovariant:
+		return "COVARIANT"
+	case ItemNodeType:
+		return "NODE_TYPE"
+	case ItemReified:
+		return "REIFIED"
 	default:
 		return "UNKNOWN"
 	}
@@ -252,69 +264,71 @@ func (tt TokenType) String() string {
 
 // Text constants that represent primitive types.
 const (
-	eof            = rune(-1)
-	binding        = rune('?')
-	leftBracket    = rune('{')
-	rightBracket   = rune('}')
-	leftPar        = rune('(')
-	rightPar       = rune(')')
-	rightSquarePar = rune(']')
-	dot            = rune('.')
-	colon          = rune(':')
-	semicolon      = rune(';')
-	comma          = rune(',')
-	slash          = rune('/')
-	underscore     = rune('_')
-	backSlash      = rune('\\')
-	lt             = rune('<')
-	gt             = rune('>')
-	eq             = rune('=')
-	quote          = rune('"')
-	hat            = rune('^')
-	at             = rune('@')
-	newLine        = rune('\n')
-	query          = "select"
-	insert         = "insert"
-	delete         = "delete"
-	create         = "create"
-	construct      = "construct"
-	deconstruct    = "deconstruct"
-	drop           = "drop"
-	graph          = "graph"
-	data           = "data"
-	into           = "into"
-	from           = "from"
-	where          = "where"
-	as             = "as"
-	before         = "before"
-	after          = "after"
-	between        = "between"
-	count          = "count"
-	distinct       = "distinct"
-	sum            = "sum"
-	group          = "group"
-	having         = "having"
-	by             = "by"
-	order          = "order"
-	asc            = "asc"
-	desc           = "desc"
-	limit          = "limit"
-	not            = "not"
-	and            = "and"
-	or             = "or"
-	id             = "id"
-	typeKeyword    = "type"
-	atKeyword      = "at"
-	inKeyword      = "in"
-	showKeyword    = "show"
-	graphsKeyword  = "graphs"
-	anchor         = "\"@["
-	literalType    = "\"^^type:"
-	literalBool    = "bool"
-	literalInt     = "int64"
-	literalFloat   = "float64"
-	literalText    = "text"
-	literalBlob    = "blob"
+	eof              = rune(-1)
+	binding          = rune('?')
+	leftBracket      = rune('{')
+	rightBracket     = rune('}')
+	leftPar          = rune('(')
+	rightPar         = rune(')')
+	rightSquarePar   = rune(']')
+	dot              = rune('.')
+	colon            = rune(':')
+	semicolon        = rune(';')
+	comma            = rune(',')
+	slash            = rune('/')
+	underscore       = rune('_')
+	backSlash        = rune('\\')
+	lt               = rune('<')
+	gt               = rune('>')
+	eq               = rune('=')
+	quote            = rune('"')
+	hat              = rune('^')
+	at               = rune('@')
+	newLine          = rune('\n')
+	query            = "select"
+	insert           = "insert"
+	delete           = "delete"
+	create           = "create"
+	construct        = "construct"
+	deconstruct      = "deconstruct"
+	drop             = "drop"
+	graph            = "graph"
+	data             = "data"
+	into             = "into"
+	from             = "from"
+	where            = "where"
+	as               = "as"
+	before           = "before"
+	after            = "after"
+	between          = "between"
+	count            = "count"
+	distinct         = "distinct"
+	sum              = "sum"
+	group            = "group"
+	having           = "having"
+	by               = "by"
+	order            = "order"
+	asc              = "asc"
+	desc             = "desc"
+	limit            = "limit"
+	not              = "not"
+	and              = "and"
+	or               = "or"
+	id               = "id"
+	typeKeyword      = "type"
+	atKeyword        = "at"
+	inKeyword        = "in"
+	showKeyword      = "show"
+	graphsKeyword    = "graphs"
+	covariantKeyword = "covariant"
+	reifiedKeyword   = "reified"
+	anchor           = "\"@["
+	literalType      = "\"^^type:"
+	literalBool      = "bool"
+	literalInt       = "int64"
+	literalFloat     = "float64"
+	literalText      = "text"
+	literalBlob      = "blob"
 )
 
 // Token contains the type and text collected around the captured token.
@@ -615,6 +629,14 @@ func lexKeyword(l *lexer) stateFn {
 		consumeKeyword(l, ItemGraphs)
 		return lexSpace
 	}
+	if strings.EqualFold(input, covariantKeyword) {
+		consumeKeyword(l, ItemCovariant)
+		return lexSpace
+	}
+	if strings.EqualFold(input, reifiedKeyword) {
+		consumeKeyword(l, ItemReified)
+		return lexSpace
+	}
 	for {
 		r := l.next()
 		if unicode.IsSpace(r) || r == eof {
@@ -626,6 +648,8 @@ func lexKeyword(l *lexer) stateFn {
 	return nil
 }
 
+// lexNode lexes a node or a bare node type out of the input. A node type is
+// a node without the ID section, terminated before any space or separator.
 func lexNode(l *lexer) stateFn {
 	ltID := false
 	for done := false; !done; {
@@ -635,13 +659,23 @@ func lexNode(l *lexer) stateFn {
 				l.next()
 				continue
 			}
-		case eof:
+		case eof, rightBracket, semicolon, comma, rightPar:
+			if !ltID && (r != eof || l.pos > l.start+1) {
+				return lexNodeType
+			}
+			if r != eof {
+				continue
+			}
 			l.emitError("node is not properly terminated; missing final > delimiter")
 			return nil
 		case lt:
 			ltID = true
 		case gt:
 			done = true
+		default:
+			if !ltID && unicode.IsSpace(r) {
+				return lexNodeType
+			}
 		}
 	}
 	if !ltID {
@@ -652,6 +686,18 @@ func lexNode(l *lexer) stateFn {
 	return lexSpace
 }
 
+// lexNodeType emits the bare node type that ends right before the last rune
+// read, which is left in the input. A lone / is not a node type.
+func lexNodeType(l *lexer) stateFn {
+	l.backup()
+	if l.pos == l.start+1 {
+		l.emitError("node type is missing after the / delimiter")
+		return nil
+	}
+	l.emit(ItemNodeType)
+	return lexSpace
+}
+
 // lexBlankNode tries to lex a blank node out of the input
 func lexBlankNode(l *lexer) stateFn {
 	if r := l.next(); r != colon {
@@ -102,6 +102,45 @@ func TestIndividualTokens(t *testing.T) {
 				{Type: ItemError, Text: "/_<foo",
 					ErrorMessage: "[lexer:0:6] node is not properly terminated; missing final > delimiter"},
 				{Type: ItemEOF}}},
+		{"CoVaRiAnT /organization /organization/company}",
+			[]Token{
+				{Type: ItemCovariant, Text: "CoVaRiAnT"},
+				{Type: ItemNodeType, Text: "/organization"},
+				{Type: ItemNodeType, Text: "/organization/company"},
+				{Type: ItemRBracket, Text: "}"},
+				{Type: ItemEOF}}},
+		{"/}",
+			[]Token{
+				{Type: ItemError, Text: "/",
+					ErrorMessage: "[lexer:0:1] node type is missing after the / delimiter"},
+				{Type: ItemEOF}}},
+		{"/;",
+			[]Token{
+				{Type: ItemError, Text: "/",
+					ErrorMessage: "[lexer:0:1] node type is missing after the / delimiter"},
+				{Type: ItemEOF}}},
+		{"covariant /, /u)",
+			[]Token{
+				{Type: ItemCovariant, Text: "covariant"},
+				{Type: ItemError, Text: "/",
+					ErrorMessage: "[lexer:0:11] node type is missing after the / delimiter"},
+				{Type: ItemEOF}}},
+		{"/ )",
+			[]Token{
+				{Type: ItemError, Text: "/",
+					ErrorMessage: "[lexer:0:1] node type is missing after the / delimiter"},
+				{Type: ItemEOF}}},
+		{"/u)",
+			[]Token{
+				{Type: ItemNodeType, Text: "/u"},
+				{Type: ItemRPar, Text: ")"},
+				{Type: ItemEOF}}},
+		{"ReIfIeD as ?s",
+			[]Token{
+				{Type: ItemReified, Text: "ReIfIeD"},
+				{Type: ItemAs, Text: "as"},
+				{Type: ItemBinding, Text: "?s"},
+				{Type: ItemEOF}}},
 		{"_:v1 _:foo_bar",
 			[]Token{
 				{Type: ItemBlankNode, Text: "_:v1"},
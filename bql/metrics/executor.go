@@ -0,0 +1,92 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/planner"
+	"github.com/google/badwolf/bql/table"
+)
+
+// executor wraps a planner.Executor and records the statements it runs.
+type executor struct {
+	planner.Executor
+	m *Metrics
+}
+
+// NewExecutor returns an executor that records the statements run by the
+// provided executor in m. If the executor implements planner.Streamer so
+// does the returned one.
+func NewExecutor(e planner.Executor, m *Metrics) planner.Executor {
+	ie := &executor{Executor: e, m: m}
+	if s, ok := e.(planner.Streamer); ok {
+		return &streamer{executor: ie, s: s}
+	}
+	return ie
+}
+
+// Execute runs the wrapped executor.
+func (e *executor) Execute(ctx context.Context) (*table.Table, error) {
+	start := time.Now()
+	t, err := e.Executor.Execute(ctx)
+	rows := 0
+	if t != nil {
+		rows = t.NumRows()
+	}
+	e.m.ObserveStatement(e.Type(), time.Since(start), rows, err)
+	return t, err
+}
+
+// streamer wraps executors that implement planner.Streamer.
+type streamer struct {
+	*executor
+	s planner.Streamer
+}
+
+// OutputBindings returns the bindings of the streamed rows.
+func (s *streamer) OutputBindings() []string {
+	return s.s.OutputBindings()
+}
+
+// Stream runs the wrapped streamer counting the rows it sends.
+func (s *streamer) Stream(ctx context.Context, rs chan<- table.Row) error {
+	defer close(rs)
+	start, rows := time.Now(), 0
+	fwd, errc := make(chan table.Row), make(chan error, 1)
+	go func() {
+		errc <- s.s.Stream(ctx, fwd)
+	}()
+	done := false
+	for r := range fwd {
+		if done {
+			continue
+		}
+		select {
+		case rs <- r:
+			rows++
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	err := <-errc
+	if err == nil && done {
+		err = ctx.Err()
+	}
+	s.m.ObserveStatement(s.Type(), time.Since(start), rows, err)
+	return err
+}
@@ -0,0 +1,227 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics instruments BadWolf stores and BQL executors. Stores
+// wrapped by NewStore count the calls to each storage method, and executors
+// wrapped by NewExecutor record the number, latency, errors, and rows of
+// the statements they run. The collected metrics can be written using the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+)
+
+// DefaultBuckets contains the upper bounds, in seconds, of the statement
+// latency histogram buckets.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Metrics collects the metrics of the instrumented stores and executors. It
+// is safe for concurrent use.
+type Metrics struct {
+	mu       sync.Mutex
+	buckets  []float64
+	queries  map[string]uint64
+	errors   map[string]uint64
+	rows     map[string]uint64
+	latency  map[string]*histogram
+	calls    map[string]uint64
+	callErrs map[string]uint64
+}
+
+// New returns an empty metrics collection using DefaultBuckets.
+func New() *Metrics {
+	return &Metrics{
+		buckets:  DefaultBuckets,
+		queries:  make(map[string]uint64),
+		errors:   make(map[string]uint64),
+		rows:     make(map[string]uint64),
+		latency:  make(map[string]*histogram),
+		calls:    make(map[string]uint64),
+		callErrs: make(map[string]uint64),
+	}
+}
+
+// ObserveStatement records a statement of the provided type that took d to
+// run, returned rows rows, and failed if err is not nil.
+func (m *Metrics) ObserveStatement(typ string, d time.Duration, rows int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries[typ]++
+	if err != nil {
+		m.errors[typ]++
+	}
+	m.rows[typ] += uint64(rows)
+	h, ok := m.latency[typ]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.latency[typ] = h
+	}
+	s := d.Seconds()
+	for i, b := range m.buckets {
+		if s <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += s
+	h.count++
+}
+
+// ObserveCall records a call to the provided storage method, which failed
+// if err is not nil.
+func (m *Metrics) ObserveCall(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[method]++
+	if err != nil {
+		m.callErrs[method]++
+	}
+}
+
+// Statements returns the number of statements of the provided type
+// observed.
+func (m *Metrics) Statements(typ string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queries[typ]
+}
+
+// Calls returns the number of calls to the provided storage method
+// observed.
+func (m *Metrics) Calls(method string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[method]
+}
+
+// escapeLabel escapes a label value for the text exposition format.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat formats the value for the text exposition format.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// writeCounter writes a metric with one sample per label value, sorted by
+// label value.
+func writeCounter(w io.Writer, name, typ, help, label string, vs map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	var ks []string
+	for k := range vs {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(k), vs[k])
+	}
+}
+
+// WriteText writes the collected metrics to the writer using the
+// Prometheus text exposition format. If a store is provided the number of
+// triples of each of its graphs is also reported. Counting triples requires
+// reading every graph, so the store provided should not be instrumented.
+func (m *Metrics) WriteText(ctx context.Context, w io.Writer, s storage.Store) error {
+	var triples map[string]uint64
+	if s != nil {
+		var err error
+		if triples, err = countTriples(ctx, s); err != nil {
+			return err
+		}
+	}
+	bw := bufio.NewWriter(w)
+	m.mu.Lock()
+	writeCounter(bw, "badwolf_statements_total", "counter", "Number of BQL statements run by type.", "type", m.queries)
+	writeCounter(bw, "badwolf_statement_errors_total", "counter", "Number of BQL statements that failed by type.", "type", m.errors)
+	writeCounter(bw, "badwolf_rows_returned_total", "counter", "Number of rows returned by BQL statements by type.", "type", m.rows)
+	const hn = "badwolf_statement_duration_seconds"
+	fmt.Fprintf(bw, "# HELP %s Latency of BQL statements by type.\n# TYPE %s histogram\n", hn, hn)
+	var ts []string
+	for t := range m.latency {
+		ts = append(ts, t)
+	}
+	sort.Strings(ts)
+	for _, t := range ts {
+		h, lt := m.latency[t], escapeLabel(t)
+		for i, b := range m.buckets {
+			fmt.Fprintf(bw, "%s_bucket{type=\"%s\",le=\"%s\"} %d\n", hn, lt, formatFloat(b), h.counts[i])
+		}
+		fmt.Fprintf(bw, "%s_bucket{type=\"%s\",le=\"+Inf\"} %d\n", hn, lt, h.count)
+		fmt.Fprintf(bw, "%s_sum{type=\"%s\"} %s\n", hn, lt, formatFloat(h.sum))
+		fmt.Fprintf(bw, "%s_count{type=\"%s\"} %d\n", hn, lt, h.count)
+	}
+	writeCounter(bw, "badwolf_storage_calls_total", "counter", "Number of calls to storage methods.", "method", m.calls)
+	writeCounter(bw, "badwolf_storage_errors_total", "counter", "Number of storage method calls that failed.", "method", m.callErrs)
+	m.mu.Unlock()
+	if triples != nil {
+		writeCounter(bw, "badwolf_graph_triples", "gauge", "Number of triples in each graph.", "graph", triples)
+	}
+	return bw.Flush()
+}
+
+// countTriples returns the number of triples of each graph in the store.
+func countTriples(ctx context.Context, s storage.Store) (map[string]uint64, error) {
+	names, errc := make(chan string), make(chan error, 1)
+	go func() {
+		errc <- s.GraphNames(ctx, names)
+	}()
+	var gns []string
+	for n := range names {
+		gns = append(gns, n)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	res := make(map[string]uint64)
+	for _, gn := range gns {
+		g, err := s.Graph(ctx, gn)
+		if err != nil {
+			// The graph may have been dropped since it was listed.
+			continue
+		}
+		ts, errc := make(chan *triple.Triple), make(chan error, 1)
+		go func() {
+			errc <- g.Triples(ctx, storage.DefaultLookup, ts)
+		}()
+		cnt := uint64(0)
+		for range ts {
+			cnt++
+		}
+		if err := <-errc; err != nil {
+			return nil, err
+		}
+		res[gn] = cnt
+	}
+	return res, nil
+}
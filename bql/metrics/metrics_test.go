@@ -0,0 +1,161 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/grammar"
+	"github.com/google/badwolf/bql/planner"
+	"github.com/google/badwolf/bql/semantic"
+	"github.com/google/badwolf/bql/table"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func newExecutor(ctx context.Context, t *testing.T, s storage.Store, m *Metrics, bql string) planner.Executor {
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		t.Fatalf("grammar.NewParser: should have produced a valid BQL parser, %v", err)
+	}
+	st := &semantic.Statement{}
+	if err := p.Parse(grammar.NewLLk(bql, 1), st); err != nil {
+		t.Fatalf("Parser.consume: failed to parse query %q with error %v", bql, err)
+	}
+	e, err := planner.New(ctx, s, st, 0, 10, nil)
+	if err != nil {
+		t.Fatalf("planner.New failed to create a valid query plan with error %v", err)
+	}
+	return NewExecutor(e, m)
+}
+
+func TestInstrumentedStoreAndExecutor(t *testing.T) {
+	ctx, m := context.Background(), New()
+	s := NewStore(memory.NewStore(), m)
+	for _, bql := range []string{
+		`create graph ?test;`,
+		`insert data into ?test {/u<joe> "knows"@[] /u<mary> . /u<mary> "knows"@[] /u<peter>};`,
+		`select ?s, ?o from ?test where {?s "knows"@[] ?o};`,
+	} {
+		if _, err := newExecutor(ctx, t, s, m, bql).Execute(ctx); err != nil {
+			t.Fatalf("Execute(%q) failed with error %v", bql, err)
+		}
+	}
+	e := newExecutor(ctx, t, s, m, `select ?s from ?test where {?s "knows"@[] /u<mary>};`)
+	st, ok := e.(planner.Streamer)
+	if !ok {
+		t.Fatalf("metrics.NewExecutor should preserve planner.Streamer for query plans")
+	}
+	rs := make(chan table.Row)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- st.Stream(ctx, rs)
+	}()
+	cnt := 0
+	for range rs {
+		cnt++
+	}
+	if err := <-errc; err != nil || cnt != 1 {
+		t.Fatalf("Stream returned %d rows and error %v; want 1 row", cnt, err)
+	}
+
+	for typ, want := range map[string]uint64{"CREATE": 1, "INSERT": 1, "SELECT": 2} {
+		if got := m.Statements(typ); got != want {
+			t.Errorf("m.Statements(%q) returned %d; want %d", typ, got, want)
+		}
+	}
+	for _, method := range []string{"NewGraph", "Graph", "AddTriples"} {
+		if m.Calls(method) == 0 {
+			t.Errorf("m.Calls(%q) returned 0; want calls to be recorded", method)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteText(ctx, &buf, s); err != nil {
+		t.Fatalf("m.WriteText failed with error %v", err)
+	}
+	for _, want := range []string{
+		`badwolf_statements_total{type="SELECT"} 2`,
+		`badwolf_rows_returned_total{type="SELECT"} 3`,
+		`badwolf_statement_duration_seconds_count{type="INSERT"} 1`,
+		`badwolf_statement_duration_seconds_bucket{type="CREATE",le="+Inf"} 1`,
+		`badwolf_storage_calls_total{method="NewGraph"} 1`,
+		`badwolf_graph_triples{graph="?test"} 2`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("m.WriteText output is missing %q; got\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestObserveStatement(t *testing.T) {
+	m := New()
+	m.ObserveStatement("SELECT", 20*time.Millisecond, 3, nil)
+	m.ObserveStatement("SELECT", 2*time.Second, 0, errors.New("failed"))
+	var buf bytes.Buffer
+	if err := m.WriteText(context.Background(), &buf, nil); err != nil {
+		t.Fatalf("m.WriteText failed with error %v", err)
+	}
+	for _, want := range []string{
+		`badwolf_statements_total{type="SELECT"} 2`,
+		`badwolf_statement_errors_total{type="SELECT"} 1`,
+		`badwolf_rows_returned_total{type="SELECT"} 3`,
+		`badwolf_statement_duration_seconds_bucket{type="SELECT",le="0.01"} 0`,
+		`badwolf_statement_duration_seconds_bucket{type="SELECT",le="0.025"} 1`,
+		`badwolf_statement_duration_seconds_bucket{type="SELECT",le="2.5"} 2`,
+		`badwolf_statement_duration_seconds_sum{type="SELECT"} 2.02`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("m.WriteText output is missing %q; got\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "badwolf_graph_triples{") {
+		t.Errorf("m.WriteText should not report triple counts without a store; got\n%s", buf.String())
+	}
+}
+
+func TestTypeGraphIsPreserved(t *testing.T) {
+	ctx, m := context.Background(), New()
+	g, err := NewStore(memory.NewStore(), m).NewGraph(ctx, "?test")
+	if err != nil {
+		t.Fatalf("NewGraph failed with error %v", err)
+	}
+	tg, ok := g.(storage.TypeGraph)
+	if !ok {
+		t.Fatalf("instrumented memory graphs should implement storage.TypeGraph")
+	}
+	tr, err := triple.Parse(`/u<joe> "knows"@[] /u<mary>`, literal.DefaultBuilder())
+	if err != nil {
+		t.Fatalf("triple.Parse failed with error %v", err)
+	}
+	if err := g.AddTriples(ctx, []*triple.Triple{tr}); err != nil {
+		t.Fatalf("AddTriples failed with error %v", err)
+	}
+	ts := make(chan *triple.Triple, 10)
+	if err := tg.TriplesForSubjectType(ctx, tr.Subject().Type(), storage.DefaultLookup, ts); err != nil {
+		t.Fatalf("TriplesForSubjectType failed with error %v", err)
+	}
+	if got := m.Calls("TriplesForSubjectType"); got != 1 {
+		t.Errorf("m.Calls(%q) returned %d; want 1", "TriplesForSubjectType", got)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// store wraps a storage.Store and counts the calls to its methods.
+type store struct {
+	storage.Store
+	m *Metrics
+}
+
+// NewStore returns a store that records the calls to the provided store and
+// the graphs it returns in m.
+func NewStore(s storage.Store, m *Metrics) storage.Store {
+	return &store{Store: s, m: m}
+}
+
+// wrap returns the instrumented version of the graph.
+func (s *store) wrap(g storage.Graph) storage.Graph {
+	ig := &graph{Graph: g, m: s.m}
+	if tg, ok := g.(storage.TypeGraph); ok {
+		return &typeGraph{graph: ig, tg: tg}
+	}
+	return ig
+}
+
+// NewGraph creates a new graph.
+func (s *store) NewGraph(ctx context.Context, id string) (storage.Graph, error) {
+	g, err := s.Store.NewGraph(ctx, id)
+	s.m.ObserveCall("NewGraph", err)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(g), nil
+}
+
+// Graph returns an existing graph if available.
+func (s *store) Graph(ctx context.Context, id string) (storage.Graph, error) {
+	g, err := s.Store.Graph(ctx, id)
+	s.m.ObserveCall("Graph", err)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(g), nil
+}
+
+// DeleteGraph deletes an existing graph.
+func (s *store) DeleteGraph(ctx context.Context, id string) error {
+	err := s.Store.DeleteGraph(ctx, id)
+	s.m.ObserveCall("DeleteGraph", err)
+	return err
+}
+
+// GraphNames returns the current available graph names in the store.
+func (s *store) GraphNames(ctx context.Context, names chan<- string) error {
+	err := s.Store.GraphNames(ctx, names)
+	s.m.ObserveCall("GraphNames", err)
+	return err
+}
+
+// graph wraps a storage.Graph and counts the calls to its methods.
+type graph struct {
+	storage.Graph
+	m *Metrics
+}
+
+// Unwrap returns the wrapped graph.
+func (g *graph) Unwrap() storage.Graph {
+	return g.Graph
+}
+
+// AddTriples adds the triples to the storage.
+func (g *graph) AddTriples(ctx context.Context, ts []*triple.Triple) error {
+	err := g.Graph.AddTriples(ctx, ts)
+	g.m.ObserveCall("AddTriples", err)
+	return err
+}
+
+// RemoveTriples removes the triples from the storage.
+func (g *graph) RemoveTriples(ctx context.Context, ts []*triple.Triple) error {
+	err := g.Graph.RemoveTriples(ctx, ts)
+	g.m.ObserveCall("RemoveTriples", err)
+	return err
+}
+
+// Objects pushes to the provided channel the objects for the given subject
+// and predicate.
+func (g *graph) Objects(ctx context.Context, s *node.Node, p *predicate.Predicate, lo *storage.LookupOptions, objs chan<- *triple.Object) error {
+	err := g.Graph.Objects(ctx, s, p, lo, objs)
+	g.m.ObserveCall("Objects", err)
+	return err
+}
+
+// Subjects pushes to the provided channel the subjects for the given
+// predicate and object.
+func (g *graph) Subjects(ctx context.Context, p *predicate.Predicate, o *triple.Object, lo *storage.LookupOptions, subs chan<- *node.Node) error {
+	err := g.Graph.Subjects(ctx, p, o, lo, subs)
+	g.m.ObserveCall("Subjects", err)
+	return err
+}
+
+// PredicatesForSubject pushes to the provided channel all the predicates
+// known for the given subject.
+func (g *graph) PredicatesForSubject(ctx context.Context, s *node.Node, lo *storage.LookupOptions, prds chan<- *predicate.Predicate) error {
+	err := g.Graph.PredicatesForSubject(ctx, s, lo, prds)
+	g.m.ObserveCall("PredicatesForSubject", err)
+	return err
+}
+
+// PredicatesForObject pushes to the provided channel all the predicates
+// known for the given object.
+func (g *graph) PredicatesForObject(ctx context.Context, o *triple.Object, lo *storage.LookupOptions, prds chan<- *predicate.Predicate) error {
+	err := g.Graph.PredicatesForObject(ctx, o, lo, prds)
+	g.m.ObserveCall("PredicatesForObject", err)
+	return err
+}
+
+// PredicatesForSubjectAndObject pushes to the provided channel all
+// predicates available for the given subject and object.
+func (g *graph) PredicatesForSubjectAndObject(ctx context.Context, s *node.Node, o *triple.Object, lo *storage.LookupOptions, prds chan<- *predicate.Predicate) error {
+	err := g.Graph.PredicatesForSubjectAndObject(ctx, s, o, lo, prds)
+	g.m.ObserveCall("PredicatesForSubjectAndObject", err)
+	return err
+}
+
+// TriplesForSubject pushes to the provided channel all triples available
+// for the given subject.
+func (g *graph) TriplesForSubject(ctx context.Context, s *node.Node, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	err := g.Graph.TriplesForSubject(ctx, s, lo, trpls)
+	g.m.ObserveCall("TriplesForSubject", err)
+	return err
+}
+
+// TriplesForPredicate pushes to the provided channel all triples available
+// for the given predicate.
+func (g *graph) TriplesForPredicate(ctx context.Context, p *predicate.Predicate, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	err := g.Graph.TriplesForPredicate(ctx, p, lo, trpls)
+	g.m.ObserveCall("TriplesForPredicate", err)
+	return err
+}
+
+// TriplesForObject pushes to the provided channel all triples available for
+// the given object.
+func (g *graph) TriplesForObject(ctx context.Context, o *triple.Object, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	err := g.Graph.TriplesForObject(ctx, o, lo, trpls)
+	g.m.ObserveCall("TriplesForObject", err)
+	return err
+}
+
+// TriplesForSubjectAndPredicate pushes to the provided channel all triples
+// available for the given subject and predicate.
+func (g *graph) TriplesForSubjectAndPredicate(ctx context.Context, s *node.Node, p *predicate.Predicate, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	err := g.Graph.TriplesForSubjectAndPredicate(ctx, s, p, lo, trpls)
+	g.m.ObserveCall("TriplesForSubjectAndPredicate", err)
+	return err
+}
+
+// TriplesForPredicateAndObject pushes to the provided channel all triples
+// available for the given predicate and object.
+func (g *graph) TriplesForPredicateAndObject(ctx context.Context, p *predicate.Predicate, o *triple.Object, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	err := g.Graph.TriplesForPredicateAndObject(ctx, p, o, lo, trpls)
+	g.m.ObserveCall("TriplesForPredicateAndObject", err)
+	return err
+}
+
+// Exist checks if the provided triple exists on the store.
+func (g *graph) Exist(ctx context.Context, t *triple.Triple) (bool, error) {
+	ok, err := g.Graph.Exist(ctx, t)
+	g.m.ObserveCall("Exist", err)
+	return ok, err
+}
+
+// Triples pushes to the provided channel all available triples in the
+// graph.
+func (g *graph) Triples(ctx context.Context, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	err := g.Graph.Triples(ctx, lo, trpls)
+	g.m.ObserveCall("Triples", err)
+	return err
+}
+
+// typeGraph wraps graphs that also provide the type index lookups.
+type typeGraph struct {
+	*graph
+	tg storage.TypeGraph
+}
+
+// TriplesForSubjectType pushes to the provided channel all triples whose
+// subject type is covariant with the provided type.
+func (g *typeGraph) TriplesForSubjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	err := g.tg.TriplesForSubjectType(ctx, t, lo, trpls)
+	g.m.ObserveCall("TriplesForSubjectType", err)
+	return err
+}
+
+// TriplesForObjectType pushes to the provided channel all triples whose
+// object is a node with a type covariant with the provided type.
+func (g *typeGraph) TriplesForObjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	err := g.tg.TriplesForObjectType(ctx, t, lo, trpls)
+	g.m.ObserveCall("TriplesForObjectType", err)
+	return err
+}
@@ -102,6 +102,82 @@ func simpleExist(ctx context.Context, gs []storage.Graph, cls *semantic.GraphCla
 	return unfeasible, tbl, nil
 }
 
+// bindGraph sets the graph binding of the clause, if any, on the provided
+// rows to the name of the graph they were retrieved from.
+func bindGraph(ctx context.Context, rs []table.Row, cls *semantic.GraphClause, g storage.Graph) {
+	if cls.GBinding == "" {
+		return
+	}
+	for _, r := range rs {
+		r[cls.GBinding] = graphCell(ctx, g)
+	}
+}
+
+// graphCell returns a cell containing the name of the graph.
+func graphCell(ctx context.Context, g storage.Graph) *table.Cell {
+	return &table.Cell{S: table.CellString(g.ID(ctx))}
+}
+
+// graphsForRow returns the graphs the clause data should be retrieved from
+// given the row provided. If the row already binds the graph of the clause,
+// only the graph with that name is returned.
+func graphsForRow(ctx context.Context, gs []storage.Graph, cls *semantic.GraphClause, r table.Row) []storage.Graph {
+	if cls.GBinding == "" {
+		return gs
+	}
+	v, ok := r[cls.GBinding]
+	if !ok {
+		return gs
+	}
+	var res []storage.Graph
+	for _, g := range gs {
+		if v.S != nil && g.ID(ctx) == *v.S {
+			res = append(res, g)
+		}
+	}
+	return res
+}
+
+// fetch returns a table containing the data specified by the graph clause as
+// simpleFetch does. If the clause binds the graph name, each graph is queried
+// independently and the rows retrieved are bound to the graph name.
+func fetch(ctx context.Context, gs []storage.Graph, cls *semantic.GraphClause, lo *storage.LookupOptions, stmLimit int64, chanSize int) (*table.Table, error) {
+	if cls.GBinding == "" {
+		return simpleFetch(ctx, gs, cls, lo, stmLimit, chanSize)
+	}
+	tbl, err := table.New(cls.Bindings())
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range gs {
+		if cls.Specificity() == 3 {
+			// The graph name is the only binding of fully specified clauses.
+			t, err := triple.New(cls.S, cls.P, cls.O)
+			if err != nil {
+				return nil, err
+			}
+			b, err := g.Exist(ctx, t)
+			if err != nil {
+				return nil, err
+			}
+			if b {
+				tbl.AddRow(table.Row{cls.GBinding: graphCell(ctx, g)})
+			}
+			continue
+		}
+		gt, err := simpleFetch(ctx, []storage.Graph{g}, cls, lo, stmLimit, chanSize)
+		if err != nil {
+			return nil, err
+		}
+		rs := gt.Rows()
+		bindGraph(ctx, rs, cls, g)
+		for _, r := range rs {
+			tbl.AddRow(r)
+		}
+	}
+	return tbl, nil
+}
+
 // simpleFetch returns a table containing the data specified by the graph
 // clause by querying the provided stora. Will return an error if it had poblems
 // retrieveing the data.
@@ -363,7 +439,7 @@ func simpleFetch(ctx context.Context, gs []storage.Graph, cls *semantic.GraphCla
 				if stmLimit > 0 {
 					nlo.MaxElements = int(stmLimit)
 				}
-				tErr = g.Triples(ctx, &nlo, ts)
+				tErr = allTriples(ctx, g, cls, &nlo, ts)
 			}()
 			aErr = addTriples(ts, cls, tbl)
 			wg.Wait()
@@ -380,11 +456,45 @@ func simpleFetch(ctx context.Context, gs []storage.Graph, cls *semantic.GraphCla
 	return nil, fmt.Errorf("planner.simpleFetch could not recognize request in clause %v", cls)
 }
 
+// allTriples pushes all the triples of the graph that may satisfy the clause
+// to the provided channel. If the clause constrains the subject or object type
+// and the graph provides a type index, the index will be used instead of
+// scanning the full graph.
+func allTriples(ctx context.Context, g storage.Graph, cls *semantic.GraphClause, lo *storage.LookupOptions, ts chan<- *triple.Triple) error {
+	if tg, ok := g.(storage.TypeGraph); ok {
+		if cls.SCovariant != nil {
+			return tg.TriplesForSubjectType(ctx, cls.SCovariant, lo, ts)
+		}
+		if cls.OCovariant != nil {
+			return tg.TriplesForObjectType(ctx, cls.OCovariant, lo, ts)
+		}
+	}
+	return g.Triples(ctx, lo, ts)
+}
+
+// isCovariantMatch returns true if the subject and object of the provided
+// triple satisfy the type covariance constraints of the graph clause.
+func isCovariantMatch(s *node.Node, o *triple.Object, cls *semantic.GraphClause) bool {
+	if cls.SCovariant != nil && !s.Type().Covariant(cls.SCovariant) {
+		return false
+	}
+	if cls.OCovariant != nil {
+		n, err := o.Node()
+		if err != nil || !n.Type().Covariant(cls.OCovariant) {
+			return false
+		}
+	}
+	return true
+}
+
 // addTriples add all the retrieved triples from the graphs into the results
 // table. The semantic graph clause is also passed to be able to identify what
 // bindings to set.
 func addTriples(ts <-chan *triple.Triple, cls *semantic.GraphClause, tbl *table.Table) error {
 	for t := range ts {
+		if !isCovariantMatch(t.Subject(), t.Object(), cls) {
+			continue
+		}
 		if cls.PID != "" {
 			// The triples need to be filtered.
 			if string(t.Predicate().ID()) != cls.PID {
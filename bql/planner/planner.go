@@ -33,6 +33,7 @@ import (
 	"github.com/google/badwolf/bql/semantic"
 	"github.com/google/badwolf/bql/table"
 	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/schema"
 	"github.com/google/badwolf/triple"
 	"github.com/google/badwolf/triple/literal"
 	"github.com/google/badwolf/triple/predicate"
@@ -50,6 +51,17 @@ type Executor interface {
 	Type() string
 }
 
+// Streamer is implemented by executors able to deliver the rows of their
+// result as they are produced instead of returning the whole table.
+type Streamer interface {
+	// OutputBindings returns the bindings of the streamed rows.
+	OutputBindings() []string
+
+	// Stream runs the plan and sends the resulting rows to the provided
+	// channel. The channel is closed when done.
+	Stream(ctx context.Context, rs chan<- table.Row) error
+}
+
 // trace attempts to write a trace if a valid writer is provided. The
 // tracer is lazy on the string generation to avoid adding too much
 // overhead when tracing ins not on.
@@ -199,6 +211,11 @@ func (p *insertPlan) Execute(ctx context.Context) (*table.Table, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Validate the data against all the output graphs before inserting it to
+	// avoid partial insertions if any graph rejects the data.
+	if err := update(ctx, p.stm.Data(), p.stm.OutputGraphNames(), p.store, validateFunc(ctx, p.tracer)); err != nil {
+		return nil, err
+	}
 	return t, update(ctx, p.stm.Data(), p.stm.OutputGraphNames(), p.store, func(g storage.Graph, d []*triple.Triple) error {
 		trace(p.tracer, func() []string {
 			return []string{"Inserting triples to graph \"" + g.ID(ctx) + "\""}
@@ -207,6 +224,17 @@ func (p *insertPlan) Execute(ctx context.Context) (*table.Table, error) {
 	})
 }
 
+// validateFunc returns an updater that validates the triples against the
+// schema of the graph, if any.
+func validateFunc(ctx context.Context, w io.Writer) updater {
+	return func(g storage.Graph, d []*triple.Triple) error {
+		trace(w, func() []string {
+			return []string{"Validating triples for graph \"" + g.ID(ctx) + "\""}
+		})
+		return schema.Validate(ctx, g, d)
+	}
+}
+
 // String returns a readable description of the execution plan.
 func (p *insertPlan) String(ctx context.Context) string {
 	b := bytes.NewBufferString("INSERT plan:\n\n")
@@ -278,6 +306,8 @@ type queryPlan struct {
 	tbl       *table.Table
 	chanSize  int
 	tracer    io.Writer
+	// sink receives the rows produced by the last clause when streaming.
+	sink func(table.Row) error
 }
 
 // Type returns the type of plan used by the executor.
@@ -312,7 +342,7 @@ func newQueryPlan(ctx context.Context, store storage.Store, stm *semantic.Statem
 func (p *queryPlan) processClause(ctx context.Context, cls *semantic.GraphClause, lo *storage.LookupOptions) (bool, error) {
 	// This method decides how to process the clause based on the current
 	// list of bindings solved and data available.
-	if cls.Specificity() == 3 {
+	if cls.Specificity() == 3 && cls.GBinding == "" {
 		t, err := triple.New(cls.S, cls.P, cls.O)
 		if err != nil {
 			return false, err
@@ -339,10 +369,13 @@ func (p *queryPlan) processClause(ctx context.Context, cls *semantic.GraphClause
 		if len(p.stm.GraphPatternClauses()) == 1 && len(p.stm.GroupBy()) == 0 && len(p.stm.HavingExpression()) == 0 {
 			stmLimit = p.stm.Limit()
 		}
-		tbl, err := simpleFetch(ctx, p.grfs, cls, lo, stmLimit, p.chanSize)
+		tbl, err := fetch(ctx, p.grfs, cls, lo, stmLimit, p.chanSize)
 		if err != nil {
 			return false, err
 		}
+		if p.sink != nil {
+			return false, p.sinkDotProduct(tbl)
+		}
 		if len(p.tbl.Bindings()) > 0 {
 			return false, p.tbl.DotProduct(tbl)
 		}
@@ -356,8 +389,9 @@ func (p *queryPlan) processClause(ctx context.Context, cls *semantic.GraphClause
 	if exist > 0 && exist == total {
 		// Since all bindings in the clause are already solved, the clause becomes a
 		// fully specified triple. If the triple does not exist the row will be
-		// deleted.
-		if cls.PTemporal && cls.PID != "" {
+		// deleted. Predicates and objects only constrained by their ID and time
+		// anchor still need to be looked up.
+		if (cls.PID != "" && cls.P == nil) || (cls.OID != "" && cls.O == nil) {
 			return false, p.specifyClauseWithTable(ctx, cls, lo)
 		}
 		return false, p.filterOnExistence(ctx, cls, lo)
@@ -423,23 +457,56 @@ func (p *queryPlan) addSpecifiedData(ctx context.Context, r table.Row, cls *sema
 	if len(p.stm.GraphPatternClauses()) == 1 && len(p.stm.GroupBy()) == 0 && len(p.stm.HavingExpression()) == 0 {
 		stmLimit = p.stm.Limit()
 	}
-	tbl, err := simpleFetch(ctx, p.grfs, cls, lo, stmLimit, p.chanSize)
+	tbl, err := fetch(ctx, graphsForRow(ctx, p.grfs, cls, r), cls, lo, stmLimit, p.chanSize)
 	if err != nil {
 		return err
 	}
 	p.tbl.AddBindings(tbl.Bindings())
 	for _, nr := range tbl.Rows() {
-		p.tbl.AddRow(table.MergeRows([]table.Row{r, nr}))
+		if err := p.addRow(table.MergeRows([]table.Row{r, nr})); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// addRow adds the row to the table, or sends it to the sink if the plan is
+// streaming the rows of its last clause.
+func (p *queryPlan) addRow(r table.Row) error {
+	if p.sink != nil {
+		return p.sink(r)
+	}
+	p.tbl.AddRow(r)
+	return nil
+}
+
+// sinkDotProduct sends the dot product of the table and the provided one to
+// the sink instead of materializing it. The table is truncated afterwards.
+func (p *queryPlan) sinkDotProduct(tbl *table.Table) error {
+	rs := p.tbl.Rows()
+	if len(p.tbl.Bindings()) == 0 {
+		rs = []table.Row{{}}
+	}
+	for _, r1 := range rs {
+		for _, r2 := range tbl.Rows() {
+			if err := p.sink(table.MergeRows([]table.Row{r1, r2})); err != nil {
+				return err
+			}
+		}
+	}
+	p.tbl.Truncate()
+	return nil
+}
+
 // specifyClauseWithTable runs the clause, but it specifies it further based on
 // the current row being processed.
 func (p *queryPlan) specifyClauseWithTable(ctx context.Context, cls *semantic.GraphClause, lo *storage.LookupOptions) error {
 	rws := p.tbl.Rows()
 	p.tbl.Truncate()
 	for _, r := range rws {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		tmpCls := &semantic.GraphClause{}
 		*tmpCls = *cls
 		if err := p.addSpecifiedData(ctx, r, tmpCls, lo); err != nil {
@@ -552,8 +619,11 @@ func (p *queryPlan) filterOnExistence(ctx context.Context, cls *semantic.GraphCl
 		if sbj == nil || prd == nil || obj == nil {
 			return fmt.Errorf("failed to fully specify clause %v for row %+v", cls, r)
 		}
+		if !isCovariantMatch(sbj, obj, cls) {
+			continue
+		}
 		exist := false
-		for _, g := range p.stm.InputGraphs() {
+		for _, g := range graphsForRow(ctx, p.stm.InputGraphs(), cls, r) {
 			t, err := triple.New(sbj, prd, obj)
 			if err != nil {
 				return err
@@ -568,7 +638,9 @@ func (p *queryPlan) filterOnExistence(ctx context.Context, cls *semantic.GraphCl
 			}
 		}
 		if exist {
-			p.tbl.AddRow(r)
+			if err := p.addRow(r); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -578,6 +650,9 @@ func (p *queryPlan) filterOnExistence(ctx context.Context, cls *semantic.GraphCl
 // data from the specified graphs.
 func (p *queryPlan) processGraphPattern(ctx context.Context, lo *storage.LookupOptions) error {
 	for _, cls := range p.cls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		trace(p.tracer, func() []string {
 			return []string{"Processing graph clause " + cls.String()}
 		})
@@ -771,6 +846,108 @@ func (p *queryPlan) Execute(ctx context.Context) (*table.Table, error) {
 	return p.tbl, nil
 }
 
+// errLimitReached signals that the streamed rows reached the query limit.
+var errLimitReached = errors.New("limit reached")
+
+// OutputBindings returns the bindings of the rows returned by the query.
+func (p *queryPlan) OutputBindings() []string {
+	return p.stm.OutputBindings()
+}
+
+// project returns the row with the projected output bindings.
+func (p *queryPlan) project(r table.Row) table.Row {
+	for _, prj := range p.stm.Projections() {
+		if prj.Alias != "" {
+			r[prj.Alias] = r[prj.Binding]
+		}
+	}
+	res := make(table.Row)
+	for _, b := range p.stm.OutputBindings() {
+		if c, ok := r[b]; ok {
+			res[b] = c
+		}
+	}
+	return res
+}
+
+// Stream runs the query and sends the projected rows to the provided channel
+// as the last clause of the graph pattern produces them. Queries that group,
+// order, or filter their results with HAVING need the whole table, so their
+// rows are only sent once the query has been fully executed.
+func (p *queryPlan) Stream(ctx context.Context, rs chan<- table.Row) error {
+	defer close(rs)
+	send := func(r table.Row) error {
+		select {
+		case rs <- r:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if len(p.cls) == 0 || len(p.stm.GroupByBindings()) > 0 || len(p.stm.OrderByConfig()) > 0 || p.stm.HasHavingClause() {
+		tbl, err := p.Execute(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range tbl.Rows() {
+			if err := send(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	trace(p.tracer, func() []string {
+		return []string{fmt.Sprintf("Caching graph instances for graphs %v", p.stm.InputGraphNames())}
+	})
+	if err := p.stm.Init(ctx, p.store); err != nil {
+		return err
+	}
+	p.grfs = p.stm.InputGraphs()
+	lo := p.stm.GlobalLookupOptions()
+	cnt := int64(0)
+	emit := func(r table.Row) error {
+		if p.stm.IsLimitSet() && cnt >= p.stm.Limit() {
+			return errLimitReached
+		}
+		cnt++
+		return send(p.project(r))
+	}
+	defer func() {
+		p.sink = nil
+	}()
+	for i, cls := range p.cls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		trace(p.tracer, func() []string {
+			return []string{"Streaming graph clause " + cls.String()}
+		})
+		if i == len(p.cls)-1 {
+			p.sink = emit
+		}
+		unresolvable, err := p.processClause(ctx, cls, lo)
+		if err == errLimitReached {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if unresolvable {
+			return nil
+		}
+	}
+	// Rows of fully specified clauses are not sent to the sink.
+	for _, r := range p.tbl.Rows() {
+		if err := emit(r); err != nil {
+			if err == errLimitReached {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 // String returns a readable description of the execution plan.
 func (p *queryPlan) String(ctx context.Context) string {
 	b := bytes.NewBufferString("QUERY plan:\n\n")
@@ -925,10 +1102,24 @@ func (p *constructPlan) Execute(ctx context.Context) (*table.Table, error) {
 	}
 	// The buffered channel has capacity to accommodate twice the amount of triples stored in a single call.
 	tripChan := make(chan *triple.Triple, 2*p.bulkSize)
-	done := make(chan bool)
+	done := make(chan error)
 
 	go func() {
 		var ts []*triple.Triple
+		// If any output graph has a schema, the constructed triples need to be
+		// validated as a whole before being inserted.
+		validate := false
+		if p.construct {
+			for _, gn := range p.stm.OutputGraphNames() {
+				g, err := p.store.Graph(ctx, gn)
+				if err != nil {
+					continue
+				}
+				if _, ok := schema.Of(g); ok {
+					validate = true
+				}
+			}
+		}
 		updateFunc := func(g storage.Graph, d []*triple.Triple) error {
 			trace(p.tracer, func() []string {
 				return []string{"Removing triples from graph \"" + g.ID(ctx) + "\""}
@@ -945,15 +1136,25 @@ func (p *constructPlan) Execute(ctx context.Context) (*table.Table, error) {
 		}
 		for elem := range tripChan {
 			ts = append(ts, elem)
-			if len(ts) >= p.bulkSize {
+			if !validate && len(ts) >= p.bulkSize {
 				update(ctx, ts, p.stm.OutputGraphNames(), p.store, updateFunc)
 				ts = []*triple.Triple{}
 			}
 		}
+		if validate {
+			if err := update(ctx, ts, p.stm.OutputGraphNames(), p.store, validateFunc(ctx, p.tracer)); err != nil {
+				done <- err
+				return
+			}
+			for p.bulkSize > 0 && len(ts) > p.bulkSize {
+				update(ctx, ts[:p.bulkSize], p.stm.OutputGraphNames(), p.store, updateFunc)
+				ts = ts[p.bulkSize:]
+			}
+		}
 		if len(ts) > 0 {
 			update(ctx, ts, p.stm.OutputGraphNames(), p.store, updateFunc)
 		}
-		done <- true
+		done <- nil
 	}()
 
 	for _, cc := range p.stm.ConstructClauses() {
@@ -989,7 +1190,9 @@ func (p *constructPlan) Execute(ctx context.Context) (*table.Table, error) {
 	}
 	close(tripChan)
 	// Wait until all triples are added to the store.
-	<-done
+	if err := <-done; err != nil {
+		return nil, err
+	}
 	return tbl, nil
 }
 
@@ -17,6 +17,8 @@ package planner
 import (
 	"bytes"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
@@ -24,11 +26,14 @@ import (
 
 	"github.com/google/badwolf/bql/grammar"
 	"github.com/google/badwolf/bql/semantic"
+	"github.com/google/badwolf/bql/table"
 	"github.com/google/badwolf/io"
 	"github.com/google/badwolf/storage"
 	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/storage/schema"
 	"github.com/google/badwolf/triple"
 	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
 )
 
 const (
@@ -468,6 +473,36 @@ func TestPlannerQuery(t *testing.T) {
 			nbs:  1,
 			nrws: 1,
 		},
+		{
+			q:    `select ?s, ?p, ?o from ?test where {?s covariant /item ?p ?o};`,
+			nbs:  3,
+			nrws: 3,
+		},
+		{
+			q:    `select ?s, ?p, ?o from ?test where {?s covariant /ite ?p ?o};`,
+			nbs:  3,
+			nrws: 0,
+		},
+		{
+			q:    `select ?s, ?o from ?test where {?s ?p ?o covariant /t};`,
+			nbs:  2,
+			nrws: 4,
+		},
+		{
+			q:    `select ?s, ?o from ?test where {?s covariant /item "in"@[?t] ?o covariant /room};`,
+			nbs:  2,
+			nrws: 3,
+		},
+		{
+			q:    `select ?s, ?c from ?test where {?s "parent_of"@[] ?o . ?o ?p ?c covariant /c};`,
+			nbs:  2,
+			nrws: 4,
+		},
+		{
+			q:    `select ?s, ?o from ?test where {?s "parent_of"@[] ?o . ?s "parent_of"@[] ?o covariant /t};`,
+			nbs:  2,
+			nrws: 0,
+		},
 	}
 
 	s, ctx := memory.NewStore(), context.Background()
@@ -991,6 +1026,208 @@ func TestReificationResolutionIssue70(t *testing.T) {
 	}
 }
 
+func TestPlannerQueryReifiedStatements(t *testing.T) {
+	s, ctx := memory.NewStore(), context.Background()
+	populateStoreWithTriples(ctx, s, "?src", `/u<joe>	"parent"@[]	/u<mary>
+		/u<mary>	"parent"@[]	/u<peter>
+		/u<joe>	"lives"@[]	/c<nyc>
+		/u<peter>	"lives"@[]	/c<nyc>
+		/u<joe>	"met"@[2016-04-10T04:21:00Z]	/u<ann>
+		`, t)
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		t.Fatalf("grammar.NewParser: should have produced a valid BQL parser with error %v", err)
+	}
+	runQuery := func(q string) (*table.Table, error) {
+		st := &semantic.Statement{}
+		if err := p.Parse(grammar.NewLLk(q, 1), st); err != nil {
+			return nil, err
+		}
+		plnr, err := New(ctx, s, st, 0, 10, nil)
+		if err != nil {
+			return nil, err
+		}
+		return plnr.Execute(ctx)
+	}
+	for _, q := range []string{
+		`create graph ?dest;`,
+		`construct {?a "grandparent"@[] ?g ; "both_live_in"@[] ?c} into ?dest from ?src where {?a "parent"@[] ?x . ?x "parent"@[] ?g . ?a "lives"@[] ?c . ?g "lives"@[] ?c};`,
+		`construct {?a "met"@[2016-04-10T04:21:00Z] ?b ; "where"@[] /c<sf>} into ?dest from ?src where {?a "met"@[2016-04-10T04:21:00Z] ?b};`,
+	} {
+		if _, err := runQuery(q); err != nil {
+			t.Fatalf("planner.Execute failed for query %q with error %v", q, err)
+		}
+	}
+	table := []struct {
+		q    string
+		nbs  int
+		nrws int
+	}{
+		{`select ?a, ?g, ?c from ?dest where {?a "grandparent"@[] ?g reified as ?st . ?st "both_live_in"@[] ?c};`, 3, 1},
+		{`select ?st from ?dest where {/u<joe> "grandparent"@[] /u<peter> reified as ?st};`, 1, 1},
+		{`select ?p from ?dest where {/u<joe> ?p ?o reified as ?st};`, 1, 2},
+		{`select ?t, ?w from ?dest where {?a "met"@[?t] ?b reified as ?st . ?st "where"@[] ?w};`, 2, 1},
+		{`select ?w from ?dest where {?a "met"@[2016-01-01T00:00:00Z,2017-01-01T00:00:00Z] ?b reified as ?st . ?st "where"@[] ?w};`, 1, 1},
+		{`select ?w from ?dest where {?a "met"@[2017-01-01T00:00:00Z,2018-01-01T00:00:00Z] ?b reified as ?st . ?st "where"@[] ?w};`, 1, 0},
+		{`select ?a from ?dest where {?a "met"@[] ?b reified as ?st};`, 1, 0},
+		// Reified statements are not asserted.
+		{`select ?a from ?dest where {?a "grandparent"@[] ?g};`, 1, 0},
+	}
+	for _, entry := range table {
+		tbl, err := runQuery(entry.q)
+		if err != nil {
+			t.Errorf("planner.Execute failed for query %q with error %v", entry.q, err)
+			continue
+		}
+		if got, want := len(tbl.Bindings()), entry.nbs; got != want {
+			t.Errorf("tbl.Bindings returned the wrong number of bindings for %q; got %d, want %d", entry.q, got, want)
+		}
+		if got, want := len(tbl.Rows()), entry.nrws; got != want {
+			t.Errorf("planner.Execute failed to return the expected number of rows for query %q; got %d want %d\nGot:\n%v\n", entry.q, got, want, tbl)
+		}
+	}
+}
+
+func TestPlannerQueryGraphBinding(t *testing.T) {
+	s, ctx := memory.NewStore(), context.Background()
+	populateStoreWithTriples(ctx, s, "?a", `/u<joe>	"knows"@[]	/u<mary>
+		/u<mary>	"knows"@[]	/u<ann>
+		`, t)
+	populateStoreWithTriples(ctx, s, "?b", `/u<joe>	"knows"@[]	/u<peter>
+		/u<peter>	"knows"@[]	/u<ann>
+		/u<mary>	"knows"@[]	/u<ann>
+		`, t)
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		t.Fatalf("grammar.NewParser: should have produced a valid BQL parser with error %v", err)
+	}
+	table := []struct {
+		q   string
+		out string
+	}{
+		{`select ?g, ?x, ?y from ?a, ?b where {graph ?g {?x "knows"@[] ?y}} order by ?g, ?x;`,
+			"?a /u<joe> /u<mary>\n?a /u<mary> /u<ann>\n?b /u<joe> /u<peter>\n?b /u<mary> /u<ann>\n?b /u<peter> /u<ann>\n"},
+		{`select ?g, ?y from ?a, ?b where {graph ?g {/u<joe> "knows"@[] ?y . ?y "knows"@[] /u<ann>}} order by ?g;`,
+			"?a /u<mary>\n?b /u<peter>\n"},
+		{`select ?g from ?a, ?b where {graph ?g {/u<mary> "knows"@[] /u<ann>}} order by ?g;`,
+			"?a\n?b\n"},
+		{`select ?g, ?h from ?a, ?b where {graph ?g {/u<joe> "knows"@[] ?y} . graph ?h {?y "knows"@[] /u<ann>}} order by ?g, ?h;`,
+			"?a ?a\n?a ?b\n?b ?b\n"},
+		{`select ?h from ?a, ?b where {/u<joe> "knows"@[] ?y . graph ?h {?y "knows"@[] /u<ann>}} order by ?h;`,
+			"?a\n?b\n?b\n"},
+	}
+	for _, entry := range table {
+		st := &semantic.Statement{}
+		if err := p.Parse(grammar.NewLLk(entry.q, 1), st); err != nil {
+			t.Errorf("Parser.consume: failed to parse query %q with error %v", entry.q, err)
+			continue
+		}
+		plnr, err := New(ctx, s, st, 0, 10, nil)
+		if err != nil {
+			t.Errorf("planner.New failed to create a valid query plan with error %v", err)
+			continue
+		}
+		tbl, err := plnr.Execute(ctx)
+		if err != nil {
+			t.Errorf("planner.Execute failed for query %q with error %v", entry.q, err)
+			continue
+		}
+		buf, err := tbl.ToText(" ")
+		if err != nil {
+			t.Fatalf("tbl.ToText failed with error %v", err)
+		}
+		got := buf.String()
+		if i := strings.Index(got, "\n"); i >= 0 {
+			got = got[i+1:]
+		}
+		if got != entry.out {
+			t.Errorf("planner.Execute returned the wrong rows for query %q;\ngot:\n%s\nwant:\n%s", entry.q, got, entry.out)
+		}
+	}
+}
+
+func TestPlannerStream(t *testing.T) {
+	s, ctx := memory.NewStore(), context.Background()
+	populateStoreWithTriples(ctx, s, "?test", testTriples, t)
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		t.Fatalf("grammar.NewParser: should have produced a valid BQL parser with error %v", err)
+	}
+	plan := func(q string) Executor {
+		st := &semantic.Statement{}
+		if err := p.Parse(grammar.NewLLk(q, 1), st); err != nil {
+			t.Fatalf("Parser.consume: failed to parse query %q with error %v", q, err)
+		}
+		plnr, err := New(ctx, s, st, 0, 10, nil)
+		if err != nil {
+			t.Fatalf("planner.New failed to create a valid query plan with error %v", err)
+		}
+		return plnr
+	}
+	rowsText := func(bs []string, rs []table.Row) []string {
+		var res []string
+		for _, r := range rs {
+			var buf bytes.Buffer
+			if err := r.ToTextLine(&buf, bs, "\t"); err != nil {
+				t.Fatalf("r.ToTextLine failed with error %v", err)
+			}
+			res = append(res, buf.String())
+		}
+		sort.Strings(res)
+		return res
+	}
+	for _, q := range []string{
+		`select ?s, ?p, ?o from ?test where {?s ?p ?o};`,
+		`select ?s as ?s1, ?o as ?o1 from ?test where {?s ?p ?o};`,
+		`select ?p, ?o from ?test where {/u<joe> ?p ?o};`,
+		`select ?s, ?o from ?test where {?s "parent_of"@[] ?o . ?o "parent_of"@[] ?x};`,
+		`select ?s, ?o from ?test where {?s ?p ?o . ?o ?p1 /t<car>};`,
+		`select ?s from ?test where {?s "parent_of"@[] /u<john> . /u<joe> "parent_of"@[] /u<mary>};`,
+		`select ?s, ?p, ?o from ?test where {?s ?p ?o} order by ?s;`,
+		`select ?p, count(?o) as ?n from ?test where {?s ?p ?o} group by ?p;`,
+	} {
+		tbl, err := plan(q).Execute(ctx)
+		if err != nil {
+			t.Fatalf("planner.Execute failed for query %q with error %v", q, err)
+		}
+		sp, ok := plan(q).(Streamer)
+		if !ok {
+			t.Fatalf("planner.New should return a Streamer for query %q", q)
+		}
+		var rs []table.Row
+		ch, errc := make(chan table.Row), make(chan error, 1)
+		go func() {
+			errc <- sp.Stream(ctx, ch)
+		}()
+		for r := range ch {
+			rs = append(rs, r)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("planner.Stream failed for query %q with error %v", q, err)
+		}
+		got, want := rowsText(sp.OutputBindings(), rs), rowsText(tbl.Bindings(), tbl.Rows())
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("planner.Stream returned the wrong rows for query %q;\ngot:\n%v\nwant:\n%v", q, got, want)
+		}
+	}
+
+	sp := plan(`select ?s, ?p, ?o from ?test where {?s ?p ?o . ?o ?p1 ?o1} limit "2"^^type:int64;`).(Streamer)
+	ch, errc := make(chan table.Row), make(chan error, 1)
+	go func() {
+		errc <- sp.Stream(ctx, ch)
+	}()
+	cnt := 0
+	for range ch {
+		cnt++
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("planner.Stream failed with error %v", err)
+	}
+	if cnt != 2 {
+		t.Errorf("planner.Stream returned %d rows; want 2", cnt)
+	}
+}
+
 // benchmarkQuery is a helper function that runs a specified query on the testing data set for benchmarking purposes.
 func benchmarkQuery(query string, b *testing.B) {
 	ctx := context.Background()
@@ -1035,3 +1272,109 @@ func BenchmarkReg2(b *testing.B) {
 func BenchmarkAs2(b *testing.B) {
 	benchmarkQuery(`select ?s as ?s1, ?p as ?p1, ?o as ?o1 from ?test where {?s ?p ?o};`, b)
 }
+
+func TestPlannerValidatesSchema(t *testing.T) {
+	ctx := context.Background()
+	s := schema.NewStore(memory.NewStore())
+	populateStoreWithTriples(ctx, s, "?src", constructTestSrcTriples, t)
+	if _, err := s.NewGraph(ctx, "?schema_dest"); err != nil {
+		t.Fatalf("memory.NewGraph failed to create \"?schema_dest\" with error %v", err)
+	}
+	pt, _ := node.NewType("/person")
+	if err := s.Register("?schema_dest", &schema.Schema{
+		Predicates: []*schema.Predicate{
+			{
+				ID:           "met",
+				SubjectTypes: []*node.Type{pt},
+				ObjectTypes:  []*node.Type{pt},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("schema.Store.Register failed with error %v", err)
+	}
+
+	testTable := []struct {
+		s    string
+		fail bool
+		trps int
+	}{
+		{
+			s:    `insert data into ?schema_dest {/city<A> "met"@[] /person<B>};`,
+			fail: true,
+		},
+		{
+			s:    `construct {?s "met"@[] ?o} into ?schema_dest from ?src where {?s "is_connected_to"@[] ?o};`,
+			fail: true,
+		},
+		{
+			s:    `insert data into ?schema_dest {/person<A> "met"@[] /person<B>};`,
+			trps: 1,
+		},
+		{
+			s:    `construct {?s "met"@[] ?o} into ?schema_dest from ?src where {?s "met"@[] ?o};`,
+			trps: 3,
+		},
+	}
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		t.Fatalf("grammar.NewParser: should have produced a valid BQL parser with error %v", err)
+	}
+	for _, entry := range testTable {
+		st := &semantic.Statement{}
+		if err := p.Parse(grammar.NewLLk(entry.s, 1), st); err != nil {
+			t.Fatalf("Parser.consume: failed to parse query %q with error %v", entry.s, err)
+		}
+		plnr, err := New(ctx, s, st, 0, 10, nil)
+		if err != nil {
+			t.Fatalf("planner.New failed to create a valid query plan with error %v", err)
+		}
+		_, err = plnr.Execute(ctx)
+		if entry.fail {
+			if err == nil || !strings.Contains(err.Error(), "schema validation") {
+				t.Errorf("planner.Execute should have failed schema validation for %q; got %v", entry.s, err)
+			}
+		} else if err != nil {
+			t.Errorf("planner.Execute failed for %q with error %v", entry.s, err)
+		}
+		g, err := s.Graph(ctx, "?schema_dest")
+		if err != nil {
+			t.Fatalf("s.Graph(%q) should have not fail with error %v", "?schema_dest", err)
+		}
+		i := 0
+		ts := make(chan *triple.Triple)
+		go func() {
+			if err := g.Triples(ctx, storage.DefaultLookup, ts); err != nil {
+				t.Error(err)
+			}
+		}()
+		for range ts {
+			i++
+		}
+		if i != entry.trps {
+			t.Errorf("g.Triples should have returned %v triples after %q, returned %v instead", entry.trps, entry.s, i)
+		}
+	}
+}
+
+func TestPlannerCanceledContext(t *testing.T) {
+	s, ctx := memory.NewStore(), context.Background()
+	populateStoreWithTriples(ctx, s, "?test", testTriples, t)
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		t.Fatalf("grammar.NewParser: should have produced a valid BQL parser with error %v", err)
+	}
+	q := `select ?s, ?o from ?test where {?s "parent_of"@[] ?o . ?o "parent_of"@[] ?x};`
+	st := &semantic.Statement{}
+	if err := p.Parse(grammar.NewLLk(q, 1), st); err != nil {
+		t.Fatalf("Parser.consume: failed to parse query %q with error %v", q, err)
+	}
+	plnr, err := New(ctx, s, st, 0, 10, nil)
+	if err != nil {
+		t.Fatalf("planner.New failed to create a valid query plan with error %v", err)
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := plnr.Execute(cctx); err != context.Canceled {
+		t.Errorf("planner.Execute(%q) with a canceled context returned error %v; want %v", q, err, context.Canceled)
+	}
+}
@@ -92,6 +92,18 @@ func WhereObjectClauseHook() ElementHook {
 	return whereObjectClause()
 }
 
+// WhereReifiedClauseHook returns the singleton for working clause hooks that
+// populates the reified statement binding.
+func WhereReifiedClauseHook() ElementHook {
+	return whereReifiedClause()
+}
+
+// WhereGraphClauseHook returns the singleton for working clause hooks that
+// track the graph binding of GRAPH blocks.
+func WhereGraphClauseHook() ElementHook {
+	return whereGraphClause()
+}
+
 // VarAccumulatorHook returns the singleton for accumulating variable
 // projections.
 func VarAccumulatorHook() ElementHook {
@@ -399,6 +411,20 @@ func whereSubjectClause() ElementHook {
 				lastNopToken = nil
 				return f, nil
 			}
+		case lexer.ItemNodeType:
+			if lastNopToken == nil || lastNopToken.Type != lexer.ItemCovariant {
+				return nil, fmt.Errorf("node type %q should be preceded by the COVARIANT keyword", tkn.Text)
+			}
+			if c.SCovariant != nil {
+				return nil, fmt.Errorf("COVARIANT type for subject has already being assigned on %v", st)
+			}
+			t, err := node.NewType(tkn.Text)
+			if err != nil {
+				return nil, err
+			}
+			c.SCovariant = t
+			lastNopToken = nil
+			return f, nil
 		}
 		lastNopToken = tkn
 		return f, nil
@@ -647,6 +673,20 @@ func whereObjectClause() ElementHook {
 				return nil, fmt.Errorf("binding %q found after invalid token %s", tkn.Text, lastNopToken)
 			}
 			return f, nil
+		case lexer.ItemNodeType:
+			if lastNopToken == nil || lastNopToken.Type != lexer.ItemCovariant {
+				return nil, fmt.Errorf("node type %q should be preceded by the COVARIANT keyword", tkn.Text)
+			}
+			if c.OCovariant != nil {
+				return nil, fmt.Errorf("COVARIANT type for object has already being assigned on %v", st)
+			}
+			t, err := node.NewType(tkn.Text)
+			if err != nil {
+				return nil, err
+			}
+			c.OCovariant = t
+			lastNopToken = nil
+			return f, nil
 		}
 		lastNopToken = tkn
 		return f, nil
@@ -654,6 +694,51 @@ func whereObjectClause() ElementHook {
 	return f
 }
 
+// whereReifiedClause returns an element hook that sets the binding of the
+// reified statement described by the working graph clause.
+func whereReifiedClause() ElementHook {
+	var f ElementHook
+	f = func(st *Statement, ce ConsumedElement) (ElementHook, error) {
+		if ce.IsSymbol() {
+			return f, nil
+		}
+		tkn := ce.Token()
+		if tkn.Type != lexer.ItemBinding {
+			return f, nil
+		}
+		c := st.WorkingClause()
+		if c.StatementBinding != "" {
+			return nil, fmt.Errorf("REIFIED AS binding has already being assigned on %v", st)
+		}
+		c.StatementBinding = tkn.Text
+		return f, nil
+	}
+	return f
+}
+
+// whereGraphClause returns an element hook that opens and closes GRAPH blocks
+// binding the name of the graph the enclosed clauses are retrieved from.
+func whereGraphClause() ElementHook {
+	var f ElementHook
+	f = func(st *Statement, ce ConsumedElement) (ElementHook, error) {
+		if ce.IsSymbol() {
+			return f, nil
+		}
+		tkn := ce.Token()
+		switch tkn.Type {
+		case lexer.ItemBinding:
+			if b := st.WorkingGraphBinding(); b != "" {
+				return nil, fmt.Errorf("GRAPH %s cannot be nested inside GRAPH %s", tkn.Text, b)
+			}
+			st.SetWorkingGraphBinding(tkn.Text)
+		case lexer.ItemRBracket:
+			st.SetWorkingGraphBinding("")
+		}
+		return f, nil
+	}
+	return f
+}
+
 // varAccumulator returns an element hook that updates the object
 // modifiers on the working graph clause.
 func varAccumulator() ElementHook {
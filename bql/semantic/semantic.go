@@ -92,6 +92,7 @@ type Statement struct {
 	data                      []*triple.Triple
 	pattern                   []*GraphClause
 	workingClause             *GraphClause
+	workingGraphBinding       string
 	constructClauses          []*ConstructClause
 	workingConstructClause    *ConstructClause
 	projection                []*Projection
@@ -112,6 +113,7 @@ type GraphClause struct {
 	SAlias     string
 	STypeAlias string
 	SIDAlias   string
+	SCovariant *node.Type
 
 	P                *predicate.Predicate
 	PID              string
@@ -139,6 +141,15 @@ type GraphClause struct {
 	OLowerBoundAlias string
 	OUpperBoundAlias string
 	OTemporal        bool
+	OCovariant       *node.Type
+
+	// StatementBinding binds the blank node of the reified statement
+	// described by the clause. See ReifiedClauses.
+	StatementBinding string
+
+	// GBinding binds the name of the graph the clause data is retrieved from,
+	// as specified by GRAPH ?g { ... } blocks.
+	GBinding string
 }
 
 // ConstructClause represents a singular clause within a construct statement.
@@ -175,6 +186,10 @@ func (c *GraphClause) String() string {
 	} else {
 		b.WriteString(c.SBinding)
 	}
+	if c.SCovariant != nil {
+		b.WriteString(" COVARIANT ")
+		b.WriteString(c.SCovariant.String())
+	}
 	if c.SAlias != "" {
 		b.WriteString(" AS ")
 		b.WriteString(c.SAlias)
@@ -257,6 +272,10 @@ func (c *GraphClause) String() string {
 		b.WriteString(c.OBinding)
 		object = true
 	}
+	if c.OCovariant != nil {
+		b.WriteString(" COVARIANT ")
+		b.WriteString(c.OCovariant.String())
+	}
 	if c.OAlias != "" {
 		b.WriteString(" AS ")
 		b.WriteString(c.OAlias)
@@ -320,10 +339,82 @@ func (c *GraphClause) String() string {
 		b.WriteString(c.OIDAlias)
 	}
 
+	if c.StatementBinding != "" {
+		b.WriteString(" REIFIED AS ")
+		b.WriteString(c.StatementBinding)
+	}
+	if c.GBinding != "" {
+		b.WriteString(" IN GRAPH ")
+		b.WriteString(c.GBinding)
+	}
+
 	b.WriteString(" }")
 	return b.String()
 }
 
+// ReifiedClauses returns the graph clauses that match the reification of the
+// statement described by the clause, as created by Triple.Reify or by
+// CONSTRUCT statements with multiple predicate-object pairs. The reified
+// statement node is bound to StatementBinding, and the _subject, _predicate,
+// and _object predicates are matched regardless of their time anchor. The
+// statement itself does not need to be asserted. The subject and predicate
+// modifiers of the clause are moved to the object of the _subject and
+// _predicate clauses respectively.
+func (c *GraphClause) ReifiedClauses() []*GraphClause {
+	sc := &GraphClause{
+		SBinding:   c.StatementBinding,
+		PID:        "_subject",
+		OBinding:   c.SBinding,
+		OAlias:     c.SAlias,
+		OTypeAlias: c.STypeAlias,
+		OIDAlias:   c.SIDAlias,
+		OCovariant: c.SCovariant,
+	}
+	if c.S != nil {
+		sc.O = triple.NewNodeObject(c.S)
+	}
+	pc := &GraphClause{
+		SBinding:         c.StatementBinding,
+		PID:              "_predicate",
+		OBinding:         c.PBinding,
+		OAlias:           c.PAlias,
+		OID:              c.PID,
+		OIDAlias:         c.PIDAlias,
+		OAnchorBinding:   c.PAnchorBinding,
+		OAnchorAlias:     c.PAnchorAlias,
+		OLowerBound:      c.PLowerBound,
+		OUpperBound:      c.PUpperBound,
+		OLowerBoundAlias: c.PLowerBoundAlias,
+		OUpperBoundAlias: c.PUpperBoundAlias,
+		OTemporal:        c.PTemporal,
+	}
+	if c.P != nil {
+		pc.O = triple.NewPredicateObject(c.P)
+	}
+	oc := &GraphClause{
+		SBinding:         c.StatementBinding,
+		PID:              "_object",
+		O:                c.O,
+		OBinding:         c.OBinding,
+		OAlias:           c.OAlias,
+		OID:              c.OID,
+		OTypeAlias:       c.OTypeAlias,
+		OIDAlias:         c.OIDAlias,
+		OAnchorBinding:   c.OAnchorBinding,
+		OAnchorAlias:     c.OAnchorAlias,
+		OLowerBound:      c.OLowerBound,
+		OUpperBound:      c.OUpperBound,
+		OLowerBoundAlias: c.OLowerBoundAlias,
+		OUpperBoundAlias: c.OUpperBoundAlias,
+		OTemporal:        c.OTemporal,
+		OCovariant:       c.OCovariant,
+	}
+	for _, rc := range []*GraphClause{sc, pc, oc} {
+		rc.GBinding = c.GBinding
+	}
+	return []*GraphClause{sc, pc, oc}
+}
+
 // Specificity return
 func (c *GraphClause) Specificity() int {
 	s := 0
@@ -362,6 +453,8 @@ func (c *GraphClause) BindingsMap() map[string]int {
 	addToBindings(bm, c.OAnchorBinding)
 	addToBindings(bm, c.OLowerBoundAlias)
 	addToBindings(bm, c.OUpperBoundAlias)
+	addToBindings(bm, c.StatementBinding)
+	addToBindings(bm, c.GBinding)
 
 	return bm
 }
@@ -584,11 +677,31 @@ func (s *Statement) WorkingClause() *GraphClause {
 	return s.workingClause
 }
 
+// WorkingGraphBinding returns the binding of the graph block currently being
+// processed, if any.
+func (s *Statement) WorkingGraphBinding() string {
+	return s.workingGraphBinding
+}
+
+// SetWorkingGraphBinding sets the binding of the graph block currently being
+// processed. Clauses added while it is set bind the name of the graph they
+// are retrieved from to it. An empty binding closes the block.
+func (s *Statement) SetWorkingGraphBinding(b string) {
+	s.workingGraphBinding = b
+}
+
 // AddWorkingGraphClause adds the current working graph clause to the set of
 // clauses that form the graph pattern.
 func (s *Statement) AddWorkingGraphClause() {
 	if s.workingClause != nil && !s.workingClause.IsEmpty() {
-		s.pattern = append(s.pattern, s.workingClause)
+		if s.workingGraphBinding != "" {
+			s.workingClause.GBinding = s.workingGraphBinding
+		}
+		if s.workingClause.StatementBinding != "" {
+			s.pattern = append(s.pattern, s.workingClause.ReifiedClauses()...)
+		} else {
+			s.pattern = append(s.pattern, s.workingClause)
+		}
 	}
 	s.ResetWorkingGraphClause()
 }
@@ -651,6 +764,7 @@ func (s *Statement) BindingsMap() map[string]int {
 			addToBindings(bm, cls.OAnchorBinding)
 			addToBindings(bm, cls.OLowerBoundAlias)
 			addToBindings(bm, cls.OUpperBoundAlias)
+			addToBindings(bm, cls.GBinding)
 		}
 	}
 	return bm
@@ -169,6 +169,48 @@ func TestIsEmptyClause(t *testing.T) {
 
 }
 
+func TestReifiedClauses(t *testing.T) {
+	p, err := predicate.Parse(`"knows"@[]`)
+	if err != nil {
+		t.Fatalf("predicate.Parse failed with error %v", err)
+	}
+	cls := &GraphClause{
+		SBinding:         "?s",
+		SAlias:           "?sa",
+		P:                p,
+		PAlias:           "?pa",
+		OBinding:         "?o",
+		OTypeAlias:       "?ot",
+		StatementBinding: "?st",
+	}
+	rcs := cls.ReifiedClauses()
+	if len(rcs) != 3 {
+		t.Fatalf("ReifiedClauses returned %d clauses; want 3", len(rcs))
+	}
+	for i, id := range []string{"_subject", "_predicate", "_object"} {
+		if rcs[i].SBinding != "?st" || rcs[i].PID != id || rcs[i].P != nil || rcs[i].PTemporal {
+			t.Errorf("ReifiedClauses returned invalid clause %v for %q", rcs[i], id)
+		}
+	}
+	if rcs[0].OBinding != "?s" || rcs[0].OAlias != "?sa" {
+		t.Errorf("ReifiedClauses failed to move the subject to the _subject object; got %v", rcs[0])
+	}
+	if op, err := rcs[1].O.Predicate(); err != nil || op != p || rcs[1].OAlias != "?pa" {
+		t.Errorf("ReifiedClauses failed to move the predicate to the _predicate object; got %v", rcs[1])
+	}
+	if rcs[2].OBinding != "?o" || rcs[2].OTypeAlias != "?ot" {
+		t.Errorf("ReifiedClauses failed to keep the object on the _object clause; got %v", rcs[2])
+	}
+
+	st := &Statement{}
+	st.ResetWorkingGraphClause()
+	*st.WorkingClause() = *cls
+	st.AddWorkingGraphClause()
+	if got := len(st.GraphPatternClauses()); got != 3 {
+		t.Errorf("AddWorkingGraphClause should have expanded the reified clause into 3 clauses; got %d", got)
+	}
+}
+
 func TestSortedGraphPatternClauses(t *testing.T) {
 	s := &Statement{
 		pattern: []*GraphClause{
@@ -303,3 +345,30 @@ func TestInputOutputBindings(t *testing.T) {
 		t.Errorf("s.OutputBindings returned the wrong output bindings; got %v, want %v", got, want)
 	}
 }
+
+func TestWorkingGraphBinding(t *testing.T) {
+	st := &Statement{}
+	st.ResetWorkingGraphClause()
+	st.SetWorkingGraphBinding("?g")
+	*st.WorkingClause() = GraphClause{SBinding: "?s", PBinding: "?p", OBinding: "?o", StatementBinding: "?st"}
+	st.AddWorkingGraphClause()
+	st.SetWorkingGraphBinding("")
+	*st.WorkingClause() = GraphClause{SBinding: "?s", PBinding: "?p", OBinding: "?o"}
+	st.AddWorkingGraphClause()
+	cls := st.GraphPatternClauses()
+	if len(cls) != 4 {
+		t.Fatalf("AddWorkingGraphClause returned %d clauses; want 4", len(cls))
+	}
+	for i, c := range cls {
+		want := "?g"
+		if i == 3 {
+			want = ""
+		}
+		if c.GBinding != want {
+			t.Errorf("AddWorkingGraphClause set graph binding %q on clause %v; want %q", c.GBinding, c, want)
+		}
+	}
+	if _, ok := st.BindingsMap()["?g"]; !ok {
+		t.Errorf("st.BindingsMap() should contain the graph binding ?g; got %v", st.BindingsMap())
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package standing provides continuous BQL queries. A standing query is a
+// SELECT statement registered against a change feed store. Every time the
+// triples it depends on are mutated the query is evaluated again and the rows
+// added to or removed from its result set are reported to the subscriber.
+//
+// Standing queries are not evaluated incrementally. Mutations of the graphs
+// and predicates a query depends on trigger a full evaluation of the query,
+// and its result set is compared with the previous one to compute the change.
+// Mutations already available when an evaluation starts are coalesced into
+// it, but the cost of every evaluation is still the cost of running the
+// query from scratch. Limits bounds that cost by failing queries whose result
+// sets grow too large or whose evaluations take too long, and lets callers
+// admit every evaluation against the other work of the process.
+package standing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/grammar"
+	"github.com/google/badwolf/bql/planner"
+	"github.com/google/badwolf/bql/semantic"
+	"github.com/google/badwolf/bql/table"
+	"github.com/google/badwolf/storage/feed"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// Change describes how the result set of a standing query changed.
+type Change struct {
+	// Seq is the sequence number of the last feed event reflected in the
+	// change.
+	Seq uint64
+	// Added contains the rows added to the result set.
+	Added []table.Row
+	// Removed contains the rows removed from the result set.
+	Removed []table.Row
+}
+
+// Limits bounds the cost of every evaluation of a standing query. Zero values
+// disable the corresponding bound.
+type Limits struct {
+	// MaxRows is the maximum number of rows of the result set.
+	MaxRows int
+	// Timeout is the maximum duration of an evaluation.
+	Timeout time.Duration
+	// Admit, if set, is called before every evaluation. The evaluation
+	// waits until it returns, and calls the returned function once done. The
+	// query fails if it returns an error.
+	Admit func(ctx context.Context) (func(), error)
+}
+
+// Query is a registered standing query.
+type Query struct {
+	// BQL contains the registered statement.
+	BQL string
+	// Changes receives the changes of the result set. The first change
+	// contains all the rows of the initial evaluation. It is closed when the
+	// query ends.
+	Changes <-chan *Change
+
+	s        *feed.Store
+	chanSize int
+	bulkSize int
+	limits   Limits
+
+	// Graphs and predicate IDs the query depends on. If any is set, any
+	// predicate does.
+	graphs   map[string]bool
+	triggers map[predicate.ID]bool
+	any      bool
+
+	bindings []string
+	rows     map[string]*rowCount
+
+	mu  sync.Mutex
+	err error
+}
+
+// rowCount keeps the number of times a row appears in the result set.
+type rowCount struct {
+	row table.Row
+	cnt int
+}
+
+// Register evaluates the provided SELECT statement against the store and
+// returns a standing query that reports how its result set changes as
+// triples are added to or removed from the queried graphs. Every evaluation
+// is bounded by the provided limits. The query ends when the context is done
+// or if it can no longer be evaluated within its limits.
+func Register(ctx context.Context, s *feed.Store, bql string, chanSize, bulkSize int, limits Limits) (*Query, error) {
+	stm, err := parse(bql)
+	if err != nil {
+		return nil, fmt.Errorf("standing.Register(%q): %v", bql, err)
+	}
+	if stm.Type() != semantic.Query {
+		return nil, fmt.Errorf("standing.Register(%q): only SELECT statements are supported; got %s", bql, stm.Type())
+	}
+	q := &Query{
+		BQL:      bql,
+		s:        s,
+		chanSize: chanSize,
+		bulkSize: bulkSize,
+		limits:   limits,
+		graphs:   make(map[string]bool),
+		triggers: make(map[predicate.ID]bool),
+		rows:     make(map[string]*rowCount),
+	}
+	for _, g := range stm.InputGraphNames() {
+		q.graphs[g] = true
+	}
+	for _, cls := range stm.GraphPatternClauses() {
+		switch {
+		case cls.P != nil:
+			q.triggers[cls.P.ID()] = true
+		case cls.PID != "":
+			q.triggers[predicate.ID(cls.PID)] = true
+		default:
+			q.any = true
+		}
+	}
+
+	// Subscribe before the initial evaluation so no mutation is missed.
+	seq := s.LastSeq()
+	sub, err := s.Subscribe(ctx, seq+1)
+	if err != nil {
+		return nil, fmt.Errorf("standing.Register(%q): %v", bql, err)
+	}
+	initial, err := q.evaluate(ctx, seq)
+	if err != nil {
+		return nil, fmt.Errorf("standing.Register(%q): %v", bql, err)
+	}
+
+	chgs := make(chan *Change, chanSize)
+	q.Changes = chgs
+	go q.run(ctx, sub, initial, chgs)
+	return q, nil
+}
+
+// Bindings returns the bindings of the rows reported by the query.
+func (q *Query) Bindings() []string {
+	return q.bindings
+}
+
+// Err returns the reason the query ended, if any. It returns nil if the query
+// ended because its context was done.
+func (q *Query) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// fail records the reason the query ended.
+func (q *Query) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.err = err
+}
+
+// run processes the feed events until the query ends.
+func (q *Query) run(ctx context.Context, sub *feed.Subscription, initial *Change, chgs chan<- *Change) {
+	defer close(chgs)
+	if !q.send(ctx, initial, chgs) {
+		return
+	}
+	for e := range sub.Events {
+		seq, relevant := e.Seq, q.relevant(e)
+		// Coalesce all the events already available into a single
+		// evaluation.
+	drain:
+		for {
+			select {
+			case ne, ok := <-sub.Events:
+				if !ok {
+					break drain
+				}
+				seq = ne.Seq
+				relevant = relevant || q.relevant(ne)
+			default:
+				break drain
+			}
+		}
+		if !relevant {
+			continue
+		}
+		c, err := q.evaluate(ctx, seq)
+		if err != nil {
+			if ctx.Err() == nil {
+				q.fail(err)
+			}
+			return
+		}
+		if len(c.Added) == 0 && len(c.Removed) == 0 {
+			continue
+		}
+		if !q.send(ctx, c, chgs) {
+			return
+		}
+	}
+	if err := sub.Err(); err != nil {
+		q.fail(err)
+	}
+}
+
+// send delivers the change unless the context is done first.
+func (q *Query) send(ctx context.Context, c *Change, chgs chan<- *Change) bool {
+	select {
+	case chgs <- c:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// relevant returns true if the event may change the result set of the query.
+func (q *Query) relevant(e *feed.Event) bool {
+	if !q.graphs[e.Graph] {
+		return false
+	}
+	if e.Triple == nil || q.any {
+		return true
+	}
+	return q.triggers[e.Triple.Predicate().ID()]
+}
+
+// evaluate runs the query and returns the difference with the previous
+// result set.
+func (q *Query) evaluate(ctx context.Context, seq uint64) (*Change, error) {
+	// Statements keep state once initialized, hence they need to be parsed
+	// for every execution.
+	stm, err := parse(q.BQL)
+	if err != nil {
+		return nil, err
+	}
+	if q.limits.Admit != nil {
+		release, err := q.limits.Admit(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	if q.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.limits.Timeout)
+		defer cancel()
+	}
+	pln, err := planner.New(ctx, q.s, stm, q.chanSize, q.bulkSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	bs, rs, err := q.execute(ctx, pln)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("evaluation exceeded the limit of %v", q.limits.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if q.bindings == nil {
+		q.bindings = bs
+	}
+
+	rows := make(map[string]*rowCount)
+	for _, r := range rs {
+		k := rowKey(r)
+		if rc, ok := rows[k]; ok {
+			rc.cnt++
+			continue
+		}
+		rows[k] = &rowCount{row: r, cnt: 1}
+	}
+	c := &Change{Seq: seq}
+	for k, rc := range rows {
+		old := 0
+		if orc, ok := q.rows[k]; ok {
+			old = orc.cnt
+		}
+		for i := old; i < rc.cnt; i++ {
+			c.Added = append(c.Added, rc.row)
+		}
+	}
+	for k, orc := range q.rows {
+		cur := 0
+		if rc, ok := rows[k]; ok {
+			cur = rc.cnt
+		}
+		for i := cur; i < orc.cnt; i++ {
+			c.Removed = append(c.Removed, orc.row)
+		}
+	}
+	q.rows = rows
+	return c, nil
+}
+
+// execute runs the plan and returns the bindings and rows of its result. It
+// stops as soon as the result exceeds the maximum number of rows.
+func (q *Query) execute(ctx context.Context, pln planner.Executor) ([]string, []table.Row, error) {
+	errRows := fmt.Errorf("result set exceeded the limit of %d rows", q.limits.MaxRows)
+	st, ok := pln.(planner.Streamer)
+	if !ok {
+		tbl, err := pln.Execute(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if q.limits.MaxRows > 0 && tbl.NumRows() > q.limits.MaxRows {
+			return nil, nil, errRows
+		}
+		return tbl.Bindings(), tbl.Rows(), nil
+	}
+
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rs, errc := make(chan table.Row, q.chanSize), make(chan error, 1)
+	go func() {
+		errc <- st.Stream(sctx, rs)
+	}()
+	var (
+		res  []table.Row
+		rErr error
+	)
+	for r := range rs {
+		if rErr != nil {
+			continue
+		}
+		if q.limits.MaxRows > 0 && len(res) >= q.limits.MaxRows {
+			rErr = errRows
+			cancel()
+			continue
+		}
+		res = append(res, r)
+	}
+	if err := <-errc; rErr == nil {
+		rErr = err
+	}
+	if rErr != nil {
+		return nil, nil, rErr
+	}
+	return st.OutputBindings(), res, nil
+}
+
+// rowKey returns a string that uniquely identifies the values of a row.
+func rowKey(r table.Row) string {
+	var bs []string
+	for b := range r {
+		bs = append(bs, b)
+	}
+	sort.Strings(bs)
+	var res []string
+	for _, b := range bs {
+		res = append(res, b+"="+r[b].String())
+	}
+	return strings.Join(res, "\x00")
+}
+
+// parse returns the semantic statement for the provided BQL query.
+func parse(bql string) (*semantic.Statement, error) {
+	p, err := grammar.NewParser(grammar.SemanticBQL())
+	if err != nil {
+		return nil, err
+	}
+	stm := &semantic.Statement{}
+	if err := p.Parse(grammar.NewLLk(bql, 1), stm); err != nil {
+		return nil, err
+	}
+	return stm, nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package standing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/table"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/feed"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func createTriples(t *testing.T, ss ...string) []*triple.Triple {
+	ts := []*triple.Triple{}
+	for _, s := range ss {
+		trpl, err := triple.Parse(s, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", s, err)
+		}
+		ts = append(ts, trpl)
+	}
+	return ts
+}
+
+func newGraph(ctx context.Context, t *testing.T, ss ...string) (*feed.Store, storage.Graph) {
+	s := feed.NewStore(memory.NewStore(), 0)
+	g, err := s.NewGraph(ctx, "?test")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	if err := g.AddTriples(ctx, createTriples(t, ss...)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	return s, g
+}
+
+func receive(t *testing.T, q *Query) *Change {
+	select {
+	case c, ok := <-q.Changes:
+		if !ok {
+			t.Fatalf("standing query ended with error %v; want more changes", q.Err())
+		}
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatalf("standing query timed out waiting for changes")
+	}
+	return nil
+}
+
+func checkRows(t *testing.T, kind string, rs []table.Row, binding string, want []string) {
+	got := make(map[string]bool)
+	for _, r := range rs {
+		got[r[binding].String()] = true
+	}
+	ok := len(got) == len(want)
+	for _, w := range want {
+		ok = ok && got[w]
+	}
+	if !ok {
+		t.Errorf("change %s the wrong %s values; got %v, want %v", kind, binding, got, want)
+	}
+}
+
+func checkChange(t *testing.T, c *Change, binding string, added, removed []string) {
+	checkRows(t, "added", c.Added, binding, added)
+	checkRows(t, "removed", c.Removed, binding, removed)
+}
+
+func TestSingleClause(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, g := newGraph(ctx, t, `/u<john> "knows"@[] /u<mary>`)
+	q, err := Register(ctx, s, `select ?o from ?test where {/u<john> "knows"@[] ?o};`, 0, 10, Limits{})
+	if err != nil {
+		t.Fatalf("standing.Register failed with error %v", err)
+	}
+	checkChange(t, receive(t, q), "?o", []string{"/u<mary>"}, nil)
+
+	// Irrelevant predicates do not change the result set.
+	if err := g.AddTriples(ctx, createTriples(t, `/u<john> "likes"@[] /u<peter>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	if err := g.AddTriples(ctx, createTriples(t, `/u<john> "knows"@[] /u<peter>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	checkChange(t, receive(t, q), "?o", []string{"/u<peter>"}, nil)
+
+	if err := g.RemoveTriples(ctx, createTriples(t, `/u<john> "knows"@[] /u<mary>`)); err != nil {
+		t.Fatalf("g.RemoveTriples failed with error %v", err)
+	}
+	checkChange(t, receive(t, q), "?o", nil, []string{"/u<mary>"})
+
+	cancel()
+	for range q.Changes {
+	}
+	if err := q.Err(); err != nil {
+		t.Errorf("canceled standing queries should not report errors; got %v", err)
+	}
+}
+
+func TestConjunctivePattern(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, g := newGraph(ctx, t)
+	q, err := Register(ctx, s, `select ?x, ?z from ?test where {?x "parent_of"@[] ?y . ?y "parent_of"@[] ?z};`, 0, 10, Limits{})
+	if err != nil {
+		t.Fatalf("standing.Register failed with error %v", err)
+	}
+	checkChange(t, receive(t, q), "?z", nil, nil)
+
+	// A single edge does not match, so no change is reported until the
+	// pattern is completed.
+	if err := g.AddTriples(ctx, createTriples(t, `/u<a> "parent_of"@[] /u<b>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	if err := g.AddTriples(ctx, createTriples(t, `/u<b> "parent_of"@[] /u<c>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	c := receive(t, q)
+	checkChange(t, c, "?z", []string{"/u<c>"}, nil)
+	checkChange(t, c, "?x", []string{"/u<a>"}, nil)
+
+	if err := g.AddTriples(ctx, createTriples(t, `/u<c> "parent_of"@[] /u<d>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	checkChange(t, receive(t, q), "?x", []string{"/u<b>"}, nil)
+
+	if err := g.RemoveTriples(ctx, createTriples(t, `/u<b> "parent_of"@[] /u<c>`)); err != nil {
+		t.Fatalf("g.RemoveTriples failed with error %v", err)
+	}
+	checkChange(t, receive(t, q), "?x", nil, []string{"/u<a>", "/u<b>"})
+
+	// Dropping the graph ends the query.
+	if err := s.DeleteGraph(ctx, "?test"); err != nil {
+		t.Fatalf("s.DeleteGraph failed with error %v", err)
+	}
+	for range q.Changes {
+	}
+	if q.Err() == nil {
+		t.Errorf("standing queries on dropped graphs should report an error")
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newGraph(ctx, t)
+	stms := []string{
+		`select ?o from ?test where {/u<john> "knows"@[] ?o}`,
+		`insert data into ?test {/u<john> "knows"@[] /u<mary>};`,
+		`select ?o from ?missing where {/u<john> "knows"@[] ?o};`,
+	}
+	for _, bql := range stms {
+		if _, err := Register(ctx, s, bql, 0, 10, Limits{}); err == nil {
+			t.Errorf("standing.Register(%q) should have failed", bql)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, g := newGraph(ctx, t, `/u<john> "knows"@[] /u<mary>`)
+	bql := `select ?o from ?test where {/u<john> "knows"@[] ?o};`
+	if _, err := Register(ctx, s, bql, 0, 10, Limits{Timeout: time.Nanosecond}); err == nil {
+		t.Errorf("standing.Register(%q) should have failed once its evaluation timed out", bql)
+	}
+
+	q, err := Register(ctx, s, bql, 0, 10, Limits{MaxRows: 1, Timeout: time.Minute})
+	if err != nil {
+		t.Fatalf("standing.Register failed with error %v", err)
+	}
+	checkChange(t, receive(t, q), "?o", []string{"/u<mary>"}, nil)
+	if err := g.AddTriples(ctx, createTriples(t, `/u<john> "knows"@[] /u<peter>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	for range q.Changes {
+	}
+	if err := q.Err(); err == nil || !strings.Contains(err.Error(), "limit of 1 rows") {
+		t.Errorf("standing queries exceeding the maximum number of rows should fail; got error %v", err)
+	}
+	if _, err := Register(ctx, s, bql, 0, 10, Limits{MaxRows: 1}); err == nil {
+		t.Errorf("standing.Register(%q) should have failed since the result exceeds the limit of rows", bql)
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, g := newGraph(ctx, t, `/u<john> "knows"@[] /u<mary>`)
+	bql := `select ?o from ?test where {/u<john> "knows"@[] ?o};`
+	admitted, released := make(chan bool, 10), make(chan bool, 10)
+	admit := func(ctx context.Context) (func(), error) {
+		admitted <- true
+		return func() { released <- true }, nil
+	}
+	q, err := Register(ctx, s, bql, 0, 10, Limits{Admit: admit})
+	if err != nil {
+		t.Fatalf("standing.Register failed with error %v", err)
+	}
+	checkChange(t, receive(t, q), "?o", []string{"/u<mary>"}, nil)
+	if err := g.AddTriples(ctx, createTriples(t, `/u<john> "knows"@[] /u<peter>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	checkChange(t, receive(t, q), "?o", []string{"/u<peter>"}, nil)
+	if got, want := len(admitted), 2; got != want {
+		t.Errorf("standing query evaluations were admitted %d times; want %d", got, want)
+	}
+	if got, want := len(released), 2; got != want {
+		t.Errorf("standing query evaluations were released %d times; want %d", got, want)
+	}
+
+	overloaded := func(ctx context.Context) (func(), error) {
+		return nil, errors.New("overloaded")
+	}
+	if _, err := Register(ctx, s, bql, 0, 10, Limits{Admit: overloaded}); err == nil {
+		t.Errorf("standing.Register(%q) should have failed since its evaluation was not admitted", bql)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// jsonNode contains the JSON representation of a node.
+type jsonNode struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// jsonPredicate contains the JSON representation of a predicate. Immutable
+// predicates have no anchor.
+type jsonPredicate struct {
+	ID     string `json:"id"`
+	Anchor string `json:"anchor,omitempty"`
+}
+
+// jsonLiteral contains the JSON representation of a literal.
+type jsonLiteral struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonCell contains the JSON representation of a cell. Only one of the
+// fields is set.
+type jsonCell struct {
+	String    *string        `json:"string,omitempty"`
+	Node      *jsonNode      `json:"node,omitempty"`
+	Predicate *jsonPredicate `json:"predicate,omitempty"`
+	Literal   *jsonLiteral   `json:"literal,omitempty"`
+	Anchor    string         `json:"anchor,omitempty"`
+}
+
+// CellToJSON returns the typed JSON representation of a cell. For instance:
+//
+//	{"string":"/u"}
+//	{"node":{"type":"/u","id":"john"}}
+//	{"predicate":{"id":"met","anchor":"2016-04-10T04:21:00Z"}}
+//	{"literal":{"type":"int64","value":42}}
+//	{"anchor":"2016-04-10T04:21:00Z"}
+//
+// Time anchors use RFC3339Nano, int64 and float64 literals are JSON numbers,
+// and blob literals are base64 encoded strings. Since JSON numbers cannot
+// represent them, NaN and infinite float64 values are encoded as the
+// strings "NaN", "+Inf", and "-Inf".
+func CellToJSON(c *Cell) ([]byte, error) {
+	jc := &jsonCell{}
+	switch {
+	case c.S != nil:
+		jc.String = c.S
+	case c.N != nil:
+		jc.Node = nodeToJSON(c.N)
+	case c.P != nil:
+		jc.Predicate = predicateToJSON(c.P)
+	case c.L != nil:
+		jl, err := literalToJSON(c.L)
+		if err != nil {
+			return nil, fmt.Errorf("table.CellToJSON: %v", err)
+		}
+		jc.Literal = jl
+	case c.T != nil:
+		jc.Anchor = c.T.Format(time.RFC3339Nano)
+	default:
+		return nil, fmt.Errorf("table.CellToJSON: empty cell")
+	}
+	return json.Marshal(jc)
+}
+
+// RowToJSON returns the typed JSON representation of the cells bound to the
+// provided bindings in the row, as returned by CellToJSON. Unbound cells are
+// omitted.
+func RowToJSON(bs []string, r Row) (map[string]json.RawMessage, error) {
+	jr := make(map[string]json.RawMessage)
+	for _, b := range bs {
+		c, ok := r[b]
+		if !ok {
+			continue
+		}
+		js, err := CellToJSON(c)
+		if err != nil {
+			return nil, err
+		}
+		jr[b] = js
+	}
+	return jr, nil
+}
+
+func nodeToJSON(n *node.Node) *jsonNode {
+	return &jsonNode{Type: n.Type().String(), ID: n.ID().String()}
+}
+
+func predicateToJSON(p *predicate.Predicate) *jsonPredicate {
+	jp := &jsonPredicate{ID: string(p.ID())}
+	if ta, err := p.TimeAnchor(); err == nil {
+		jp.Anchor = ta.Format(time.RFC3339Nano)
+	}
+	return jp
+}
+
+func literalToJSON(l *literal.Literal) (*jsonLiteral, error) {
+	var v interface{}
+	switch iv := l.Interface().(type) {
+	case float64:
+		switch {
+		case math.IsNaN(iv):
+			v = "NaN"
+		case math.IsInf(iv, 1):
+			v = "+Inf"
+		case math.IsInf(iv, -1):
+			v = "-Inf"
+		default:
+			v = json.Number(strconv.FormatFloat(iv, 'g', -1, 64))
+		}
+	default:
+		v = iv
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLiteral{Type: l.Type().String(), Value: raw}, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+func TestCellToJSON(t *testing.T) {
+	b := literal.DefaultBuilder()
+	n, err := node.Parse("/u<john>")
+	if err != nil {
+		t.Fatalf("node.Parse failed with error %v", err)
+	}
+	p, err := predicate.Parse(`"met"@[2016-04-10T04:21:00Z]`)
+	if err != nil {
+		t.Fatalf("predicate.Parse failed with error %v", err)
+	}
+	i, err := b.Build(literal.Int64, int64(42))
+	if err != nil {
+		t.Fatalf("literal.Build failed with error %v", err)
+	}
+	txt, err := b.Build(literal.Text, "a \"b\"\n")
+	if err != nil {
+		t.Fatalf("literal.Build failed with error %v", err)
+	}
+	nan, err := b.Build(literal.Float64, math.NaN())
+	if err != nil {
+		t.Fatalf("literal.Build failed with error %v", err)
+	}
+	ta, err := p.TimeAnchor()
+	if err != nil {
+		t.Fatalf("p.TimeAnchor failed with error %v", err)
+	}
+	for _, entry := range []struct {
+		c    *Cell
+		want string
+	}{
+		{&Cell{S: CellString("/u")}, `{"string":"/u"}`},
+		{&Cell{N: n}, `{"node":{"type":"/u","id":"john"}}`},
+		{&Cell{P: p}, `{"predicate":{"id":"met","anchor":"2016-04-10T04:21:00Z"}}`},
+		{&Cell{L: i}, `{"literal":{"type":"int64","value":42}}`},
+		{&Cell{L: txt}, `{"literal":{"type":"text","value":"a \"b\"\n"}}`},
+		{&Cell{L: nan}, `{"literal":{"type":"float64","value":"NaN"}}`},
+		{&Cell{T: ta}, `{"anchor":"2016-04-10T04:21:00Z"}`},
+	} {
+		got, err := CellToJSON(entry.c)
+		if err != nil {
+			t.Errorf("table.CellToJSON(%v) failed with error %v", entry.c, err)
+			continue
+		}
+		if string(got) != entry.want {
+			t.Errorf("table.CellToJSON(%v) returned %s; want %s", entry.c, got, entry.want)
+		}
+	}
+	if _, err := CellToJSON(&Cell{}); err == nil {
+		t.Errorf("table.CellToJSON should have failed for an empty cell")
+	}
+}
+
+func TestRowToJSON(t *testing.T) {
+	n, err := node.Parse("/u<john>")
+	if err != nil {
+		t.Fatalf("node.Parse failed with error %v", err)
+	}
+	jr, err := RowToJSON([]string{"?n", "?missing"}, Row{"?n": &Cell{N: n}, "?other": &Cell{N: n}})
+	if err != nil {
+		t.Fatalf("table.RowToJSON failed with error %v", err)
+	}
+	got, err := json.Marshal(jr)
+	if err != nil {
+		t.Fatalf("json.Marshal failed with error %v", err)
+	}
+	if want := `{"?n":{"node":{"type":"/u","id":"john"}}}`; string(got) != want {
+		t.Errorf("table.RowToJSON returned %s; want %s", got, want)
+	}
+}
@@ -16,6 +16,7 @@
 package table
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
@@ -798,66 +799,14 @@ func (t *Table) String() string {
 	return b.String()
 }
 
-// ToJSON convert the table intotext versions. It requires the
-// separator to be used between cells JSON.
-func (t *Table) ToJSON(w io.Writer) {
-	w.Write([]byte(`{ "bindings": [`))
-
-	if len(t.AvailableBindings) > 0 {
-		w.Write([]byte(`"`))
-		w.Write([]byte(strings.Join(t.AvailableBindings, `", "`)))
-		w.Write([]byte(`"`))
-	}
-
-	w.Write([]byte(`], "rows": [`))
-
-	rc := len(t.Data)
-	for _, r := range t.Data {
-		if len(r) > 0 {
-			w.Write([]byte(`{ `))
-
-			cc := len(t.AvailableBindings)
-			for _, k := range t.AvailableBindings {
-				if k != "" {
-					c := r[k]
-					w.Write([]byte(`"`))
-					w.Write([]byte(k))
-					w.Write([]byte(`": {"`))
-
-					if c.S != nil {
-						w.Write([]byte(`string": "`))
-						w.Write([]byte(strings.Replace(*c.S, `"`, `\"`, -1)))
-					} else if c.N != nil {
-						w.Write([]byte(`node": "`))
-						w.Write([]byte(strings.Replace(c.N.String(), `"`, `\"`, -1)))
-					} else if c.P != nil {
-						w.Write([]byte(`pred": "`))
-						w.Write([]byte(strings.Replace(c.P.String(), `"`, `\"`, -1)))
-
-					} else if c.L != nil {
-						w.Write([]byte(`lit": "`))
-						w.Write([]byte(strings.Replace(c.L.String(), `"`, `\"`, -1)))
-
-					} else if c.T != nil {
-						w.Write([]byte(`anchor": "`))
-						w.Write([]byte(strings.Replace(c.T.Format(time.RFC3339Nano), `"`, `\"`, -1)))
-					}
-
-					w.Write([]byte(`"}`))
-					if cc > 1 {
-						w.Write([]byte(`,`))
-					}
-					w.Write([]byte(` `))
-				}
-				cc--
-			}
-			w.Write([]byte(` }`))
-			if rc > 1 {
-				w.Write([]byte(`, `))
-			}
-		}
-		rc--
-	}
-
-	w.Write([]byte(`] }`))
+// ToJSON writes the JSON representation of the table to the writer. The
+// bindings are listed under "bindings" and the rows under "rows". Each row
+// maps bindings to an object containing the string version of the cell
+// under one of the "string", "node", "pred", "lit", or "anchor" fields
+// depending on the cell type. Time anchors use RFC3339Nano.
+//
+// This untyped representation is kept for compatibility. NewJSONWriter
+// writes typed cells instead.
+func (t *Table) ToJSON(w io.Writer) error {
+	return t.Write(&jsonWriter{w: bufio.NewWriter(w), encode: untypedRowToJSON})
 }
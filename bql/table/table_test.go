@@ -16,6 +16,7 @@ package table
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -1134,3 +1135,36 @@ func TestFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestTableToJSON(t *testing.T) {
+	tbl, err := New([]string{"?s", "?l"})
+	if err != nil {
+		t.Fatalf("table.New failed with error %v", err)
+	}
+	n, err := node.Parse("/u<john \"j\" doe>")
+	if err != nil {
+		t.Fatalf("node.Parse failed with error %v", err)
+	}
+	l, err := literal.DefaultBuilder().Build(literal.Text, "line\n\"quoted\"\t\\ and \x01")
+	if err != nil {
+		t.Fatalf("literal.Build failed with error %v", err)
+	}
+	tbl.AddRow(Row{"?s": &Cell{N: n}, "?l": &Cell{L: l}})
+	var buf bytes.Buffer
+	if err := tbl.ToJSON(&buf); err != nil {
+		t.Fatalf("tbl.ToJSON failed with error %v", err)
+	}
+	var got struct {
+		Bindings []string                       `json:"bindings"`
+		Rows     []map[string]map[string]string `json:"rows"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("tbl.ToJSON returned invalid JSON %s; %v", buf.String(), err)
+	}
+	if !reflect.DeepEqual(got.Bindings, []string{"?s", "?l"}) {
+		t.Errorf("tbl.ToJSON returned the wrong bindings; got %v", got.Bindings)
+	}
+	if len(got.Rows) != 1 || got.Rows[0]["?s"]["node"] != n.String() || got.Rows[0]["?l"]["lit"] != l.String() {
+		t.Errorf("tbl.ToJSON returned the wrong rows; got %v", got.Rows)
+	}
+}
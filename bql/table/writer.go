@@ -0,0 +1,513 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Writer writes the rows of a result as they are produced. WriteHeader is
+// called once with the bindings of the result before WriteRow is called for
+// each of its rows. Flush must be called once all rows have been written.
+type Writer interface {
+	// WriteHeader writes the bindings of the rows that follow.
+	WriteHeader(bs []string) error
+	// WriteRow writes a row of the result.
+	WriteRow(r Row) error
+	// Flush completes the output.
+	Flush() error
+}
+
+// Supported output formats.
+const (
+	// ASCII aligns the columns of the result and draws their borders.
+	ASCII = "ascii"
+	// CSV writes comma separated values as described in RFC 4180.
+	CSV = "csv"
+	// TSV writes tab separated values, escaping tabs, new lines, and
+	// backslashes in cells.
+	TSV = "tsv"
+	// JSON writes a single object with typed cells as returned by
+	// CellToJSON.
+	JSON = "json"
+	// NDJSON writes a JSON object per row.
+	NDJSON = "ndjson"
+	// Markdown writes a GitHub flavored markdown table.
+	Markdown = "markdown"
+)
+
+// Formats lists the supported output formats.
+var Formats = []string{ASCII, CSV, TSV, JSON, NDJSON, Markdown}
+
+// DefaultMaxWidth is the default maximum width of the columns of ASCII
+// tables.
+const DefaultMaxWidth = 60
+
+// NewWriter returns a writer for the provided format. The maximum width only
+// applies to ASCII tables; see NewASCIIWriter.
+func NewWriter(w io.Writer, format string, maxWidth int) (Writer, error) {
+	switch format {
+	case ASCII:
+		return NewASCIIWriter(w, maxWidth), nil
+	case CSV:
+		return NewCSVWriter(w), nil
+	case TSV:
+		return NewTSVWriter(w), nil
+	case JSON:
+		return NewJSONWriter(w), nil
+	case NDJSON:
+		return NewNDJSONWriter(w), nil
+	case Markdown:
+		return NewMarkdownWriter(w), nil
+	default:
+		return nil, fmt.Errorf("table.NewWriter: unknown format %q; valid formats are %q", format, Formats)
+	}
+}
+
+// Write writes the bindings and the rows of the table to the writer and
+// flushes it.
+func (t *Table) Write(w Writer) error {
+	var bs []string
+	for _, b := range t.AvailableBindings {
+		if b != "" {
+			bs = append(bs, b)
+		}
+	}
+	if err := w.WriteHeader(bs); err != nil {
+		return err
+	}
+	for _, r := range t.Data {
+		if len(r) == 0 {
+			continue
+		}
+		if err := w.WriteRow(r); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Collector is a writer that collects the rows written into a table.
+type Collector struct {
+	t *Table
+}
+
+// WriteHeader creates the table with the provided bindings.
+func (c *Collector) WriteHeader(bs []string) error {
+	t, err := New(bs)
+	if err != nil {
+		return err
+	}
+	c.t = t
+	return nil
+}
+
+// WriteRow adds the row to the table.
+func (c *Collector) WriteRow(r Row) error {
+	c.t.AddRow(r)
+	return nil
+}
+
+// Flush does nothing since rows are added to the table as they are written.
+func (c *Collector) Flush() error {
+	return nil
+}
+
+// Table returns the collected table. It is nil if no header was written.
+func (c *Collector) Table() *Table {
+	return c.t
+}
+
+// cellText returns the text of the cell bound to b in the row.
+func cellText(r Row, b string) string {
+	if c, ok := r[b]; ok {
+		return c.String()
+	}
+	return "<NULL>"
+}
+
+// asciiWriter buffers the rows of a result to align its columns.
+type asciiWriter struct {
+	w        io.Writer
+	maxWidth int
+	bs       []string
+	rows     [][]string
+}
+
+// NewASCIIWriter returns a writer that draws the result as a table with
+// aligned columns. Cells wider than maxWidth runes are truncated; a
+// maxWidth of zero disables truncation. Rows are buffered until Flush is
+// called. Results without bindings are not written.
+func NewASCIIWriter(w io.Writer, maxWidth int) Writer {
+	return &asciiWriter{w: w, maxWidth: maxWidth}
+}
+
+// asciiEscaper escapes the characters that would break the alignment of
+// ASCII tables.
+var asciiEscaper = strings.NewReplacer("\n", `\n`, "\t", `\t`, "\r", `\r`)
+
+// truncate returns the printable version of the text limited to the
+// maximum width.
+func (a *asciiWriter) truncate(s string) string {
+	s = asciiEscaper.Replace(s)
+	if a.maxWidth <= 0 || utf8.RuneCountInString(s) <= a.maxWidth {
+		return s
+	}
+	if a.maxWidth <= 3 {
+		return string([]rune(s)[:a.maxWidth])
+	}
+	return string([]rune(s)[:a.maxWidth-3]) + "..."
+}
+
+// WriteHeader records the bindings of the result.
+func (a *asciiWriter) WriteHeader(bs []string) error {
+	a.bs = bs
+	return nil
+}
+
+// WriteRow buffers the row.
+func (a *asciiWriter) WriteRow(r Row) error {
+	cs := make([]string, 0, len(a.bs))
+	for _, b := range a.bs {
+		cs = append(cs, a.truncate(cellText(r, b)))
+	}
+	a.rows = append(a.rows, cs)
+	return nil
+}
+
+// Flush writes the table.
+func (a *asciiWriter) Flush() error {
+	if len(a.bs) == 0 {
+		return nil
+	}
+	ws := make([]int, len(a.bs))
+	hdr := make([]string, len(a.bs))
+	for i, b := range a.bs {
+		hdr[i] = a.truncate(b)
+		ws[i] = utf8.RuneCountInString(hdr[i])
+	}
+	for _, r := range a.rows {
+		for i, c := range r {
+			if n := utf8.RuneCountInString(c); n > ws[i] {
+				ws[i] = n
+			}
+		}
+	}
+	bw := bufio.NewWriter(a.w)
+	sep := func() {
+		for _, w := range ws {
+			bw.WriteString("+" + strings.Repeat("-", w+2))
+		}
+		bw.WriteString("+\n")
+	}
+	line := func(cs []string) {
+		for i, c := range cs {
+			bw.WriteString("| " + c + strings.Repeat(" ", ws[i]-utf8.RuneCountInString(c)) + " ")
+		}
+		bw.WriteString("|\n")
+	}
+	sep()
+	line(hdr)
+	sep()
+	for _, r := range a.rows {
+		line(r)
+	}
+	if len(a.rows) > 0 {
+		sep()
+	}
+	a.rows = nil
+	return bw.Flush()
+}
+
+// csvWriter writes comma separated values.
+type csvWriter struct {
+	w  *csv.Writer
+	bs []string
+}
+
+// NewCSVWriter returns a writer that writes the bindings and the rows of the
+// result as comma separated values. Unbound cells are left empty. Results
+// without bindings are not written.
+func NewCSVWriter(w io.Writer) Writer {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+// WriteHeader writes the bindings as the first record.
+func (c *csvWriter) WriteHeader(bs []string) error {
+	c.bs = bs
+	if len(bs) == 0 {
+		return nil
+	}
+	return c.w.Write(bs)
+}
+
+// WriteRow writes the row as a record.
+func (c *csvWriter) WriteRow(r Row) error {
+	rec := make([]string, 0, len(c.bs))
+	for _, b := range c.bs {
+		v := ""
+		if cl, ok := r[b]; ok {
+			v = cl.String()
+		}
+		rec = append(rec, v)
+	}
+	return c.w.Write(rec)
+}
+
+// Flush writes any buffered record.
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// tsvWriter writes tab separated values.
+type tsvWriter struct {
+	w  *bufio.Writer
+	bs []string
+}
+
+// tsvEscaper escapes the characters that cannot appear in TSV cells.
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// NewTSVWriter returns a writer that writes the bindings and the rows of the
+// result as tab separated values. Unbound cells are left empty. Results
+// without bindings are not written.
+func NewTSVWriter(w io.Writer) Writer {
+	return &tsvWriter{w: bufio.NewWriter(w)}
+}
+
+// line writes the escaped values as a line.
+func (t *tsvWriter) line(vs []string) error {
+	for i, v := range vs {
+		if i > 0 {
+			t.w.WriteByte('\t')
+		}
+		t.w.WriteString(tsvEscaper.Replace(v))
+	}
+	return t.w.WriteByte('\n')
+}
+
+// WriteHeader writes the bindings as the first line.
+func (t *tsvWriter) WriteHeader(bs []string) error {
+	t.bs = bs
+	if len(bs) == 0 {
+		return nil
+	}
+	return t.line(bs)
+}
+
+// WriteRow writes the row as a line.
+func (t *tsvWriter) WriteRow(r Row) error {
+	vs := make([]string, 0, len(t.bs))
+	for _, b := range t.bs {
+		v := ""
+		if c, ok := r[b]; ok {
+			v = c.String()
+		}
+		vs = append(vs, v)
+	}
+	return t.line(vs)
+}
+
+// Flush writes any buffered line.
+func (t *tsvWriter) Flush() error {
+	return t.w.Flush()
+}
+
+// untypedCellToJSON returns the untyped JSON friendly version of the cell
+// used by ToJSON.
+func untypedCellToJSON(c *Cell) map[string]string {
+	jc := make(map[string]string)
+	switch {
+	case c.S != nil:
+		jc["string"] = *c.S
+	case c.N != nil:
+		jc["node"] = c.N.String()
+	case c.P != nil:
+		jc["pred"] = c.P.String()
+	case c.L != nil:
+		jc["lit"] = c.L.String()
+	case c.T != nil:
+		jc["anchor"] = c.T.Format(time.RFC3339Nano)
+	}
+	return jc
+}
+
+// untypedRowToJSON returns the untyped JSON encoding of the cells bound in
+// the row used by ToJSON.
+func untypedRowToJSON(bs []string, r Row) ([]byte, error) {
+	jr := make(map[string]map[string]string)
+	for _, b := range bs {
+		if c, ok := r[b]; ok {
+			jr[b] = untypedCellToJSON(c)
+		}
+	}
+	return json.Marshal(jr)
+}
+
+// typedRowToJSON returns the JSON encoding of the row as returned by
+// RowToJSON.
+func typedRowToJSON(bs []string, r Row) ([]byte, error) {
+	jr, err := RowToJSON(bs, r)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jr)
+}
+
+// jsonWriter writes the result as a single JSON object.
+type jsonWriter struct {
+	w      *bufio.Writer
+	encode func(bs []string, r Row) ([]byte, error)
+	bs     []string
+	rows   int
+}
+
+// NewJSONWriter returns a writer that writes the result as a single JSON
+// object listing the bindings under "bindings" and the rows under "rows".
+// Each row maps its bound bindings to the typed cells returned by
+// CellToJSON. Rows are written as they are produced.
+func NewJSONWriter(w io.Writer) Writer {
+	return &jsonWriter{w: bufio.NewWriter(w), encode: typedRowToJSON}
+}
+
+// WriteHeader opens the object and writes the bindings.
+func (j *jsonWriter) WriteHeader(bs []string) error {
+	if bs == nil {
+		bs = []string{}
+	}
+	j.bs = bs
+	b, err := json.Marshal(bs)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(j.w, `{"bindings":%s,"rows":[`, b)
+	return err
+}
+
+// WriteRow writes the row as an element of the rows array.
+func (j *jsonWriter) WriteRow(r Row) error {
+	b, err := j.encode(j.bs, r)
+	if err != nil {
+		return err
+	}
+	if j.rows > 0 {
+		j.w.WriteByte(',')
+	}
+	j.rows++
+	_, err = j.w.Write(b)
+	return err
+}
+
+// Flush closes the object.
+func (j *jsonWriter) Flush() error {
+	if _, err := j.w.WriteString("]}\n"); err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
+
+// ndjsonWriter writes a JSON object per row.
+type ndjsonWriter struct {
+	w  *bufio.Writer
+	bs []string
+}
+
+// NewNDJSONWriter returns a writer that writes each row as a JSON object on
+// its own line, using the typed cells of NewJSONWriter. The bindings are not
+// written.
+func NewNDJSONWriter(w io.Writer) Writer {
+	return &ndjsonWriter{w: bufio.NewWriter(w)}
+}
+
+// WriteHeader records the bindings of the result.
+func (n *ndjsonWriter) WriteHeader(bs []string) error {
+	n.bs = bs
+	return nil
+}
+
+// WriteRow writes the row as a line.
+func (n *ndjsonWriter) WriteRow(r Row) error {
+	b, err := typedRowToJSON(n.bs, r)
+	if err != nil {
+		return err
+	}
+	n.w.Write(b)
+	return n.w.WriteByte('\n')
+}
+
+// Flush writes any buffered line.
+func (n *ndjsonWriter) Flush() error {
+	return n.w.Flush()
+}
+
+// markdownWriter writes the result as a markdown table.
+type markdownWriter struct {
+	w  *bufio.Writer
+	bs []string
+}
+
+// markdownEscaper escapes the characters that would break a markdown table
+// row.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", "<br>", "\r", "")
+
+// NewMarkdownWriter returns a writer that writes the result as a GitHub
+// flavored markdown table. Results without bindings are not written.
+func NewMarkdownWriter(w io.Writer) Writer {
+	return &markdownWriter{w: bufio.NewWriter(w)}
+}
+
+// line writes the escaped values as a table row.
+func (m *markdownWriter) line(vs []string) error {
+	for _, v := range vs {
+		m.w.WriteString("| " + markdownEscaper.Replace(v) + " ")
+	}
+	_, err := m.w.WriteString("|\n")
+	return err
+}
+
+// WriteHeader writes the bindings as the table header.
+func (m *markdownWriter) WriteHeader(bs []string) error {
+	m.bs = bs
+	if len(bs) == 0 {
+		return nil
+	}
+	if err := m.line(bs); err != nil {
+		return err
+	}
+	_, err := m.w.WriteString(strings.Repeat("| --- ", len(bs)) + "|\n")
+	return err
+}
+
+// WriteRow writes the row as a table row.
+func (m *markdownWriter) WriteRow(r Row) error {
+	vs := make([]string, 0, len(m.bs))
+	for _, b := range m.bs {
+		vs = append(vs, cellText(r, b))
+	}
+	return m.line(vs)
+}
+
+// Flush writes any buffered row.
+func (m *markdownWriter) Flush() error {
+	return m.w.Flush()
+}
@@ -0,0 +1,221 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/badwolf/triple/node"
+)
+
+// testWriterTable returns a table with a missing cell and cells containing
+// characters that require escaping.
+func testWriterTable(t *testing.T) *Table {
+	tbl, err := New([]string{"?a", "?b"})
+	if err != nil {
+		t.Fatalf("table.New failed with error %v", err)
+	}
+	s1, s2, s3 := "foo", "a,\"b\"|c\td\ne\\", "x"
+	tbl.AddRow(Row{"?a": &Cell{S: &s1}, "?b": &Cell{S: &s2}})
+	tbl.AddRow(Row{"?a": &Cell{S: &s3}})
+	return tbl
+}
+
+func TestWriterFormats(t *testing.T) {
+	table := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: ASCII,
+			want: "+-----+----------------+\n" +
+				"| ?a  | ?b             |\n" +
+				"+-----+----------------+\n" +
+				"| foo | a,\"b\"|c\\td\\ne\\ |\n" +
+				"| x   | <NULL>         |\n" +
+				"+-----+----------------+\n",
+		},
+		{
+			format: CSV,
+			want:   "?a,?b\nfoo,\"a,\"\"b\"\"|c\td\ne\\\"\nx,\n",
+		},
+		{
+			format: TSV,
+			want:   "?a\t?b\nfoo\ta,\"b\"|c\\td\\ne\\\\\nx\t\n",
+		},
+		{
+			format: NDJSON,
+			want: `{"?a":{"string":"foo"},"?b":{"string":"a,\"b\"|c\td\ne\\"}}` + "\n" +
+				`{"?a":{"string":"x"}}` + "\n",
+		},
+		{
+			format: Markdown,
+			want: "| ?a | ?b |\n| --- | --- |\n" +
+				"| foo | a,\"b\"\\|c\td<br>e\\\\ |\n" +
+				"| x | <NULL> |\n",
+		},
+	}
+	for _, entry := range table {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, entry.format, DefaultMaxWidth)
+		if err != nil {
+			t.Fatalf("table.NewWriter(_, %q, _) failed with error %v", entry.format, err)
+		}
+		if err := testWriterTable(t).Write(w); err != nil {
+			t.Fatalf("tbl.Write failed for format %q with error %v", entry.format, err)
+		}
+		if got := buf.String(); got != entry.want {
+			t.Errorf("tbl.Write for format %q returned\n%s\nwant\n%s", entry.format, got, entry.want)
+		}
+	}
+}
+
+func TestJSONWritersUseTypedCells(t *testing.T) {
+	tbl := testWriterTable(t)
+	n, err := node.Parse("/u<john>")
+	if err != nil {
+		t.Fatalf("node.Parse failed with error %v", err)
+	}
+	tbl.AddRow(Row{"?a": &Cell{N: n}})
+	row := `{"?a":{"node":{"type":"/u","id":"john"}}}`
+
+	var buf bytes.Buffer
+	if err := tbl.Write(NewJSONWriter(&buf)); err != nil {
+		t.Fatalf("tbl.Write failed with error %v", err)
+	}
+	var got struct {
+		Bindings []string          `json:"bindings"`
+		Rows     []json.RawMessage `json:"rows"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("JSON writer returned invalid JSON %s; %v", buf.String(), err)
+	}
+	if !reflect.DeepEqual(got.Bindings, []string{"?a", "?b"}) {
+		t.Errorf("JSON writer returned the wrong bindings; got %v", got.Bindings)
+	}
+	if len(got.Rows) != 3 || string(got.Rows[1]) != `{"?a":{"string":"x"}}` || string(got.Rows[2]) != row {
+		t.Errorf("JSON writer returned the wrong rows; got %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := tbl.Write(NewNDJSONWriter(&buf)); err != nil {
+		t.Fatalf("tbl.Write failed with error %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 || lines[2] != row {
+		t.Errorf("NDJSON writer returned\n%s\nwant the last line to be %s", buf.String(), row)
+	}
+}
+
+func TestToJSONKeepsUntypedCells(t *testing.T) {
+	var buf bytes.Buffer
+	empty, err := New(nil)
+	if err != nil {
+		t.Fatalf("table.New failed with error %v", err)
+	}
+	if err := empty.ToJSON(&buf); err != nil {
+		t.Fatalf("tbl.ToJSON failed with error %v", err)
+	}
+	if got, want := buf.String(), "{\"bindings\":[],\"rows\":[]}\n"; got != want {
+		t.Errorf("tbl.ToJSON for an empty table returned %q; want %q", got, want)
+	}
+
+	tbl, err := New([]string{"?n"})
+	if err != nil {
+		t.Fatalf("table.New failed with error %v", err)
+	}
+	n, err := node.Parse("/u<john>")
+	if err != nil {
+		t.Fatalf("node.Parse failed with error %v", err)
+	}
+	tbl.AddRow(Row{"?n": &Cell{N: n}})
+	buf.Reset()
+	if err := tbl.ToJSON(&buf); err != nil {
+		t.Fatalf("tbl.ToJSON failed with error %v", err)
+	}
+	if got, want := buf.String(), "{\"bindings\":[\"?n\"],\"rows\":[{\"?n\":{\"node\":\"/u\\u003cjohn\\u003e\"}}]}\n"; got != want {
+		t.Errorf("tbl.ToJSON returned %q; want %q", got, want)
+	}
+}
+
+func TestASCIIWriterTruncates(t *testing.T) {
+	tbl, err := New([]string{"?long"})
+	if err != nil {
+		t.Fatalf("table.New failed with error %v", err)
+	}
+	s := "abcdefghij"
+	tbl.AddRow(Row{"?long": &Cell{S: &s}})
+	table := []struct {
+		maxWidth int
+		want     string
+	}{
+		{0, "| abcdefghij |\n"},
+		{8, "| abcde... |\n"},
+		{3, "| abc |\n"},
+	}
+	for _, entry := range table {
+		var buf bytes.Buffer
+		if err := tbl.Write(NewASCIIWriter(&buf, entry.maxWidth)); err != nil {
+			t.Fatalf("tbl.Write failed with error %v", err)
+		}
+		if got := buf.String(); !bytes.Contains([]byte(got), []byte(entry.want)) {
+			t.Errorf("ASCII writer with max width %d returned\n%s\nwant it to contain\n%s", entry.maxWidth, got, entry.want)
+		}
+	}
+}
+
+func TestWritersSkipResultsWithoutBindings(t *testing.T) {
+	tbl, err := New(nil)
+	if err != nil {
+		t.Fatalf("table.New failed with error %v", err)
+	}
+	for _, f := range []string{ASCII, CSV, TSV, NDJSON, Markdown} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, f, DefaultMaxWidth)
+		if err != nil {
+			t.Fatalf("table.NewWriter(_, %q, _) failed with error %v", f, err)
+		}
+		if err := tbl.Write(w); err != nil {
+			t.Fatalf("tbl.Write failed for format %q with error %v", f, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("tbl.Write for format %q should not write results without bindings; got %q", f, buf.String())
+		}
+	}
+}
+
+func TestNewWriterUnknownFormat(t *testing.T) {
+	if _, err := NewWriter(&bytes.Buffer{}, "xml", DefaultMaxWidth); err == nil {
+		t.Errorf("table.NewWriter(_, %q, _) should have failed", "xml")
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c := &Collector{}
+	if c.Table() != nil {
+		t.Errorf("Collector.Table should return nil before a header is written")
+	}
+	want := testWriterTable(t)
+	if err := want.Write(c); err != nil {
+		t.Fatalf("tbl.Write failed with error %v", err)
+	}
+	got := c.Table()
+	if !reflect.DeepEqual(got.Bindings(), want.Bindings()) || !reflect.DeepEqual(got.Rows(), want.Rows()) {
+		t.Errorf("Collector.Table returned %v; want %v", got, want)
+	}
+}
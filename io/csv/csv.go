@@ -0,0 +1,418 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package csv imports CSV files into graphs. A declarative mapping describes
+// how each CSV row is converted into triples: which columns form the subject
+// node, which columns become which predicates and literal types, and which
+// column, if any, holds the time anchor of the predicates.
+package csv
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// Subject describes how the subject node of each row is built.
+type Subject struct {
+	// Type is the node type of the subject.
+	Type string `json:"type"`
+	// ID is the template used to build the node ID. Column names enclosed in
+	// braces are replaced by the value of the column in the row; for
+	// instance, "{first}_{last}".
+	ID string `json:"id"`
+}
+
+// Column describes the triple built out of a column.
+type Column struct {
+	// Column is the name of the column.
+	Column string `json:"column"`
+	// Predicate is the ID of the predicate of the triple.
+	Predicate string `json:"predicate"`
+	// Type is the literal type of the object: bool, int64, float64, text, or
+	// blob. Blob values are base64 encoded. It defaults to text.
+	Type string `json:"type,omitempty"`
+	// NodeType, if provided, makes the object a node of the provided type
+	// using the column value as ID instead of a literal.
+	NodeType string `json:"node_type,omitempty"`
+}
+
+// Anchor describes the column holding the time anchor of the predicates.
+type Anchor struct {
+	// Column is the name of the column.
+	Column string `json:"column"`
+	// Layout is the time layout used to parse the column values as accepted
+	// by time.Parse. It defaults to time.RFC3339Nano.
+	Layout string `json:"layout,omitempty"`
+}
+
+// Mapping describes how CSV rows are converted into triples. For instance:
+//
+//	{
+//	  "subject": {"type": "/person", "id": "{id}"},
+//	  "columns": [
+//	    {"column": "name", "predicate": "name"},
+//	    {"column": "age", "predicate": "age", "type": "int64"},
+//	    {"column": "manager", "predicate": "reports_to", "node_type": "/person"}
+//	  ],
+//	  "anchor": {"column": "updated"}
+//	}
+type Mapping struct {
+	// Header contains the names of the columns for files without a header
+	// row. If empty, the first row of the file is used as header.
+	Header []string `json:"header,omitempty"`
+	// Separator is the field separator. It defaults to a comma.
+	Separator string `json:"separator,omitempty"`
+	// Subject describes the subject node of each row.
+	Subject *Subject `json:"subject"`
+	// Columns lists the columns converted into triples. Empty values do not
+	// generate triples.
+	Columns []*Column `json:"columns"`
+	// Anchor describes the optional time anchor column. Rows with an empty
+	// anchor generate immutable predicates.
+	Anchor *Anchor `json:"anchor,omitempty"`
+}
+
+// literalTypes maps the literal type names to their types.
+var literalTypes = map[string]literal.Type{
+	"bool":    literal.Bool,
+	"int64":   literal.Int64,
+	"float64": literal.Float64,
+	"text":    literal.Text,
+	"blob":    literal.Blob,
+}
+
+// ParseMapping reads a JSON mapping from the provided reader and validates
+// it.
+func ParseMapping(r io.Reader) (*Mapping, error) {
+	m := &Mapping{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("csv.ParseMapping: %v", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("csv.ParseMapping: %v", err)
+	}
+	return m, nil
+}
+
+// Validate checks that the mapping is well formed.
+func (m *Mapping) Validate() error {
+	if m.Subject == nil || m.Subject.ID == "" {
+		return fmt.Errorf("mappings require a subject type and ID template")
+	}
+	if _, err := node.NewType(m.Subject.Type); err != nil {
+		return fmt.Errorf("invalid subject type %q; %v", m.Subject.Type, err)
+	}
+	if _, err := parseTemplate(m.Subject.ID); err != nil {
+		return err
+	}
+	if len(m.Columns) == 0 {
+		return fmt.Errorf("mappings require at least one column")
+	}
+	for _, c := range m.Columns {
+		if c.Column == "" || c.Predicate == "" {
+			return fmt.Errorf("columns require a column name and a predicate ID; got %+v", c)
+		}
+		if c.NodeType != "" {
+			if _, err := node.NewType(c.NodeType); err != nil {
+				return fmt.Errorf("invalid node type %q for column %q; %v", c.NodeType, c.Column, err)
+			}
+			continue
+		}
+		if _, ok := literalTypes[c.literalType()]; !ok {
+			return fmt.Errorf("unknown literal type %q for column %q", c.Type, c.Column)
+		}
+	}
+	if m.Anchor != nil && m.Anchor.Column == "" {
+		return fmt.Errorf("anchors require a column name")
+	}
+	if utf8.RuneCountInString(m.Separator) > 1 {
+		return fmt.Errorf("invalid separator %q", m.Separator)
+	}
+	return nil
+}
+
+// literalType returns the name of the literal type of the column.
+func (c *Column) literalType() string {
+	if c.Type == "" {
+		return "text"
+	}
+	return strings.ToLower(c.Type)
+}
+
+// segment is either a literal string or a column reference of an ID
+// template.
+type segment struct {
+	text   string
+	column string
+}
+
+// parseTemplate splits an ID template into its segments.
+func parseTemplate(tmpl string) ([]segment, error) {
+	var segs []segment
+	for tmpl != "" {
+		i := strings.Index(tmpl, "{")
+		if i < 0 {
+			segs = append(segs, segment{text: tmpl})
+			break
+		}
+		if i > 0 {
+			segs = append(segs, segment{text: tmpl[:i]})
+		}
+		j := strings.Index(tmpl[i:], "}")
+		if j < 0 {
+			return nil, fmt.Errorf("unterminated column reference in template %q", tmpl)
+		}
+		if j == 1 {
+			return nil, fmt.Errorf("empty column reference in template %q", tmpl)
+		}
+		segs = append(segs, segment{column: tmpl[i+1 : i+j]})
+		tmpl = tmpl[i+j+1:]
+	}
+	return segs, nil
+}
+
+// compiled contains a mapping resolved against the header of a file.
+type compiled struct {
+	b        literal.Builder
+	sType    *node.Type
+	subject  []segment
+	index    map[string]int
+	columns  []*Column
+	types    []*node.Type
+	anchor   int
+	layout   string
+	nColumns int
+}
+
+// compile resolves the mapping against the provided header.
+func (m *Mapping) compile(header []string, b literal.Builder) (*compiled, error) {
+	c := &compiled{
+		b:        b,
+		index:    make(map[string]int),
+		columns:  m.Columns,
+		anchor:   -1,
+		layout:   time.RFC3339Nano,
+		nColumns: len(header),
+	}
+	for i, h := range header {
+		c.index[strings.TrimSpace(h)] = i
+	}
+	col := func(name string) (int, error) {
+		i, ok := c.index[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown column %q", name)
+		}
+		return i, nil
+	}
+	t, err := node.NewType(m.Subject.Type)
+	if err != nil {
+		return nil, err
+	}
+	c.sType = t
+	if c.subject, err = parseTemplate(m.Subject.ID); err != nil {
+		return nil, err
+	}
+	for _, s := range c.subject {
+		if s.column == "" {
+			continue
+		}
+		if _, err := col(s.column); err != nil {
+			return nil, err
+		}
+	}
+	for _, cl := range m.Columns {
+		if _, err := col(cl.Column); err != nil {
+			return nil, err
+		}
+		var nt *node.Type
+		if cl.NodeType != "" {
+			if nt, err = node.NewType(cl.NodeType); err != nil {
+				return nil, err
+			}
+		}
+		c.types = append(c.types, nt)
+	}
+	if m.Anchor != nil {
+		if c.anchor, err = col(m.Anchor.Column); err != nil {
+			return nil, err
+		}
+		if m.Anchor.Layout != "" {
+			c.layout = m.Anchor.Layout
+		}
+	}
+	return c, nil
+}
+
+// row converts a CSV record into triples.
+func (c *compiled) row(rec []string) ([]*triple.Triple, error) {
+	var id []string
+	for _, s := range c.subject {
+		if s.column == "" {
+			id = append(id, s.text)
+			continue
+		}
+		v := strings.TrimSpace(rec[c.index[s.column]])
+		if v == "" {
+			return nil, fmt.Errorf("empty column %q used in the subject ID", s.column)
+		}
+		id = append(id, v)
+	}
+	sID, err := node.NewID(strings.Join(id, ""))
+	if err != nil {
+		return nil, err
+	}
+	s := node.NewNode(c.sType, sID)
+	var anchor *time.Time
+	if c.anchor >= 0 {
+		if v := strings.TrimSpace(rec[c.anchor]); v != "" {
+			ta, err := time.Parse(c.layout, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid time anchor %q; %v", v, err)
+			}
+			anchor = &ta
+		}
+	}
+	var ts []*triple.Triple
+	for i, cl := range c.columns {
+		v := rec[c.index[cl.Column]]
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		var p *predicate.Predicate
+		if anchor != nil {
+			p, err = predicate.NewTemporal(cl.Predicate, *anchor)
+		} else {
+			p, err = predicate.NewImmutable(cl.Predicate)
+		}
+		if err != nil {
+			return nil, err
+		}
+		o, err := c.object(cl, c.types[i], v)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %v", cl.Column, err)
+		}
+		t, err := triple.New(s, p, o)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// object returns the object for the provided column value.
+func (c *compiled) object(cl *Column, nt *node.Type, v string) (*triple.Object, error) {
+	if nt != nil {
+		id, err := node.NewID(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		return triple.NewNodeObject(node.NewNode(nt, id)), nil
+	}
+	t := literalTypes[cl.literalType()]
+	var (
+		iv  interface{}
+		err error
+	)
+	switch t {
+	case literal.Bool:
+		iv, err = strconv.ParseBool(strings.TrimSpace(v))
+	case literal.Int64:
+		iv, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	case literal.Float64:
+		iv, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+	case literal.Blob:
+		iv, err = base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	default:
+		iv = v
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q; %v", t, v, err)
+	}
+	l, err := c.b.Build(t, iv)
+	if err != nil {
+		return nil, err
+	}
+	return triple.NewLiteralObject(l), nil
+}
+
+// Read streams the CSV rows on the reader, converts them into triples as
+// described by the mapping, and calls fn with the triples of each row. It
+// stops on the first error, which indicates the offending line, and returns
+// the number of rows processed.
+func Read(ctx context.Context, r io.Reader, m *Mapping, b literal.Builder, fn func([]*triple.Triple) error) (int, error) {
+	if err := m.Validate(); err != nil {
+		return 0, fmt.Errorf("csv.Read: %v", err)
+	}
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	cr.FieldsPerRecord = -1
+	if m.Separator != "" {
+		cr.Comma, _ = utf8.DecodeRuneInString(m.Separator)
+	}
+	header := m.Header
+	if len(header) == 0 {
+		rec, err := cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("csv.Read: failed to read header; %v", err)
+		}
+		header = append([]string{}, rec...)
+	}
+	c, err := m.compile(header, b)
+	if err != nil {
+		return 0, fmt.Errorf("csv.Read: %v", err)
+	}
+	cnt := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return cnt, err
+		}
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return cnt, nil
+		}
+		if err != nil {
+			return cnt, fmt.Errorf("csv.Read: %v", err)
+		}
+		line, _ := cr.FieldPos(0)
+		if len(rec) != c.nColumns {
+			return cnt, fmt.Errorf("csv.Read: line %d: expected %d columns, got %d", line, c.nColumns, len(rec))
+		}
+		ts, err := c.row(rec)
+		if err != nil {
+			return cnt, fmt.Errorf("csv.Read: line %d: %v", line, err)
+		}
+		if err := fn(ts); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+const testMapping = `{
+  "subject": {"type": "/person", "id": "{first}_{last}"},
+  "columns": [
+    {"column": "age", "predicate": "age", "type": "int64"},
+    {"column": "height", "predicate": "height", "type": "float64"},
+    {"column": "alive", "predicate": "alive", "type": "bool"},
+    {"column": "photo", "predicate": "photo", "type": "blob"},
+    {"column": "city", "predicate": "lives_in", "node_type": "/city"},
+    {"column": "first", "predicate": "name"}
+  ],
+  "anchor": {"column": "updated"}
+}`
+
+func readAll(t *testing.T, m *Mapping, s string) (int, []string) {
+	var res []string
+	cnt, err := Read(context.Background(), strings.NewReader(s), m, literal.DefaultBuilder(), func(ts []*triple.Triple) error {
+		for _, trpl := range ts {
+			res = append(res, trpl.String())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("csv.Read(%q) failed with error %v", s, err)
+	}
+	sort.Strings(res)
+	return cnt, res
+}
+
+func TestRead(t *testing.T) {
+	m, err := ParseMapping(strings.NewReader(testMapping))
+	if err != nil {
+		t.Fatalf("csv.ParseMapping failed with error %v", err)
+	}
+	data := `first,last,age,height,alive,photo,city,updated
+John,Doe,42,1.8,true,AQID,nyc,
+Mary,"Smith, Jr",,,false,,,2016-04-10T04:21:00.000000001Z
+
+`
+	cnt, got := readAll(t, m, data)
+	if cnt != 2 {
+		t.Errorf("csv.Read returned the wrong number of rows; got %d, want 2", cnt)
+	}
+	want := []string{
+		"/person<John_Doe>\t\"age\"@[]\t\"42\"^^type:int64",
+		"/person<John_Doe>\t\"height\"@[]\t\"1.8\"^^type:float64",
+		"/person<John_Doe>\t\"alive\"@[]\t\"true\"^^type:bool",
+		"/person<John_Doe>\t\"photo\"@[]\t\"[1 2 3]\"^^type:blob",
+		"/person<John_Doe>\t\"lives_in\"@[]\t/city<nyc>",
+		"/person<John_Doe>\t\"name\"@[]\t\"John\"^^type:text",
+		"/person<Mary_Smith, Jr>\t\"alive\"@[2016-04-10T04:21:00.000000001Z]\t\"false\"^^type:bool",
+		"/person<Mary_Smith, Jr>\t\"name\"@[2016-04-10T04:21:00.000000001Z]\t\"Mary\"^^type:text",
+	}
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("csv.Read returned the wrong triples;\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestReadWithHeader(t *testing.T) {
+	m := &Mapping{
+		Header:    []string{"id", "name"},
+		Separator: "\t",
+		Subject:   &Subject{Type: "/u", ID: "{id}"},
+		Columns:   []*Column{{Column: "name", Predicate: "name"}},
+	}
+	cnt, got := readAll(t, m, "1\tjohn\n2\tmary\n")
+	want := []string{
+		"/u<1>\t\"name\"@[]\t\"john\"^^type:text",
+		"/u<2>\t\"name\"@[]\t\"mary\"^^type:text",
+	}
+	if cnt != 2 || strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("csv.Read returned the wrong triples; got %d rows %v, want 2 rows %v", cnt, got, want)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	m, err := ParseMapping(strings.NewReader(testMapping))
+	if err != nil {
+		t.Fatalf("csv.ParseMapping failed with error %v", err)
+	}
+	header := "first,last,age,height,alive,photo,city,updated\n"
+	table := []struct {
+		data string
+		err  string
+	}{
+		{"first,age\n", "unknown column"},
+		{header + "John,Doe,1,1,true,,,\nJohn,Doe,old,1,true,,,\n", "line 3:"},
+		{header + "John,Doe,1,1,true,,,yesterday\n", "line 2:"},
+		{header + ",Doe,1,1,true,,,\n", "line 2:"},
+		{header + "John,Doe\n", "line 2:"},
+	}
+	for _, entry := range table {
+		_, err := Read(context.Background(), strings.NewReader(entry.data), m, literal.DefaultBuilder(), func([]*triple.Triple) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), entry.err) {
+			t.Errorf("csv.Read(%q) should have failed with %q; got %v", entry.data, entry.err, err)
+		}
+	}
+}
+
+func TestParseMappingErrors(t *testing.T) {
+	for _, s := range []string{
+		`{"columns": [{"column": "a", "predicate": "a"}]}`,
+		`{"subject": {"type": "person", "id": "{id}"}, "columns": [{"column": "a", "predicate": "a"}]}`,
+		`{"subject": {"type": "/person", "id": "{id"}, "columns": [{"column": "a", "predicate": "a"}]}`,
+		`{"subject": {"type": "/person", "id": "{id}"}}`,
+		`{"subject": {"type": "/person", "id": "{id}"}, "columns": [{"column": "a", "predicate": "a", "type": "date"}]}`,
+		`{"subject": {"type": "/person", "id": "{id}"}, "columns": [{"column": "a"}]}`,
+		`{"subject": {"type": "/person", "id": "{id}"}, "columns": [{"column": "a", "predicate": "a"}], "anchor": {}}`,
+	} {
+		if _, err := ParseMapping(strings.NewReader(s)); err == nil {
+			t.Errorf("csv.ParseMapping(%q) should have failed", s)
+		}
+	}
+}
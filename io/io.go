@@ -16,15 +16,14 @@
 package io
 
 import (
-	"bufio"
 	"fmt"
 	"io"
-	"strings"
 	"sync"
 
 	"golang.org/x/net/context"
 
 	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/schema"
 	"github.com/google/badwolf/triple"
 	"github.com/google/badwolf/triple/literal"
 )
@@ -32,24 +31,38 @@ import (
 // ReadIntoGraph reads a graph out of the provided reader. The data on the
 // reader is interpret as text. Each line represents one triple using the
 // standard serialized format. ReadIntoGraph will stop if fails to Parse
-// a triple on the stream. The triples read till then would have also been
-// added to the graph. The int value returns the number of triples added.
+// a triple on the stream and return a *LineError indicating the offending
+// line. The triples read till then would have also been added to the graph.
+// The int value returns the number of triples added. Triples are added in
+// batches using Load with its default options, hence gzip compressed inputs
+// and comment lines are also accepted.
+//
+// If the graph has a schema, as returned by schema.Of, the whole
+// stream is read and validated as a single batch before adding any triple,
+// so the triples providing the required predicates of a node may appear
+// anywhere in the stream. If parsing or validation fails no triple is added,
+// and schema violations are returned as a *schema.ValidationError. Since all
+// the triples are kept in memory until validated, use Load to stream large
+// inputs into graphs with a schema.
 func ReadIntoGraph(ctx context.Context, g storage.Graph, r io.Reader, b literal.Builder) (int, error) {
-	cnt, scanner := 0, bufio.NewScanner(r)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		text := strings.TrimSpace(scanner.Text())
-		if text == "" {
-			continue
-		}
-		t, err := triple.Parse(text, b)
-		if err != nil {
-			return cnt, err
-		}
-		cnt++
-		g.AddTriples(ctx, []*triple.Triple{t})
+	if _, ok := schema.Of(g); !ok {
+		p, err := Load(ctx, r, []storage.Graph{g}, b, nil)
+		return p.Triples, err
 	}
-	return cnt, nil
+	var ts []*triple.Triple
+	if _, err := load(ctx, r, b, nil, func(bts []*triple.Triple) error {
+		ts = append(ts, bts...)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	if err := schema.Validate(ctx, g, ts); err != nil {
+		return 0, err
+	}
+	if err := g.AddTriples(ctx, ts); err != nil {
+		return 0, err
+	}
+	return len(ts), nil
 }
 
 // WriteGraph serializes the graph into the writer where each triple is
@@ -87,3 +100,40 @@ func WriteGraph(ctx context.Context, w io.Writer, g storage.Graph) (int, error)
 	}
 	return cnt, nil
 }
+
+// WriteGraphBinary serializes the graph into the writer as a binary triple
+// stream using triple.Encoder. It returns the number of triples serialized.
+func WriteGraphBinary(ctx context.Context, w io.Writer, g storage.Graph) (int, error) {
+	var (
+		wg   sync.WaitGroup
+		tErr error
+		wErr error
+	)
+	cnt, ts, e := 0, make(chan *triple.Triple), triple.NewEncoder(w)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tErr = g.Triples(ctx, storage.DefaultLookup, ts)
+	}()
+	for t := range ts {
+		if wErr != nil {
+			continue
+		}
+		if err := e.Encode(t); err != nil {
+			wErr = err
+			continue
+		}
+		cnt++
+	}
+	wg.Wait()
+	if tErr != nil {
+		return 0, tErr
+	}
+	if wErr != nil {
+		return 0, wErr
+	}
+	if err := e.Flush(); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
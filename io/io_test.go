@@ -16,15 +16,22 @@ package io
 
 import (
 	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"testing"
 
 	"golang.org/x/net/context"
 
 	"github.com/google/badwolf/storage"
 	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/storage/schema"
 	"github.com/google/badwolf/triple"
 	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
 )
 
 func getTestTriples(t *testing.T) []*triple.Triple {
@@ -136,3 +143,505 @@ func TestSerializationContents(t *testing.T) {
 		t.Errorf("Failed to unmarshal marshaled the right number of triples, %d != %d != 6", gs, gos)
 	}
 }
+
+func TestReadIntoGraphWithSchema(t *testing.T) {
+	var buffer bytes.Buffer
+	ts, ctx := getTestTriples(t), context.Background()
+	for _, trpl := range ts {
+		buffer.WriteString(fmt.Sprintf("%s\n", trpl.String()))
+	}
+	s := schema.NewStore(memory.NewStore())
+	g, err := s.NewGraph(ctx, "?io_schema_test")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	if err := s.Register("?io_schema_test", &schema.Schema{
+		Predicates: []*schema.Predicate{
+			{
+				ID:          "knows",
+				Cardinality: schema.SingleValued,
+			},
+		},
+	}); err != nil {
+		t.Fatalf("schema.Store.Register failed with error %v", err)
+	}
+
+	cnt, err := ReadIntoGraph(ctx, g, &buffer, literal.DefaultBuilder())
+	if err == nil {
+		t.Errorf("io.ReadIntoGraph should have rejected triples violating the schema")
+	}
+	if cnt != 0 {
+		t.Errorf("io.ReadIntoGraph should have not added any triple; got %d", cnt)
+	}
+	trpls := make(chan *triple.Triple, 10)
+	if err := g.Triples(ctx, storage.DefaultLookup, trpls); err != nil {
+		t.Errorf("g.Triples failed to retrieve triples with error %v", err)
+	}
+	if _, ok := <-trpls; ok {
+		t.Errorf("io.ReadIntoGraph should have not added triples to the graph on rejected batches")
+	}
+}
+
+func TestReadIntoGraphWithSchemaSharesTheLoader(t *testing.T) {
+	ctx := context.Background()
+	s := schema.NewStore(memory.NewStore())
+	g, err := s.NewGraph(ctx, "?io_schema_loader_test")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	if err := s.Register("?io_schema_loader_test", &schema.Schema{
+		Predicates: []*schema.Predicate{
+			{
+				ID:          "knows",
+				Cardinality: schema.SingleValued,
+			},
+		},
+	}); err != nil {
+		t.Fatalf("schema.Store.Register failed with error %v", err)
+	}
+
+	var buffer bytes.Buffer
+	w := gzip.NewWriter(&buffer)
+	fmt.Fprintf(w, "# People and who they know.\n\n/u<john>\t\"knows\"@[]\t/u<mary>\n/u<mary>\t\"knows\"@[]\t/u<%s>\n", strings.Repeat("x", 128*1024))
+	if err := w.Close(); err != nil {
+		t.Fatalf("gzip.Writer.Close failed with error %v", err)
+	}
+	cnt, err := ReadIntoGraph(ctx, g, &buffer, literal.DefaultBuilder())
+	if err != nil {
+		t.Fatalf("io.ReadIntoGraph failed with error %v", err)
+	}
+	if cnt != 2 {
+		t.Errorf("io.ReadIntoGraph added %d triples; want 2", cnt)
+	}
+}
+
+func TestReadIntoGraphWithSchemaValidatesTheWholeStream(t *testing.T) {
+	ctx := context.Background()
+	person, err := node.NewType("/person")
+	if err != nil {
+		t.Fatalf("node.NewType failed with error %v", err)
+	}
+	sch := &schema.Schema{
+		Types: []*schema.Type{{Type: person, Required: []predicate.ID{"name"}}},
+	}
+
+	// The name of joe is far more than a loader batch away from its first
+	// triple.
+	var buffer bytes.Buffer
+	buffer.WriteString("/person<joe>\t\"knows\"@[]\t/u<mary>\n")
+	for i := 0; i < 2500; i++ {
+		fmt.Fprintf(&buffer, "/u<user%d>\t\"knows\"@[]\t/u<mary>\n", i)
+	}
+	in := buffer.String()
+	for _, entry := range []struct {
+		name string
+		want int
+	}{
+		{"/person<joe>\t\"name\"@[]\t\"Joe\"^^type:text\n", 2502},
+		{"", 0},
+	} {
+		s := schema.NewStore(memory.NewStore())
+		if err := s.Register("?io_schema_stream_test", sch); err != nil {
+			t.Fatalf("schema.Store.Register failed with error %v", err)
+		}
+		g, err := s.NewGraph(ctx, "?io_schema_stream_test")
+		if err != nil {
+			t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+		}
+		cnt, err := ReadIntoGraph(ctx, g, strings.NewReader(in+entry.name), literal.DefaultBuilder())
+		if entry.want > 0 && err != nil {
+			t.Errorf("io.ReadIntoGraph failed with error %v", err)
+		}
+		if _, ok := err.(*schema.ValidationError); entry.want == 0 && !ok {
+			t.Errorf("io.ReadIntoGraph should have returned a *schema.ValidationError; got %v", err)
+		}
+		trpls := make(chan *triple.Triple, 3000)
+		if err := g.Triples(ctx, storage.DefaultLookup, trpls); err != nil {
+			t.Fatalf("g.Triples failed with error %v", err)
+		}
+		if cnt != entry.want || len(trpls) != entry.want {
+			t.Errorf("io.ReadIntoGraph added %d triples and the graph contains %d; want %d", cnt, len(trpls), entry.want)
+		}
+	}
+}
+
+func TestJSONLinesRoundTrip(t *testing.T) {
+	ctx, b := context.Background(), literal.DefaultBuilder()
+	ss := []string{
+		"/u<john>\t\"knows\"@[]\t/u<mary>",
+		"/u<john doe>\t\"met\"@[2016-04-10T04:21:00.000000123+02:00]\t/u<peter>",
+		"/u<john>\t\"name\"@[]\t\"John \"J\" Doe\"^^type:text",
+		"/u<john>\t\"age\"@[]\t\"42\"^^type:int64",
+		"/u<john>\t\"alive\"@[]\t\"true\"^^type:bool",
+		"/u<john>\t\"data\"@[]\t\"[0 1 255]\"^^type:blob",
+		"/u<john>\t\"empty\"@[]\t\"[]\"^^type:blob",
+		"/u<john>\t\"refers\"@[]\t\"met\"@[2016-04-10T04:21:00.000000123Z]",
+	}
+	var ts []*triple.Triple
+	for _, s := range ss {
+		trpl, err := triple.Parse(s, b)
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", s, err)
+		}
+		ts = append(ts, trpl)
+	}
+	for _, f := range []float64{0.1 + 0.2, -1e-300, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		l, err := b.Build(literal.Float64, f)
+		if err != nil {
+			t.Fatalf("literal.Build failed with error %v", err)
+		}
+		trpl, err := triple.New(ts[0].Subject(), ts[0].Predicate(), triple.NewLiteralObject(l))
+		if err != nil {
+			t.Fatalf("triple.New failed with error %v", err)
+		}
+		ts = append(ts, trpl)
+	}
+	for _, trpl := range ts {
+		bs, err := TripleToJSON(trpl)
+		if err != nil {
+			t.Fatalf("io.TripleToJSON(%s) failed with error %v", trpl, err)
+		}
+		got, err := TripleFromJSON(bs, b)
+		if err != nil {
+			t.Fatalf("io.TripleFromJSON(%s) failed with error %v", bs, err)
+		}
+		if got.String() != trpl.String() || got.UUID().String() != trpl.UUID().String() {
+			t.Errorf("io.TripleFromJSON(io.TripleToJSON(%s)) returned %s via %s", trpl, got, bs)
+		}
+	}
+
+	g, err := memory.NewStore().NewGraph(ctx, "?jsonl")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	if err := g.AddTriples(ctx, ts); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	var buffer bytes.Buffer
+	cnt, err := WriteGraphJSONLines(ctx, &buffer, g)
+	if err != nil {
+		t.Fatalf("io.WriteGraphJSONLines failed with error %v", err)
+	}
+	if got, want := cnt, len(ts); got != want {
+		t.Errorf("io.WriteGraphJSONLines wrote the wrong number of triples; got %d, want %d", got, want)
+	}
+	g2, err := memory.NewStore().NewGraph(ctx, "?jsonl")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	cnt, err = ReadJSONLinesIntoGraph(ctx, g2, &buffer, b)
+	if err != nil {
+		t.Fatalf("io.ReadJSONLinesIntoGraph failed with error %v", err)
+	}
+	if got, want := cnt, len(ts); got != want {
+		t.Errorf("io.ReadJSONLinesIntoGraph read the wrong number of triples; got %d, want %d", got, want)
+	}
+	for _, trpl := range ts {
+		ok, err := g2.Exist(ctx, trpl)
+		if err != nil {
+			t.Fatalf("g2.Exist failed with error %v", err)
+		}
+		if !ok {
+			t.Errorf("io.ReadJSONLinesIntoGraph failed to read triple %s", trpl)
+		}
+	}
+}
+
+func TestReadJSONLinesErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, s := range []string{
+		`{"subject":{"type":"/u","id":"john"},"predicate":{"id":"knows"}}`,
+		`{"subject":{"type":"u","id":"john"},"predicate":{"id":"knows"},"object":{"node":{"type":"/u","id":"mary"}}}`,
+		`{"subject":{"type":"/u","id":"john"},"predicate":{"id":"knows","anchor":"yesterday"},"object":{"node":{"type":"/u","id":"mary"}}}`,
+		`{"subject":{"type":"/u","id":"john"},"predicate":{"id":"age"},"object":{"literal":{"type":"int64","value":"old"}}}`,
+		`{"subject":{"type":"/u","id":"john"},"predicate":{"id":"age"},"object":{"literal":{"type":"date","value":"2016"}}}`,
+		`not json`,
+	} {
+		g, err := memory.NewStore().NewGraph(ctx, "?jsonl")
+		if err != nil {
+			t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+		}
+		in := "\n" + s + "\n"
+		if _, err := ReadJSONLinesIntoGraph(ctx, g, bytes.NewBufferString(in), literal.DefaultBuilder()); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("io.ReadJSONLinesIntoGraph(%q) should have failed on line 2; got %v", in, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	var buffer bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&buffer, "/u<user%d>\t\"knows\"@[]\t/u<user%d>\n", i, i+1)
+		if i%10 == 3 {
+			buffer.WriteString("# A comment.\n\n")
+		}
+		if i == 41 || i == 77 {
+			buffer.WriteString("not a triple\n")
+		}
+	}
+	data := buffer.Bytes()
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("gzip.Writer.Write failed with error %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip.Writer.Close failed with error %v", err)
+	}
+	// Line numbers of the invalid lines: each comment block before them adds
+	// two lines.
+	bad := []int{42 + 4*2 + 1, 78 + 8*2 + 1 + 1}
+
+	for _, in := range [][]byte{data, gzipped.Bytes()} {
+		for _, workers := range []int{1, 4} {
+			g, err := memory.NewStore().NewGraph(ctx, "?load")
+			if err != nil {
+				t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+			}
+			var (
+				lines    []int
+				progress []Progress
+			)
+			p, err := Load(ctx, bytes.NewReader(in), []storage.Graph{g}, literal.DefaultBuilder(), &LoaderOptions{
+				BatchSize:  7,
+				Workers:    workers,
+				Mode:       Skip,
+				OnError:    func(e *LineError) { lines = append(lines, e.Line) },
+				OnProgress: func(p Progress) { progress = append(progress, p) },
+			})
+			if err != nil {
+				t.Fatalf("io.Load failed with error %v", err)
+			}
+			if p.Triples != 100 || p.Skipped != 2 {
+				t.Errorf("io.Load returned the wrong progress; got %+v, want 100 triples and 2 skipped lines", p)
+			}
+			if fmt.Sprint(lines) != fmt.Sprint(bad) {
+				t.Errorf("io.Load reported the wrong lines; got %v, want %v", lines, bad)
+			}
+			if len(progress) == 0 || progress[len(progress)-1] != p {
+				t.Errorf("io.Load reported the wrong progress; got %v, want last %+v", progress, p)
+			}
+			trpls := make(chan *triple.Triple, 200)
+			if err := g.Triples(ctx, storage.DefaultLookup, trpls); err != nil {
+				t.Fatalf("g.Triples failed with error %v", err)
+			}
+			if got := len(trpls); got != 100 {
+				t.Errorf("io.Load added the wrong number of triples; got %d, want 100", got)
+			}
+
+			g, err = memory.NewStore().NewGraph(ctx, "?load")
+			if err != nil {
+				t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+			}
+			p, err = Load(ctx, bytes.NewReader(in), []storage.Graph{g}, literal.DefaultBuilder(), &LoaderOptions{
+				BatchSize: 7,
+				Workers:   workers,
+			})
+			le, ok := err.(*LineError)
+			if !ok || le.Line != bad[0] {
+				t.Fatalf("io.Load should have failed on line %d; got %v", bad[0], err)
+			}
+			if p.Triples != 42 || p.Lines != bad[0]-1 {
+				t.Errorf("io.Load should have added the 42 triples before line %d; got %+v", bad[0], p)
+			}
+		}
+	}
+}
+
+func TestLoadSchemaViolation(t *testing.T) {
+	ctx := context.Background()
+	s := schema.NewStore(memory.NewStore())
+	g, err := s.NewGraph(ctx, "?io_load_schema_test")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	if err := s.Register("?io_load_schema_test", &schema.Schema{
+		Predicates: []*schema.Predicate{
+			{
+				ID:          "knows",
+				Cardinality: schema.SingleValued,
+			},
+		},
+	}); err != nil {
+		t.Fatalf("schema.Store.Register failed with error %v", err)
+	}
+
+	in := "/u<john>\t\"knows\"@[]\t/u<mary>\n/u<mary>\t\"knows\"@[]\t/u<john>\n/u<john>\t\"knows\"@[]\t/u<peter>\n"
+	p, err := Load(ctx, strings.NewReader(in), []storage.Graph{g}, literal.DefaultBuilder(), &LoaderOptions{BatchSize: 2})
+	var ve *schema.ValidationError
+	if !errors.As(err, &ve) || len(ve.Violations) != 1 {
+		t.Fatalf("io.Load should have returned a single *schema.ValidationError violation; got %#v", err)
+	}
+	var be *BatchError
+	if !errors.As(err, &be) || be.First != 3 || be.Last != 3 {
+		t.Errorf("io.Load should have rejected the batch with line 3; got %v", err)
+	}
+	if p.Triples != 2 {
+		t.Errorf("io.Load should have added the 2 triples before the rejected batch; got %+v", p)
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	ts, ctx := getTestTriples(t), context.Background()
+	g, err := memory.NewStore().NewGraph(ctx, "?binary")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	if err := g.AddTriples(ctx, ts); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	var buffer bytes.Buffer
+	cnt, err := WriteGraphBinary(ctx, &buffer, g)
+	if err != nil {
+		t.Fatalf("io.WriteGraphBinary failed with error %v", err)
+	}
+	if cnt != len(ts) {
+		t.Errorf("io.WriteGraphBinary wrote the wrong number of triples; got %d, want %d", cnt, len(ts))
+	}
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	if _, err := zw.Write(buffer.Bytes()); err != nil {
+		t.Fatalf("gzip.Writer.Write failed with error %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip.Writer.Close failed with error %v", err)
+	}
+	for _, in := range [][]byte{buffer.Bytes(), gzipped.Bytes()} {
+		g2, err := memory.NewStore().NewGraph(ctx, "?binary")
+		if err != nil {
+			t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+		}
+		p, err := Load(ctx, bytes.NewReader(in), []storage.Graph{g2}, literal.DefaultBuilder(), &LoaderOptions{BatchSize: 4})
+		if err != nil {
+			t.Fatalf("io.Load failed with error %v", err)
+		}
+		if p.Triples != len(ts) || p.Lines != len(ts) {
+			t.Errorf("io.Load returned the wrong progress; got %+v, want %d triples", p, len(ts))
+		}
+		for _, trpl := range ts {
+			if ok, err := g2.Exist(ctx, trpl); err != nil || !ok {
+				t.Errorf("io.Load failed to load triple %s; %v", trpl, err)
+			}
+		}
+	}
+	g3, err := memory.NewStore().NewGraph(ctx, "?binary")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	if _, err := Load(ctx, bytes.NewReader(buffer.Bytes()[:buffer.Len()-1]), []storage.Graph{g3}, literal.DefaultBuilder(), nil); err == nil {
+		t.Errorf("io.Load should have failed to load a truncated binary stream")
+	}
+}
+
+func TestQuadsRoundTrip(t *testing.T) {
+	ctx, b := context.Background(), literal.DefaultBuilder()
+	ts := getTestTriples(t)
+	s := memory.NewStore()
+	var gs []storage.Graph
+	for i, id := range []string{"?a", "?b"} {
+		g, err := s.NewGraph(ctx, id)
+		if err != nil {
+			t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+		}
+		if err := g.AddTriples(ctx, ts[i*3:i*3+3]); err != nil {
+			t.Fatalf("g.AddTriples failed with error %v", err)
+		}
+		gs = append(gs, g)
+	}
+	var buffer bytes.Buffer
+	cnt, err := WriteQuads(ctx, &buffer, gs)
+	if err != nil {
+		t.Fatalf("io.WriteQuads failed with error %v", err)
+	}
+	if got, want := cnt, len(ts); got != want {
+		t.Errorf("io.WriteQuads wrote the wrong number of quads; got %d, want %d", got, want)
+	}
+	s2 := memory.NewStore()
+	if _, err := s2.NewGraph(ctx, "?a"); err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	cnts, err := ReadQuadsIntoStore(ctx, s2, strings.NewReader("# Quads.\n\n"+buffer.String()), b)
+	if err != nil {
+		t.Fatalf("io.ReadQuadsIntoStore failed with error %v", err)
+	}
+	for i, id := range []string{"?a", "?b"} {
+		if got, want := cnts[id], 3; got != want {
+			t.Errorf("io.ReadQuadsIntoStore added %d triples to graph %q; want %d", got, id, want)
+		}
+		g, err := s2.Graph(ctx, id)
+		if err != nil {
+			t.Fatalf("io.ReadQuadsIntoStore failed to create graph %q with error %v", id, err)
+		}
+		for j, trpl := range ts {
+			ok, err := g.Exist(ctx, trpl)
+			if err != nil {
+				t.Fatalf("g.Exist failed with error %v", err)
+			}
+			if want := j/3 == i; ok != want {
+				t.Errorf("g.Exist(%s) in graph %q returned %v; want %v", trpl, id, ok, want)
+			}
+		}
+	}
+
+	for _, ln := range []string{
+		"/u<john>\t\"knows\"@[]\t/u<mary>",
+		"/u<john>\t\"knows\"@[]\t?a",
+		"/u<john>\t\"knows\"@[]\t/u<mary>\ta",
+	} {
+		_, err := ReadQuadsIntoStore(ctx, memory.NewStore(), strings.NewReader("\n"+ln), b)
+		if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("io.ReadQuadsIntoStore(%q) should have failed on line 2; got %v", ln, err)
+		}
+	}
+}
+
+func TestReadQuadsIntoStoreValidatesAllGraphs(t *testing.T) {
+	ctx, b := context.Background(), literal.DefaultBuilder()
+	s := schema.NewStore(memory.NewStore())
+	if err := s.Register("?io_quads_schema_test", &schema.Schema{
+		Predicates: []*schema.Predicate{
+			{
+				ID:          "knows",
+				Cardinality: schema.SingleValued,
+			},
+		},
+	}); err != nil {
+		t.Fatalf("schema.Store.Register failed with error %v", err)
+	}
+
+	if _, err := s.NewGraph(ctx, "?existing"); err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	in := strings.Join([]string{
+		"/u<john>\t\"knows\"@[]\t/u<mary>\t?existing",
+		"/u<john>\t\"knows\"@[]\t/u<mary>\t?new",
+		"/u<john>\t\"knows\"@[]\t/u<mary>\t?io_quads_schema_test",
+		"/u<john>\t\"knows\"@[]\t/u<peter>\t?io_quads_schema_test",
+	}, "\n")
+	if _, err := ReadQuadsIntoStore(ctx, s, strings.NewReader(in), b); err == nil {
+		t.Fatalf("io.ReadQuadsIntoStore should have rejected quads violating the schema")
+	}
+	names := make(chan string, 10)
+	if err := s.GraphNames(ctx, names); err != nil {
+		t.Fatalf("s.GraphNames failed with error %v", err)
+	}
+	var got []string
+	for n := range names {
+		got = append(got, n)
+	}
+	if len(got) != 1 || got[0] != "?existing" {
+		t.Errorf("io.ReadQuadsIntoStore should have dropped the graphs it created; got graphs %v", got)
+	}
+	g, err := s.Graph(ctx, "?existing")
+	if err != nil {
+		t.Fatalf("s.Graph failed with error %v", err)
+	}
+	trpls := make(chan *triple.Triple, 10)
+	if err := g.Triples(ctx, storage.DefaultLookup, trpls); err != nil {
+		t.Fatalf("g.Triples failed with error %v", err)
+	}
+	if _, ok := <-trpls; ok {
+		t.Errorf("io.ReadQuadsIntoStore should have not added triples to existing graphs on rejected inputs")
+	}
+}
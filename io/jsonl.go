@@ -0,0 +1,305 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/table"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/schema"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// jsonNode contains the JSON representation of a node.
+type jsonNode struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// jsonPredicate contains the JSON representation of a predicate. Immutable
+// predicates have no anchor.
+type jsonPredicate struct {
+	ID     string `json:"id"`
+	Anchor string `json:"anchor,omitempty"`
+}
+
+// jsonLiteral contains the JSON representation of a literal.
+type jsonLiteral struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonObject contains the JSON representation of an object. Only one of the
+// fields is set.
+type jsonObject struct {
+	Node      *jsonNode      `json:"node,omitempty"`
+	Predicate *jsonPredicate `json:"predicate,omitempty"`
+	Literal   *jsonLiteral   `json:"literal,omitempty"`
+}
+
+// jsonTriple contains the JSON representation of a triple.
+type jsonTriple struct {
+	Subject   *jsonNode      `json:"subject"`
+	Predicate *jsonPredicate `json:"predicate"`
+	Object    *jsonObject    `json:"object"`
+}
+
+// TripleToJSON returns the JSON representation of the triple used by the
+// JSON Lines format. For instance:
+//
+//	{"subject":{"type":"/u","id":"john"},"predicate":{"id":"met","anchor":"2016-04-10T04:21:00Z"},"object":{"literal":{"type":"int64","value":42}}}
+//
+// Objects use the typed cells returned by table.CellToJSON, so time anchors
+// use RFC3339Nano, int64 and float64 literals are JSON numbers, and blob
+// literals are base64 encoded strings. Since JSON numbers cannot represent
+// them, NaN and infinite float64 values are encoded as the strings "NaN",
+// "+Inf", and "-Inf".
+func TripleToJSON(t *triple.Triple) ([]byte, error) {
+	c, o := &table.Cell{}, t.Object()
+	if n, err := o.Node(); err == nil {
+		c.N = n
+	} else if p, err := o.Predicate(); err == nil {
+		c.P = p
+	} else if l, err := o.Literal(); err == nil {
+		c.L = l
+	} else {
+		return nil, fmt.Errorf("io.TripleToJSON: invalid object in triple %s", t)
+	}
+	jo, err := table.CellToJSON(c)
+	if err != nil {
+		return nil, fmt.Errorf("io.TripleToJSON: %v", err)
+	}
+	return json.Marshal(&struct {
+		Subject   *jsonNode       `json:"subject"`
+		Predicate *jsonPredicate  `json:"predicate"`
+		Object    json.RawMessage `json:"object"`
+	}{
+		Subject:   nodeToJSON(t.Subject()),
+		Predicate: predicateToJSON(t.Predicate()),
+		Object:    jo,
+	})
+}
+
+func nodeToJSON(n *node.Node) *jsonNode {
+	return &jsonNode{Type: n.Type().String(), ID: n.ID().String()}
+}
+
+func predicateToJSON(p *predicate.Predicate) *jsonPredicate {
+	jp := &jsonPredicate{ID: string(p.ID())}
+	if ta, err := p.TimeAnchor(); err == nil {
+		jp.Anchor = ta.Format(time.RFC3339Nano)
+	}
+	return jp
+}
+
+// TripleFromJSON returns the triple for the provided JSON representation as
+// produced by TripleToJSON.
+func TripleFromJSON(data []byte, b literal.Builder) (*triple.Triple, error) {
+	jt := &jsonTriple{}
+	if err := json.Unmarshal(data, jt); err != nil {
+		return nil, fmt.Errorf("io.TripleFromJSON: %v", err)
+	}
+	if jt.Subject == nil || jt.Predicate == nil || jt.Object == nil {
+		return nil, fmt.Errorf("io.TripleFromJSON: triples require a subject, a predicate, and an object in %s", data)
+	}
+	s, err := nodeFromJSON(jt.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("io.TripleFromJSON: %v", err)
+	}
+	p, err := predicateFromJSON(jt.Predicate)
+	if err != nil {
+		return nil, fmt.Errorf("io.TripleFromJSON: %v", err)
+	}
+	var o *triple.Object
+	switch jo := jt.Object; {
+	case jo.Node != nil:
+		n, err := nodeFromJSON(jo.Node)
+		if err != nil {
+			return nil, fmt.Errorf("io.TripleFromJSON: %v", err)
+		}
+		o = triple.NewNodeObject(n)
+	case jo.Predicate != nil:
+		op, err := predicateFromJSON(jo.Predicate)
+		if err != nil {
+			return nil, fmt.Errorf("io.TripleFromJSON: %v", err)
+		}
+		o = triple.NewPredicateObject(op)
+	case jo.Literal != nil:
+		l, err := literalFromJSON(jo.Literal, b)
+		if err != nil {
+			return nil, fmt.Errorf("io.TripleFromJSON: %v", err)
+		}
+		o = triple.NewLiteralObject(l)
+	default:
+		return nil, fmt.Errorf("io.TripleFromJSON: missing object value in %s", data)
+	}
+	return triple.New(s, p, o)
+}
+
+func nodeFromJSON(jn *jsonNode) (*node.Node, error) {
+	return node.NewNodeFromStrings(jn.Type, jn.ID)
+}
+
+func predicateFromJSON(jp *jsonPredicate) (*predicate.Predicate, error) {
+	if jp.Anchor == "" {
+		return predicate.NewImmutable(jp.ID)
+	}
+	ta, err := time.Parse(time.RFC3339Nano, jp.Anchor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time anchor %q; %v", jp.Anchor, err)
+	}
+	return predicate.NewTemporal(jp.ID, ta)
+}
+
+func literalFromJSON(jl *jsonLiteral, b literal.Builder) (*literal.Literal, error) {
+	raw := strings.TrimSpace(string(jl.Value))
+	switch jl.Type {
+	case "bool":
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool literal value %s; %v", raw, err)
+		}
+		return b.Build(literal.Bool, v)
+	case "int64":
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int64 literal value %s; %v", raw, err)
+		}
+		return b.Build(literal.Int64, v)
+	case "float64":
+		if uq, err := strconv.Unquote(raw); err == nil {
+			raw = uq
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float64 literal value %s; %v", raw, err)
+		}
+		return b.Build(literal.Float64, v)
+	case "text":
+		var v string
+		if err := json.Unmarshal(jl.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid text literal value %s; %v", raw, err)
+		}
+		return b.Build(literal.Text, v)
+	case "blob":
+		v := []byte{}
+		if err := json.Unmarshal(jl.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid blob literal value %s; %v", raw, err)
+		}
+		if v == nil {
+			v = []byte{}
+		}
+		return b.Build(literal.Blob, v)
+	default:
+		return nil, fmt.Errorf("unknown literal type %q", jl.Type)
+	}
+}
+
+// ReadJSONLinesIntoGraph reads a graph out of the provided reader. Each line
+// contains the JSON representation of a triple as returned by TripleToJSON.
+// Empty lines are ignored. It stops on the first line that cannot be parsed
+// and returns the number of triples added. As ReadIntoGraph, graphs with a
+// schema are validated as a single batch.
+func ReadJSONLinesIntoGraph(ctx context.Context, g storage.Graph, r io.Reader, b literal.Builder) (int, error) {
+	_, validate := schema.Of(g)
+	var ts []*triple.Triple
+	cnt, scanner := 0, bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		t, err := TripleFromJSON(text, b)
+		if err != nil {
+			return cnt, fmt.Errorf("line %d: %v", line, err)
+		}
+		if validate {
+			ts = append(ts, t)
+			continue
+		}
+		if err := g.AddTriples(ctx, []*triple.Triple{t}); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	if err := scanner.Err(); err != nil {
+		return cnt, err
+	}
+	if !validate {
+		return cnt, nil
+	}
+	if err := schema.Validate(ctx, g, ts); err != nil {
+		return 0, err
+	}
+	if err := g.AddTriples(ctx, ts); err != nil {
+		return 0, err
+	}
+	return len(ts), nil
+}
+
+// WriteGraphJSONLines serializes the graph into the writer where each triple
+// is marshaled into a separate line using TripleToJSON. It returns the
+// number of triples serialized.
+func WriteGraphJSONLines(ctx context.Context, w io.Writer, g storage.Graph) (int, error) {
+	var (
+		wg   sync.WaitGroup
+		tErr error
+		wErr error
+	)
+	cnt, ts := 0, make(chan *triple.Triple)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tErr = g.Triples(ctx, storage.DefaultLookup, ts)
+	}()
+	for t := range ts {
+		if wErr != nil {
+			continue
+		}
+		bs, err := TripleToJSON(t)
+		if err != nil {
+			wErr = err
+			continue
+		}
+		if _, err := w.Write(append(bs, '\n')); err != nil {
+			wErr = err
+			continue
+		}
+		cnt++
+	}
+	wg.Wait()
+	if tErr != nil {
+		return 0, tErr
+	}
+	if wErr != nil {
+		return 0, wErr
+	}
+	return cnt, nil
+}
@@ -0,0 +1,446 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/schema"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+// ErrorMode describes how the loader handles lines that cannot be parsed.
+type ErrorMode uint8
+
+const (
+	// Abort stops loading on the first line that cannot be parsed.
+	Abort ErrorMode = iota
+	// Skip ignores the lines that cannot be parsed and keeps loading.
+	Skip
+)
+
+// String returns the name of the error mode.
+func (m ErrorMode) String() string {
+	switch m {
+	case Abort:
+		return "abort"
+	case Skip:
+		return "skip"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseErrorMode returns the error mode for the provided name.
+func ParseErrorMode(s string) (ErrorMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "abort":
+		return Abort, nil
+	case "skip":
+		return Skip, nil
+	default:
+		return 0, fmt.Errorf("io.ParseErrorMode: unknown error mode %q", s)
+	}
+}
+
+// LineError contains the error found while parsing a line of the input.
+type LineError struct {
+	// Line is the line number, starting at 1.
+	Line int
+	// Text is the content of the line.
+	Text string
+	// Err is the parsing error.
+	Err error
+}
+
+// Error returns the error message including the line number.
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// BatchError contains the error found while validating or adding a batch of
+// triples to the graphs.
+type BatchError struct {
+	// First and Last are the numbers of the first and last lines of the
+	// batch, starting at 1. Binary and RDF inputs number records instead.
+	First, Last int
+	// Err is the error returned by the schema validation, usually a
+	// *schema.ValidationError, or by AddTriples.
+	Err error
+}
+
+// Error returns the error message including the lines of the batch.
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("lines %d-%d: %v", e.First, e.Last, e.Err)
+}
+
+// Unwrap returns the error that made the batch fail.
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Progress contains the loader counters.
+type Progress struct {
+	// Lines is the number of lines processed.
+	Lines int
+	// Triples is the number of triples added to the graphs.
+	Triples int
+	// Skipped is the number of lines skipped because they could not be
+	// parsed.
+	Skipped int
+}
+
+// LoaderOptions configures the bulk loader.
+type LoaderOptions struct {
+	// BatchSize is the number of lines parsed and added as a single batch.
+	// It defaults to 1000.
+	BatchSize int
+	// Workers is the number of concurrent parsers. It defaults to the number
+	// of CPUs.
+	Workers int
+	// Mode indicates what to do with lines that cannot be parsed.
+	Mode ErrorMode
+	// Parse parses a single line. It defaults to triple.Parse.
+	Parse func(line string, b literal.Builder) (*triple.Triple, error)
+	// OnError, if provided, is called for each line skipped.
+	OnError func(*LineError)
+	// OnProgress, if provided, is called after each batch is added to the
+	// graphs.
+	OnProgress func(Progress)
+	// MaxBytes, if positive, is the maximum size of the input once
+	// decompressed. Larger inputs stop the load with ErrTooLarge.
+	MaxBytes int64
+}
+
+// batch contains a chunk of consecutive lines and their parsed triples.
+type batch struct {
+	seq     int
+	first   int
+	lines   []string
+	triples []*triple.Triple
+	errs    []*LineError
+}
+
+// Load reads the lines on the reader and adds the resulting triples to all
+// the provided graphs. Gzip compressed inputs are transparently
+// decompressed. The input is streamed: lines are read in batches, parsed
+// concurrently by the configured number of workers, and each batch is added
+// to the graphs with a single AddTriples call in the same order found on the
+// reader. Empty lines and lines starting with # are ignored.
+//
+// Binary triple streams, as written by triple.Encoder, are also detected and
+// decoded in batches. Since binary streams cannot be resynchronized, errors
+// on them always abort the load, and progress counts records instead of
+// lines.
+//
+// In Abort mode Load stops on the first line that cannot be parsed and
+// returns a *LineError; all the triples on the lines before it would have
+// been added to the graphs. Graphs with a schema, as returned by schema.Of,
+// validate each batch before adding it, hence the required predicates of a
+// node need to be in the same batch as the node or in an earlier one.
+// Batches that fail validation or cannot be added stop the load with a
+// *BatchError wrapping the cause, so schema violations can be found with
+// errors.As.
+func Load(ctx context.Context, r io.Reader, gs []storage.Graph, b literal.Builder, opts *LoaderOptions) (Progress, error) {
+	return load(ctx, r, b, opts, func(ts []*triple.Triple) error {
+		for _, g := range gs {
+			if err := schema.Validate(ctx, g, ts); err != nil {
+				return err
+			}
+		}
+		for _, g := range gs {
+			if err := g.AddTriples(ctx, ts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// load reads and parses the input as Load does, but hands each batch of
+// triples to the add function.
+func load(ctx context.Context, r io.Reader, b literal.Builder, opts *LoaderOptions, add func([]*triple.Triple) error) (_ Progress, rErr error) {
+	var p Progress
+	o := LoaderOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.BatchSize < 1 {
+		o.BatchSize = 1000
+	}
+	if o.Workers < 1 {
+		o.Workers = runtime.NumCPU()
+	}
+	if o.Parse == nil {
+		o.Parse = triple.Parse
+	}
+	dr, err := Decompress(r)
+	if err != nil {
+		return p, err
+	}
+	if o.MaxBytes > 0 {
+		lr := &LimitedReader{R: dr, N: o.MaxBytes}
+		defer func() {
+			// Parsers may wrap or replace the error returned by the reader.
+			if lr.Exceeded() {
+				rErr = ErrTooLarge
+			}
+		}()
+		dr = lr
+	}
+	br := bufio.NewReader(dr)
+	if h, _ := br.Peek(4); triple.IsBinary(h) {
+		return loadBinary(ctx, br, b, o, add)
+	}
+	r = br
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		readErr error
+	)
+	// The tokens bound the number of batches in flight, hence the memory used
+	// regardless of the size of the input.
+	tokens := make(chan bool, 2*o.Workers)
+	pending, parsed := make(chan *batch, o.Workers), make(chan *batch, o.Workers)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 64*1024*1024)
+		seq, line := 0, 0
+		cur := &batch{first: 1}
+		send := func() bool {
+			if ctx.Err() != nil {
+				return false
+			}
+			select {
+			case tokens <- true:
+			case <-ctx.Done():
+				return false
+			}
+			pending <- cur
+			seq++
+			cur = &batch{seq: seq, first: line + 1}
+			return true
+		}
+		for scanner.Scan() {
+			line++
+			cur.lines = append(cur.lines, scanner.Text())
+			if len(cur.lines) == o.BatchSize && !send() {
+				return
+			}
+		}
+		readErr = scanner.Err()
+		if len(cur.lines) > 0 {
+			send()
+		}
+	}()
+	var pwg sync.WaitGroup
+	for i := 0; i < o.Workers; i++ {
+		pwg.Add(1)
+		go func() {
+			defer pwg.Done()
+			for bt := range pending {
+				for i, l := range bt.lines {
+					text := strings.TrimSpace(l)
+					if text == "" || strings.HasPrefix(text, "#") {
+						continue
+					}
+					t, err := o.Parse(text, b)
+					if err != nil {
+						bt.errs = append(bt.errs, &LineError{Line: bt.first + i, Text: l, Err: err})
+						if o.Mode == Abort {
+							break
+						}
+						continue
+					}
+					bt.triples = append(bt.triples, t)
+				}
+				parsed <- bt
+			}
+		}()
+	}
+	go func() {
+		pwg.Wait()
+		close(parsed)
+	}()
+	// Drain all the goroutines before returning.
+	defer func() {
+		cancel()
+		for range parsed {
+			<-tokens
+		}
+		wg.Wait()
+	}()
+
+	next, ready := 0, make(map[int]*batch)
+	for bt := range parsed {
+		ready[bt.seq] = bt
+		for {
+			bt, ok := ready[next]
+			if !ok {
+				break
+			}
+			delete(ready, next)
+			next++
+			<-tokens
+			if err := loadBatch(bt, o, add, &p); err != nil {
+				return p, err
+			}
+			if o.OnProgress != nil {
+				o.OnProgress(p)
+			}
+		}
+	}
+	wg.Wait()
+	if readErr != nil {
+		return p, readErr
+	}
+	return p, nil
+}
+
+// loadBatch adds the triples of a parsed batch and updates the progress.
+func loadBatch(bt *batch, o LoaderOptions, add func([]*triple.Triple) error, p *Progress) error {
+	ts, lines := bt.triples, len(bt.lines)
+	if len(bt.errs) > 0 && o.Mode == Abort {
+		// In abort mode workers stop parsing the batch on the first error, so
+		// all the triples parsed belong to lines before it.
+		lines = bt.errs[0].Line - bt.first
+	}
+	if len(ts) > 0 {
+		if err := add(ts); err != nil {
+			return &BatchError{First: bt.first, Last: bt.first + len(bt.lines) - 1, Err: err}
+		}
+	}
+	p.Lines += lines
+	p.Triples += len(ts)
+	if len(bt.errs) == 0 {
+		return nil
+	}
+	if o.Mode == Abort {
+		return bt.errs[0]
+	}
+	p.Skipped += len(bt.errs)
+	if o.OnError != nil {
+		for _, e := range bt.errs {
+			o.OnError(e)
+		}
+	}
+	return nil
+}
+
+// loadBinary loads a binary triple stream.
+func loadBinary(ctx context.Context, r io.Reader, b literal.Builder, o LoaderOptions, add func([]*triple.Triple) error) (Progress, error) {
+	var p Progress
+	d := triple.NewDecoder(r, b)
+	for done := false; !done; {
+		if err := ctx.Err(); err != nil {
+			return p, err
+		}
+		bt := &batch{first: p.Lines + 1}
+		for len(bt.lines) < o.BatchSize {
+			t, err := d.Decode()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return p, err
+			}
+			bt.triples = append(bt.triples, t)
+			// Records have no text, but lines keeps track of their number.
+			bt.lines = append(bt.lines, "")
+		}
+		if len(bt.lines) == 0 {
+			break
+		}
+		if err := loadBatch(bt, o, add, &p); err != nil {
+			return p, err
+		}
+		if o.OnProgress != nil {
+			o.OnProgress(p)
+		}
+	}
+	return p, nil
+}
+
+// ErrTooLarge is returned when an input exceeds its maximum size.
+var ErrTooLarge = errors.New("the input exceeds its maximum size")
+
+// LimitedReader reads from R but fails with ErrTooLarge once more than N
+// bytes have been read. Unlike io.LimitedReader, inputs exceeding the limit
+// are reported instead of being silently truncated.
+type LimitedReader struct {
+	R        io.Reader
+	N        int64
+	exceeded bool
+}
+
+// Read reads from the underlying reader as long as the limit is not
+// exceeded.
+func (l *LimitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrTooLarge
+	}
+	// Reading one byte past the limit tells inputs of exactly N bytes apart
+	// from larger ones.
+	if int64(len(p)) > l.N+1 {
+		p = p[:l.N+1]
+	}
+	n, err := l.R.Read(p)
+	if int64(n) > l.N {
+		l.exceeded = true
+		return int(l.N), ErrTooLarge
+	}
+	l.N -= int64(n)
+	return n, err
+}
+
+// Exceeded returns true if the input read exceeded the limit.
+func (l *LimitedReader) Exceeded() bool {
+	return l.exceeded
+}
+
+// gzipMagic contains the first bytes of gzip streams.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Decompress returns a reader decompressing the provided reader if it
+// contains a gzip stream. Otherwise the data is returned unchanged.
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
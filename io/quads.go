@@ -0,0 +1,156 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package io
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/schema"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+// QuadString returns the serialized quad for the triple stored in the
+// provided graph. Quads are serialized triples followed by a tab and the
+// name of the graph. For instance:
+//
+//	/u<joe>	"knows"@[]	/u<mary>	?family
+func QuadString(t *triple.Triple, graph string) string {
+	return t.String() + "\t" + graph
+}
+
+// ParseQuad parses a quad as returned by QuadString. It returns the triple
+// and the name of the graph it belongs to.
+func ParseQuad(line string, b literal.Builder) (*triple.Triple, string, error) {
+	raw := strings.TrimSpace(line)
+	idx := strings.LastIndexAny(raw, " \t")
+	if idx < 0 || !strings.HasPrefix(raw[idx+1:], "?") {
+		return nil, "", fmt.Errorf("io.ParseQuad: missing graph name in %q", raw)
+	}
+	t, err := triple.Parse(raw[:idx], b)
+	if err != nil {
+		return nil, "", fmt.Errorf("io.ParseQuad: %v", err)
+	}
+	return t, raw[idx+1:], nil
+}
+
+// ReadQuadsIntoStore reads the quads in the provided reader and adds each
+// triple to the graph named in its quad. Graphs that do not exist in the
+// store are created. Empty lines and lines starting with # are ignored. The
+// whole stream is read before adding any triple, and graphs with a schema
+// are validated first. If a line cannot
+// be parsed a *LineError is returned and no triple is added. If any graph
+// fails its validation no triple is added and the graphs created for the
+// input are dropped. It returns the number of triples added to each graph.
+func ReadQuadsIntoStore(ctx context.Context, s storage.Store, r io.Reader, b literal.Builder) (map[string]int, error) {
+	var gns []string
+	tss := make(map[string][]*triple.Triple)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		t, gn, err := ParseQuad(text, b)
+		if err != nil {
+			return nil, &LineError{Line: line, Text: scanner.Text(), Err: err}
+		}
+		if _, ok := tss[gn]; !ok {
+			gns = append(gns, gn)
+		}
+		tss[gn] = append(tss[gn], t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// All the graphs are validated before adding any triple. Graphs created
+	// here are dropped if any graph fails, so rejected inputs leave the store
+	// unchanged.
+	var created []string
+	fail := func(err error) (map[string]int, error) {
+		for _, gn := range created {
+			s.DeleteGraph(ctx, gn)
+		}
+		return nil, err
+	}
+	gs := make(map[string]storage.Graph)
+	for _, gn := range gns {
+		g, err := s.Graph(ctx, gn)
+		if err != nil {
+			if g, err = s.NewGraph(ctx, gn); err != nil {
+				return fail(err)
+			}
+			created = append(created, gn)
+		}
+		if err := schema.Validate(ctx, g, tss[gn]); err != nil {
+			return fail(err)
+		}
+		gs[gn] = g
+	}
+	cnt := make(map[string]int)
+	for _, gn := range gns {
+		if err := gs[gn].AddTriples(ctx, tss[gn]); err != nil {
+			return cnt, err
+		}
+		cnt[gn] = len(tss[gn])
+	}
+	return cnt, nil
+}
+
+// WriteQuads serializes the provided graphs into the writer where each
+// triple is marshaled as a quad using QuadString. It returns the number of
+// quads serialized.
+func WriteQuads(ctx context.Context, w io.Writer, gs []storage.Graph) (int, error) {
+	cnt := 0
+	for _, g := range gs {
+		var (
+			wg   sync.WaitGroup
+			tErr error
+			wErr error
+		)
+		gn, ts := g.ID(ctx), make(chan *triple.Triple)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tErr = g.Triples(ctx, storage.DefaultLookup, ts)
+		}()
+		for t := range ts {
+			if wErr != nil {
+				continue
+			}
+			if _, err := io.WriteString(w, QuadString(t, gn)+"\n"); err != nil {
+				wErr = err
+				continue
+			}
+			cnt++
+		}
+		wg.Wait()
+		if tErr != nil {
+			return 0, tErr
+		}
+		if wErr != nil {
+			return 0, wErr
+		}
+	}
+	return cnt, nil
+}
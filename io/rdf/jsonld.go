@@ -0,0 +1,441 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rdf
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/node"
+)
+
+// JSON-LD documents are node centric: all the triples sharing a subject are
+// written as a single node object. Since a node object can only be written
+// once all the triples of its subject are known, the whole document is
+// buffered until the writer is closed. Temporal triples are always written
+// using JSON-LD-star @annotation blocks holding the bw:anchor of the triple.
+
+// ldNode contains the properties of a JSON-LD node object.
+type ldNode struct {
+	id    string
+	props map[string][]interface{}
+}
+
+// ldContext returns the JSON-LD context used by the writer.
+func (w *Writer) ldContext() map[string]interface{} {
+	ctx := map[string]interface{}{
+		"@vocab": w.m.predicateBase(),
+		"node":   NodeBase + "/",
+	}
+	for _, ns := range w.prefixes {
+		ctx[ns.Prefix] = ns.IRI
+	}
+	return ctx
+}
+
+// ldCompact returns the compact version of an IRI using the provided terms.
+func ldCompact(iri string, prefixes []*Namespace) string {
+	for _, ns := range prefixes {
+		if l := strings.TrimPrefix(iri, ns.IRI); l != iri && !strings.HasPrefix(l, "//") {
+			return ns.Prefix + ":" + l
+		}
+	}
+	return iri
+}
+
+// ldID returns the JSON-LD identifier for a serialized node.
+func (w *Writer) ldID(iri string) string {
+	if strings.HasPrefix(iri, NodeBase+"/") {
+		return "node:" + iri[len(NodeBase)+1:]
+	}
+	return ldCompact(iri, w.prefixes)
+}
+
+// ldNodeID returns the JSON-LD identifier for a node.
+func (w *Writer) ldNodeID(n *node.Node) string {
+	if n.Type().String() == "/_" {
+		return "_:" + blankLabel(n.ID().String())
+	}
+	return w.ldID(w.m.nodeIRI(n))
+}
+
+// ldKey returns the JSON-LD property key for a predicate IRI. IRIs in the
+// vocabulary are written as terms unless they clash with a prefix.
+func (w *Writer) ldKey(iri string) string {
+	if l := strings.TrimPrefix(iri, w.m.predicateBase()); l != iri && l != "node" && !strings.ContainsAny(l, ":@") {
+		clash := false
+		for _, ns := range w.prefixes {
+			clash = clash || ns.Prefix == l
+		}
+		if !clash {
+			return l
+		}
+	}
+	return ldCompact(iri, w.prefixes)
+}
+
+// ldObject returns the JSON-LD value for an object.
+func (w *Writer) ldObject(o *triple.Object) (map[string]interface{}, error) {
+	if n, err := o.Node(); err == nil {
+		return map[string]interface{}{"@id": w.ldNodeID(n)}, nil
+	}
+	if p, err := o.Predicate(); err == nil {
+		return w.ldTyped(p.String(), bwPredicate), nil
+	}
+	l, err := o.Literal()
+	if err != nil {
+		return nil, fmt.Errorf("rdf.Writer: unknown object %v", o)
+	}
+	// Numbers are written as typed strings to avoid any loss of precision on
+	// JSON processors that decode numbers as doubles.
+	switch v := l.Interface().(type) {
+	case bool:
+		return map[string]interface{}{"@value": v}, nil
+	case int64:
+		return w.ldTyped(strconv.FormatInt(v, 10), xsdInteger), nil
+	case float64:
+		return w.ldTyped(formatDouble(v), xsdDouble), nil
+	case string:
+		return map[string]interface{}{"@value": v}, nil
+	case []byte:
+		return w.ldTyped(base64.StdEncoding.EncodeToString(v), xsdBase64), nil
+	default:
+		return nil, fmt.Errorf("rdf.Writer: unsupported literal type %v", l.Type())
+	}
+}
+
+// ldTriple buffers the provided triple into the JSON-LD document.
+func (w *Writer) ldTriple(t *triple.Triple) error {
+	o, err := w.ldObject(t.Object())
+	if err != nil {
+		return err
+	}
+	anchor := ""
+	if ta, err := t.Predicate().TimeAnchor(); err == nil {
+		anchor = ta.Format(time.RFC3339Nano)
+	}
+	w.ldWrite(w.ldNodeID(t.Subject()), w.ldKey(w.m.predicateIRI(string(t.Predicate().ID()))), o, anchor)
+	return nil
+}
+
+// ldTyped returns a JSON-LD typed value.
+func (w *Writer) ldTyped(v, dt string) map[string]interface{} {
+	return map[string]interface{}{"@value": v, "@type": ldCompact(dt, w.prefixes)}
+}
+
+// ldWrite buffers a statement into the JSON-LD document. The anchor is only
+// provided for temporal triples.
+func (w *Writer) ldWrite(s, p string, o map[string]interface{}, anchor string) {
+	n, ok := w.ld[s]
+	if !ok {
+		n = &ldNode{id: s, props: make(map[string][]interface{})}
+		w.ld[s] = n
+		w.ldOrder = append(w.ldOrder, s)
+	}
+	if anchor != "" {
+		o["@annotation"] = map[string]interface{}{
+			ldCompact(bwAnchor, w.prefixes): w.ldTyped(anchor, xsdDateTime),
+		}
+	}
+	n.props[p] = append(n.props[p], o)
+}
+
+// ldClose writes the buffered JSON-LD document.
+func (w *Writer) ldClose() error {
+	var graph []interface{}
+	for _, id := range w.ldOrder {
+		n := w.ld[id]
+		obj := map[string]interface{}{"@id": n.id}
+		for k, vs := range n.props {
+			obj[k] = vs
+		}
+		graph = append(graph, obj)
+	}
+	if graph == nil {
+		graph = []interface{}{}
+	}
+	bs, err := json.MarshalIndent(map[string]interface{}{
+		"@context": w.ldContext(),
+		"@graph":   graph,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// ldParser converts a JSON-LD document into statements.
+type ldParser struct {
+	terms map[string]string
+	vocab string
+	emit  func(*statement) error
+}
+
+// parseJSONLD parses the JSON-LD document on the reader and calls emit for
+// each of the statements found. It supports the node centric documents
+// produced by the writer: node objects with embedded node objects, value
+// objects, lists, and @annotation blocks. Only the simple term definitions
+// of the top level context are used to expand IRIs.
+func parseJSONLD(r io.Reader, emit func(*statement) error) error {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var doc interface{}
+	if err := d.Decode(&doc); err != nil {
+		return err
+	}
+	p := &ldParser{
+		terms: make(map[string]string),
+		vocab: PredicateBase,
+		emit:  emit,
+	}
+	var nodes []interface{}
+	switch v := doc.(type) {
+	case []interface{}:
+		nodes = v
+	case map[string]interface{}:
+		if ctx, ok := v["@context"].(map[string]interface{}); ok {
+			for k, iri := range ctx {
+				s, ok := iri.(string)
+				if !ok {
+					continue
+				}
+				if k == "@vocab" {
+					p.vocab = s
+					continue
+				}
+				p.terms[k] = s
+			}
+		}
+		if g, ok := v["@graph"].([]interface{}); ok {
+			nodes = g
+		} else {
+			nodes = []interface{}{v}
+		}
+	default:
+		return fmt.Errorf("JSON-LD documents must be objects or arrays")
+	}
+	for _, n := range nodes {
+		obj, ok := n.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid node object %v", n)
+		}
+		if _, err := p.node(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expand returns the expanded version of an IRI. Property keys and types are
+// expanded using the vocabulary.
+func (p *ldParser) expand(s string, vocab bool) string {
+	if idx := strings.Index(s, ":"); idx > 0 {
+		if ns, ok := p.terms[s[:idx]]; ok && !strings.HasPrefix(s[idx+1:], "//") {
+			return ns + s[idx+1:]
+		}
+		return s
+	}
+	if vocab {
+		if t, ok := p.terms[s]; ok {
+			return t
+		}
+		return p.vocab + s
+	}
+	return s
+}
+
+// idTerm returns the term for a node identifier.
+func (p *ldParser) idTerm(id string) *term {
+	if strings.HasPrefix(id, "_:") {
+		return &term{kind: blankTerm, value: id[2:]}
+	}
+	return &term{kind: iriTerm, value: p.expand(id, false)}
+}
+
+// node processes a node object and returns its term.
+func (p *ldParser) node(obj map[string]interface{}) (*term, error) {
+	s := fresh()
+	if id, ok := obj["@id"].(string); ok {
+		s = p.idTerm(id)
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch k {
+		case "@id", "@context":
+			continue
+		case "@type":
+			for _, v := range asList(obj[k]) {
+				t, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid @type %v", v)
+				}
+				if err := p.emit(&statement{s: s, p: &term{kind: iriTerm, value: rdfType}, o: &term{kind: iriTerm, value: p.expand(t, true)}}); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(k, "@") {
+			return nil, fmt.Errorf("unsupported keyword %q", k)
+		}
+		pred := &term{kind: iriTerm, value: p.expand(k, true)}
+		for _, v := range asList(obj[k]) {
+			if err := p.property(s, pred, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// property emits the statement for a property value and its annotations.
+// Statements annotated with a bw:anchor are temporal triples, hence only the
+// annotation is emitted.
+func (p *ldParser) property(s, pred *term, v interface{}) error {
+	o, err := p.value(v)
+	if err != nil {
+		return err
+	}
+	var ann map[string]interface{}
+	if obj, ok := v.(map[string]interface{}); ok {
+		ann, _ = obj["@annotation"].(map[string]interface{})
+	}
+	keys := make([]string, 0, len(ann))
+	temporal := false
+	for k := range ann {
+		keys = append(keys, k)
+		temporal = temporal || p.expand(k, true) == bwAnchor
+	}
+	sort.Strings(keys)
+	if !temporal {
+		if err := p.emit(&statement{s: s, p: pred, o: o}); err != nil {
+			return err
+		}
+	}
+	q := &term{kind: quotedTerm, quoted: &statement{s: s, p: pred, o: o}}
+	for _, k := range keys {
+		av := ann[k]
+		ap := &term{kind: iriTerm, value: p.expand(k, true)}
+		for _, a := range asList(av) {
+			ao, err := p.value(a)
+			if err != nil {
+				return err
+			}
+			if err := p.emit(&statement{s: q, p: ap, o: ao}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// value returns the term for a property value.
+func (p *ldParser) value(v interface{}) (*term, error) {
+	switch tv := v.(type) {
+	case string:
+		return &term{kind: literalTerm, value: tv}, nil
+	case bool:
+		return &term{kind: literalTerm, value: fmt.Sprint(tv), datatype: xsdBoolean}, nil
+	case json.Number:
+		return numberTerm(tv), nil
+	case map[string]interface{}:
+		if val, ok := tv["@value"]; ok {
+			l, err := p.value(val)
+			if err != nil {
+				return nil, err
+			}
+			if l.kind != literalTerm {
+				return nil, fmt.Errorf("invalid @value %v", val)
+			}
+			if dt, ok := tv["@type"].(string); ok {
+				l.datatype = p.expand(dt, true)
+			}
+			if lang, ok := tv["@language"].(string); ok {
+				l.lang = lang
+			}
+			return l, nil
+		}
+		if list, ok := tv["@list"]; ok {
+			return p.list(asList(list))
+		}
+		if id, ok := tv["@id"].(string); ok && isReference(tv) {
+			return p.idTerm(id), nil
+		}
+		return p.node(tv)
+	default:
+		return nil, fmt.Errorf("unsupported JSON-LD value %v", v)
+	}
+}
+
+// list emits the statements of an RDF list and returns its head.
+func (p *ldParser) list(vs []interface{}) (*term, error) {
+	head := &term{kind: iriTerm, value: rdfNil}
+	for i := len(vs) - 1; i >= 0; i-- {
+		o, err := p.value(vs[i])
+		if err != nil {
+			return nil, err
+		}
+		n := fresh()
+		if err := p.emit(&statement{s: n, p: &term{kind: iriTerm, value: rdfFirst}, o: o}); err != nil {
+			return nil, err
+		}
+		if err := p.emit(&statement{s: n, p: &term{kind: iriTerm, value: rdfRest}, o: head}); err != nil {
+			return nil, err
+		}
+		head = n
+	}
+	return head, nil
+}
+
+// isReference returns true if the object only references a node.
+func isReference(obj map[string]interface{}) bool {
+	for k := range obj {
+		if k != "@id" && k != "@annotation" {
+			return false
+		}
+	}
+	return true
+}
+
+// numberTerm returns the literal term for a JSON number.
+func numberTerm(n json.Number) *term {
+	dt := xsdInteger
+	if strings.ContainsAny(string(n), ".eE") {
+		dt = xsdDouble
+	}
+	return &term{kind: literalTerm, value: string(n), datatype: dt}
+}
+
+// asList returns the provided value as a list.
+func asList(v interface{}) []interface{} {
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+	return []interface{}{v}
+}
@@ -0,0 +1,277 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rdf provides tools to exchange BadWolf graphs with RDF tooling. It
+// reads and writes N-Triples, Turtle, and JSON-LD, and maps RDF terms to BadWolf nodes,
+// predicates, and literals using a configurable mapping.
+package rdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/badwolf/triple/literal"
+)
+
+// Format describes the RDF serialization used.
+type Format uint8
+
+const (
+	// NTriples is the line based N-Triples format.
+	NTriples Format = iota
+	// Turtle is the Terse RDF Triple Language format.
+	Turtle
+	// JSONLD is the JSON-LD format. Documents are framed by subject: each node
+	// object contains all the triples of a subject.
+	JSONLD
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case NTriples:
+		return "ntriples"
+	case Turtle:
+		return "turtle"
+	case JSONLD:
+		return "jsonld"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseFormat returns the format for the provided name. It accepts the
+// format names and their usual file extensions.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "ntriples", "n-triples", "nt":
+		return NTriples, nil
+	case "turtle", "ttl":
+		return Turtle, nil
+	case "jsonld", "json-ld":
+		return JSONLD, nil
+	default:
+		return 0, fmt.Errorf("rdf.ParseFormat: unknown RDF format %q", s)
+	}
+}
+
+// Well known vocabularies.
+const (
+	RDFNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	XSDNamespace = "http://www.w3.org/2001/XMLSchema#"
+
+	// BadWolfNamespace contains the terms used to represent BadWolf
+	// concepts that have no RDF counterpart.
+	BadWolfNamespace = "http://github.com/google/badwolf/ns#"
+	// NodeBase is the IRI prefix used for nodes without a namespace. The node
+	// type is appended to it followed by the escaped node ID.
+	NodeBase = "http://github.com/google/badwolf/node"
+	// PredicateBase is the IRI prefix used for predicates without a
+	// namespace.
+	PredicateBase = "http://github.com/google/badwolf/predicate/"
+
+	rdfType      = RDFNamespace + "type"
+	rdfStatement = RDFNamespace + "Statement"
+	rdfSubject   = RDFNamespace + "subject"
+	rdfPredicate = RDFNamespace + "predicate"
+	rdfObject    = RDFNamespace + "object"
+	rdfFirst     = RDFNamespace + "first"
+	rdfRest      = RDFNamespace + "rest"
+	rdfNil       = RDFNamespace + "nil"
+	rdfLangStr   = RDFNamespace + "langString"
+
+	xsdString   = XSDNamespace + "string"
+	xsdBoolean  = XSDNamespace + "boolean"
+	xsdInteger  = XSDNamespace + "integer"
+	xsdDecimal  = XSDNamespace + "decimal"
+	xsdDouble   = XSDNamespace + "double"
+	xsdBase64   = XSDNamespace + "base64Binary"
+	xsdDateTime = XSDNamespace + "dateTime"
+
+	bwAnchor    = BadWolfNamespace + "anchor"
+	bwPredicate = BadWolfNamespace + "predicate"
+)
+
+// TemporalMode describes how temporal predicates are represented in RDF.
+type TemporalMode uint8
+
+const (
+	// Reify represents temporal triples as reified rdf:Statement resources
+	// with an additional bw:anchor property holding the time anchor.
+	Reify TemporalMode = iota
+	// Annotate represents temporal triples as RDF-star quoted triples
+	// annotated with a bw:anchor property.
+	Annotate
+)
+
+// String returns the name of the temporal mode.
+func (t TemporalMode) String() string {
+	switch t {
+	case Reify:
+		return "reify"
+	case Annotate:
+		return "annotate"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Namespace maps an IRI prefix to BadWolf terms.
+type Namespace struct {
+	// Prefix is the Turtle prefix label used when writing. It is optional.
+	Prefix string `json:"prefix,omitempty"`
+	// IRI is the IRI prefix. The rest of the IRI becomes the ID of the
+	// node or predicate.
+	IRI string `json:"iri"`
+	// Type is the node type of the nodes in the namespace. It is ignored for
+	// predicate namespaces.
+	Type string `json:"type,omitempty"`
+}
+
+// Mapping describes how RDF terms and BadWolf terms relate to each other.
+type Mapping struct {
+	// Nodes maps IRI prefixes to node types. The longest matching prefix is
+	// used when reading. IRIs with no match become nodes of DefaultType
+	// whose ID is the full IRI.
+	Nodes []*Namespace
+	// Predicates lists the IRI prefixes stripped from predicate IRIs when
+	// reading. IRIs with no match keep the full IRI as predicate ID. When
+	// writing, predicate IDs that are not IRIs are appended to the first
+	// predicate namespace, or PredicateBase if there is none.
+	Predicates []*Namespace
+	// DefaultType is the node type used for IRIs that do not belong to any
+	// namespace. It defaults to /iri.
+	DefaultType string
+	// Datatypes maps datatype IRIs to literal types. It extends the default
+	// XML schema mapping. Unknown datatypes are read as text.
+	Datatypes map[string]literal.Type
+	// Temporal describes how temporal triples are written. Both
+	// representations are always understood when reading.
+	Temporal TemporalMode
+}
+
+// DefaultMapping returns the mapping used when none is provided.
+func DefaultMapping() *Mapping {
+	return &Mapping{}
+}
+
+// defaultDatatypes maps the XML schema datatypes to literal types.
+var defaultDatatypes = map[string]literal.Type{
+	xsdString:                           literal.Text,
+	rdfLangStr:                          literal.Text,
+	xsdBoolean:                          literal.Bool,
+	xsdInteger:                          literal.Int64,
+	XSDNamespace + "int":                literal.Int64,
+	XSDNamespace + "long":               literal.Int64,
+	XSDNamespace + "short":              literal.Int64,
+	XSDNamespace + "byte":               literal.Int64,
+	XSDNamespace + "nonNegativeInteger": literal.Int64,
+	XSDNamespace + "nonPositiveInteger": literal.Int64,
+	XSDNamespace + "positiveInteger":    literal.Int64,
+	XSDNamespace + "negativeInteger":    literal.Int64,
+	XSDNamespace + "unsignedInt":        literal.Int64,
+	XSDNamespace + "unsignedShort":      literal.Int64,
+	XSDNamespace + "unsignedByte":       literal.Int64,
+	xsdDecimal:                          literal.Float64,
+	xsdDouble:                           literal.Float64,
+	XSDNamespace + "float":              literal.Float64,
+	xsdBase64:                           literal.Blob,
+}
+
+// datatype returns the literal type for the provided datatype IRI.
+func (m *Mapping) datatype(iri string) literal.Type {
+	if t, ok := m.Datatypes[iri]; ok {
+		return t
+	}
+	if t, ok := defaultDatatypes[iri]; ok {
+		return t
+	}
+	return literal.Text
+}
+
+// defaultType returns the type used for IRIs without namespace.
+func (m *Mapping) defaultType() string {
+	if m.DefaultType == "" {
+		return "/iri"
+	}
+	return m.DefaultType
+}
+
+// mappingFile contains the JSON representation of a mapping.
+type mappingFile struct {
+	Nodes       []*Namespace      `json:"nodes,omitempty"`
+	Predicates  []*Namespace      `json:"predicates,omitempty"`
+	DefaultType string            `json:"default_type,omitempty"`
+	Datatypes   map[string]string `json:"datatypes,omitempty"`
+	Temporal    string            `json:"temporal,omitempty"`
+}
+
+// ParseMapping reads a JSON mapping from the provided reader. For instance:
+//
+//	{
+//	  "nodes": [{"prefix": "ex", "iri": "http://example.org/person/", "type": "/person"}],
+//	  "predicates": [{"prefix": "foaf", "iri": "http://xmlns.com/foaf/0.1/"}],
+//	  "default_type": "/iri",
+//	  "datatypes": {"http://www.w3.org/2001/XMLSchema#date": "text"},
+//	  "temporal": "annotate"
+//	}
+func ParseMapping(r io.Reader) (*Mapping, error) {
+	mf := &mappingFile{}
+	if err := json.NewDecoder(r).Decode(mf); err != nil {
+		return nil, fmt.Errorf("rdf.ParseMapping: %v", err)
+	}
+	m := &Mapping{
+		Nodes:       mf.Nodes,
+		Predicates:  mf.Predicates,
+		DefaultType: mf.DefaultType,
+		Datatypes:   make(map[string]literal.Type),
+	}
+	for _, ns := range m.Nodes {
+		if ns.IRI == "" || ns.Type == "" {
+			return nil, fmt.Errorf("rdf.ParseMapping: node namespaces require an IRI and a type; got %+v", ns)
+		}
+	}
+	for _, ns := range m.Predicates {
+		if ns.IRI == "" {
+			return nil, fmt.Errorf("rdf.ParseMapping: predicate namespaces require an IRI; got %+v", ns)
+		}
+	}
+	for iri, s := range mf.Datatypes {
+		t, ok := literalTypes[strings.ToLower(s)]
+		if !ok {
+			return nil, fmt.Errorf("rdf.ParseMapping: unknown literal type %q for datatype %q", s, iri)
+		}
+		m.Datatypes[iri] = t
+	}
+	switch strings.ToLower(mf.Temporal) {
+	case "", "reify":
+		m.Temporal = Reify
+	case "annotate":
+		m.Temporal = Annotate
+	default:
+		return nil, fmt.Errorf("rdf.ParseMapping: unknown temporal mode %q", mf.Temporal)
+	}
+	return m, nil
+}
+
+// literalTypes maps the literal type names to their types.
+var literalTypes = map[string]literal.Type{
+	"bool":    literal.Bool,
+	"int64":   literal.Int64,
+	"float64": literal.Float64,
+	"text":    literal.Text,
+	"blob":    literal.Blob,
+}
@@ -0,0 +1,316 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rdf
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	bwio "github.com/google/badwolf/io"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/storage/schema"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func readAll(t *testing.T, s string, f Format, m *Mapping) []string {
+	var res []string
+	if _, err := Read(context.Background(), strings.NewReader(s), f, m, literal.DefaultBuilder(), func(trpl *triple.Triple) error {
+		res = append(res, trpl.String())
+		return nil
+	}); err != nil {
+		t.Fatalf("rdf.Read(%q) failed with error %v", s, err)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func checkTriples(t *testing.T, id string, got, want []string) {
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%s returned the wrong triples;\ngot:\n%s\nwant:\n%s", id, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func testMapping() *Mapping {
+	return &Mapping{
+		Nodes: []*Namespace{
+			{Prefix: "person", IRI: "http://example.org/person/", Type: "/person"},
+		},
+		Predicates: []*Namespace{
+			{Prefix: "foaf", IRI: "http://xmlns.com/foaf/0.1/"},
+		},
+	}
+}
+
+func TestReadNTriples(t *testing.T) {
+	nt := `# A comment.
+<http://example.org/person/john> <http://xmlns.com/foaf/0.1/knows> <http://example.org/person/mary> .
+<http://example.org/person/john> <http://xmlns.com/foaf/0.1/name> "John \"J\" Doe"@en .
+<http://example.org/person/john> <http://xmlns.com/foaf/0.1/age> "42"^^<http://www.w3.org/2001/XMLSchema#integer> .
+<http://example.org/person/john> <http://xmlns.com/foaf/0.1/homepage> <http://john.example.org/> .
+_:b1 <http://example.org/rel> "1.5"^^<http://www.w3.org/2001/XMLSchema#double> .
+`
+	want := []string{
+		"/person<john>\t\"knows\"@[]\t/person<mary>",
+		"/person<john>\t\"name\"@[]\t\"John \"J\" Doe\"^^type:text",
+		"/person<john>\t\"age\"@[]\t\"42\"^^type:int64",
+		"/person<john>\t\"homepage\"@[]\t/iri<http://john.example.org/>",
+		"/_<b1>\t\"http://example.org/rel\"@[]\t\"1.5\"^^type:float64",
+	}
+	checkTriples(t, "rdf.Read(NTriples)", readAll(t, nt, NTriples, testMapping()), want)
+}
+
+func TestReadTurtle(t *testing.T) {
+	ttl := `@prefix p: <http://example.org/person/> .
+@prefix foaf: <http://xmlns.com/foaf/0.1/> .
+@prefix xsd: <http://www.w3.org/2001/XMLSchema#> .
+BASE <http://example.org/>
+
+p:john foaf:knows p:mary, p:peter ;
+    foaf:age 42 ;
+    foaf:height 1.8 ;
+    foaf:alive true ;
+    foaf:bio """Multi
+line""" ;
+    foaf:nick 'jd'^^xsd:string ;
+    a <Person> .
+p:mary foaf:friends ( p:john ) .
+`
+	want := []string{
+		"/person<john>\t\"knows\"@[]\t/person<mary>",
+		"/person<john>\t\"knows\"@[]\t/person<peter>",
+		"/person<john>\t\"age\"@[]\t\"42\"^^type:int64",
+		"/person<john>\t\"height\"@[]\t\"1.8\"^^type:float64",
+		"/person<john>\t\"alive\"@[]\t\"true\"^^type:bool",
+		"/person<john>\t\"bio\"@[]\t\"Multi\nline\"^^type:text",
+		"/person<john>\t\"nick\"@[]\t\"jd\"^^type:text",
+		"/person<john>\t\"http://www.w3.org/1999/02/22-rdf-syntax-ns#type\"@[]\t/iri<http://example.org/Person>",
+	}
+	got := readAll(t, ttl, Turtle, testMapping())
+	// The collection generates blank nodes with random IDs, so only count
+	// them.
+	var named []string
+	blanks := 0
+	for _, s := range got {
+		if strings.HasPrefix(s, "/_<") || strings.Contains(s, "\t/_<") {
+			blanks++
+			continue
+		}
+		named = append(named, s)
+	}
+	checkTriples(t, "rdf.Read(Turtle)", named, want)
+	if got, want := blanks, 3; got != want {
+		t.Errorf("rdf.Read(Turtle) returned the wrong number of collection triples; got %d, want %d", got, want)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	table := []string{
+		`<http://example.org/a> <http://example.org/b> .`,
+		`<http://example.org/a> <http://example.org/b> "unterminated .`,
+		`undefined:a <http://example.org/b> <http://example.org/c> .`,
+		`<http://example.org/a> <http://example.org/b> "x"^^<http://www.w3.org/2001/XMLSchema#integer> .`,
+		`<< <http://example.org/a> <http://example.org/b> <http://example.org/c> >> <http://example.org/d> "x" .`,
+	}
+	for _, s := range table {
+		if _, err := Read(context.Background(), strings.NewReader(s), Turtle, nil, literal.DefaultBuilder(), func(*triple.Triple) error { return nil }); err == nil {
+			t.Errorf("rdf.Read(%q) should have failed", s)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := []string{
+		"/person<john>\t\"knows\"@[]\t/person<mary>",
+		"/u<john doe>\t\"knows\"@[]\t/u<mary/smith>",
+		"/u<john>\t\"http://example.org/name\"@[]\t\"John\"^^type:text",
+		"/u<john>\t\"met\"@[2016-04-10T04:21:00Z]\t/u<peter>",
+		"/u<john>\t\"age\"@[]\t\"42\"^^type:int64",
+		"/u<john>\t\"height\"@[]\t\"1.8\"^^type:float64",
+		"/u<john>\t\"alive\"@[]\t\"true\"^^type:bool",
+		"/u<john>\t\"data\"@[]\t\"[1 2 3]\"^^type:blob",
+		"/u<john>\t\"refers\"@[]\t\"met\"@[2016-04-10T04:21:00Z]",
+		"/u<john>\t\"seen\"@[2016-04-10T04:21:00.000000123+02:00]\t\"x\"^^type:text",
+		"/u<john>\t\"xsd\"@[]\t\"clash\"^^type:text",
+		"/_<b0>\t\"knows\"@[]\t/iri<http://example.org/x>",
+	}
+	for _, f := range []Format{NTriples, Turtle, JSONLD} {
+		for _, tm := range []TemporalMode{Reify, Annotate} {
+			g, err := memory.NewStore().NewGraph(ctx, "?test")
+			if err != nil {
+				t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+			}
+			for _, s := range src {
+				trpl, err := triple.Parse(s, literal.DefaultBuilder())
+				if err != nil {
+					t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", s, err)
+				}
+				if err := g.AddTriples(ctx, []*triple.Triple{trpl}); err != nil {
+					t.Fatalf("g.AddTriples failed with error %v", err)
+				}
+			}
+			m := testMapping()
+			m.Temporal = tm
+			var buf bytes.Buffer
+			cnt, err := WriteGraph(ctx, &buf, g, f, m)
+			if err != nil {
+				t.Fatalf("rdf.WriteGraph(%s, %s) failed with error %v", f, tm, err)
+			}
+			if got, want := cnt, len(src); got != want {
+				t.Errorf("rdf.WriteGraph(%s, %s) wrote the wrong number of triples; got %d, want %d", f, tm, got, want)
+			}
+			want := append([]string{}, src...)
+			checkTriples(t, "rdf.Read(rdf.WriteGraph("+f.String()+", "+tm.String()+"))", readAll(t, buf.String(), f, m), want)
+		}
+	}
+}
+
+func TestReadJSONLD(t *testing.T) {
+	doc := `{
+  "@context": {
+    "@vocab": "http://xmlns.com/foaf/0.1/",
+    "p": "http://example.org/person/",
+    "bw": "http://github.com/google/badwolf/ns#",
+    "xsd": "http://www.w3.org/2001/XMLSchema#"
+  },
+  "@graph": [
+    {
+      "@id": "p:john",
+      "@type": "http://example.org/Person",
+      "name": "John",
+      "age": 42,
+      "height": {"@value": "1.8", "@type": "xsd:double"},
+      "alive": true,
+      "knows": [
+        {"@id": "p:mary"},
+        {"@id": "p:peter", "@annotation": {"bw:anchor": {"@value": "2016-04-10T04:21:00Z", "@type": "xsd:dateTime"}}}
+      ],
+      "account": {"@id": "_:a1", "http://example.org/nick": "jd"}
+    }
+  ]
+}`
+	want := []string{
+		"/person<john>\t\"http://www.w3.org/1999/02/22-rdf-syntax-ns#type\"@[]\t/iri<http://example.org/Person>",
+		"/person<john>\t\"name\"@[]\t\"John\"^^type:text",
+		"/person<john>\t\"age\"@[]\t\"42\"^^type:int64",
+		"/person<john>\t\"height\"@[]\t\"1.8\"^^type:float64",
+		"/person<john>\t\"alive\"@[]\t\"true\"^^type:bool",
+		"/person<john>\t\"knows\"@[]\t/person<mary>",
+		"/person<john>\t\"knows\"@[2016-04-10T04:21:00Z]\t/person<peter>",
+		"/person<john>\t\"account\"@[]\t/_<a1>",
+		"/_<a1>\t\"http://example.org/nick\"@[]\t\"jd\"^^type:text",
+	}
+	checkTriples(t, "rdf.Read(JSONLD)", readAll(t, doc, JSONLD, testMapping()), want)
+}
+
+func TestParseMapping(t *testing.T) {
+	m, err := ParseMapping(strings.NewReader(`{
+		"nodes": [{"prefix": "p", "iri": "http://example.org/person/", "type": "/person"}],
+		"predicates": [{"iri": "http://xmlns.com/foaf/0.1/"}],
+		"default_type": "/web",
+		"datatypes": {"http://www.w3.org/2001/XMLSchema#date": "text", "http://example.org/flag": "bool"},
+		"temporal": "annotate"
+	}`))
+	if err != nil {
+		t.Fatalf("rdf.ParseMapping failed with error %v", err)
+	}
+	if got, want := m.Temporal, Annotate; got != want {
+		t.Errorf("rdf.ParseMapping returned the wrong temporal mode; got %v, want %v", got, want)
+	}
+	nt := `<http://example.org/x> <http://xmlns.com/foaf/0.1/flag> "1"^^<http://example.org/flag> .`
+	want := []string{"/web<http://example.org/x>\t\"flag\"@[]\t\"true\"^^type:bool"}
+	checkTriples(t, "rdf.Read(NTriples)", readAll(t, nt, NTriples, m), want)
+
+	for _, s := range []string{
+		`{"nodes": [{"iri": "http://example.org/"}]}`,
+		`{"datatypes": {"http://example.org/t": "date"}}`,
+		`{"temporal": "ignore"}`,
+	} {
+		if _, err := ParseMapping(strings.NewReader(s)); err == nil {
+			t.Errorf("rdf.ParseMapping(%q) should have failed", s)
+		}
+	}
+}
+
+// countingGraph counts the calls to AddTriples.
+type countingGraph struct {
+	storage.Graph
+	calls int
+}
+
+func (g *countingGraph) AddTriples(ctx context.Context, ts []*triple.Triple) error {
+	g.calls++
+	return g.Graph.AddTriples(ctx, ts)
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	nt := `<http://example.org/person/john> <http://xmlns.com/foaf/0.1/knows> <http://example.org/person/mary> .
+<http://example.org/person/mary> <http://xmlns.com/foaf/0.1/knows> <http://example.org/person/john> .
+<http://example.org/person/john> <http://xmlns.com/foaf/0.1/knows> <http://example.org/person/peter> .
+`
+	s := memory.NewStore()
+	var gs []storage.Graph
+	for _, id := range []string{"?rdf_load_a", "?rdf_load_b"} {
+		g, err := s.NewGraph(ctx, id)
+		if err != nil {
+			t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+		}
+		gs = append(gs, &countingGraph{Graph: g})
+	}
+	cnt, err := Load(ctx, strings.NewReader(nt), gs, NTriples, testMapping(), literal.DefaultBuilder(), 2)
+	if err != nil {
+		t.Fatalf("rdf.Load failed with error %v", err)
+	}
+	if cnt != 3 {
+		t.Errorf("rdf.Load added %d triples; want 3", cnt)
+	}
+	for _, g := range gs {
+		if got := g.(*countingGraph).calls; got != 2 {
+			t.Errorf("rdf.Load called AddTriples %d times on graph %q; want 2", got, g.ID(ctx))
+		}
+	}
+
+	// The first batch is added and the second one violates the schema.
+	ss := schema.NewStore(s)
+	g, err := ss.NewGraph(ctx, "?rdf_load_schema")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	if err := ss.Register("?rdf_load_schema", &schema.Schema{
+		Predicates: []*schema.Predicate{{ID: "knows", Cardinality: schema.SingleValued}},
+	}); err != nil {
+		t.Fatalf("schema.Store.Register failed with error %v", err)
+	}
+	cnt, err = Load(ctx, strings.NewReader(nt), []storage.Graph{g}, NTriples, testMapping(), literal.DefaultBuilder(), 2)
+	var ve *schema.ValidationError
+	if !errors.As(err, &ve) {
+		t.Errorf("rdf.Load should have returned a *schema.ValidationError; got %v", err)
+	}
+	if be, ok := err.(*bwio.BatchError); !ok || be.First != 3 || be.Last != 3 {
+		t.Errorf("rdf.Load should have rejected the batch with the third triple; got %v", err)
+	}
+	if cnt != 2 {
+		t.Errorf("rdf.Load added %d triples before the rejected batch; want 2", cnt)
+	}
+}
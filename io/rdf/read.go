@@ -0,0 +1,403 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rdf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	bwio "github.com/google/badwolf/io"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/schema"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// reification collects the statements describing a reified statement.
+type reification struct {
+	s, p, o, anchor *term
+	stms            []*statement
+}
+
+// complete returns true if the reification describes a temporal triple.
+func (r *reification) complete() bool {
+	return r.s != nil && r.p != nil && r.p.kind == iriTerm && r.o != nil && r.anchor != nil
+}
+
+// reader converts RDF statements into triples.
+type reader struct {
+	m       *Mapping
+	b       literal.Builder
+	fn      func(*triple.Triple) error
+	cnt     int
+	reified map[string]*reification
+	order   []string
+}
+
+// Read parses the RDF statements on the reader and calls fn for each of the
+// resulting triples. Temporal triples represented as reified statements are
+// only reported once the whole input has been read. It returns the number of
+// triples reported. If no mapping is provided DefaultMapping is used.
+// N-Triples and Turtle are parsed by the same Turtle parser, since N-Triples
+// is a subset of Turtle.
+func Read(ctx context.Context, r io.Reader, f Format, m *Mapping, b literal.Builder, fn func(*triple.Triple) error) (int, error) {
+	if m == nil {
+		m = DefaultMapping()
+	}
+	rd := &reader{
+		m:       m,
+		b:       b,
+		fn:      fn,
+		reified: make(map[string]*reification),
+	}
+	parseFn := parse
+	if f == JSONLD {
+		parseFn = parseJSONLD
+	}
+	if err := parseFn(r, rd.statement); err != nil {
+		return rd.cnt, fmt.Errorf("rdf.Read(%s): %v", f, err)
+	}
+	if err := rd.flush(); err != nil {
+		return rd.cnt, fmt.Errorf("rdf.Read(%s): %v", f, err)
+	}
+	return rd.cnt, nil
+}
+
+// defaultBatchSize is the number of triples added at once by ReadIntoGraph.
+const defaultBatchSize = 1000
+
+// ReadIntoGraph reads the RDF statements on the reader and adds the resulting
+// triples to the graph in batches using Load.
+func ReadIntoGraph(ctx context.Context, g storage.Graph, r io.Reader, f Format, m *Mapping, b literal.Builder) (int, error) {
+	return Load(ctx, r, []storage.Graph{g}, f, m, b, defaultBatchSize)
+}
+
+// Load reads the RDF statements on the reader and adds the resulting triples
+// to all the provided graphs in batches of batchSize triples, 1000 if not
+// positive. As io.Load, graphs with a schema validate each batch before
+// adding it, and the first batch that is rejected or cannot be added stops
+// the load with an *io.BatchError numbering the triples of the batch. The
+// batches before it would have been added to the graphs. It returns the
+// number of triples added.
+func Load(ctx context.Context, r io.Reader, gs []storage.Graph, f Format, m *Mapping, b literal.Builder, batchSize int) (int, error) {
+	if batchSize < 1 {
+		batchSize = defaultBatchSize
+	}
+	var (
+		ts   []*triple.Triple
+		cnt  int
+		aErr error
+	)
+	add := func() error {
+		if err := addBatch(ctx, gs, ts); err != nil {
+			aErr = &bwio.BatchError{First: cnt + 1, Last: cnt + len(ts), Err: err}
+			return aErr
+		}
+		cnt += len(ts)
+		ts = nil
+		return nil
+	}
+	_, err := Read(ctx, r, f, m, b, func(t *triple.Triple) error {
+		if ts = append(ts, t); len(ts) < batchSize {
+			return nil
+		}
+		return add()
+	})
+	if err == nil {
+		err = add()
+	}
+	if aErr != nil {
+		// Errors adding the triples are returned as is so callers can tell
+		// them apart from parsing errors.
+		return cnt, aErr
+	}
+	return cnt, err
+}
+
+// addBatch validates the batch against the schemas of all the graphs and then
+// adds it to them.
+func addBatch(ctx context.Context, gs []storage.Graph, ts []*triple.Triple) error {
+	if len(ts) == 0 {
+		return nil
+	}
+	for _, g := range gs {
+		if err := schema.Validate(ctx, g, ts); err != nil {
+			return err
+		}
+	}
+	for _, g := range gs {
+		if err := g.AddTriples(ctx, ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// report calls the callback for the provided triple.
+func (r *reader) report(t *triple.Triple) error {
+	if err := r.fn(t); err != nil {
+		return err
+	}
+	r.cnt++
+	return nil
+}
+
+// statement processes a parsed statement.
+func (r *reader) statement(st *statement) error {
+	if st.s.kind == quotedTerm {
+		if st.p.value != bwAnchor || st.o.kind != literalTerm {
+			return fmt.Errorf("quoted triples are only supported as subject of %s annotations", bwAnchor)
+		}
+		t, err := r.temporal(st.s.quoted.s, st.s.quoted.p, st.s.quoted.o, st.o)
+		if err != nil {
+			return err
+		}
+		return r.report(t)
+	}
+	if st.s.kind == blankTerm {
+		if rf := r.reification(st); rf != nil {
+			rf.stms = append(rf.stms, st)
+			return nil
+		}
+	}
+	t, err := r.triple(st)
+	if err != nil {
+		return err
+	}
+	return r.report(t)
+}
+
+// reification returns the reification the statement belongs to, if any.
+func (r *reader) reification(st *statement) *reification {
+	switch st.p.value {
+	case rdfSubject, rdfPredicate, rdfObject, bwAnchor:
+	case rdfType:
+		if st.o.kind != iriTerm || st.o.value != rdfStatement {
+			return nil
+		}
+	default:
+		return nil
+	}
+	rf, ok := r.reified[st.s.value]
+	if !ok {
+		rf = &reification{}
+		r.reified[st.s.value] = rf
+		r.order = append(r.order, st.s.value)
+	}
+	switch st.p.value {
+	case rdfSubject:
+		rf.s = st.o
+	case rdfPredicate:
+		rf.p = st.o
+	case rdfObject:
+		rf.o = st.o
+	case bwAnchor:
+		rf.anchor = st.o
+	}
+	return rf
+}
+
+// flush reports the triples of the collected reifications. Reifications
+// that do not describe a temporal triple are reported as regular triples.
+func (r *reader) flush() error {
+	for _, id := range r.order {
+		rf := r.reified[id]
+		if rf.complete() {
+			t, err := r.temporal(rf.s, rf.p, rf.o, rf.anchor)
+			if err != nil {
+				return err
+			}
+			if err := r.report(t); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, st := range rf.stms {
+			t, err := r.triple(st)
+			if err != nil {
+				return err
+			}
+			if err := r.report(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// triple converts a statement into an immutable triple.
+func (r *reader) triple(st *statement) (*triple.Triple, error) {
+	if st.p.kind != iriTerm {
+		return nil, fmt.Errorf("predicates must be IRIs; got %q", st.p.value)
+	}
+	p, err := predicate.NewImmutable(r.m.predicateID(st.p.value))
+	if err != nil {
+		return nil, err
+	}
+	return r.build(st.s, p, st.o)
+}
+
+// temporal converts a statement and its time anchor into a temporal triple.
+func (r *reader) temporal(s, p, o, anchor *term) (*triple.Triple, error) {
+	if p.kind != iriTerm {
+		return nil, fmt.Errorf("predicates must be IRIs; got %q", p.value)
+	}
+	ta, err := time.Parse(time.RFC3339Nano, anchor.value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time anchor %q; %v", anchor.value, err)
+	}
+	tp, err := predicate.NewTemporal(r.m.predicateID(p.value), ta)
+	if err != nil {
+		return nil, err
+	}
+	return r.build(s, tp, o)
+}
+
+// build returns the triple for the provided terms.
+func (r *reader) build(s *term, p *predicate.Predicate, o *term) (*triple.Triple, error) {
+	sn, err := r.node(s)
+	if err != nil {
+		return nil, err
+	}
+	var obj *triple.Object
+	switch o.kind {
+	case iriTerm, blankTerm:
+		n, err := r.node(o)
+		if err != nil {
+			return nil, err
+		}
+		obj = triple.NewNodeObject(n)
+	case literalTerm:
+		if obj, err = r.literal(o); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("quoted triples are not supported as objects")
+	}
+	return triple.New(sn, p, obj)
+}
+
+// node converts an IRI or blank node into a node.
+func (r *reader) node(t *term) (*node.Node, error) {
+	switch t.kind {
+	case iriTerm:
+		return r.m.node(t.value)
+	case blankTerm:
+		return node.NewNodeFromStrings("/_", t.value)
+	default:
+		return nil, fmt.Errorf("subjects must be IRIs or blank nodes")
+	}
+}
+
+// literal converts an RDF literal into a literal or predicate object.
+func (r *reader) literal(t *term) (*triple.Object, error) {
+	if t.datatype == bwPredicate {
+		p, err := predicate.Parse(t.value)
+		if err != nil {
+			return nil, err
+		}
+		return triple.NewPredicateObject(p), nil
+	}
+	dt := t.datatype
+	if dt == "" {
+		dt = xsdString
+		if t.lang != "" {
+			dt = rdfLangStr
+		}
+	}
+	var (
+		lt  = r.m.datatype(dt)
+		v   interface{}
+		err error
+	)
+	switch lt {
+	case literal.Bool:
+		v, err = strconv.ParseBool(t.value)
+	case literal.Int64:
+		v, err = strconv.ParseInt(strings.TrimPrefix(t.value, "+"), 10, 64)
+	case literal.Float64:
+		v, err = strconv.ParseFloat(t.value, 64)
+	case literal.Blob:
+		v, err = base64.StdEncoding.DecodeString(t.value)
+	default:
+		lt, v = literal.Text, t.value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s literal %q; %v", dt, t.value, err)
+	}
+	l, err := r.b.Build(lt, v)
+	if err != nil {
+		return nil, err
+	}
+	return triple.NewLiteralObject(l), nil
+}
+
+// unescape returns the unescaped version of an IRI segment.
+func unescape(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// longest returns the namespace with the longest IRI prefix of the provided
+// IRI that is not the whole IRI.
+func longest(nss []*Namespace, iri string) *Namespace {
+	var res *Namespace
+	for _, ns := range nss {
+		if len(iri) > len(ns.IRI) && strings.HasPrefix(iri, ns.IRI) && (res == nil || len(ns.IRI) > len(res.IRI)) {
+			res = ns
+		}
+	}
+	return res
+}
+
+// node returns the node for the provided IRI.
+func (m *Mapping) node(iri string) (*node.Node, error) {
+	if ns := longest(m.Nodes, iri); ns != nil {
+		if n, err := node.NewNodeFromStrings(ns.Type, unescape(iri[len(ns.IRI):])); err == nil {
+			return n, nil
+		}
+	}
+	if strings.HasPrefix(iri, NodeBase+"/") {
+		rest := iri[len(NodeBase):]
+		if idx := strings.LastIndex(rest, "/"); idx > 0 {
+			if n, err := node.NewNodeFromStrings(rest[:idx], unescape(rest[idx+1:])); err == nil {
+				return n, nil
+			}
+		}
+	}
+	return node.NewNodeFromStrings(m.defaultType(), iri)
+}
+
+// predicateID returns the predicate ID for the provided IRI.
+func (m *Mapping) predicateID(iri string) string {
+	if ns := longest(m.Predicates, iri); ns != nil {
+		return unescape(iri[len(ns.IRI):])
+	}
+	if len(iri) > len(PredicateBase) && strings.HasPrefix(iri, PredicateBase) {
+		return unescape(iri[len(PredicateBase):])
+	}
+	return iri
+}
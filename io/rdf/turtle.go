@@ -0,0 +1,842 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/badwolf/triple/node"
+)
+
+// termKind describes the kind of an RDF term.
+type termKind uint8
+
+const (
+	iriTerm termKind = iota
+	blankTerm
+	literalTerm
+	quotedTerm
+)
+
+// term represents an RDF term.
+type term struct {
+	kind     termKind
+	value    string
+	datatype string
+	lang     string
+	quoted   *statement
+}
+
+// statement represents an RDF statement.
+type statement struct {
+	s, p, o *term
+}
+
+// tokenType describes the tokens produced by the Turtle lexer.
+type tokenType uint8
+
+const (
+	tokEOF tokenType = iota
+	tokIRI
+	tokPName
+	tokBlank
+	tokString
+	tokAt
+	tokNumber
+	tokName
+	tokPunct
+)
+
+// token represents a Turtle token.
+type token struct {
+	typ    tokenType
+	value  string
+	prefix string
+	// datatype holds the XML schema datatype of numbers.
+	datatype string
+}
+
+// parser implements a streaming Turtle parser. N-Triples documents are
+// valid Turtle documents, so the same parser is used for both.
+type parser struct {
+	r        *bufio.Reader
+	line     int
+	prefixes map[string]string
+	base     *url.URL
+	emit     func(*statement) error
+	tok      *token
+}
+
+// parse parses all the statements on the reader and calls emit for each of
+// them.
+func parse(r io.Reader, emit func(*statement) error) error {
+	p := &parser{
+		r:        bufio.NewReader(r),
+		line:     1,
+		prefixes: make(map[string]string),
+		emit:     emit,
+	}
+	return p.document()
+}
+
+// errorf returns an error annotated with the current line.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// peek returns the next token without consuming it.
+func (p *parser) peek() (*token, error) {
+	if p.tok == nil {
+		t, err := p.lex()
+		if err != nil {
+			return nil, err
+		}
+		p.tok = t
+	}
+	return p.tok, nil
+}
+
+// next consumes and returns the next token.
+func (p *parser) next() (*token, error) {
+	t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	p.tok = nil
+	return t, nil
+}
+
+// isPunct returns true if the token is the provided punctuation.
+func isPunct(t *token, v string) bool {
+	return t.typ == tokPunct && t.value == v
+}
+
+// expect consumes the next token and checks it is the provided punctuation.
+func (p *parser) expect(v string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if !isPunct(t, v) {
+		return p.errorf("expected %q; got %q", v, t.value)
+	}
+	return nil
+}
+
+// document parses a full Turtle document.
+func (p *parser) document() error {
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return err
+		}
+		switch {
+		case t.typ == tokEOF:
+			return nil
+		case t.typ == tokAt:
+			p.next()
+			if err := p.directive(t.value); err != nil {
+				return err
+			}
+			if err := p.expect("."); err != nil {
+				return err
+			}
+		case t.typ == tokName && (strings.EqualFold(t.value, "prefix") || strings.EqualFold(t.value, "base")):
+			p.next()
+			if err := p.directive(strings.ToLower(t.value)); err != nil {
+				return err
+			}
+		default:
+			if err := p.triples(); err != nil {
+				return err
+			}
+			if err := p.expect("."); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// directive parses the body of a prefix or base directive.
+func (p *parser) directive(d string) error {
+	switch d {
+	case "prefix":
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t.typ != tokPName || t.value != "" {
+			return p.errorf("expected prefix name; got %q", t.value)
+		}
+		iri, err := p.next()
+		if err != nil {
+			return err
+		}
+		if iri.typ != tokIRI {
+			return p.errorf("expected IRI for prefix %q; got %q", t.prefix, iri.value)
+		}
+		p.prefixes[t.prefix] = p.resolve(iri.value)
+	case "base":
+		iri, err := p.next()
+		if err != nil {
+			return err
+		}
+		if iri.typ != tokIRI {
+			return p.errorf("expected base IRI; got %q", iri.value)
+		}
+		u, err := url.Parse(p.resolve(iri.value))
+		if err != nil {
+			return p.errorf("invalid base IRI %q; %v", iri.value, err)
+		}
+		p.base = u
+	default:
+		return p.errorf("unknown directive @%s", d)
+	}
+	return nil
+}
+
+// resolve resolves relative IRIs against the base IRI, if any.
+func (p *parser) resolve(iri string) string {
+	if p.base == nil {
+		return iri
+	}
+	u, err := url.Parse(iri)
+	if err != nil || u.IsAbs() {
+		return iri
+	}
+	return p.base.ResolveReference(u).String()
+}
+
+// fresh returns a new anonymous blank node.
+func fresh() *term {
+	return &term{kind: blankTerm, value: node.NewBlankNode().ID().String()}
+}
+
+// triples parses a subject and its predicate object list.
+func (p *parser) triples() error {
+	t, err := p.peek()
+	if err != nil {
+		return err
+	}
+	if isPunct(t, "[") {
+		s, err := p.blankNodePropertyList()
+		if err != nil {
+			return err
+		}
+		if t, err = p.peek(); err != nil {
+			return err
+		}
+		if isPunct(t, ".") {
+			return nil
+		}
+		return p.predicateObjectList(s)
+	}
+	s, err := p.subject()
+	if err != nil {
+		return err
+	}
+	return p.predicateObjectList(s)
+}
+
+// predicateObjectList parses the predicates and objects of a subject.
+func (p *parser) predicateObjectList(s *term) error {
+	for {
+		v, err := p.verb()
+		if err != nil {
+			return err
+		}
+		if err := p.objectList(s, v); err != nil {
+			return err
+		}
+		t, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if !isPunct(t, ";") {
+			return nil
+		}
+		for isPunct(t, ";") {
+			p.next()
+			if t, err = p.peek(); err != nil {
+				return err
+			}
+		}
+		if isPunct(t, ".") || isPunct(t, "]") || t.typ == tokEOF {
+			return nil
+		}
+	}
+}
+
+// objectList parses the objects of a subject and predicate.
+func (p *parser) objectList(s, v *term) error {
+	for {
+		o, err := p.object()
+		if err != nil {
+			return err
+		}
+		if err := p.emit(&statement{s: s, p: v, o: o}); err != nil {
+			return err
+		}
+		t, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if isPunct(t, "{|") {
+			return p.errorf("annotation blocks are not supported")
+		}
+		if !isPunct(t, ",") {
+			return nil
+		}
+		p.next()
+	}
+}
+
+// verb parses a predicate.
+func (p *parser) verb() (*term, error) {
+	t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if t.typ == tokName && t.value == "a" {
+		p.next()
+		return &term{kind: iriTerm, value: rdfType}, nil
+	}
+	return p.iri()
+}
+
+// iri parses an IRI or a prefixed name.
+func (p *parser) iri() (*term, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t.typ {
+	case tokIRI:
+		return &term{kind: iriTerm, value: p.resolve(t.value)}, nil
+	case tokPName:
+		ns, ok := p.prefixes[t.prefix]
+		if !ok {
+			return nil, p.errorf("undefined prefix %q", t.prefix)
+		}
+		return &term{kind: iriTerm, value: ns + t.value}, nil
+	default:
+		return nil, p.errorf("expected IRI; got %q", t.value)
+	}
+}
+
+// subject parses the subject of a statement.
+func (p *parser) subject() (*term, error) {
+	t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case t.typ == tokBlank:
+		p.next()
+		return &term{kind: blankTerm, value: t.value}, nil
+	case isPunct(t, "("):
+		return p.collection()
+	case isPunct(t, "<<"):
+		return p.quotedTriple()
+	default:
+		return p.iri()
+	}
+}
+
+// object parses the object of a statement.
+func (p *parser) object() (*term, error) {
+	t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case t.typ == tokBlank:
+		p.next()
+		return &term{kind: blankTerm, value: t.value}, nil
+	case isPunct(t, "["):
+		return p.blankNodePropertyList()
+	case isPunct(t, "("):
+		return p.collection()
+	case isPunct(t, "<<"):
+		return p.quotedTriple()
+	case t.typ == tokString:
+		return p.literal()
+	case t.typ == tokNumber:
+		p.next()
+		return &term{kind: literalTerm, value: t.value, datatype: t.datatype}, nil
+	case t.typ == tokName && (t.value == "true" || t.value == "false"):
+		p.next()
+		return &term{kind: literalTerm, value: t.value, datatype: xsdBoolean}, nil
+	default:
+		return p.iri()
+	}
+}
+
+// literal parses a string literal and its optional language or datatype.
+func (p *parser) literal() (*term, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	l := &term{kind: literalTerm, value: t.value}
+	nt, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case nt.typ == tokAt:
+		p.next()
+		l.lang = nt.value
+	case isPunct(nt, "^^"):
+		p.next()
+		dt, err := p.iri()
+		if err != nil {
+			return nil, err
+		}
+		l.datatype = dt.value
+	}
+	return l, nil
+}
+
+// blankNodePropertyList parses a [ ... ] construct.
+func (p *parser) blankNodePropertyList() (*term, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	b := fresh()
+	t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if !isPunct(t, "]") {
+		if err := p.predicateObjectList(b); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// collection parses a ( ... ) construct into an RDF list.
+func (p *parser) collection() (*term, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var head, cur *term
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if isPunct(t, ")") {
+			p.next()
+			break
+		}
+		if t.typ == tokEOF {
+			return nil, p.errorf("unterminated collection")
+		}
+		o, err := p.object()
+		if err != nil {
+			return nil, err
+		}
+		n := fresh()
+		if head == nil {
+			head = n
+		} else if err := p.emit(&statement{s: cur, p: &term{kind: iriTerm, value: rdfRest}, o: n}); err != nil {
+			return nil, err
+		}
+		if err := p.emit(&statement{s: n, p: &term{kind: iriTerm, value: rdfFirst}, o: o}); err != nil {
+			return nil, err
+		}
+		cur = n
+	}
+	nilTerm := &term{kind: iriTerm, value: rdfNil}
+	if head == nil {
+		return nilTerm, nil
+	}
+	if err := p.emit(&statement{s: cur, p: &term{kind: iriTerm, value: rdfRest}, o: nilTerm}); err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+// quotedTriple parses a << s p o >> construct.
+func (p *parser) quotedTriple() (*term, error) {
+	if err := p.expect("<<"); err != nil {
+		return nil, err
+	}
+	s, err := p.subject()
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.verb()
+	if err != nil {
+		return nil, err
+	}
+	o, err := p.object()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(">>"); err != nil {
+		return nil, err
+	}
+	return &term{kind: quotedTerm, quoted: &statement{s: s, p: v, o: o}}, nil
+}
+
+// Lexer.
+
+// peekByte returns the next byte without consuming it. It returns 0 at the
+// end of the input.
+func (p *parser) peekByte(i int) byte {
+	bs, err := p.r.Peek(i + 1)
+	if err != nil || len(bs) <= i {
+		return 0
+	}
+	return bs[i]
+}
+
+// readRune consumes the next rune keeping track of the line number.
+func (p *parser) readRune() (rune, error) {
+	r, _, err := p.r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if r == '\n' {
+		p.line++
+	}
+	return r, nil
+}
+
+// isNameByte returns true if the byte can be part of a name. Bytes of multi
+// byte runes are accepted.
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_' || b == '-' || b >= utf8.RuneSelf
+}
+
+// lex returns the next token on the input.
+func (p *parser) lex() (*token, error) {
+	if err := p.skipSpace(); err != nil {
+		return nil, err
+	}
+	c, err := p.readRune()
+	if err == io.EOF {
+		return &token{typ: tokEOF}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c == '<':
+		if p.peekByte(0) == '<' {
+			p.readRune()
+			return &token{typ: tokPunct, value: "<<"}, nil
+		}
+		return p.lexIRI()
+	case c == '>':
+		if p.peekByte(0) == '>' {
+			p.readRune()
+			return &token{typ: tokPunct, value: ">>"}, nil
+		}
+		return nil, p.errorf("unexpected '>'")
+	case c == '{' && p.peekByte(0) == '|', c == '|' && p.peekByte(0) == '}':
+		n, _ := p.readRune()
+		return &token{typ: tokPunct, value: string(c) + string(n)}, nil
+	case c == '^':
+		if n, _ := p.readRune(); n != '^' {
+			return nil, p.errorf("expected '^^'")
+		}
+		return &token{typ: tokPunct, value: "^^"}, nil
+	case c == '.' && p.peekByte(0) >= '0' && p.peekByte(0) <= '9':
+		return p.lexNumber(c)
+	case strings.ContainsRune(".;,[]()", c):
+		return &token{typ: tokPunct, value: string(c)}, nil
+	case c == '"' || c == '\'':
+		return p.lexString(c)
+	case c == '@':
+		return &token{typ: tokAt, value: p.lexName(false)}, nil
+	case c == '_' && p.peekByte(0) == ':':
+		p.readRune()
+		l := p.lexName(true)
+		if l == "" {
+			return nil, p.errorf("empty blank node label")
+		}
+		return &token{typ: tokBlank, value: l}, nil
+	case c >= '0' && c <= '9' || c == '+' || c == '-':
+		return p.lexNumber(c)
+	case c == ':' || isNameByte(byte(c)) || c >= utf8.RuneSelf:
+		return p.lexPName(c)
+	default:
+		return nil, p.errorf("unexpected character %q", c)
+	}
+}
+
+// skipSpace skips white spaces and comments.
+func (p *parser) skipSpace() error {
+	for {
+		c := p.peekByte(0)
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			p.readRune()
+		case '#':
+			for {
+				r, err := p.readRune()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if r == '\n' {
+					break
+				}
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// lexName reads a name. Dots are only accepted in the middle of the name. If
+// colon is true, colons are also accepted.
+func (p *parser) lexName(colon bool) string {
+	var buf bytes.Buffer
+	for {
+		b := p.peekByte(0)
+		switch {
+		case isNameByte(b) || colon && b == ':':
+			r, _ := p.readRune()
+			buf.WriteRune(r)
+		case b == '.' && (isNameByte(p.peekByte(1)) || colon && p.peekByte(1) == ':'):
+			p.readRune()
+			buf.WriteByte('.')
+		default:
+			return buf.String()
+		}
+	}
+}
+
+// lexPName reads a prefixed name or a bare keyword.
+func (p *parser) lexPName(c rune) (*token, error) {
+	var prefix string
+	if c != ':' {
+		var buf bytes.Buffer
+		buf.WriteRune(c)
+		buf.WriteString(p.lexName(false))
+		prefix = buf.String()
+		if p.peekByte(0) != ':' {
+			return &token{typ: tokName, value: prefix}, nil
+		}
+		p.readRune()
+	}
+	local, err := p.lexLocal()
+	if err != nil {
+		return nil, err
+	}
+	return &token{typ: tokPName, prefix: prefix, value: local}, nil
+}
+
+// lexLocal reads the local part of a prefixed name, including escaped
+// characters and percent encoded bytes.
+func (p *parser) lexLocal() (string, error) {
+	var buf bytes.Buffer
+	for {
+		b := p.peekByte(0)
+		switch {
+		case isNameByte(b) || b == ':' || b == '%':
+			r, _ := p.readRune()
+			buf.WriteRune(r)
+		case b == '\\':
+			p.readRune()
+			r, err := p.readRune()
+			if err != nil {
+				return "", p.errorf("unterminated escape sequence")
+			}
+			buf.WriteRune(r)
+		case b == '.' && (isNameByte(p.peekByte(1)) || p.peekByte(1) == ':' || p.peekByte(1) == '%'):
+			p.readRune()
+			buf.WriteByte('.')
+		default:
+			return buf.String(), nil
+		}
+	}
+}
+
+// lexIRI reads an IRI reference. The opening < has already been consumed.
+func (p *parser) lexIRI() (*token, error) {
+	var buf bytes.Buffer
+	for {
+		r, err := p.readRune()
+		if err != nil {
+			return nil, p.errorf("unterminated IRI")
+		}
+		switch {
+		case r == '>':
+			return &token{typ: tokIRI, value: buf.String()}, nil
+		case r == '\\':
+			u, err := p.lexUnicode()
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteRune(u)
+		case r <= ' ' || r == '<' || r == '"':
+			return nil, p.errorf("invalid character %q in IRI", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+// lexUnicode reads a \u or \U escape sequence. The backslash has already been
+// consumed.
+func (p *parser) lexUnicode() (rune, error) {
+	r, err := p.readRune()
+	if err != nil {
+		return 0, p.errorf("unterminated escape sequence")
+	}
+	n := 0
+	switch r {
+	case 'u':
+		n = 4
+	case 'U':
+		n = 8
+	default:
+		return 0, p.errorf("invalid escape sequence \\%c", r)
+	}
+	var hex bytes.Buffer
+	for i := 0; i < n; i++ {
+		h, err := p.readRune()
+		if err != nil {
+			return 0, p.errorf("unterminated escape sequence")
+		}
+		hex.WriteRune(h)
+	}
+	v, err := strconv.ParseUint(hex.String(), 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid escape sequence \\%c%s", r, hex.String())
+	}
+	return rune(v), nil
+}
+
+// lexString reads a string. The opening quote has already been consumed.
+func (p *parser) lexString(q rune) (*token, error) {
+	long := p.peekByte(0) == byte(q) && p.peekByte(1) == byte(q)
+	if long {
+		p.readRune()
+		p.readRune()
+	} else if p.peekByte(0) == byte(q) {
+		p.readRune()
+		return &token{typ: tokString}, nil
+	}
+	var buf bytes.Buffer
+	for {
+		r, err := p.readRune()
+		if err != nil {
+			return nil, p.errorf("unterminated string")
+		}
+		switch {
+		case r == q && !long:
+			return &token{typ: tokString, value: buf.String()}, nil
+		case r == q && p.peekByte(0) == byte(q) && p.peekByte(1) == byte(q):
+			// Quotes right before the closing ones belong to the string.
+			for p.peekByte(2) == byte(q) {
+				p.readRune()
+				buf.WriteRune(q)
+			}
+			p.readRune()
+			p.readRune()
+			return &token{typ: tokString, value: buf.String()}, nil
+		case r == '\\':
+			switch e := p.peekByte(0); e {
+			case 'u', 'U':
+				u, err := p.lexUnicode()
+				if err != nil {
+					return nil, err
+				}
+				buf.WriteRune(u)
+			default:
+				p.readRune()
+				v, ok := stringEscapes[e]
+				if !ok {
+					return nil, p.errorf("invalid escape sequence \\%c", e)
+				}
+				buf.WriteByte(v)
+			}
+		case (r == '\n' || r == '\r') && !long:
+			return nil, p.errorf("unterminated string")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+// stringEscapes contains the valid string escape sequences.
+var stringEscapes = map[byte]byte{
+	't':  '\t',
+	'b':  '\b',
+	'n':  '\n',
+	'r':  '\r',
+	'f':  '\f',
+	'"':  '"',
+	'\'': '\'',
+	'\\': '\\',
+}
+
+// lexNumber reads a numeric literal. The first character has already been
+// consumed.
+func (p *parser) lexNumber(c rune) (*token, error) {
+	var buf bytes.Buffer
+	buf.WriteRune(c)
+	digits := func() {
+		for b := p.peekByte(0); b >= '0' && b <= '9'; b = p.peekByte(0) {
+			p.readRune()
+			buf.WriteByte(b)
+		}
+	}
+	dt := xsdInteger
+	if c == '.' {
+		dt = xsdDecimal
+	}
+	digits()
+	if dt == xsdInteger && p.peekByte(0) == '.' && p.peekByte(1) >= '0' && p.peekByte(1) <= '9' {
+		p.readRune()
+		buf.WriteByte('.')
+		dt = xsdDecimal
+		digits()
+	}
+	if b := p.peekByte(0); b == 'e' || b == 'E' {
+		p.readRune()
+		buf.WriteByte(b)
+		if s := p.peekByte(0); s == '+' || s == '-' {
+			p.readRune()
+			buf.WriteByte(s)
+		}
+		dt = xsdDouble
+		digits()
+	}
+	v := buf.String()
+	if v == "+" || v == "-" {
+		return nil, p.errorf("invalid number %q", v)
+	}
+	return &token{typ: tokNumber, value: v, datatype: dt}, nil
+}
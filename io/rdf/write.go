@@ -0,0 +1,383 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rdf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/node"
+)
+
+// Writer serializes triples as RDF statements.
+type Writer struct {
+	w        *bufio.Writer
+	f        Format
+	m        *Mapping
+	prefixes []*Namespace
+	started  bool
+	subject  string
+	ld       map[string]*ldNode
+	ldOrder  []string
+}
+
+// NewWriter returns a writer serializing triples in the provided format. If
+// no mapping is provided DefaultMapping is used. Close needs to be called
+// once all triples have been written.
+func NewWriter(w io.Writer, f Format, m *Mapping) *Writer {
+	if m == nil {
+		m = DefaultMapping()
+	}
+	wr := &Writer{
+		w: bufio.NewWriter(w),
+		f: f,
+		m: m,
+		prefixes: []*Namespace{
+			{Prefix: "rdf", IRI: RDFNamespace},
+			{Prefix: "xsd", IRI: XSDNamespace},
+			{Prefix: "bw", IRI: BadWolfNamespace},
+		},
+		ld: make(map[string]*ldNode),
+	}
+	for _, nss := range [][]*Namespace{m.Nodes, m.Predicates} {
+		for _, ns := range nss {
+			if ns.Prefix != "" {
+				wr.prefixes = append(wr.prefixes, ns)
+			}
+		}
+	}
+	return wr
+}
+
+// start writes the document header if needed.
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	if w.f != Turtle {
+		return nil
+	}
+	for _, ns := range w.prefixes {
+		if _, err := fmt.Fprintf(w.w, "@prefix %s: <%s> .\n", ns.Prefix, escapeIRI(ns.IRI)); err != nil {
+			return err
+		}
+	}
+	_, err := w.w.WriteString("\n")
+	return err
+}
+
+// Write serializes the provided triple.
+func (w *Writer) Write(t *triple.Triple) error {
+	if w.f == JSONLD {
+		return w.ldTriple(t)
+	}
+	if err := w.start(); err != nil {
+		return err
+	}
+	s := w.node(t.Subject())
+	p := w.iri(w.m.predicateIRI(string(t.Predicate().ID())))
+	o, err := w.object(t.Object())
+	if err != nil {
+		return err
+	}
+	ta, err := t.Predicate().TimeAnchor()
+	if err != nil {
+		return w.statement(s, p, o)
+	}
+	anchor := w.typed(ta.Format(time.RFC3339Nano), xsdDateTime)
+	if w.m.Temporal == Annotate {
+		return w.statement(fmt.Sprintf("<< %s %s %s >>", s, p, o), w.iri(bwAnchor), anchor)
+	}
+	b := "_:" + t.UUID().String()
+	for _, po := range [][2]string{
+		{w.iri(rdfType), w.iri(rdfStatement)},
+		{w.iri(rdfSubject), s},
+		{w.iri(rdfPredicate), p},
+		{w.iri(rdfObject), o},
+		{w.iri(bwAnchor), anchor},
+	} {
+		if err := w.statement(b, po[0], po[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close terminates the document and flushes any buffered data. It does not
+// close the underlying writer.
+func (w *Writer) Close() error {
+	if w.f == JSONLD {
+		return w.ldClose()
+	}
+	if err := w.start(); err != nil {
+		return err
+	}
+	if w.subject != "" {
+		if _, err := w.w.WriteString(" .\n"); err != nil {
+			return err
+		}
+		w.subject = ""
+	}
+	return w.w.Flush()
+}
+
+// statement writes a single statement. Turtle statements sharing the same
+// subject are grouped.
+func (w *Writer) statement(s, p, o string) error {
+	if w.f != Turtle {
+		_, err := fmt.Fprintf(w.w, "%s %s %s .\n", s, p, o)
+		return err
+	}
+	if p == w.iri(rdfType) {
+		p = "a"
+	}
+	if s == w.subject {
+		_, err := fmt.Fprintf(w.w, " ;\n    %s %s", p, o)
+		return err
+	}
+	if w.subject != "" {
+		if _, err := w.w.WriteString(" .\n"); err != nil {
+			return err
+		}
+	}
+	w.subject = s
+	_, err := fmt.Fprintf(w.w, "%s %s %s", s, p, o)
+	return err
+}
+
+// iri returns the serialized version of an IRI. Turtle IRIs are abbreviated
+// using the known prefixes when possible.
+func (w *Writer) iri(iri string) string {
+	if w.f == Turtle {
+		for _, ns := range w.prefixes {
+			if l := strings.TrimPrefix(iri, ns.IRI); l != iri && isSimpleLocal(l) {
+				return ns.Prefix + ":" + l
+			}
+		}
+	}
+	return "<" + escapeIRI(iri) + ">"
+}
+
+// node returns the serialized version of a node.
+func (w *Writer) node(n *node.Node) string {
+	if n.Type().String() == "/_" {
+		return "_:" + blankLabel(n.ID().String())
+	}
+	return w.iri(w.m.nodeIRI(n))
+}
+
+// object returns the serialized version of an object.
+func (w *Writer) object(o *triple.Object) (string, error) {
+	if n, err := o.Node(); err == nil {
+		return w.node(n), nil
+	}
+	if p, err := o.Predicate(); err == nil {
+		return w.typed(p.String(), bwPredicate), nil
+	}
+	l, err := o.Literal()
+	if err != nil {
+		return "", fmt.Errorf("rdf.Writer: unknown object %v", o)
+	}
+	switch v := l.Interface().(type) {
+	case bool:
+		return w.typed(strconv.FormatBool(v), xsdBoolean), nil
+	case int64:
+		return w.typed(strconv.FormatInt(v, 10), xsdInteger), nil
+	case float64:
+		return w.typed(formatDouble(v), xsdDouble), nil
+	case string:
+		return quote(v), nil
+	case []byte:
+		return w.typed(base64.StdEncoding.EncodeToString(v), xsdBase64), nil
+	default:
+		return "", fmt.Errorf("rdf.Writer: unsupported literal type %v", l.Type())
+	}
+}
+
+// typed returns a typed literal.
+func (w *Writer) typed(v, dt string) string {
+	return quote(v) + "^^" + w.iri(dt)
+}
+
+// formatDouble returns the XML schema lexical form of a float.
+func formatDouble(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "INF"
+	case math.IsInf(f, -1):
+		return "-INF"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// quote returns the quoted and escaped version of a string.
+func quote(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < ' ' {
+				fmt.Fprintf(&buf, `\u%04X`, r)
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// escapeIRI percent encodes the characters not allowed in IRI references.
+func escapeIRI(iri string) string {
+	var buf bytes.Buffer
+	for _, r := range iri {
+		if r <= ' ' || strings.ContainsRune("<>\"{}|^`\\", r) {
+			fmt.Fprintf(&buf, "%%%02X", r)
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// isSimpleLocal returns true if the string can be used as local name of a
+// prefixed name without escaping.
+func isSimpleLocal(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+		case r == '-' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// blankLabel returns a valid blank node label for the provided ID.
+func blankLabel(id string) string {
+	var buf bytes.Buffer
+	for i, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			buf.WriteRune(r)
+		case r == '-' && i > 0:
+			buf.WriteRune(r)
+		default:
+			buf.WriteRune('_')
+		}
+	}
+	return buf.String()
+}
+
+// nodeIRI returns the IRI for the provided node.
+func (m *Mapping) nodeIRI(n *node.Node) string {
+	t, id := n.Type().String(), n.ID().String()
+	if t == m.defaultType() {
+		return id
+	}
+	for _, ns := range m.Nodes {
+		if ns.Type == t {
+			return ns.IRI + url.PathEscape(id)
+		}
+	}
+	return NodeBase + t + "/" + url.PathEscape(id)
+}
+
+// predicateIRI returns the IRI for the provided predicate ID. IDs that are
+// already IRIs are returned unchanged.
+func (m *Mapping) predicateIRI(id string) string {
+	if u, err := url.Parse(id); err == nil && u.Scheme != "" && !strings.ContainsAny(id, " \t\n\r") {
+		return id
+	}
+	return m.predicateBase() + url.PathEscape(id)
+}
+
+// predicateBase returns the IRI prefix used for predicate IDs that are not
+// IRIs.
+func (m *Mapping) predicateBase() string {
+	if len(m.Predicates) > 0 {
+		return m.Predicates[0].IRI
+	}
+	return PredicateBase
+}
+
+// WriteGraph serializes all the triples in the graph into the writer. It
+// returns the number of triples serialized.
+func WriteGraph(ctx context.Context, w io.Writer, g storage.Graph, f Format, m *Mapping) (int, error) {
+	var (
+		wg   sync.WaitGroup
+		tErr error
+		wErr error
+	)
+	cnt, ts, wr := 0, make(chan *triple.Triple), NewWriter(w, f, m)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tErr = g.Triples(ctx, storage.DefaultLookup, ts)
+	}()
+	for t := range ts {
+		if wErr != nil {
+			continue
+		}
+		if err := wr.Write(t); err != nil {
+			wErr = err
+			continue
+		}
+		cnt++
+	}
+	wg.Wait()
+	if tErr != nil {
+		return 0, tErr
+	}
+	if wErr != nil {
+		return 0, wErr
+	}
+	if err := wr.Close(); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
@@ -0,0 +1,363 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package viz exports graphs for visualization using the Graphviz DOT and
+// GraphML formats. Nodes become vertices carrying their type, predicates
+// become labeled edges carrying their time anchor, and literal and predicate
+// objects become vertices of their own unless folded into the properties of
+// their subject.
+package viz
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+)
+
+// Format describes the visualization format used.
+type Format uint8
+
+const (
+	// DOT is the Graphviz graph description language.
+	DOT Format = iota
+	// GraphML is the XML based graph format.
+	GraphML
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case DOT:
+		return "dot"
+	case GraphML:
+		return "graphml"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseFormat returns the format for the provided name.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "dot", "gv", "graphviz":
+		return DOT, nil
+	case "graphml":
+		return GraphML, nil
+	default:
+		return 0, fmt.Errorf("viz.ParseFormat: unknown visualization format %q", s)
+	}
+}
+
+// Options configures how graphs are rendered.
+type Options struct {
+	// FoldLiterals adds literal objects as properties of their subject
+	// instead of creating a vertex for each of them.
+	FoldLiterals bool
+	// Clusters groups DOT vertices by node type in subgraph clusters. Node
+	// types are always available as the type attribute of the vertices.
+	Clusters bool
+}
+
+// vertex kinds.
+const (
+	nodeVertex      = "node"
+	literalVertex   = "literal"
+	predicateVertex = "predicate"
+)
+
+// vertex contains a vertex of the rendered graph.
+type vertex struct {
+	id    string
+	kind  string
+	typ   string
+	label string
+	props []property
+}
+
+// property contains a folded literal.
+type property struct {
+	name, value string
+}
+
+// edge contains an edge of the rendered graph.
+type edge struct {
+	from, to  *vertex
+	predicate string
+	anchor    string
+}
+
+// Writer renders triples as a graph. Since vertices need to be declared
+// before edges, the whole graph is buffered until the writer is closed.
+type Writer struct {
+	w        *bufio.Writer
+	f        Format
+	o        Options
+	vertices []*vertex
+	nodes    map[string]*vertex
+	edges    []*edge
+}
+
+// NewWriter returns a writer rendering triples in the provided format. If no
+// options are provided the defaults are used. Close needs to be called once
+// all triples have been written.
+func NewWriter(w io.Writer, f Format, o *Options) *Writer {
+	wr := &Writer{
+		w:     bufio.NewWriter(w),
+		f:     f,
+		nodes: make(map[string]*vertex),
+	}
+	if o != nil {
+		wr.o = *o
+	}
+	return wr
+}
+
+// vertex returns a new vertex.
+func (w *Writer) vertex(kind, typ, label string) *vertex {
+	v := &vertex{
+		id:    fmt.Sprintf("v%d", len(w.vertices)),
+		kind:  kind,
+		typ:   typ,
+		label: label,
+	}
+	w.vertices = append(w.vertices, v)
+	return v
+}
+
+// node returns the vertex for the provided node.
+func (w *Writer) node(t, id string) *vertex {
+	k := t + "<" + id + ">"
+	if v, ok := w.nodes[k]; ok {
+		return v
+	}
+	v := w.vertex(nodeVertex, t, id)
+	w.nodes[k] = v
+	return v
+}
+
+// Write adds the provided triple to the graph.
+func (w *Writer) Write(t *triple.Triple) error {
+	s := w.node(t.Subject().Type().String(), t.Subject().ID().String())
+	e := &edge{from: s, predicate: string(t.Predicate().ID())}
+	if ta, err := t.Predicate().TimeAnchor(); err == nil {
+		e.anchor = ta.Format(time.RFC3339Nano)
+	}
+	o := t.Object()
+	if n, err := o.Node(); err == nil {
+		e.to = w.node(n.Type().String(), n.ID().String())
+		w.edges = append(w.edges, e)
+		return nil
+	}
+	var kind, typ, label string
+	if p, err := o.Predicate(); err == nil {
+		kind, typ, label = predicateVertex, "predicate", p.String()
+	} else {
+		l, err := o.Literal()
+		if err != nil {
+			return fmt.Errorf("viz.Writer: unknown object %v", o)
+		}
+		kind, typ, label = literalVertex, l.Type().String(), fmt.Sprint(l.Interface())
+	}
+	if w.o.FoldLiterals {
+		name := e.predicate
+		if e.anchor != "" {
+			name += "@[" + e.anchor + "]"
+		}
+		s.props = append(s.props, property{name: name, value: label})
+		return nil
+	}
+	e.to = w.vertex(kind, typ, label)
+	w.edges = append(w.edges, e)
+	return nil
+}
+
+// Close renders the buffered graph and flushes it. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	var err error
+	if w.f == GraphML {
+		err = w.graphML()
+	} else {
+		err = w.dot()
+	}
+	if err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// dotQuote returns the quoted DOT version of a string.
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "")
+	return `"` + r.Replace(s) + `"`
+}
+
+// dotVertex writes a DOT vertex declaration.
+func (w *Writer) dotVertex(indent string, v *vertex) {
+	attrs := []string{
+		"label=" + dotQuote(v.label),
+		"type=" + dotQuote(v.typ),
+	}
+	switch v.kind {
+	case literalVertex:
+		attrs = append(attrs, "shape=note")
+	case predicateVertex:
+		attrs = append(attrs, "shape=diamond")
+	}
+	for _, p := range v.props {
+		attrs = append(attrs, dotQuote(p.name)+"="+dotQuote(p.value))
+	}
+	fmt.Fprintf(w.w, "%s%s [%s];\n", indent, v.id, strings.Join(attrs, ", "))
+}
+
+// dot renders the graph in DOT.
+func (w *Writer) dot() error {
+	w.w.WriteString("digraph badwolf {\n")
+	if w.o.Clusters {
+		clusters, types := make(map[string][]*vertex), []string{}
+		for _, v := range w.vertices {
+			if v.kind != nodeVertex {
+				w.dotVertex("  ", v)
+				continue
+			}
+			if _, ok := clusters[v.typ]; !ok {
+				types = append(types, v.typ)
+			}
+			clusters[v.typ] = append(clusters[v.typ], v)
+		}
+		sort.Strings(types)
+		for i, t := range types {
+			fmt.Fprintf(w.w, "  subgraph cluster_%d {\n    label=%s;\n", i, dotQuote(t))
+			for _, v := range clusters[t] {
+				w.dotVertex("    ", v)
+			}
+			w.w.WriteString("  }\n")
+		}
+	} else {
+		for _, v := range w.vertices {
+			w.dotVertex("  ", v)
+		}
+	}
+	for _, e := range w.edges {
+		attrs := []string{"label=" + dotQuote(e.predicate)}
+		if e.anchor != "" {
+			attrs = append(attrs, "anchor="+dotQuote(e.anchor))
+		}
+		fmt.Fprintf(w.w, "  %s -> %s [%s];\n", e.from.id, e.to.id, strings.Join(attrs, ", "))
+	}
+	_, err := w.w.WriteString("}\n")
+	return err
+}
+
+// xmlEscape returns the escaped XML version of a string.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// graphML renders the graph in GraphML.
+func (w *Writer) graphML() error {
+	w.w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="label" for="node" attr.name="label" attr.type="string"/>
+  <key id="type" for="node" attr.name="type" attr.type="string"/>
+  <key id="kind" for="node" attr.name="kind" attr.type="string"/>
+  <key id="predicate" for="edge" attr.name="predicate" attr.type="string"/>
+  <key id="anchor" for="edge" attr.name="anchor" attr.type="string"/>
+`)
+	// Folded properties are declared as additional node keys.
+	keys, names := make(map[string]string), []string{}
+	for _, v := range w.vertices {
+		for _, p := range v.props {
+			if _, ok := keys[p.name]; !ok {
+				keys[p.name] = fmt.Sprintf("p%d", len(names))
+				names = append(names, p.name)
+			}
+		}
+	}
+	for _, n := range names {
+		fmt.Fprintf(w.w, "  <key id=%q for=\"node\" attr.name=\"%s\" attr.type=\"string\"/>\n", keys[n], xmlEscape(n))
+	}
+	w.w.WriteString("  <graph id=\"badwolf\" edgedefault=\"directed\">\n")
+	for _, v := range w.vertices {
+		fmt.Fprintf(w.w, "    <node id=%q>\n", v.id)
+		fmt.Fprintf(w.w, "      <data key=\"label\">%s</data>\n", xmlEscape(v.label))
+		fmt.Fprintf(w.w, "      <data key=\"type\">%s</data>\n", xmlEscape(v.typ))
+		fmt.Fprintf(w.w, "      <data key=\"kind\">%s</data>\n", v.kind)
+		for _, p := range v.props {
+			fmt.Fprintf(w.w, "      <data key=%q>%s</data>\n", keys[p.name], xmlEscape(p.value))
+		}
+		w.w.WriteString("    </node>\n")
+	}
+	for i, e := range w.edges {
+		fmt.Fprintf(w.w, "    <edge id=\"e%d\" source=%q target=%q>\n", i, e.from.id, e.to.id)
+		fmt.Fprintf(w.w, "      <data key=\"predicate\">%s</data>\n", xmlEscape(e.predicate))
+		if e.anchor != "" {
+			fmt.Fprintf(w.w, "      <data key=\"anchor\">%s</data>\n", e.anchor)
+		}
+		w.w.WriteString("    </edge>\n")
+	}
+	_, err := w.w.WriteString("  </graph>\n</graphml>\n")
+	return err
+}
+
+// WriteGraph renders all the triples in the graph into the writer. It
+// returns the number of triples rendered. Graphs built by CONSTRUCT queries
+// can be rendered the same way.
+func WriteGraph(ctx context.Context, w io.Writer, g storage.Graph, f Format, o *Options) (int, error) {
+	var (
+		wg   sync.WaitGroup
+		tErr error
+		wErr error
+	)
+	cnt, ts, wr := 0, make(chan *triple.Triple), NewWriter(w, f, o)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tErr = g.Triples(ctx, storage.DefaultLookup, ts)
+	}()
+	for t := range ts {
+		if wErr != nil {
+			continue
+		}
+		if err := wr.Write(t); err != nil {
+			wErr = err
+			continue
+		}
+		cnt++
+	}
+	wg.Wait()
+	if tErr != nil {
+		return 0, tErr
+	}
+	if wErr != nil {
+		return 0, wErr
+	}
+	if err := wr.Close(); err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package viz
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func testGraph(t *testing.T) storage.Graph {
+	ctx := context.Background()
+	g, err := memory.NewStore().NewGraph(ctx, "?viz")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	for _, s := range []string{
+		"/u<john>\t\"knows\"@[]\t/u<mary>",
+		"/u<john>\t\"met\"@[2016-04-10T04:21:00Z]\t/city<nyc>",
+		"/u<john>\t\"name\"@[]\t\"John \"J\" <Doe>\"^^type:text",
+		"/u<mary>\t\"age\"@[]\t\"42\"^^type:int64",
+	} {
+		trpl, err := triple.Parse(s, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", s, err)
+		}
+		if err := g.AddTriples(ctx, []*triple.Triple{trpl}); err != nil {
+			t.Fatalf("g.AddTriples failed with error %v", err)
+		}
+	}
+	return g
+}
+
+func render(t *testing.T, f Format, o *Options) string {
+	var buf bytes.Buffer
+	cnt, err := WriteGraph(context.Background(), &buf, testGraph(t), f, o)
+	if err != nil {
+		t.Fatalf("viz.WriteGraph(%s, %+v) failed with error %v", f, o, err)
+	}
+	if cnt != 4 {
+		t.Errorf("viz.WriteGraph(%s, %+v) rendered the wrong number of triples; got %d, want 4", f, o, cnt)
+	}
+	return buf.String()
+}
+
+func TestDOT(t *testing.T) {
+	table := []struct {
+		o        *Options
+		want     []string
+		unwanted []string
+		edges    int
+	}{
+		{
+			o: nil,
+			want: []string{
+				"digraph badwolf {",
+				`[label="john", type="/u"]`,
+				`[label="nyc", type="/city"]`,
+				`[label="John \"J\" <Doe>", type="text", shape=note]`,
+				`[label="met", anchor="2016-04-10T04:21:00Z"]`,
+			},
+			unwanted: []string{"subgraph"},
+			edges:    4,
+		},
+		{
+			o: &Options{FoldLiterals: true, Clusters: true},
+			want: []string{
+				`subgraph cluster_0 {`,
+				`label="/city";`,
+				`[label="mary", type="/u", "age"="42"]`,
+				`"name"="John \"J\" <Doe>"`,
+			},
+			unwanted: []string{"shape=note"},
+			edges:    2,
+		},
+	}
+	for _, entry := range table {
+		got := render(t, DOT, entry.o)
+		for _, w := range entry.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("viz.WriteGraph(DOT, %+v) should contain %q; got\n%s", entry.o, w, got)
+			}
+		}
+		for _, w := range entry.unwanted {
+			if strings.Contains(got, w) {
+				t.Errorf("viz.WriteGraph(DOT, %+v) should not contain %q; got\n%s", entry.o, w, got)
+			}
+		}
+		if n := strings.Count(got, " -> "); n != entry.edges {
+			t.Errorf("viz.WriteGraph(DOT, %+v) rendered the wrong number of edges; got %d, want %d", entry.o, n, entry.edges)
+		}
+	}
+}
+
+func TestGraphML(t *testing.T) {
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	type doc struct {
+		Keys []struct {
+			ID   string `xml:"id,attr"`
+			Name string `xml:"attr.name,attr"`
+		} `xml:"key"`
+		Nodes []struct {
+			ID   string `xml:"id,attr"`
+			Data []data `xml:"data"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+			Target string `xml:"target,attr"`
+			Data   []data `xml:"data"`
+		} `xml:"graph>edge"`
+	}
+	for _, entry := range []struct {
+		o            *Options
+		keys, nodes  int
+		edges, props int
+	}{
+		{nil, 5, 5, 4, 0},
+		{&Options{FoldLiterals: true}, 7, 3, 2, 2},
+	} {
+		d := &doc{}
+		got := render(t, GraphML, entry.o)
+		if err := xml.Unmarshal([]byte(got), d); err != nil {
+			t.Fatalf("viz.WriteGraph(GraphML, %+v) returned invalid XML %v:\n%s", entry.o, err, got)
+		}
+		if len(d.Keys) != entry.keys || len(d.Nodes) != entry.nodes || len(d.Edges) != entry.edges {
+			t.Errorf("viz.WriteGraph(GraphML, %+v) returned %d keys, %d nodes, %d edges; want %d, %d, %d", entry.o, len(d.Keys), len(d.Nodes), len(d.Edges), entry.keys, entry.nodes, entry.edges)
+		}
+		props, anchors := 0, 0
+		for _, n := range d.Nodes {
+			for _, dt := range n.Data {
+				if strings.HasPrefix(dt.Key, "p") {
+					props++
+				}
+				if dt.Key == "label" && strings.Contains(dt.Value, "<Doe>") && dt.Value != `John "J" <Doe>` {
+					t.Errorf("viz.WriteGraph(GraphML) failed to escape label %q", dt.Value)
+				}
+			}
+		}
+		for _, e := range d.Edges {
+			for _, dt := range e.Data {
+				if dt.Key == "anchor" && dt.Value == "2016-04-10T04:21:00Z" {
+					anchors++
+				}
+			}
+		}
+		if props != entry.props || anchors != 1 {
+			t.Errorf("viz.WriteGraph(GraphML, %+v) returned %d folded properties and %d anchors; want %d and 1", entry.o, props, anchors, entry.props)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for s, want := range map[string]Format{"dot": DOT, "GraphML": GraphML, "gv": DOT} {
+		if got, err := ParseFormat(s); err != nil || got != want {
+			t.Errorf("viz.ParseFormat(%q) returned %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("svg"); err == nil {
+		t.Errorf("viz.ParseFormat(\"svg\") should have failed")
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package canonical provides a canonical form for sets of triples. Blank
+// nodes, as created by node.NewBlankNode or Triple.Reify, get random IDs. The
+// canonical form relabels them deterministically based on the structure of
+// the graph, so two graphs that only differ on the IDs of their blank nodes
+// share the same canonical form and digest.
+//
+// Blank nodes are labeled by iteratively hashing their neighborhoods until
+// the partition of blank nodes stops being refined. Remaining ties, which
+// only happen on symmetric structures, are broken by trying each candidate
+// and keeping the labeling that yields the smallest serialization. Highly
+// symmetric blank node structures may hence take exponential time.
+package canonical
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/node"
+)
+
+// blankType is the type of blank nodes.
+const blankType = "/_"
+
+// LabelPrefix is the prefix of the IDs assigned to relabeled blank nodes.
+const LabelPrefix = "c14n"
+
+// term contains a subject or object of a statement.
+type term struct {
+	v     string // serialized form of non blank terms
+	blank int    // index of the blank node, or -1
+}
+
+// statement contains a triple with its blank nodes indexed.
+type statement struct {
+	t    *triple.Triple
+	s, o term
+	p    string
+}
+
+// graph contains the deduplicated statements of a set of triples.
+type graph struct {
+	stmts  []statement
+	blanks int
+	// adj lists the statements each blank node participates in.
+	adj [][]int
+	// bstmts lists the statements containing blank nodes.
+	bstmts []int
+}
+
+// newGraph indexes the provided triples.
+func newGraph(ts []*triple.Triple) *graph {
+	g, idx, seen := &graph{}, make(map[string]int), make(map[string]bool)
+	tm := func(n *node.Node) term {
+		if n.Type().String() != blankType {
+			return term{v: n.String(), blank: -1}
+		}
+		k := n.ID().String()
+		i, ok := idx[k]
+		if !ok {
+			i = g.blanks
+			idx[k] = i
+			g.blanks++
+			g.adj = append(g.adj, nil)
+		}
+		return term{blank: i}
+	}
+	for _, t := range ts {
+		k := t.String()
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		st := statement{
+			t: t,
+			s: tm(t.Subject()),
+			p: t.Predicate().String(),
+			o: term{v: t.Object().String(), blank: -1},
+		}
+		if n, err := t.Object().Node(); err == nil {
+			st.o = tm(n)
+		}
+		i := len(g.stmts)
+		g.stmts = append(g.stmts, st)
+		if st.s.blank >= 0 {
+			g.adj[st.s.blank] = append(g.adj[st.s.blank], i)
+		}
+		if st.o.blank >= 0 && st.o.blank != st.s.blank {
+			g.adj[st.o.blank] = append(g.adj[st.o.blank], i)
+		}
+		if st.s.blank >= 0 || st.o.blank >= 0 {
+			g.bstmts = append(g.bstmts, i)
+		}
+	}
+	return g
+}
+
+// hash returns the hex encoded SHA-256 of the provided strings.
+func hash(ss ...string) string {
+	h := sha256.New()
+	for _, s := range ss {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// distinct returns the number of different values.
+func distinct(h []string) int {
+	m := make(map[string]bool, len(h))
+	for _, v := range h {
+		m[v] = true
+	}
+	return len(m)
+}
+
+// refine iteratively hashes the neighborhood of each blank node until the
+// number of distinct hashes stops growing.
+func (g *graph) refine(h []string) []string {
+	for {
+		n, nh := distinct(h), make([]string, len(h))
+		for b := range h {
+			es := []string{h[b]}
+			for _, i := range g.adj[b] {
+				st := g.stmts[i]
+				tm := func(x term) string {
+					switch x.blank {
+					case -1:
+						return x.v
+					case b:
+						return "_:@"
+					default:
+						return "_:" + h[x.blank]
+					}
+				}
+				es = append(es, tm(st.s)+"\t"+st.p+"\t"+tm(st.o))
+			}
+			sort.Strings(es[1:])
+			nh[b] = hash(es...)
+		}
+		if distinct(nh) == n {
+			return h
+		}
+		h = nh
+	}
+}
+
+// labels returns the rank of each blank node given their distinct hashes.
+func labels(h []string) []int {
+	idx := byHash{h: h, idx: make([]int, len(h))}
+	for i := range idx.idx {
+		idx.idx[i] = i
+	}
+	sort.Sort(idx)
+	ls := make([]int, len(h))
+	for r, b := range idx.idx {
+		ls[b] = r
+	}
+	return ls
+}
+
+// byHash sorts blank node indexes by their hashes.
+type byHash struct {
+	h   []string
+	idx []int
+}
+
+func (b byHash) Len() int           { return len(b.idx) }
+func (b byHash) Swap(i, j int)      { b.idx[i], b.idx[j] = b.idx[j], b.idx[i] }
+func (b byHash) Less(i, j int) bool { return b.h[b.idx[i]] < b.h[b.idx[j]] }
+
+// label returns the canonical ID of the blank node with the provided rank.
+func label(r int) string {
+	return LabelPrefix + strconv.Itoa(r)
+}
+
+// line returns the serialized statement using the provided labels.
+func (g *graph) line(st statement, ls []int) string {
+	tm := func(x term) string {
+		if x.blank < 0 {
+			return x.v
+		}
+		return blankType + "<" + label(ls[x.blank]) + ">"
+	}
+	return tm(st.s) + "\t" + st.p + "\t" + tm(st.o)
+}
+
+// blankLines returns the sorted serialization of the statements containing
+// blank nodes.
+func (g *graph) blankLines(ls []int) string {
+	var lns []string
+	for _, i := range g.bstmts {
+		lns = append(lns, g.line(g.stmts[i], ls))
+	}
+	sort.Strings(lns)
+	var buf bytes.Buffer
+	for _, l := range lns {
+		buf.WriteString(l)
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// search returns the canonical labels of the blank nodes. Ties left after
+// refinement are broken by individualizing each member of the first tied
+// class and keeping the labeling with the smallest serialization.
+func (g *graph) search(h []string) []int {
+	h = g.refine(h)
+	classes := make(map[string][]int)
+	for b, v := range h {
+		classes[v] = append(classes[v], b)
+	}
+	tie := ""
+	for v, c := range classes {
+		if len(c) > 1 && (tie == "" || v < tie) {
+			tie = v
+		}
+	}
+	if tie == "" {
+		return labels(h)
+	}
+	var (
+		best  []int
+		bestS string
+	)
+	for _, b := range classes[tie] {
+		nh := append([]string{}, h...)
+		nh[b] = hash(nh[b], "!")
+		ls := g.search(nh)
+		if s := g.blankLines(ls); best == nil || s < bestS {
+			best, bestS = ls, s
+		}
+	}
+	return best
+}
+
+// canonicalLabels returns the canonical labels of the blank nodes.
+func (g *graph) canonicalLabels() []int {
+	if g.blanks == 0 {
+		return nil
+	}
+	return g.search(make([]string, g.blanks))
+}
+
+// Relabel returns the triples with their blank nodes relabeled using
+// deterministic IDs starting with LabelPrefix. Duplicated triples are
+// removed, and the returned triples are sorted by their serialized form.
+// Triples without blank nodes are returned unchanged.
+func Relabel(ts []*triple.Triple) ([]*triple.Triple, error) {
+	g := newGraph(ts)
+	ls := g.canonicalLabels()
+	bt, err := node.NewType(blankType)
+	if err != nil {
+		return nil, fmt.Errorf("canonical.Relabel: %v", err)
+	}
+	nodes := make(map[int]*node.Node)
+	bn := func(i int) (*node.Node, error) {
+		if n, ok := nodes[i]; ok {
+			return n, nil
+		}
+		id, err := node.NewID(label(ls[i]))
+		if err != nil {
+			return nil, err
+		}
+		n := node.NewNode(bt, id)
+		nodes[i] = n
+		return n, nil
+	}
+	var res []*triple.Triple
+	for _, st := range g.stmts {
+		if st.s.blank < 0 && st.o.blank < 0 {
+			res = append(res, st.t)
+			continue
+		}
+		s, o := st.t.Subject(), st.t.Object()
+		if st.s.blank >= 0 {
+			if s, err = bn(st.s.blank); err != nil {
+				return nil, fmt.Errorf("canonical.Relabel: %v", err)
+			}
+		}
+		if st.o.blank >= 0 {
+			n, err := bn(st.o.blank)
+			if err != nil {
+				return nil, fmt.Errorf("canonical.Relabel: %v", err)
+			}
+			o = triple.NewNodeObject(n)
+		}
+		t, err := triple.New(s, st.t.Predicate(), o)
+		if err != nil {
+			return nil, fmt.Errorf("canonical.Relabel: %v", err)
+		}
+		res = append(res, t)
+	}
+	sort.Sort(byString(res))
+	return res, nil
+}
+
+// byString sorts triples by their serialized form.
+type byString []*triple.Triple
+
+func (b byString) Len() int           { return len(b) }
+func (b byString) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byString) Less(i, j int) bool { return b[i].String() < b[j].String() }
+
+// Digest returns the hex encoded SHA-256 digest of the canonical form of the
+// provided triples. The digest does not depend on the order of the triples,
+// on duplicates, or on the IDs of the blank nodes.
+func Digest(ts []*triple.Triple) string {
+	g := newGraph(ts)
+	ls := g.canonicalLabels()
+	lns := make([]string, 0, len(g.stmts))
+	for _, st := range g.stmts {
+		lns = append(lns, g.line(st, ls))
+	}
+	sort.Strings(lns)
+	h := sha256.New()
+	for _, l := range lns {
+		h.Write([]byte(l))
+		h.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GraphDigest returns the canonical digest of all the triples in the graph.
+// Graphs with the same digest contain the same triples up to the IDs of
+// their blank nodes. All the triples of the graph are kept in memory while
+// the digest is computed.
+func GraphDigest(ctx context.Context, g storage.Graph) (string, error) {
+	var ts []*triple.Triple
+	ch, errc := make(chan *triple.Triple), make(chan error, 1)
+	go func() {
+		errc <- g.Triples(ctx, storage.DefaultLookup, ch)
+	}()
+	for t := range ch {
+		ts = append(ts, t)
+	}
+	if err := <-errc; err != nil {
+		return "", fmt.Errorf("canonical.GraphDigest: %v", err)
+	}
+	return Digest(ts), nil
+}
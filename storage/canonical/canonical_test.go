@@ -0,0 +1,170 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package canonical
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func parse(t *testing.T, ss ...string) []*triple.Triple {
+	var ts []*triple.Triple
+	for _, s := range ss {
+		trpl, err := triple.Parse(s, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", s, err)
+		}
+		ts = append(ts, trpl)
+	}
+	return ts
+}
+
+func reified(t *testing.T, ss ...string) []*triple.Triple {
+	var res []*triple.Triple
+	for _, trpl := range parse(t, ss...) {
+		rts, _, err := trpl.Reify()
+		if err != nil {
+			t.Fatalf("t.Reify failed with error %v", err)
+		}
+		res = append(res, rts...)
+	}
+	return res
+}
+
+func reverse(ts []*triple.Triple) []*triple.Triple {
+	var res []*triple.Triple
+	for i := len(ts) - 1; i >= 0; i-- {
+		res = append(res, ts[i])
+	}
+	return res
+}
+
+func TestDigest(t *testing.T) {
+	facts := []string{
+		"/u<john>\t\"knows\"@[]\t/u<mary>",
+		"/u<john>\t\"met\"@[2016-04-10T04:21:00Z]\t/u<mary>",
+		"/u<mary>\t\"age\"@[]\t\"42\"^^type:int64",
+	}
+	a, b := reified(t, facts...), reified(t, facts...)
+	if Digest(a) != Digest(reverse(b)) {
+		t.Errorf("canonical.Digest should not depend on blank node IDs or triple order")
+	}
+	if got, want := Digest(append(a, a...)), Digest(a); got != want {
+		t.Errorf("canonical.Digest should ignore duplicated triples; got %s, want %s", got, want)
+	}
+	if Digest(a) == Digest(reified(t, facts[:2]...)) {
+		t.Errorf("canonical.Digest should differ for different graphs")
+	}
+	if Digest(parse(t, facts...)) == Digest(parse(t, facts[1:]...)) {
+		t.Errorf("canonical.Digest should differ for different graphs without blank nodes")
+	}
+}
+
+func TestDigestSymmetric(t *testing.T) {
+	// Two blank node cycles that can only be told apart once a node of the
+	// cycle is individualized.
+	a := parse(t,
+		"/_<x>\t\"next\"@[]\t/_<y>",
+		"/_<y>\t\"next\"@[]\t/_<z>",
+		"/_<z>\t\"next\"@[]\t/_<x>",
+		"/_<x>\t\"tag\"@[]\t\"a\"^^type:text",
+		"/_<y>\t\"tag\"@[]\t\"a\"^^type:text",
+		"/_<z>\t\"tag\"@[]\t\"a\"^^type:text",
+	)
+	b := parse(t,
+		"/_<q>\t\"next\"@[]\t/_<p>",
+		"/_<p>\t\"next\"@[]\t/_<r>",
+		"/_<r>\t\"next\"@[]\t/_<q>",
+		"/_<r>\t\"tag\"@[]\t\"a\"^^type:text",
+		"/_<q>\t\"tag\"@[]\t\"a\"^^type:text",
+		"/_<p>\t\"tag\"@[]\t\"a\"^^type:text",
+	)
+	if Digest(a) != Digest(b) {
+		t.Errorf("canonical.Digest should match for isomorphic graphs")
+	}
+	c := parse(t,
+		"/_<x>\t\"next\"@[]\t/_<y>",
+		"/_<y>\t\"next\"@[]\t/_<x>",
+		"/_<z>\t\"next\"@[]\t/_<z>",
+		"/_<x>\t\"tag\"@[]\t\"a\"^^type:text",
+		"/_<y>\t\"tag\"@[]\t\"a\"^^type:text",
+		"/_<z>\t\"tag\"@[]\t\"a\"^^type:text",
+	)
+	if Digest(a) == Digest(c) {
+		t.Errorf("canonical.Digest should differ for non isomorphic graphs")
+	}
+}
+
+func TestRelabel(t *testing.T) {
+	facts := []string{
+		"/u<john>\t\"knows\"@[]\t/u<mary>",
+		"/u<mary>\t\"age\"@[]\t\"42\"^^type:int64",
+	}
+	a, err := Relabel(reified(t, facts...))
+	if err != nil {
+		t.Fatalf("canonical.Relabel failed with error %v", err)
+	}
+	b, err := Relabel(reverse(reified(t, facts...)))
+	if err != nil {
+		t.Fatalf("canonical.Relabel failed with error %v", err)
+	}
+	if len(a) != 8 || len(a) != len(b) {
+		t.Fatalf("canonical.Relabel returned %d and %d triples; want 8", len(a), len(b))
+	}
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			t.Errorf("canonical.Relabel returned different triples; got %s, want %s", b[i], a[i])
+		}
+		if s := a[i].Subject(); s.Type().String() == blankType && !strings.HasPrefix(s.ID().String(), LabelPrefix) {
+			t.Errorf("canonical.Relabel failed to relabel blank node %s", a[i].Subject())
+		}
+	}
+	if got, want := Digest(a), Digest(reified(t, facts...)); got != want {
+		t.Errorf("canonical.Relabel should preserve the digest; got %s, want %s", got, want)
+	}
+	plain := parse(t, facts...)
+	c, err := Relabel(plain)
+	if err != nil {
+		t.Fatalf("canonical.Relabel failed with error %v", err)
+	}
+	if len(c) != 2 || c[0] != plain[0] || c[1] != plain[1] {
+		t.Errorf("canonical.Relabel should return triples without blank nodes unchanged; got %v", c)
+	}
+}
+
+func TestGraphDigest(t *testing.T) {
+	ctx := context.Background()
+	ts := reified(t, "/u<john>\t\"knows\"@[]\t/u<mary>")
+	g, err := memory.NewStore().NewGraph(ctx, "?g")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	if err := g.AddTriples(ctx, ts); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	got, err := GraphDigest(ctx, g)
+	if err != nil {
+		t.Fatalf("canonical.GraphDigest failed with error %v", err)
+	}
+	if want := Digest(ts); got != want {
+		t.Errorf("canonical.GraphDigest returned %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diff computes the differences between graphs and applies them as
+// patches. Triples are matched using their UUIDs.
+//
+// Patches use a line based text format. Each line contains a triple in the
+// standard serialized format prefixed by "- " if the triple is removed or
+// "+ " if it is added. Empty lines and lines starting with # are ignored.
+// For instance:
+//
+//	# Patch from ?staging to ?production.
+//	- /u<john>	"knows"@[]	/u<peter>
+//	+ /u<john>	"knows"@[]	/u<mary>
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/schema"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+// Diff contains the triples that need to be added and removed to turn a
+// graph into another.
+type Diff struct {
+	Added   []*triple.Triple
+	Removed []*triple.Triple
+}
+
+// Empty returns true if the diff contains no changes.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// Compute returns the diff that turns the from graph into the to graph. The
+// triples of the from graph are kept in memory while the to graph is
+// streamed. Added and removed triples are sorted by their serialized form.
+func Compute(ctx context.Context, from, to storage.Graph) (*Diff, error) {
+	ft := make(map[string]*triple.Triple)
+	if err := triples(ctx, from, func(t *triple.Triple) {
+		ft[t.UUID().String()] = t
+	}); err != nil {
+		return nil, fmt.Errorf("diff.Compute: %v", err)
+	}
+	d := &Diff{}
+	if err := triples(ctx, to, func(t *triple.Triple) {
+		id := t.UUID().String()
+		if _, ok := ft[id]; ok {
+			delete(ft, id)
+			return
+		}
+		d.Added = append(d.Added, t)
+	}); err != nil {
+		return nil, fmt.Errorf("diff.Compute: %v", err)
+	}
+	for _, t := range ft {
+		d.Removed = append(d.Removed, t)
+	}
+	sort.Sort(byString(d.Added))
+	sort.Sort(byString(d.Removed))
+	return d, nil
+}
+
+// triples calls fn for each triple in the graph.
+func triples(ctx context.Context, g storage.Graph, fn func(*triple.Triple)) error {
+	ts, errc := make(chan *triple.Triple), make(chan error, 1)
+	go func() {
+		errc <- g.Triples(ctx, storage.DefaultLookup, ts)
+	}()
+	for t := range ts {
+		fn(t)
+	}
+	return <-errc
+}
+
+// byString sorts triples by their serialized form.
+type byString []*triple.Triple
+
+func (b byString) Len() int           { return len(b) }
+func (b byString) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byString) Less(i, j int) bool { return b[i].String() < b[j].String() }
+
+// Apply applies the diff to the provided graph. Removals are applied before
+// additions. If the graph has a schema, as returned by schema.Of, the added triples are validated before applying any change.
+func (d *Diff) Apply(ctx context.Context, g storage.Graph) error {
+	if len(d.Added) > 0 {
+		if err := schema.Validate(ctx, g, d.Added); err != nil {
+			return fmt.Errorf("diff.Apply: %v", err)
+		}
+	}
+	if len(d.Removed) > 0 {
+		if err := g.RemoveTriples(ctx, d.Removed); err != nil {
+			return fmt.Errorf("diff.Apply: %v", err)
+		}
+	}
+	if len(d.Added) > 0 {
+		if err := g.AddTriples(ctx, d.Added); err != nil {
+			return fmt.Errorf("diff.Apply: %v", err)
+		}
+	}
+	return nil
+}
+
+// Write serializes the diff as a patch. Removals are written first.
+func (d *Diff) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range []struct {
+		prefix string
+		ts     []*triple.Triple
+	}{{"- ", d.Removed}, {"+ ", d.Added}} {
+		for _, t := range e.ts {
+			if _, err := bw.WriteString(e.prefix + t.String() + "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Parse reads a patch from the provided reader. Errors indicate the line
+// that could not be parsed.
+func Parse(r io.Reader, b literal.Builder) (*Diff, error) {
+	d, scanner := &Diff{}, bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if len(text) < 2 || (text[0] != '+' && text[0] != '-') {
+			return nil, fmt.Errorf("diff.Parse: line %d: changes need to start with + or -; got %q", line, text)
+		}
+		t, err := triple.Parse(strings.TrimSpace(text[1:]), b)
+		if err != nil {
+			return nil, fmt.Errorf("diff.Parse: line %d: %v", line, err)
+		}
+		if text[0] == '+' {
+			d.Added = append(d.Added, t)
+		} else {
+			d.Removed = append(d.Removed, t)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("diff.Parse: %v", err)
+	}
+	return d, nil
+}
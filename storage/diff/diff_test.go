@@ -0,0 +1,121 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func newGraph(t *testing.T, s storage.Store, id string, ts ...string) storage.Graph {
+	ctx := context.Background()
+	g, err := s.NewGraph(ctx, id)
+	if err != nil {
+		t.Fatalf("s.NewGraph(%q) failed with error %v", id, err)
+	}
+	for _, ln := range ts {
+		trpl, err := triple.Parse(ln, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", ln, err)
+		}
+		if err := g.AddTriples(ctx, []*triple.Triple{trpl}); err != nil {
+			t.Fatalf("g.AddTriples failed with error %v", err)
+		}
+	}
+	return g
+}
+
+func graphTriples(t *testing.T, g storage.Graph) string {
+	var res []*triple.Triple
+	if err := triples(context.Background(), g, func(t *triple.Triple) {
+		res = append(res, t)
+	}); err != nil {
+		t.Fatalf("g.Triples failed with error %v", err)
+	}
+	sort.Sort(byString(res))
+	var buf bytes.Buffer
+	if err := (&Diff{Added: res}).Write(&buf); err != nil {
+		t.Fatalf("diff.Write failed with error %v", err)
+	}
+	return buf.String()
+}
+
+func TestComputeAndApply(t *testing.T) {
+	ctx, s := context.Background(), memory.NewStore()
+	from := newGraph(t, s, "?from",
+		"/u<john>\t\"knows\"@[]\t/u<mary>",
+		"/u<john>\t\"knows\"@[]\t/u<peter>",
+		"/u<john>\t\"met\"@[2016-04-10T04:21:00Z]\t/u<alice>",
+	)
+	to := newGraph(t, s, "?to",
+		"/u<john>\t\"knows\"@[]\t/u<mary>",
+		"/u<john>\t\"met\"@[2016-04-10T04:22:00Z]\t/u<alice>",
+		"/u<john>\t\"age\"@[]\t\"42\"^^type:int64",
+	)
+	d, err := Compute(ctx, from, to)
+	if err != nil {
+		t.Fatalf("diff.Compute failed with error %v", err)
+	}
+	want := "- /u<john>\t\"knows\"@[]\t/u<peter>\n" +
+		"- /u<john>\t\"met\"@[2016-04-10T04:21:00Z]\t/u<alice>\n" +
+		"+ /u<john>\t\"age\"@[]\t\"42\"^^type:int64\n" +
+		"+ /u<john>\t\"met\"@[2016-04-10T04:22:00Z]\t/u<alice>\n"
+	var buf bytes.Buffer
+	if err := d.Write(&buf); err != nil {
+		t.Fatalf("diff.Write failed with error %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("diff.Write returned the wrong patch;\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	p, err := Parse(strings.NewReader("# A patch.\n\n"+buf.String()), literal.DefaultBuilder())
+	if err != nil {
+		t.Fatalf("diff.Parse failed with error %v", err)
+	}
+	if err := p.Apply(ctx, from); err != nil {
+		t.Fatalf("diff.Apply failed with error %v", err)
+	}
+	if got, want := graphTriples(t, from), graphTriples(t, to); got != want {
+		t.Errorf("diff.Apply returned the wrong graph;\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	d, err = Compute(ctx, from, to)
+	if err != nil {
+		t.Fatalf("diff.Compute failed with error %v", err)
+	}
+	if !d.Empty() {
+		t.Errorf("diff.Compute should have returned an empty diff for equal graphs; got %v", d)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"/u<john>\t\"knows\"@[]\t/u<mary>",
+		"+ /u<john>\t\"knows\"@[]",
+		"+",
+	} {
+		if _, err := Parse(strings.NewReader("\n"+s), literal.DefaultBuilder()); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("diff.Parse(%q) should have failed on line 2; got %v", s, err)
+		}
+	}
+}
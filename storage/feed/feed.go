@@ -0,0 +1,405 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package feed provides a change feed for BadWolf stores. It wraps a
+// storage.Store and publishes an ordered stream of the mutations applied to
+// its graphs.
+package feed
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/node"
+)
+
+// Op describes the kind of mutation an event represents.
+type Op uint8
+
+const (
+	// AddTriple indicates that a triple was added to a graph.
+	AddTriple Op = iota
+	// RemoveTriple indicates that a triple was removed from a graph.
+	RemoveTriple
+	// NewGraph indicates that a graph was created.
+	NewGraph
+	// DeleteGraph indicates that a graph was deleted.
+	DeleteGraph
+)
+
+// String returns the pretty printing version of the operation.
+func (o Op) String() string {
+	switch o {
+	case AddTriple:
+		return "add"
+	case RemoveTriple:
+		return "remove"
+	case NewGraph:
+		return "create"
+	case DeleteGraph:
+		return "drop"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Event describes a single mutation applied to the store.
+type Event struct {
+	// Seq is the sequence number of the event. Sequence numbers start at 1
+	// and are strictly increasing.
+	Seq uint64
+	// Op is the kind of mutation.
+	Op Op
+	// Graph is the ID of the mutated graph.
+	Graph string
+	// Triple is the triple added or removed. It is nil for graph events.
+	Triple *triple.Triple
+	// Time is the time the mutation was applied.
+	Time time.Time
+}
+
+// String returns a readable version of the event.
+func (e *Event) String() string {
+	if e.Triple == nil {
+		return fmt.Sprintf("%d\t%s\t%s", e.Seq, e.Op, e.Graph)
+	}
+	return fmt.Sprintf("%d\t%s\t%s\t%s", e.Seq, e.Op, e.Graph, e.Triple)
+}
+
+// DefaultRetention is the number of events retained when none is provided.
+const DefaultRetention = 10000
+
+// Store wraps a storage.Store and publishes all the mutations applied through
+// it. Only effective mutations are published: adding a triple that already
+// exists or removing one that does not exist produces no events. Mutations
+// applied directly to the wrapped store are not published.
+type Store struct {
+	storage.Store
+
+	// mu guards the event log and locks. It is only held while events are
+	// appended, hence the mutations and the existence checks preceding them
+	// run holding the lock of their graph instead.
+	mu    sync.Mutex
+	cond  *sync.Cond
+	locks map[string]*sync.Mutex
+	// events is a ring buffer holding the retained events. The event with
+	// sequence number seq is stored at index (seq-1) % retention.
+	events    []*Event
+	next      uint64
+	retention int
+}
+
+// NewStore returns a new store publishing the mutations applied to the
+// provided store. The last retention events are kept to allow subscribers to
+// resume. If retention is not positive DefaultRetention is used.
+func NewStore(s storage.Store, retention int) *Store {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	fs := &Store{
+		Store:     s,
+		locks:     make(map[string]*sync.Mutex),
+		next:      1,
+		retention: retention,
+	}
+	fs.cond = sync.NewCond(&fs.mu)
+	return fs
+}
+
+// publish appends a new event to the log, replacing the oldest retained
+// event once the log is full. It requires the lock to be held.
+func (s *Store) publish(op Op, graph string, t *triple.Triple) {
+	e := &Event{
+		Seq:    s.next,
+		Op:     op,
+		Graph:  graph,
+		Triple: t,
+		Time:   time.Now(),
+	}
+	if len(s.events) < s.retention {
+		s.events = append(s.events, e)
+	} else {
+		s.events[s.index(e.Seq)] = e
+	}
+	s.next++
+	s.cond.Broadcast()
+}
+
+// index returns the position of the event with the provided sequence number
+// in the ring buffer.
+func (s *Store) index(seq uint64) int {
+	return int((seq - 1) % uint64(s.retention))
+}
+
+// wrap returns the version of the graph publishing its mutations.
+func (s *Store) wrap(g storage.Graph) storage.Graph {
+	fg := &graph{Graph: g, s: s}
+	if tg, ok := g.(storage.TypeGraph); ok {
+		return &typeGraph{graph: fg, tg: tg}
+	}
+	return fg
+}
+
+// lock locks the graph with the provided ID and returns the function
+// unlocking it. Mutations are checked, applied, and published holding the
+// lock of their graph, so the events of a graph follow the order of its
+// mutations while different graphs are mutated concurrently.
+func (s *Store) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	s.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// NewGraph creates a new graph.
+func (s *Store) NewGraph(ctx context.Context, id string) (storage.Graph, error) {
+	defer s.lock(id)()
+	g, err := s.Store.NewGraph(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.publish(NewGraph, id, nil)
+	s.mu.Unlock()
+	return s.wrap(g), nil
+}
+
+// Graph returns an existing graph if available.
+func (s *Store) Graph(ctx context.Context, id string) (storage.Graph, error) {
+	g, err := s.Store.Graph(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(g), nil
+}
+
+// DeleteGraph deletes an existing graph.
+func (s *Store) DeleteGraph(ctx context.Context, id string) error {
+	defer s.lock(id)()
+	if err := s.Store.DeleteGraph(ctx, id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish(DeleteGraph, id, nil)
+	return nil
+}
+
+// LastSeq returns the sequence number of the last published event. It
+// returns 0 if no event has been published yet.
+func (s *Store) LastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next - 1
+}
+
+// Subscription delivers the events of a store in order.
+type Subscription struct {
+	// Events receives the published events. It is closed when the
+	// subscription ends.
+	Events <-chan *Event
+
+	mu  sync.Mutex
+	err error
+}
+
+// Err returns the reason the subscription ended, if any. It returns nil if
+// the subscription ended because its context was done.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Subscribe returns a subscription that delivers all the events with a
+// sequence number equal or greater than from. If from is 0, only events
+// published after the call are delivered. Subscribe fails if the requested
+// events are no longer retained. The subscription ends when the context is
+// done or if the subscriber falls behind the retained events.
+func (s *Store) Subscribe(ctx context.Context, from uint64) (*Subscription, error) {
+	s.mu.Lock()
+	if from == 0 {
+		from = s.next
+	}
+	if err := s.checkRetained(from); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.mu.Unlock()
+
+	evts := make(chan *Event)
+	sub := &Subscription{Events: evts}
+
+	// Wake up the subscription if the context is done.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		defer close(evts)
+		defer close(done)
+		cursor := from
+		for {
+			s.mu.Lock()
+			for cursor >= s.next && ctx.Err() == nil {
+				s.cond.Wait()
+			}
+			if ctx.Err() != nil {
+				s.mu.Unlock()
+				return
+			}
+			if err := s.checkRetained(cursor); err != nil {
+				s.mu.Unlock()
+				sub.mu.Lock()
+				sub.err = err
+				sub.mu.Unlock()
+				return
+			}
+			pending := make([]*Event, 0, s.next-cursor)
+			for seq := cursor; seq < s.next; seq++ {
+				pending = append(pending, s.events[s.index(seq)])
+			}
+			s.mu.Unlock()
+			for _, e := range pending {
+				select {
+				case evts <- e:
+					cursor = e.Seq + 1
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// checkRetained checks that all events starting at the provided sequence
+// number are available. It requires the lock to be held.
+func (s *Store) checkRetained(from uint64) error {
+	if from > s.next {
+		return fmt.Errorf("feed.Subscribe: sequence number %d is ahead of the next event %d", from, s.next)
+	}
+	if oldest := s.next - uint64(len(s.events)); from < oldest {
+		return fmt.Errorf("feed.Subscribe: sequence number %d is no longer retained; oldest available is %d", from, oldest)
+	}
+	return nil
+}
+
+// graph wraps a storage.Graph and publishes its mutations.
+type graph struct {
+	storage.Graph
+	s *Store
+}
+
+// Unwrap returns the wrapped graph.
+func (g *graph) Unwrap() storage.Graph {
+	return g.Graph
+}
+
+// AddTriples adds the triples to the graph and publishes the ones that did not
+// exist before.
+func (g *graph) AddTriples(ctx context.Context, ts []*triple.Triple) error {
+	id := g.ID(ctx)
+	defer g.s.lock(id)()
+	nts, err := g.filter(ctx, ts, false)
+	if err != nil {
+		return err
+	}
+	if err := g.Graph.AddTriples(ctx, ts); err != nil {
+		return err
+	}
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+	for _, t := range nts {
+		g.s.publish(AddTriple, id, t)
+	}
+	return nil
+}
+
+// RemoveTriples removes the triples from the graph and publishes the ones
+// that existed.
+func (g *graph) RemoveTriples(ctx context.Context, ts []*triple.Triple) error {
+	id := g.ID(ctx)
+	defer g.s.lock(id)()
+	ets, err := g.filter(ctx, ts, true)
+	if err != nil {
+		return err
+	}
+	if err := g.Graph.RemoveTriples(ctx, ts); err != nil {
+		return err
+	}
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+	for _, t := range ets {
+		g.s.publish(RemoveTriple, id, t)
+	}
+	return nil
+}
+
+// filter returns the unique triples whose existence in the graph matches the
+// provided value.
+func (g *graph) filter(ctx context.Context, ts []*triple.Triple, exist bool) ([]*triple.Triple, error) {
+	var res []*triple.Triple
+	seen := make(map[string]bool)
+	for _, t := range ts {
+		k := t.UUID().String()
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		b, err := g.Graph.Exist(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		if b == exist {
+			res = append(res, t)
+		}
+	}
+	return res, nil
+}
+
+// typeGraph wraps graphs that also provide the type index lookups.
+type typeGraph struct {
+	*graph
+	tg storage.TypeGraph
+}
+
+// TriplesForSubjectType pushes to the provided channel all triples whose
+// subject type is covariant with the provided type.
+func (g *typeGraph) TriplesForSubjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	return g.tg.TriplesForSubjectType(ctx, t, lo, trpls)
+}
+
+// TriplesForObjectType pushes to the provided channel all triples whose
+// object is a node with a type covariant with the provided type.
+func (g *typeGraph) TriplesForObjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	return g.tg.TriplesForObjectType(ctx, t, lo, trpls)
+}
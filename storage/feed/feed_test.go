@@ -0,0 +1,371 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package feed
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func createTriples(t *testing.T, ss ...string) []*triple.Triple {
+	ts := []*triple.Triple{}
+	for _, s := range ss {
+		trpl, err := triple.Parse(s, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", s, err)
+		}
+		ts = append(ts, trpl)
+	}
+	return ts
+}
+
+func receive(t *testing.T, sub *Subscription, n int) []*Event {
+	var res []*Event
+	for i := 0; i < n; i++ {
+		select {
+		case e, ok := <-sub.Events:
+			if !ok {
+				t.Fatalf("subscription ended after %d events with error %v; want %d events", len(res), sub.Err(), n)
+			}
+			res = append(res, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("subscription timed out after %d events; want %d events", len(res), n)
+		}
+	}
+	return res
+}
+
+func mutate(ctx context.Context, t *testing.T, s *Store) {
+	g, err := s.NewGraph(ctx, "?test")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	ts := createTriples(t,
+		`/u<john> "knows"@[] /u<mary>`,
+		`/u<john> "knows"@[] /u<peter>`)
+	if err := g.AddTriples(ctx, ts); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	// Adding existing triples should not publish events.
+	if err := g.AddTriples(ctx, ts); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	if err := g.RemoveTriples(ctx, ts[:1]); err != nil {
+		t.Fatalf("g.RemoveTriples failed with error %v", err)
+	}
+	// Removing non existing triples should not publish events.
+	if err := g.RemoveTriples(ctx, ts[:1]); err != nil {
+		t.Fatalf("g.RemoveTriples failed with error %v", err)
+	}
+	if err := s.DeleteGraph(ctx, "?test"); err != nil {
+		t.Fatalf("s.DeleteGraph failed with error %v", err)
+	}
+}
+
+func TestOrderedEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewStore(memory.NewStore(), 0)
+	sub, err := s.Subscribe(ctx, 0)
+	if err != nil {
+		t.Fatalf("s.Subscribe failed with error %v", err)
+	}
+	mutate(ctx, t, s)
+	want := []Op{NewGraph, AddTriple, AddTriple, RemoveTriple, DeleteGraph}
+	for i, e := range receive(t, sub, len(want)) {
+		if got, want := e.Seq, uint64(i+1); got != want {
+			t.Errorf("event %v has the wrong sequence number; got %d, want %d", e, got, want)
+		}
+		if got, want := e.Op, want[i]; got != want {
+			t.Errorf("event %v has the wrong operation; got %v, want %v", e, got, want)
+		}
+		if got, want := e.Graph, "?test"; got != want {
+			t.Errorf("event %v has the wrong graph; got %q, want %q", e, got, want)
+		}
+	}
+	if got, want := s.LastSeq(), uint64(len(want)); got != want {
+		t.Errorf("s.LastSeq returned the wrong sequence number; got %d, want %d", got, want)
+	}
+	cancel()
+	for range sub.Events {
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("canceled subscriptions should not report errors; got %v", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewStore(), 3)
+	mutate(ctx, t, s)
+
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub, err := s.Subscribe(sctx, 4)
+	if err != nil {
+		t.Fatalf("s.Subscribe(4) failed with error %v", err)
+	}
+	evts := receive(t, sub, 2)
+	if got, want := evts[0].Op, RemoveTriple; got != want {
+		t.Errorf("resumed subscription returned the wrong first event; got %v, want %v", got, want)
+	}
+	if got, want := evts[1].Seq, uint64(5); got != want {
+		t.Errorf("resumed subscription returned the wrong sequence number; got %d, want %d", got, want)
+	}
+
+	if _, err := s.Subscribe(ctx, 1); err == nil {
+		t.Errorf("s.Subscribe(1) should fail since the event is no longer retained")
+	}
+	if _, err := s.Subscribe(ctx, 10); err == nil {
+		t.Errorf("s.Subscribe(10) should fail since the event has not been published")
+	}
+}
+
+func TestRetentionWrapsAround(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewStore(memory.NewStore(), 4)
+	// Each iteration publishes 5 events, so the log wraps several times.
+	for i := 0; i < 3; i++ {
+		mutate(ctx, t, s)
+	}
+	if _, err := s.Subscribe(ctx, 11); err == nil {
+		t.Errorf("s.Subscribe(11) should fail since the event is no longer retained")
+	}
+	sub, err := s.Subscribe(ctx, 12)
+	if err != nil {
+		t.Fatalf("s.Subscribe(12) failed with error %v", err)
+	}
+	want := []Op{AddTriple, AddTriple, RemoveTriple, DeleteGraph}
+	for i, e := range receive(t, sub, len(want)) {
+		if e.Seq != uint64(12+i) || e.Op != want[i] {
+			t.Errorf("retained event %d is %d %v; want %d %v", i, e.Seq, e.Op, 12+i, want[i])
+		}
+	}
+}
+
+func countTriples(ctx context.Context, t *testing.T, s storage.Store, id string) int {
+	g, err := s.Graph(ctx, id)
+	if err != nil {
+		t.Fatalf("s.Graph(%q) failed with error %v", id, err)
+	}
+	ts, errc := make(chan *triple.Triple), make(chan error, 1)
+	go func() {
+		errc <- g.Triples(ctx, storage.DefaultLookup, ts)
+	}()
+	cnt := 0
+	for range ts {
+		cnt++
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("g.Triples failed with error %v", err)
+	}
+	return cnt
+}
+
+func TestSnapshotAndApply(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewStore(), 0)
+	g, err := s.NewGraph(ctx, "?base")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	if err := g.AddTriples(ctx, createTriples(t,
+		`/u<john> "knows"@[] /u<mary>`,
+		`/u<john> "knows"@[] /u<peter>`)); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+
+	r := memory.NewStore()
+	seq, err := s.Snapshot(ctx, func(e *Event) error {
+		return Apply(ctx, r, e)
+	})
+	if err != nil {
+		t.Fatalf("s.Snapshot failed with error %v", err)
+	}
+	if got, want := seq, s.LastSeq(); got != want {
+		t.Errorf("s.Snapshot returned the wrong sequence number; got %d, want %d", got, want)
+	}
+	if got, want := countTriples(ctx, t, r, "?base"), 2; got != want {
+		t.Errorf("replica has the wrong number of triples after the snapshot; got %d, want %d", got, want)
+	}
+
+	// Replay the events that follow the snapshot, along with the whole log
+	// to check that applying events is idempotent.
+	mutate(ctx, t, s)
+	for _, from := range []uint64{seq + 1, 1} {
+		sctx, cancel := context.WithCancel(ctx)
+		sub, err := s.Subscribe(sctx, from)
+		if err != nil {
+			t.Fatalf("s.Subscribe(%d) failed with error %v", from, err)
+		}
+		for _, e := range receive(t, sub, int(s.LastSeq()-from+1)) {
+			if err := Apply(ctx, r, e); err != nil {
+				t.Errorf("Apply(%v) failed with error %v", e, err)
+			}
+		}
+		cancel()
+	}
+	if got, want := countTriples(ctx, t, r, "?base"), 2; got != want {
+		t.Errorf("replica has the wrong number of triples after replaying the log; got %d, want %d", got, want)
+	}
+	if _, err := r.Graph(ctx, "?test"); err == nil {
+		t.Errorf("replica should not contain the deleted graph ?test")
+	}
+}
+
+func TestGraphsDoNotBlockEachOther(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewStore(), 0)
+	if _, err := s.NewGraph(ctx, "?busy"); err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	g, err := s.NewGraph(ctx, "?test")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	sub, err := s.Subscribe(ctx, 0)
+	if err != nil {
+		t.Fatalf("s.Subscribe failed with error %v", err)
+	}
+
+	// Simulate a slow mutation of another graph.
+	unlock := s.lock("?busy")
+	defer unlock()
+	done := make(chan error, 1)
+	go func() {
+		done <- g.AddTriples(ctx, createTriples(t, `/u<john> "knows"@[] /u<mary>`))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("g.AddTriples failed with error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("g.AddTriples should not wait for the mutations of other graphs")
+	}
+	if e := receive(t, sub, 1)[0]; e.Op != AddTriple || e.Graph != "?test" {
+		t.Errorf("g.AddTriples published %v on %q; want %v on %q", e.Op, e.Graph, AddTriple, "?test")
+	}
+}
+
+func TestSnapshotDuringMutations(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewStore(), 0)
+	gs := make(map[string]storage.Graph)
+	for _, id := range []string{"?a", "?b"} {
+		g, err := s.NewGraph(ctx, id)
+		if err != nil {
+			t.Fatalf("s.NewGraph failed with error %v", err)
+		}
+		gs[id] = g
+	}
+	ts := createTriples(t,
+		`/u<john> "knows"@[] /u<mary>`,
+		`/u<john> "knows"@[] /u<peter>`)
+	if err := gs["?a"].AddTriples(ctx, ts[:1]); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+
+	// While a graph is copied, mutate the other one: the mutations of ?b
+	// are both in the snapshot and in the log, and the ones of ?a only in
+	// the log.
+	r := memory.NewStore()
+	seq, err := s.Snapshot(ctx, func(e *Event) error {
+		if e.Op == NewGraph {
+			other := map[string]string{"?a": "?b", "?b": "?a"}[e.Graph]
+			if err := gs[other].AddTriples(ctx, ts[1:]); err != nil {
+				return err
+			}
+			if err := gs[other].RemoveTriples(ctx, ts[:1]); err != nil {
+				return err
+			}
+		}
+		return Apply(ctx, r, e)
+	})
+	if err != nil {
+		t.Fatalf("s.Snapshot failed with error %v", err)
+	}
+	sub, err := s.Subscribe(ctx, seq+1)
+	if err != nil {
+		t.Fatalf("s.Subscribe(%d) failed with error %v", seq+1, err)
+	}
+	for _, e := range receive(t, sub, int(s.LastSeq()-seq)) {
+		if err := Apply(ctx, r, e); err != nil {
+			t.Errorf("Apply(%v) failed with error %v", e, err)
+		}
+	}
+	for _, id := range []string{"?a", "?b"} {
+		g, err := r.Graph(ctx, id)
+		if err != nil {
+			t.Fatalf("r.Graph(%q) failed with error %v", id, err)
+		}
+		for i, want := range []bool{false, true} {
+			if got, err := g.Exist(ctx, ts[i]); err != nil || got != want {
+				t.Errorf("replica graph %q contains %s returned %v, %v; want %v", id, ts[i], got, err, want)
+			}
+		}
+	}
+}
+
+func TestParseOp(t *testing.T) {
+	for _, o := range []Op{AddTriple, RemoveTriple, NewGraph, DeleteGraph} {
+		got, err := ParseOp(o.String())
+		if err != nil || got != o {
+			t.Errorf("ParseOp(%q) returned %v, %v; want %v", o.String(), got, err, o)
+		}
+	}
+	if _, err := ParseOp("rename"); err == nil {
+		t.Errorf("ParseOp(%q) should have failed", "rename")
+	}
+}
+
+func TestTypeGraphIsPreserved(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(memory.NewStore(), 0)
+	sub, err := s.Subscribe(ctx, 0)
+	if err != nil {
+		t.Fatalf("s.Subscribe failed with error %v", err)
+	}
+	g, err := s.NewGraph(ctx, "?test")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	tg, ok := g.(storage.TypeGraph)
+	if !ok {
+		t.Fatalf("feed graphs wrapping memory graphs should implement storage.TypeGraph")
+	}
+	ts := createTriples(t, `/u<john> "knows"@[] /u<mary>`)
+	if err := tg.AddTriples(ctx, ts); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	if evts := receive(t, sub, 2); evts[1].Op != AddTriple {
+		t.Errorf("adding a triple through a type graph published %v; want %v", evts[1].Op, AddTriple)
+	}
+	trpls := make(chan *triple.Triple, 10)
+	if err := tg.TriplesForSubjectType(ctx, ts[0].Subject().Type(), storage.DefaultLookup, trpls); err != nil {
+		t.Fatalf("g.TriplesForSubjectType failed with error %v", err)
+	}
+	if got := len(trpls); got != 1 {
+		t.Errorf("g.TriplesForSubjectType returned %d triples; want 1", got)
+	}
+}
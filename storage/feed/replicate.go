@@ -0,0 +1,127 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package feed
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+)
+
+// Snapshot calls f with the events that recreate the current contents of the
+// store: a NewGraph event for each graph, sorted by ID, followed by an
+// AddTriple event for each of its triples. Snapshot events have no sequence
+// number. It returns the sequence number of the last event published before
+// the snapshot was taken, so replicas can apply the snapshot and then
+// subscribe to the events that follow it. Mutations are not blocked while the
+// snapshot is taken: the ones missing from it, and possibly some included in
+// it, are published after the returned sequence number. Since applying
+// events is idempotent, replicas applying them in order converge to the
+// store contents. Each graph is locked while it is copied.
+func (s *Store) Snapshot(ctx context.Context, f func(*Event) error) (uint64, error) {
+	seq := s.LastSeq()
+	names, errc := make(chan string), make(chan error, 1)
+	go func() {
+		errc <- s.Store.GraphNames(ctx, names)
+	}()
+	var ids []string
+	for n := range names {
+		ids = append(ids, n)
+	}
+	if err := <-errc; err != nil {
+		return 0, err
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := s.snapshotGraph(ctx, id, f); err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// snapshotGraph calls f with the events that recreate the graph holding its
+// lock.
+func (s *Store) snapshotGraph(ctx context.Context, id string, f func(*Event) error) error {
+	defer s.lock(id)()
+	g, err := s.Store.Graph(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := f(&Event{Op: NewGraph, Graph: id}); err != nil {
+		return err
+	}
+	ts, errc := make(chan *triple.Triple), make(chan error, 1)
+	go func() {
+		errc <- g.Triples(ctx, storage.DefaultLookup, ts)
+	}()
+	var fErr error
+	for t := range ts {
+		if fErr == nil {
+			fErr = f(&Event{Op: AddTriple, Graph: id, Triple: t})
+		}
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	return fErr
+}
+
+// Apply applies the mutation described by the event to the provided store.
+// Applying an event is idempotent: creating an existing graph or deleting a
+// missing one succeeds without changing the store.
+func Apply(ctx context.Context, s storage.Store, e *Event) error {
+	switch e.Op {
+	case NewGraph:
+		if _, err := s.Graph(ctx, e.Graph); err == nil {
+			return nil
+		}
+		_, err := s.NewGraph(ctx, e.Graph)
+		return err
+	case DeleteGraph:
+		if _, err := s.Graph(ctx, e.Graph); err != nil {
+			return nil
+		}
+		return s.DeleteGraph(ctx, e.Graph)
+	case AddTriple, RemoveTriple:
+		if e.Triple == nil {
+			return fmt.Errorf("feed.Apply: event %d has no triple", e.Seq)
+		}
+		g, err := s.Graph(ctx, e.Graph)
+		if err != nil {
+			return err
+		}
+		if e.Op == AddTriple {
+			return g.AddTriples(ctx, []*triple.Triple{e.Triple})
+		}
+		return g.RemoveTriples(ctx, []*triple.Triple{e.Triple})
+	default:
+		return fmt.Errorf("feed.Apply: unknown operation %v", e.Op)
+	}
+}
+
+// ParseOp returns the operation with the provided pretty printing name.
+func ParseOp(s string) (Op, error) {
+	for _, o := range []Op{AddTriple, RemoveTriple, NewGraph, DeleteGraph} {
+		if o.String() == s {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("feed.ParseOp: unknown operation %q", s)
+}
@@ -70,6 +70,8 @@ func (s *memoryStore) NewGraph(ctx context.Context, id string) (storage.Graph, e
 		idxSP: make(map[string]map[string]*triple.Triple, initialAllocation),
 		idxPO: make(map[string]map[string]*triple.Triple, initialAllocation),
 		idxSO: make(map[string]map[string]*triple.Triple, initialAllocation),
+		idxST: make(map[string]map[string]*triple.Triple, initialAllocation),
+		idxOT: make(map[string]map[string]*triple.Triple, initialAllocation),
 	}
 
 	s.rwmu.Lock()
@@ -129,6 +131,8 @@ type memory struct {
 	idxSP map[string]map[string]*triple.Triple
 	idxPO map[string]map[string]*triple.Triple
 	idxSO map[string]map[string]*triple.Triple
+	idxST map[string]map[string]*triple.Triple
+	idxOT map[string]map[string]*triple.Triple
 }
 
 // ID returns the id for this graph.
@@ -180,6 +184,20 @@ func (m *memory) AddTriples(ctx context.Context, ts []*triple.Triple) error {
 			m.idxSO[key] = make(map[string]*triple.Triple)
 		}
 		m.idxSO[key][suuid] = t
+
+		key = t.Subject().Type().String()
+		if _, ok := m.idxST[key]; !ok {
+			m.idxST[key] = make(map[string]*triple.Triple)
+		}
+		m.idxST[key][suuid] = t
+
+		if n, err := t.Object().Node(); err == nil {
+			key = n.Type().String()
+			if _, ok := m.idxOT[key]; !ok {
+				m.idxOT[key] = make(map[string]*triple.Triple)
+			}
+			m.idxOT[key][suuid] = t
+		}
 	}
 	return nil
 }
@@ -216,6 +234,20 @@ func (m *memory) RemoveTriples(ctx context.Context, ts []*triple.Triple) error {
 			delete(m.idxSO, key)
 		}
 
+		key = t.Subject().Type().String()
+		delete(m.idxST[key], suuid)
+		if len(m.idxST[key]) == 0 {
+			delete(m.idxST, key)
+		}
+
+		if n, err := t.Object().Node(); err == nil {
+			key = n.Type().String()
+			delete(m.idxOT[key], suuid)
+			if len(m.idxOT[key]) == 0 {
+				delete(m.idxOT, key)
+			}
+		}
+
 		m.rwmu.Unlock()
 	}
 	return nil
@@ -465,6 +497,49 @@ func (m *memory) TriplesForPredicateAndObject(ctx context.Context, p *predicate.
 	return nil
 }
 
+// TriplesForSubjectType publishes all triples whose subject type is
+// covariant with the provided type to the provided channel.
+func (m *memory) TriplesForSubjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	if trpls == nil {
+		return fmt.Errorf("cannot provide an empty channel")
+	}
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	defer close(trpls)
+
+	return pushCovariant(m.idxST, t, newChecker(lo), trpls)
+}
+
+// TriplesForObjectType publishes all triples whose object node type is
+// covariant with the provided type to the provided channel.
+func (m *memory) TriplesForObjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	if trpls == nil {
+		return fmt.Errorf("cannot provide an empty channel")
+	}
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	defer close(trpls)
+
+	return pushCovariant(m.idxOT, t, newChecker(lo), trpls)
+}
+
+// pushCovariant pushes all the triples in the type index whose key is
+// covariant with the provided type.
+func pushCovariant(idx map[string]map[string]*triple.Triple, t *node.Type, ckr *checker, trpls chan<- *triple.Triple) error {
+	for k, ts := range idx {
+		kt := node.Type(k)
+		if !kt.Covariant(t) {
+			continue
+		}
+		for _, tr := range ts {
+			if ckr.CheckAndUpdate(tr.Predicate()) {
+				trpls <- tr
+			}
+		}
+	}
+	return nil
+}
+
 // Exist checks if the provided triple exists on the store.
 func (m *memory) Exist(ctx context.Context, t *triple.Triple) (bool, error) {
 	suuid := UUIDToByteString(t.UUID())
@@ -524,3 +524,70 @@ func TestTriples(t *testing.T) {
 		t.Errorf("g.TriplesForPredicateAndObject(%s, %s) failed to retrieve 1 predicates, got %d instead", ts[0].Predicate(), ts[0].Object(), cnt)
 	}
 }
+
+func TestTriplesForSubjectAndObjectType(t *testing.T) {
+	ts, ctx := createTriples(t, []string{
+		"/org<acme>\t\"employs\"@[]\t/person<john>",
+		"/org/company<initech>\t\"employs\"@[]\t/person/engineer<peter>",
+		"/organization<umbrella>\t\"employs\"@[]\t/person<alice>",
+		"/org/company<initech>\t\"named\"@[]\t\"Initech\"^^type:text",
+	}), context.Background()
+	g, _ := NewStore().NewGraph(ctx, "test")
+	if err := g.AddTriples(ctx, ts); err != nil {
+		t.Errorf("g.AddTriples(_) failed failed to add test triples with error %v", err)
+	}
+	tg, ok := g.(storage.TypeGraph)
+	if !ok {
+		t.Fatalf("memory graph should implement storage.TypeGraph")
+	}
+	table := []struct {
+		t        string
+		sub, obj int
+	}{
+		{"/org", 3, 0},
+		{"/org/company", 2, 0},
+		{"/organization", 1, 0},
+		{"/person", 0, 3},
+		{"/person/engineer", 0, 1},
+		{"/per", 0, 0},
+	}
+	for _, entry := range table {
+		nt, err := node.NewType(entry.t)
+		if err != nil {
+			t.Fatalf("node.NewType(%q) failed with error %v", entry.t, err)
+		}
+		trpls := make(chan *triple.Triple, 100)
+		if err := tg.TriplesForSubjectType(ctx, nt, storage.DefaultLookup, trpls); err != nil {
+			t.Errorf("g.TriplesForSubjectType(%s) failed with error %v", nt, err)
+		}
+		cnt := 0
+		for _ = range trpls {
+			cnt++
+		}
+		if cnt != entry.sub {
+			t.Errorf("g.TriplesForSubjectType(%s) failed to retrieve %d triples, got %d instead", nt, entry.sub, cnt)
+		}
+		trpls = make(chan *triple.Triple, 100)
+		if err := tg.TriplesForObjectType(ctx, nt, storage.DefaultLookup, trpls); err != nil {
+			t.Errorf("g.TriplesForObjectType(%s) failed with error %v", nt, err)
+		}
+		cnt = 0
+		for _ = range trpls {
+			cnt++
+		}
+		if cnt != entry.obj {
+			t.Errorf("g.TriplesForObjectType(%s) failed to retrieve %d triples, got %d instead", nt, entry.obj, cnt)
+		}
+	}
+	if err := g.RemoveTriples(ctx, ts); err != nil {
+		t.Errorf("g.RemoveTriples(_) failed failed to remove test triples with error %v", err)
+	}
+	trpls := make(chan *triple.Triple, 100)
+	nt, _ := node.NewType("/org")
+	if err := tg.TriplesForSubjectType(ctx, nt, storage.DefaultLookup, trpls); err != nil {
+		t.Errorf("g.TriplesForSubjectType(%s) failed with error %v", nt, err)
+	}
+	if _, ok := <-trpls; ok {
+		t.Errorf("g.TriplesForSubjectType(%s) should not return triples after removal", nt)
+	}
+}
@@ -0,0 +1,633 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schema provides optional per graph schemas and the machinery to
+// validate batches of triples against them before they get added to a graph.
+//
+// Schemas are only registered programmatically on the Store wrapping the
+// storage.Store whose graphs they constrain. They are neither persisted by the
+// storage drivers nor exposed through BQL or the bw command line tool.
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// ObjectKind describes the kind of value boxed in a triple object.
+type ObjectKind uint8
+
+const (
+	// NodeObject indicates that the object is a node.
+	NodeObject ObjectKind = iota
+	// PredicateObject indicates that the object is a predicate.
+	PredicateObject
+	// BoolLiteral indicates that the object is a bool literal.
+	BoolLiteral
+	// Int64Literal indicates that the object is an int64 literal.
+	Int64Literal
+	// Float64Literal indicates that the object is a float64 literal.
+	Float64Literal
+	// TextLiteral indicates that the object is a text literal.
+	TextLiteral
+	// BlobLiteral indicates that the object is a blob literal.
+	BlobLiteral
+)
+
+// String returns the pretty printing version of the object kind.
+func (k ObjectKind) String() string {
+	switch k {
+	case NodeObject:
+		return "node"
+	case PredicateObject:
+		return "predicate"
+	case BoolLiteral:
+		return "bool"
+	case Int64Literal:
+		return "int64"
+	case Float64Literal:
+		return "float64"
+	case TextLiteral:
+		return "text"
+	case BlobLiteral:
+		return "blob"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// KindOf returns the kind of the provided object.
+func KindOf(o *triple.Object) (ObjectKind, error) {
+	if _, err := o.Node(); err == nil {
+		return NodeObject, nil
+	}
+	if _, err := o.Predicate(); err == nil {
+		return PredicateObject, nil
+	}
+	l, err := o.Literal()
+	if err != nil {
+		return 0, fmt.Errorf("schema.KindOf: unknown object type in object %s", o)
+	}
+	switch l.Type() {
+	case literal.Bool:
+		return BoolLiteral, nil
+	case literal.Int64:
+		return Int64Literal, nil
+	case literal.Float64:
+		return Float64Literal, nil
+	case literal.Text:
+		return TextLiteral, nil
+	case literal.Blob:
+		return BlobLiteral, nil
+	}
+	return 0, fmt.Errorf("schema.KindOf: unknown literal type in object %s", o)
+}
+
+// Cardinality describes how many values a subject may have for a predicate.
+type Cardinality uint8
+
+const (
+	// MultiValued allows any number of values per subject.
+	MultiValued Cardinality = iota
+	// SingleValued allows only one value per subject regardless of the time
+	// anchor of the predicate.
+	SingleValued
+	// SingleValuedPerAnchor allows only one value per subject and time anchor.
+	// For immutable predicates it behaves as SingleValued.
+	SingleValuedPerAnchor
+)
+
+// String returns the pretty printing version of the cardinality.
+func (c Cardinality) String() string {
+	switch c {
+	case MultiValued:
+		return "multi valued"
+	case SingleValued:
+		return "single valued"
+	case SingleValuedPerAnchor:
+		return "single valued per anchor"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Predicate describes the constraints for all triples whose predicate has the
+// provided ID. Empty lists impose no constraints.
+type Predicate struct {
+	// ID of the predicate the constraint applies to.
+	ID predicate.ID
+	// SubjectTypes lists the allowed subject types. Subjects must be covariant
+	// with at least one of them.
+	SubjectTypes []*node.Type
+	// ObjectKinds lists the allowed kinds of objects.
+	ObjectKinds []ObjectKind
+	// ObjectTypes lists the allowed object types when the object is a node.
+	// Node objects must be covariant with at least one of them.
+	ObjectTypes []*node.Type
+	// Cardinality of the predicate.
+	Cardinality Cardinality
+}
+
+// Type describes the constraints for all nodes covariant with the provided
+// type.
+type Type struct {
+	// Type of the nodes the constraint applies to.
+	Type *node.Type
+	// Required lists the predicate IDs every node of the type needs to have at
+	// least one triple for.
+	Required []predicate.ID
+}
+
+// Schema describes the constraints that the triples in a graph need to
+// satisfy.
+type Schema struct {
+	Predicates []*Predicate
+	Types      []*Type
+}
+
+// check makes sure the schema is well formed.
+func (s *Schema) check() error {
+	ids := make(map[predicate.ID]bool)
+	for _, p := range s.Predicates {
+		if p == nil || p.ID == "" {
+			return fmt.Errorf("schema: predicate constraints require a non empty predicate ID")
+		}
+		if ids[p.ID] {
+			return fmt.Errorf("schema: duplicated constraints for predicate ID %q", p.ID)
+		}
+		ids[p.ID] = true
+	}
+	types := make(map[string]bool)
+	for _, t := range s.Types {
+		if t == nil || t.Type == nil {
+			return fmt.Errorf("schema: type constraints require a non empty type")
+		}
+		if types[t.Type.String()] {
+			return fmt.Errorf("schema: duplicated constraints for type %q", t.Type)
+		}
+		types[t.Type.String()] = true
+	}
+	return nil
+}
+
+// Violation describes why a triple does not satisfy a schema.
+type Violation struct {
+	Triple *triple.Triple
+	Reason string
+}
+
+// String returns a readable version of the violation.
+func (v *Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Triple, v.Reason)
+}
+
+// ValidationError is returned when a batch of triples violates the schema of
+// a graph. It lists all the offending triples.
+type ValidationError struct {
+	Graph      string
+	Violations []*Violation
+}
+
+// Error returns the list of violations found.
+func (e *ValidationError) Error() string {
+	b := bytes.NewBufferString(fmt.Sprintf("schema validation for graph %q rejected the batch with %d violation(s):", e.Graph, len(e.Violations)))
+	for _, v := range e.Violations {
+		b.WriteString("\n\t")
+		b.WriteString(v.String())
+	}
+	return b.String()
+}
+
+// Validate checks that the provided batch of triples can be added to the
+// graph without violating the schema. Cardinality and required predicate
+// constraints are checked against the union of the batch and the triples
+// already stored in the graph. If the batch violates the schema a
+// *ValidationError is returned.
+func (s *Schema) Validate(ctx context.Context, g storage.Graph, ts []*triple.Triple) error {
+	v := &validator{
+		ctx:      ctx,
+		g:        g,
+		prds:     make(map[predicate.ID]*Predicate),
+		existing: make(map[string][]*triple.Triple),
+	}
+	for _, p := range s.Predicates {
+		v.prds[p.ID] = p
+	}
+
+	// Dedup the batch to avoid reporting the same triple twice.
+	seen, batch := make(map[string]bool), []*triple.Triple{}
+	for _, t := range ts {
+		k := t.UUID().String()
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		batch = append(batch, t)
+	}
+
+	for _, t := range batch {
+		v.checkTriple(t)
+	}
+	if err := v.checkCardinality(batch); err != nil {
+		return err
+	}
+	if err := v.checkRequired(batch, s.Types); err != nil {
+		return err
+	}
+	if len(v.violations) > 0 {
+		return &ValidationError{
+			Graph:      g.ID(ctx),
+			Violations: v.violations,
+		}
+	}
+	return nil
+}
+
+// validator keeps the state of a single schema validation.
+type validator struct {
+	ctx        context.Context
+	g          storage.Graph
+	prds       map[predicate.ID]*Predicate
+	existing   map[string][]*triple.Triple
+	violations []*Violation
+}
+
+// add records a new violation.
+func (v *validator) add(t *triple.Triple, format string, args ...interface{}) {
+	v.violations = append(v.violations, &Violation{
+		Triple: t,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkTriple checks the type and kind constraints of a single triple.
+func (v *validator) checkTriple(t *triple.Triple) {
+	p, ok := v.prds[t.Predicate().ID()]
+	if !ok {
+		return
+	}
+	if len(p.SubjectTypes) > 0 && !covariantWithAny(t.Subject().Type(), p.SubjectTypes) {
+		v.add(t, "subject type %s is not allowed for predicate %q", t.Subject().Type(), p.ID)
+	}
+	k, err := KindOf(t.Object())
+	if err != nil {
+		v.add(t, "%v", err)
+		return
+	}
+	if len(p.ObjectKinds) > 0 {
+		allowed := false
+		for _, ak := range p.ObjectKinds {
+			if ak == k {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			v.add(t, "object kind %s is not allowed for predicate %q", k, p.ID)
+		}
+	}
+	if n, err := t.Object().Node(); err == nil && len(p.ObjectTypes) > 0 && !covariantWithAny(n.Type(), p.ObjectTypes) {
+		v.add(t, "object type %s is not allowed for predicate %q", n.Type(), p.ID)
+	}
+}
+
+// checkCardinality checks that single valued predicates do not end up with
+// more than one value per subject.
+func (v *validator) checkCardinality(batch []*triple.Triple) error {
+	groups, order := make(map[string][]*triple.Triple), []string{}
+	for _, t := range batch {
+		p, ok := v.prds[t.Predicate().ID()]
+		if !ok || p.Cardinality == MultiValued {
+			continue
+		}
+		k, err := cardinalityKey(p, t)
+		if err != nil {
+			return err
+		}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], t)
+	}
+	for _, k := range order {
+		ts := groups[k]
+		p := v.prds[ts[0].Predicate().ID()]
+		values := make(map[string]bool)
+		for _, t := range ts {
+			values[t.Object().UUID().String()] = true
+		}
+		ets, err := v.subjectTriples(ts[0].Subject())
+		if err != nil {
+			return err
+		}
+		for _, et := range ets {
+			if et.Predicate().ID() != p.ID {
+				continue
+			}
+			ek, err := cardinalityKey(p, et)
+			if err != nil {
+				return err
+			}
+			if ek == k {
+				values[et.Object().UUID().String()] = true
+			}
+		}
+		if len(values) > 1 {
+			for _, t := range ts {
+				v.add(t, "predicate %q is %s but subject %s would have %d values", p.ID, p.Cardinality, t.Subject(), len(values))
+			}
+		}
+	}
+	return nil
+}
+
+// checkRequired checks that all the subjects in the batch that are covariant
+// with a type constraint have all the required predicates.
+func (v *validator) checkRequired(batch []*triple.Triple, types []*Type) error {
+	if len(types) == 0 {
+		return nil
+	}
+	bySubject, order := make(map[string][]*triple.Triple), []string{}
+	for _, t := range batch {
+		k := t.Subject().UUID().String()
+		if _, ok := bySubject[k]; !ok {
+			order = append(order, k)
+		}
+		bySubject[k] = append(bySubject[k], t)
+	}
+	for _, k := range order {
+		ts := bySubject[k]
+		s := ts[0].Subject()
+		var required []predicate.ID
+		for _, tc := range types {
+			if s.Type().Covariant(tc.Type) {
+				required = append(required, tc.Required...)
+			}
+		}
+		if len(required) == 0 {
+			continue
+		}
+		present := make(map[predicate.ID]bool)
+		for _, t := range ts {
+			present[t.Predicate().ID()] = true
+		}
+		ets, err := v.subjectTriples(s)
+		if err != nil {
+			return err
+		}
+		for _, et := range ets {
+			present[et.Predicate().ID()] = true
+		}
+		for _, id := range required {
+			if present[id] {
+				continue
+			}
+			for _, t := range ts {
+				v.add(t, "subject %s of type %s is missing required predicate %q", s, s.Type(), id)
+			}
+		}
+	}
+	return nil
+}
+
+// subjectTriples returns the triples already stored in the graph for the
+// provided subject.
+func (v *validator) subjectTriples(s *node.Node) ([]*triple.Triple, error) {
+	k := s.UUID().String()
+	if ts, ok := v.existing[k]; ok {
+		return ts, nil
+	}
+	var (
+		err error
+		wg  sync.WaitGroup
+		res []*triple.Triple
+	)
+	ts := make(chan *triple.Triple)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err = v.g.TriplesForSubject(v.ctx, s, storage.DefaultLookup, ts)
+	}()
+	for t := range ts {
+		res = append(res, t)
+	}
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	v.existing[k] = res
+	return res, nil
+}
+
+// cardinalityKey returns the key used to group values for the cardinality
+// checks.
+func cardinalityKey(p *Predicate, t *triple.Triple) (string, error) {
+	k := t.Subject().UUID().String() + "\t" + string(p.ID)
+	if p.Cardinality != SingleValuedPerAnchor || t.Predicate().Type() != predicate.Temporal {
+		return k, nil
+	}
+	ta, err := t.Predicate().TimeAnchor()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\t%d", k, ta.UnixNano()), nil
+}
+
+// covariantWithAny returns true if the type is covariant with any of the
+// provided types.
+func covariantWithAny(t *node.Type, ts []*node.Type) bool {
+	for _, ot := range ts {
+		if t.Covariant(ot) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry keeps track of the schemas attached to each graph.
+type Registry struct {
+	rwmu    sync.RWMutex
+	schemas map[string]*Schema
+}
+
+// NewRegistry returns a new empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		schemas: make(map[string]*Schema),
+	}
+}
+
+// Register attaches the schema to the graph with the provided ID, replacing
+// any previously registered schema.
+func (r *Registry) Register(id string, s *Schema) error {
+	if s == nil {
+		return fmt.Errorf("schema.Register(%q): cannot register a nil schema", id)
+	}
+	if err := s.check(); err != nil {
+		return fmt.Errorf("schema.Register(%q): %v", id, err)
+	}
+	r.rwmu.Lock()
+	defer r.rwmu.Unlock()
+	r.schemas[id] = s
+	return nil
+}
+
+// Unregister removes the schema attached to the graph with the provided ID if
+// any.
+func (r *Registry) Unregister(id string) {
+	r.rwmu.Lock()
+	defer r.rwmu.Unlock()
+	delete(r.schemas, id)
+}
+
+// Schema returns the schema attached to the graph with the provided ID.
+func (r *Registry) Schema(id string) (*Schema, bool) {
+	r.rwmu.RLock()
+	defer r.rwmu.RUnlock()
+	s, ok := r.schemas[id]
+	return s, ok
+}
+
+// Validate validates the batch of triples against the schema of the provided
+// graph. Graphs without a registered schema accept any triple.
+func (r *Registry) Validate(ctx context.Context, g storage.Graph, ts []*triple.Triple) error {
+	s, ok := r.Schema(g.ID(ctx))
+	if !ok {
+		return nil
+	}
+	return s.Validate(ctx, g, ts)
+}
+
+// Store wraps a storage.Store and keeps the schemas attached to its graphs.
+// The graphs it returns carry their schema, which Of and Validate retrieve.
+// Deleting a graph removes its schema.
+type Store struct {
+	storage.Store
+	*Registry
+}
+
+// NewStore returns a store without schemas wrapping the provided store.
+func NewStore(s storage.Store) *Store {
+	return &Store{Store: s, Registry: NewRegistry()}
+}
+
+// wrap returns the version of the graph carrying its schema.
+func (s *Store) wrap(id string, g storage.Graph) storage.Graph {
+	sg := &graph{Graph: g, id: id, r: s.Registry}
+	if tg, ok := g.(storage.TypeGraph); ok {
+		return &typeGraph{graph: sg, tg: tg}
+	}
+	return sg
+}
+
+// NewGraph creates a new graph.
+func (s *Store) NewGraph(ctx context.Context, id string) (storage.Graph, error) {
+	g, err := s.Store.NewGraph(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(id, g), nil
+}
+
+// Graph returns an existing graph if available.
+func (s *Store) Graph(ctx context.Context, id string) (storage.Graph, error) {
+	g, err := s.Store.Graph(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(id, g), nil
+}
+
+// DeleteGraph deletes an existing graph along with its schema.
+func (s *Store) DeleteGraph(ctx context.Context, id string) error {
+	if err := s.Store.DeleteGraph(ctx, id); err != nil {
+		return err
+	}
+	s.Unregister(id)
+	return nil
+}
+
+// graph wraps a storage.Graph returned by a Store.
+type graph struct {
+	storage.Graph
+	id string
+	r  *Registry
+}
+
+// Unwrap returns the wrapped graph.
+func (g *graph) Unwrap() storage.Graph {
+	return g.Graph
+}
+
+// schema returns the schema currently attached to the graph.
+func (g *graph) schema() (*Schema, bool) {
+	return g.r.Schema(g.id)
+}
+
+// typeGraph wraps graphs that also provide the type index lookups.
+type typeGraph struct {
+	*graph
+	tg storage.TypeGraph
+}
+
+// TriplesForSubjectType pushes to the provided channel all triples whose
+// subject type is covariant with the provided type.
+func (g *typeGraph) TriplesForSubjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	return g.tg.TriplesForSubjectType(ctx, t, lo, trpls)
+}
+
+// TriplesForObjectType pushes to the provided channel all triples whose
+// object is a node with a type covariant with the provided type.
+func (g *typeGraph) TriplesForObjectType(ctx context.Context, t *node.Type, lo *storage.LookupOptions, trpls chan<- *triple.Triple) error {
+	return g.tg.TriplesForObjectType(ctx, t, lo, trpls)
+}
+
+// Of returns the schema of a graph returned by a Store. Graphs wrapping
+// another graph, as the ones returned by the feed, inference, and metrics
+// stores, are looked through via their Unwrap method.
+func Of(g storage.Graph) (*Schema, bool) {
+	for {
+		switch sg := g.(type) {
+		case interface {
+			schema() (*Schema, bool)
+		}:
+			return sg.schema()
+		case interface {
+			Unwrap() storage.Graph
+		}:
+			g = sg.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// Validate validates the batch of triples against the schema of the provided
+// graph as returned by Of. Graphs without a schema accept any triple.
+func Validate(ctx context.Context, g storage.Graph, ts []*triple.Triple) error {
+	s, ok := Of(g)
+	if !ok {
+		return nil
+	}
+	return s.Validate(ctx, g, ts)
+}
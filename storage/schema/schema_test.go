@@ -0,0 +1,256 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+func mustType(t *testing.T, s string) *node.Type {
+	nt, err := node.NewType(s)
+	if err != nil {
+		t.Fatalf("node.NewType(%q) failed with error %v", s, err)
+	}
+	return nt
+}
+
+func createTriples(t *testing.T, ss []string) []*triple.Triple {
+	ts := []*triple.Triple{}
+	for _, s := range ss {
+		trpl, err := triple.Parse(s, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", s, err)
+		}
+		ts = append(ts, trpl)
+	}
+	return ts
+}
+
+func testSchema(t *testing.T) *Schema {
+	return &Schema{
+		Predicates: []*Predicate{
+			{
+				ID:           "employs",
+				SubjectTypes: []*node.Type{mustType(t, "/org")},
+				ObjectKinds:  []ObjectKind{NodeObject},
+				ObjectTypes:  []*node.Type{mustType(t, "/person")},
+			},
+			{
+				ID:          "name",
+				ObjectKinds: []ObjectKind{TextLiteral},
+				Cardinality: SingleValued,
+			},
+			{
+				ID:          "located_in",
+				ObjectKinds: []ObjectKind{NodeObject},
+				Cardinality: SingleValuedPerAnchor,
+			},
+		},
+		Types: []*Type{
+			{
+				Type:     mustType(t, "/org"),
+				Required: []predicate.ID{"name"},
+			},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	existing := []string{
+		"/org<acme>\t\"name\"@[]\t\"ACME\"^^type:text",
+		"/person<john>\t\"located_in\"@[2016-01-01T00:00:00-08:00]\t/city<paris>",
+	}
+	table := []struct {
+		id         string
+		ts         []string
+		violations int
+	}{
+		{
+			id: "valid batch",
+			ts: []string{
+				"/org<acme>\t\"employs\"@[]\t/person<john>",
+				"/org/company<initech>\t\"name\"@[]\t\"Initech\"^^type:text",
+				"/org/company<initech>\t\"employs\"@[]\t/person/engineer<peter>",
+				"/person<john>\t\"located_in\"@[2016-02-01T00:00:00-08:00]\t/city<rome>",
+			},
+		},
+		{
+			id: "already existing value does not break cardinality",
+			ts: []string{
+				"/org<acme>\t\"name\"@[]\t\"ACME\"^^type:text",
+			},
+		},
+		{
+			id: "wrong subject type",
+			ts: []string{
+				"/person<mary>\t\"employs\"@[]\t/person<john>",
+			},
+			violations: 1,
+		},
+		{
+			id: "wrong object type and kind",
+			ts: []string{
+				"/org<acme>\t\"employs\"@[]\t/city<paris>",
+				"/org<acme>\t\"employs\"@[]\t\"john\"^^type:text",
+			},
+			violations: 2,
+		},
+		{
+			id: "single valued conflicts with existing value",
+			ts: []string{
+				"/org<acme>\t\"name\"@[]\t\"ACME Corp\"^^type:text",
+			},
+			violations: 1,
+		},
+		{
+			id: "single valued per anchor conflicts",
+			ts: []string{
+				"/person<john>\t\"located_in\"@[2016-01-01T00:00:00-08:00]\t/city<rome>",
+			},
+			violations: 1,
+		},
+		{
+			id: "missing required predicate",
+			ts: []string{
+				"/org/company<initech>\t\"employs\"@[]\t/person<peter>",
+			},
+			violations: 1,
+		},
+	}
+	for _, entry := range table {
+		g, err := memory.NewStore().NewGraph(ctx, "test")
+		if err != nil {
+			t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+		}
+		if err := g.AddTriples(ctx, createTriples(t, existing)); err != nil {
+			t.Fatalf("g.AddTriples failed to add triples with error %v", err)
+		}
+		err = testSchema(t).Validate(ctx, g, createTriples(t, entry.ts))
+		if entry.violations == 0 {
+			if err != nil {
+				t.Errorf("schema.Validate(%q) should have accepted the batch; got %v", entry.id, err)
+			}
+			continue
+		}
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("schema.Validate(%q) should have returned a *ValidationError; got %v", entry.id, err)
+			continue
+		}
+		if got, want := len(verr.Violations), entry.violations; got != want {
+			t.Errorf("schema.Validate(%q) returned the wrong number of violations; got %d, want %d\n%v", entry.id, got, want, verr)
+		}
+		for _, v := range verr.Violations {
+			if !strings.Contains(verr.Error(), v.Triple.String()) {
+				t.Errorf("schema.Validate(%q) error should list offending triple %s; got %v", entry.id, v.Triple, verr)
+			}
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	r := NewRegistry()
+	if err := r.Register("?bad", &Schema{Predicates: []*Predicate{{ID: "foo"}, {ID: "foo"}}}); err == nil {
+		t.Errorf("registry.Register should have rejected a schema with duplicated predicate constraints")
+	}
+	if err := r.Register("?test", testSchema(t)); err != nil {
+		t.Fatalf("registry.Register failed to register a valid schema with error %v", err)
+	}
+	if _, ok := r.Schema("?test"); !ok {
+		t.Errorf("registry.Schema(%q) should have returned the registered schema", "?test")
+	}
+	g, err := memory.NewStore().NewGraph(ctx, "?test")
+	if err != nil {
+		t.Fatalf("memory.NewStore().NewGraph should have never failed to create a graph")
+	}
+	ts := createTriples(t, []string{"/person<mary>\t\"employs\"@[]\t/person<john>"})
+	if err := r.Validate(ctx, g, ts); err == nil {
+		t.Errorf("registry.Validate should have rejected %v", ts)
+	}
+	r.Unregister("?test")
+	if err := r.Validate(ctx, g, ts); err != nil {
+		t.Errorf("registry.Validate should accept any triple on graphs without schema; got %v", err)
+	}
+}
+
+// wrappingGraph wraps a graph as the feed, inference, and metrics graphs do.
+type wrappingGraph struct {
+	storage.Graph
+}
+
+func (g *wrappingGraph) Unwrap() storage.Graph {
+	return g.Graph
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s1, s2 := NewStore(memory.NewStore()), NewStore(memory.NewStore())
+	for _, s := range []*Store{s1, s2} {
+		if _, err := s.NewGraph(ctx, "?test"); err != nil {
+			t.Fatalf("Store.NewGraph failed with error %v", err)
+		}
+	}
+	if err := s1.Register("?test", testSchema(t)); err != nil {
+		t.Fatalf("Store.Register failed with error %v", err)
+	}
+	ts := createTriples(t, []string{"/person<mary>\t\"employs\"@[]\t/person<john>"})
+
+	g1, err := s1.Graph(ctx, "?test")
+	if err != nil {
+		t.Fatalf("Store.Graph failed with error %v", err)
+	}
+	if _, ok := g1.(storage.TypeGraph); !ok {
+		t.Errorf("graphs of a store wrapping a memory store should implement storage.TypeGraph")
+	}
+	if _, ok := Of(&wrappingGraph{g1}); !ok {
+		t.Errorf("schema.Of should have found the schema through the wrapping graph")
+	}
+	if err := Validate(ctx, &wrappingGraph{g1}, ts); err == nil {
+		t.Errorf("schema.Validate should have rejected %v", ts)
+	}
+	g2, err := s2.Graph(ctx, "?test")
+	if err != nil {
+		t.Fatalf("Store.Graph failed with error %v", err)
+	}
+	if _, ok := Of(g2); ok {
+		t.Errorf("schema.Of should not return the schema of a graph with the same ID in another store")
+	}
+	if err := Validate(ctx, g2, ts); err != nil {
+		t.Errorf("schema.Validate should accept any triple on graphs without schema; got %v", err)
+	}
+
+	if err := s1.DeleteGraph(ctx, "?test"); err != nil {
+		t.Fatalf("Store.DeleteGraph failed with error %v", err)
+	}
+	g1, err = s1.NewGraph(ctx, "?test")
+	if err != nil {
+		t.Fatalf("Store.NewGraph failed with error %v", err)
+	}
+	if _, ok := Of(g1); ok {
+		t.Errorf("deleting a graph should have removed its schema")
+	}
+}
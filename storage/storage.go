@@ -242,3 +242,26 @@ type Graph interface {
 	// elements in the channel.
 	Triples(ctx context.Context, lo *LookupOptions, trpls chan<- *triple.Triple) error
 }
+
+// TypeGraph interface describes the optional type index lookups a driver may
+// provide. Drivers that do not implement it are still queried correctly, but
+// covariant type patterns will fall back to full scans.
+type TypeGraph interface {
+	Graph
+
+	// TriplesForSubjectType pushes to the provided channel all triples whose
+	// subject type is covariant with the provided type. The function does not
+	// return immediately. The caller is expected to detach them into a go
+	// routine.
+	//
+	// Lookup options are honored the same way they are for TriplesForSubject.
+	TriplesForSubjectType(ctx context.Context, t *node.Type, lo *LookupOptions, trpls chan<- *triple.Triple) error
+
+	// TriplesForObjectType pushes to the provided channel all triples whose
+	// object is a node with a type covariant with the provided type. The
+	// function does not return immediately. The caller is expected to detach
+	// them into a go routine.
+	//
+	// Lookup options are honored the same way they are for TriplesForObject.
+	TriplesForObjectType(ctx context.Context, t *node.Type, lo *LookupOptions, trpls chan<- *triple.Triple) error
+}
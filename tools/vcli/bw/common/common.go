@@ -29,8 +29,11 @@ import (
 	"github.com/google/badwolf/tools/vcli/bw/assert"
 	"github.com/google/badwolf/tools/vcli/bw/benchmark"
 	"github.com/google/badwolf/tools/vcli/bw/command"
+	"github.com/google/badwolf/tools/vcli/bw/csv"
+	"github.com/google/badwolf/tools/vcli/bw/diff"
 	"github.com/google/badwolf/tools/vcli/bw/export"
 	"github.com/google/badwolf/tools/vcli/bw/load"
+	"github.com/google/badwolf/tools/vcli/bw/patch"
 	"github.com/google/badwolf/tools/vcli/bw/repl"
 	"github.com/google/badwolf/tools/vcli/bw/run"
 	"github.com/google/badwolf/tools/vcli/bw/server"
@@ -107,8 +110,11 @@ func InitializeCommands(driver storage.Store, chanSize, bulkTripleOpSize, builde
 	return []*command.Command{
 		assert.New(driver, literal.DefaultBuilder(), chanSize, bulkTripleOpSize),
 		benchmark.New(driver, chanSize, bulkTripleOpSize),
+		csv.New(driver, bulkTripleOpSize, builderSize),
+		diff.New(driver),
 		export.New(driver, bulkTripleOpSize),
 		load.New(driver, bulkTripleOpSize, builderSize),
+		patch.New(driver, builderSize),
 		run.New(driver, chanSize, bulkTripleOpSize),
 		repl.New(driver, chanSize, bulkTripleOpSize, builderSize, rl, done),
 		server.New(driver, chanSize, bulkTripleOpSize),
@@ -0,0 +1,122 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package csv contains the command allowing to import CSV files into graphs
+// using a declarative mapping.
+package csv
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	bwcsv "github.com/google/badwolf/io/csv"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/tools/vcli/bw/command"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+// New creates the csv command.
+func New(store storage.Store, bulkSize, builderSize int) *command.Command {
+	cmd := &command.Command{
+		UsageLine: "csv <mapping_file> <csv_file_path> <graph_names_separated_by_commas>",
+		Short:     "imports the rows of a CSV file as triples.",
+		Long: `Imports all the rows of a CSV file into the provided graphs. Graph names
+need to be separated by commas with no whitespaces. The JSON mapping file
+describes how each row is converted into triples: which columns form the
+subject node, which columns become which predicates and literal types, and
+which column, if any, contains the time anchor of the predicates. The file
+is streamed and triples are added in batches of bulk_triple_op_size. If the
+import fails you may end up with partially loaded data.
+`,
+	}
+	cmd.Run = func(ctx context.Context, args []string) int {
+		return Eval(ctx, cmd.UsageLine+"\n\n"+cmd.Long, args, store, bulkSize, builderSize)
+	}
+	return cmd
+}
+
+// Eval imports the CSV file as indicated by the command.
+func Eval(ctx context.Context, usage string, args []string, store storage.Store, bulkSize, builderSize int) int {
+	if len(args) < 4 {
+		log.Printf("[ERROR] Missing required mapping file, CSV file, and/or graph names.\n\n%s", usage)
+		return 2
+	}
+	mPath, path, graphs := args[len(args)-3], args[len(args)-2], strings.Split(args[len(args)-1], ",")
+	mf, err := os.Open(mPath)
+	if err != nil {
+		log.Printf("[ERROR] Failed to open mapping file %q. %v\n", mPath, err)
+		return 2
+	}
+	m, err := bwcsv.ParseMapping(mf)
+	mf.Close()
+	if err != nil {
+		log.Printf("[ERROR] Failed to read mapping file %q. %v\n", mPath, err)
+		return 2
+	}
+	var sgs []storage.Graph
+	for _, gr := range graphs {
+		g, err := store.Graph(ctx, gr)
+		if err != nil {
+			log.Printf("[ERROR] Failed to retrieve graph %q. %v\n", gr, err)
+			return 2
+		}
+		sgs = append(sgs, g)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("[ERROR] Failed to open file %q. %v\n", path, err)
+		return 2
+	}
+	defer f.Close()
+
+	if bulkSize < 1 {
+		bulkSize = 1
+	}
+	var buf []*triple.Triple
+	flush := func(n int) error {
+		for len(buf) >= n && len(buf) > 0 {
+			b := buf
+			if len(b) > bulkSize {
+				b = b[:bulkSize]
+			}
+			for _, g := range sgs {
+				if err := g.AddTriples(ctx, b); err != nil {
+					return err
+				}
+			}
+			buf = buf[len(b):]
+		}
+		return nil
+	}
+	tcnt := 0
+	cnt, err := bwcsv.Read(ctx, f, m, literal.NewBoundedBuilder(builderSize), func(ts []*triple.Triple) error {
+		buf = append(buf, ts...)
+		tcnt += len(ts)
+		return flush(bulkSize)
+	})
+	if err == nil {
+		err = flush(1)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to import file %q after %d rows. %v\n", path, cnt, err)
+		return 2
+	}
+	fmt.Printf("Successfully processed %d rows from file %q.\n%d triples loaded into graphs:\n\t- %s\n", cnt, path, tcnt, strings.Join(graphs, "\n\t- "))
+	return 0
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diff contains the command allowing to compute the differences
+// between two graphs as a patch.
+package diff
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	bwdiff "github.com/google/badwolf/storage/diff"
+	"github.com/google/badwolf/tools/vcli/bw/command"
+)
+
+// New creates the diff command.
+func New(store storage.Store) *command.Command {
+	cmd := &command.Command{
+		UsageLine: "diff <from_graph> <to_graph> <patch_file_path>",
+		Short:     "writes the patch that turns a graph into another.",
+		Long: `Compares the triples of two graphs, matching them by their UUIDs, and
+writes into the provided file the patch that turns the first graph into the
+second one. Removed triples are listed first prefixed by "- ", followed by
+the added triples prefixed by "+ ". Use - as the file path to write the
+patch to the standard output. The patch can be applied using the patch
+command.
+`,
+	}
+	cmd.Run = func(ctx context.Context, args []string) int {
+		return Eval(ctx, cmd.UsageLine+"\n\n"+cmd.Long, args, store)
+	}
+	return cmd
+}
+
+// Eval computes the diff between the graphs as indicated by the command.
+func Eval(ctx context.Context, usage string, args []string, store storage.Store) int {
+	if len(args) < 4 {
+		log.Printf("[ERROR] Missing required graph names and/or patch file path.\n\n%s", usage)
+		return 2
+	}
+	fgn, tgn, path := args[len(args)-3], args[len(args)-2], args[len(args)-1]
+	from, err := store.Graph(ctx, fgn)
+	if err != nil {
+		log.Printf("[ERROR] Failed to retrieve graph %q. %v\n", fgn, err)
+		return 2
+	}
+	to, err := store.Graph(ctx, tgn)
+	if err != nil {
+		log.Printf("[ERROR] Failed to retrieve graph %q. %v\n", tgn, err)
+		return 2
+	}
+	d, err := bwdiff.Compute(ctx, from, to)
+	if err != nil {
+		log.Printf("[ERROR] Failed to compare graph %q with graph %q. %v\n", fgn, tgn, err)
+		return 2
+	}
+	if path == "-" {
+		if err := d.Write(os.Stdout); err != nil {
+			log.Printf("[ERROR] Failed to write the patch. %v\n", err)
+			return 2
+		}
+		return 0
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("[ERROR] Failed to create file %q. %v\n", path, err)
+		return 2
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "# Patch from %s to %s.\n", fgn, tgn); err != nil {
+		log.Printf("[ERROR] Failed to write file %q. %v\n", path, err)
+		return 2
+	}
+	if err := d.Write(f); err != nil {
+		log.Printf("[ERROR] Failed to write file %q. %v\n", path, err)
+		return 2
+	}
+	fmt.Printf("Successfully written the patch from graph %q to graph %q into file %q.\n%d triples added, %d triples removed.\n", fgn, tgn, path, len(d.Added), len(d.Removed))
+	return 0
+}
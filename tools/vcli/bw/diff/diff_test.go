@@ -0,0 +1,71 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func newGraph(t *testing.T, s storage.Store, id string, ts ...string) {
+	ctx := context.Background()
+	g, err := s.NewGraph(ctx, id)
+	if err != nil {
+		t.Fatalf("s.NewGraph(%q) failed with error %v", id, err)
+	}
+	for _, ln := range ts {
+		trpl, err := triple.Parse(ln, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", ln, err)
+		}
+		if err := g.AddTriples(ctx, []*triple.Triple{trpl}); err != nil {
+			t.Fatalf("g.AddTriples failed with error %v", err)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	ctx, s := context.Background(), memory.NewStore()
+	newGraph(t, s, "?from", `/u<a> "knows"@[] /u<b>`)
+	newGraph(t, s, "?to", `/u<a> "knows"@[] /u<c>`)
+	path := filepath.Join(t.TempDir(), "p.patch")
+
+	// The arguments are passed as provided by common.Eval.
+	if got := New(s).Run(ctx, []string{"diff", "?from", "?to", path}); got != 0 {
+		t.Fatalf("diff command returned %d; want 0", got)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) failed with error %v", path, err)
+	}
+	for _, want := range []string{"- /u<a>\t\"knows\"@[]\t/u<b>", "+ /u<a>\t\"knows\"@[]\t/u<c>"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("diff command wrote\n%s\nwant it to contain %q", b, want)
+		}
+	}
+
+	if got := New(s).Run(ctx, []string{"diff", "?from", "?to"}); got != 2 {
+		t.Errorf("diff command with missing arguments returned %d; want 2", got)
+	}
+}
@@ -21,22 +21,40 @@ import (
 	"log"
 	"os"
 	"strings"
-	"sync"
 
 	"golang.org/x/net/context"
 
+	bwio "github.com/google/badwolf/io"
+	"github.com/google/badwolf/io/rdf"
+	"github.com/google/badwolf/io/viz"
 	"github.com/google/badwolf/storage"
 	"github.com/google/badwolf/tools/vcli/bw/command"
+	"github.com/google/badwolf/tools/vcli/bw/io"
 	"github.com/google/badwolf/triple"
 )
 
 // New creates the help command.
 func New(store storage.Store, bulkSize int) *command.Command {
 	cmd := &command.Command{
-		UsageLine: "export <graph_names_separated_by_commas> <file_path>",
+		UsageLine: "export [--format=bw|jsonl|binary|quads|ntriples|turtle|jsonld|dot|graphml] [--mapping=<mapping_file>] [--fold_literals] [--clusters] <graph_names_separated_by_commas> <file_path>",
 		Short:     "export triples in bulk from graphs into a file.",
 		Long: `Export all the triples in the provided graphs into the provided
-text file.`,
+text file.
+
+The --format flag allows exporting the triples as JSON Lines (jsonl), the
+compact binary triple encoding (binary), BadWolf quads (quads), N-Triples
+(ntriples), Turtle (turtle), or JSON-LD (jsonld) instead of BadWolf triples
+(bw). Quads are triples followed by a tab and the name of their graph, so a
+single file can carry several named graphs. JSON-LD documents contain one
+node object per subject. BadWolf terms are mapped to RDF terms using the JSON
+mapping file provided via the --mapping flag, if any.
+
+The dot and graphml formats render the graphs for visualization using
+Graphviz DOT or GraphML. Nodes become vertices with a type attribute,
+predicates become edges labeled with the predicate ID, and time anchors
+become edge attributes. The --fold_literals flag adds literal objects as
+properties of their subject instead of separate vertices, and the
+--clusters flag groups DOT vertices by node type.`,
 	}
 	cmd.Run = func(ctx context.Context, args []string) int {
 		return Eval(ctx, cmd.UsageLine+"\n\n"+cmd.Long, args, store, bulkSize)
@@ -46,17 +64,18 @@ text file.`,
 
 // Eval loads the triples in the file against as indicated by the command.
 func Eval(ctx context.Context, usage string, args []string, store storage.Store, bulkSize int) int {
+	flags, args := io.ExtractFlags(args)
 	if len(args) < 3 {
 		log.Printf("[ERROR] Missing required file path and/or graph names.\n\n%s", usage)
 		return 2
 	}
 	graphs, path := strings.Split(args[len(args)-2], ","), args[len(args)-1]
-	f, err := os.Create(path)
+	format := flags["format"]
+	newWriter, err := writerFor(format, flags)
 	if err != nil {
-		log.Printf("[ERROR] Failed to open target file %q with error %v.\n\n", path, err)
+		log.Printf("[ERROR] %v\n\n", err)
 		return 2
 	}
-	defer f.Close()
 	var sgs []storage.Graph
 	for _, gr := range graphs {
 		g, err := store.Graph(ctx, gr)
@@ -67,33 +86,107 @@ func Eval(ctx context.Context, usage string, args []string, store storage.Store,
 		sgs = append(sgs, g)
 	}
 
-	cnt := 0
-	var errs []error
-	var mu sync.Mutex
-	chn := make(chan *triple.Triple, bulkSize)
-	for _, vg := range sgs {
-		go func(g storage.Graph) {
-			err := g.Triples(ctx, storage.DefaultLookup, chn)
-			mu.Lock()
-			errs = append(errs, err)
-			mu.Unlock()
-		}(vg)
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("[ERROR] Failed to open target file %q with error %v.\n\n", path, err)
+		return 2
 	}
+	defer f.Close()
+	write, closeWriter := newWriter(f)
 
-	for t := range chn {
-		if _, err := f.WriteString(t.String() + "\n"); err != nil {
-			log.Printf("[ERROR] Failed to write triple %s to file %q, %v.\n\n", t.String(), path, err)
+	cnt := 0
+	if format == "quads" {
+		// Quads carry the name of their graph, so all the graphs are written
+		// together.
+		if cnt, err = bwio.WriteQuads(ctx, f, sgs); err != nil {
+			log.Printf("[ERROR] Failed to write quads to file %q, %v.\n\n", path, err)
 			return 2
 		}
-		cnt++
-	}
-	for _, err := range errs {
-		if err != nil {
-			log.Printf("[ERROR] Failed to retrieve triples with error %v.\n\n", err)
-			return 2
+	} else {
+		for _, g := range sgs {
+			chn, errc := make(chan *triple.Triple, bulkSize), make(chan error, 1)
+			go func(g storage.Graph) {
+				errc <- g.Triples(ctx, storage.DefaultLookup, chn)
+			}(g)
+			for t := range chn {
+				if err := write(t); err != nil {
+					log.Printf("[ERROR] Failed to write triple %s to file %q, %v.\n\n", t.String(), path, err)
+					return 2
+				}
+				cnt++
+			}
+			if err := <-errc; err != nil {
+				log.Printf("[ERROR] Failed to retrieve triples with error %v.\n\n", err)
+				return 2
+			}
 		}
 	}
+	if err := closeWriter(); err != nil {
+		log.Printf("[ERROR] Failed to write to file %q, %v.\n\n", path, err)
+		return 2
+	}
 
 	fmt.Printf("Successfully written %d triples to file %q.\nTriples exported from graphs:\n\t- %s\n", cnt, path, strings.Join(graphs, "\n\t- "))
 	return 0
 }
+
+// newWriterFunc returns the function writing a triple into the file and the
+// function to call once all triples are written.
+type newWriterFunc func(f *os.File) (func(*triple.Triple) error, func() error)
+
+// writerFor returns the writer constructor for the provided format. Formats
+// and mappings are checked before the target file gets created.
+func writerFor(format string, flags map[string]string) (newWriterFunc, error) {
+	switch format {
+	case "", "bw", "quads":
+		// Quads are written by bwio.WriteQuads.
+		return func(f *os.File) (func(*triple.Triple) error, func() error) {
+			return func(t *triple.Triple) error {
+				_, err := f.WriteString(t.String() + "\n")
+				return err
+			}, func() error { return nil }
+		}, nil
+	case "jsonl":
+		return func(f *os.File) (func(*triple.Triple) error, func() error) {
+			return func(t *triple.Triple) error {
+				bs, err := bwio.TripleToJSON(t)
+				if err != nil {
+					return err
+				}
+				_, err = f.Write(append(bs, '\n'))
+				return err
+			}, func() error { return nil }
+		}, nil
+	case "binary":
+		return func(f *os.File) (func(*triple.Triple) error, func() error) {
+			e := triple.NewEncoder(f)
+			return e.Encode, e.Flush
+		}, nil
+	case "dot", "graphml":
+		vf, err := viz.ParseFormat(format)
+		if err != nil {
+			return nil, err
+		}
+		o := &viz.Options{
+			FoldLiterals: flags["fold_literals"] == "true",
+			Clusters:     flags["clusters"] == "true",
+		}
+		return func(f *os.File) (func(*triple.Triple) error, func() error) {
+			w := viz.NewWriter(f, vf, o)
+			return w.Write, w.Close
+		}, nil
+	default:
+		rf, err := rdf.ParseFormat(format)
+		if err != nil {
+			return nil, err
+		}
+		m, err := io.ReadMapping(flags["mapping"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mapping file %q with error %v", flags["mapping"], err)
+		}
+		return func(f *os.File) (func(*triple.Triple) error, func() error) {
+			w := rdf.NewWriter(f, rf, m)
+			return w.Write, w.Close
+		}, nil
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func TestCommand(t *testing.T) {
+	ctx, s := context.Background(), memory.NewStore()
+	g, err := s.NewGraph(ctx, "?g")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	trpl, err := triple.Parse(`/u<a> "knows"@[] /u<b>`, literal.DefaultBuilder())
+	if err != nil {
+		t.Fatalf("triple.Parse failed with error %v", err)
+	}
+	if err := g.AddTriples(ctx, []*triple.Triple{trpl}); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+
+	dir := t.TempDir()
+	table := []struct {
+		args []string
+		want int
+		out  string
+	}{
+		{[]string{"export", "?g", "bw.txt"}, 0, trpl.String() + "\n"},
+		{[]string{"export", "--format=quads", "?g", "quads.txt"}, 0, trpl.String() + "\t?g\n"},
+		{[]string{"export", "--format=xml", "?g", "xml.txt"}, 2, ""},
+		{[]string{"export", "--format=turtle", "--mapping=" + filepath.Join(dir, "missing.json"), "?g", "ttl.txt"}, 2, ""},
+		{[]string{"export", "?missing", "missing.txt"}, 2, ""},
+	}
+	for _, entry := range table {
+		path := filepath.Join(dir, entry.args[len(entry.args)-1])
+		args := append(append([]string{}, entry.args[:len(entry.args)-1]...), path)
+		if got := New(s, 10).Run(ctx, args); got != entry.want {
+			t.Errorf("export command %v returned %d; want %d", entry.args, got, entry.want)
+		}
+		b, err := os.ReadFile(path)
+		if entry.want != 0 {
+			// Failed exports should not create the target file.
+			if !os.IsNotExist(err) {
+				t.Errorf("export command %v should not have created %q; got error %v", entry.args, path, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("os.ReadFile(%q) failed with error %v", path, err)
+		}
+		if got := string(b); got != entry.out {
+			t.Errorf("export command %v wrote %q; want %q", entry.args, got, entry.out)
+		}
+	}
+}
@@ -18,8 +18,13 @@ package io
 
 import (
 	"bufio"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+
+	"github.com/google/badwolf/bql/table"
+	"github.com/google/badwolf/io/rdf"
 )
 
 // GetStatementsFromFile returns the statements found in the provided file.
@@ -84,3 +89,91 @@ func ProcessLines(path string, fp func(line string) error) (int, error) {
 	}
 	return cnt, scanner.Err()
 }
+
+// ExtractFlags splits the provided arguments into --name=value flags and the
+// remaining arguments. Flags without a value are set to "true".
+func ExtractFlags(args []string) (map[string]string, []string) {
+	flags, rest := make(map[string]string), []string{}
+	for _, a := range args {
+		if !strings.HasPrefix(a, "--") || len(a) == 2 {
+			rest = append(rest, a)
+			continue
+		}
+		kv := strings.SplitN(a[2:], "=", 2)
+		if len(kv) == 1 {
+			flags[kv[0]] = "true"
+			continue
+		}
+		flags[kv[0]] = kv[1]
+	}
+	return flags, rest
+}
+
+// ReadMapping reads the RDF mapping stored in the provided file. It returns
+// nil if no path is provided.
+func ReadMapping(path string) (*rdf.Mapping, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return rdf.ParseMapping(f)
+}
+
+// TableOutput describes how the results of BQL queries are printed.
+type TableOutput struct {
+	// Format is one of table.Formats.
+	Format string
+	// MaxWidth is the maximum width of the columns of ASCII tables. Zero
+	// disables truncation.
+	MaxWidth int
+}
+
+// NewTableOutput returns the output configured via the --format and
+// --max_width flags. Results are printed as ASCII tables by default.
+func NewTableOutput(flags map[string]string) (*TableOutput, error) {
+	o := &TableOutput{Format: table.ASCII, MaxWidth: table.DefaultMaxWidth}
+	for _, n := range []string{"format", "max_width"} {
+		if v, ok := flags[n]; ok {
+			if err := o.Set(n, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return o, nil
+}
+
+// Set changes the provided setting, either "format" or "max_width".
+func (o *TableOutput) Set(name, value string) error {
+	switch name {
+	case "format":
+		for _, f := range table.Formats {
+			if f == value {
+				o.Format = value
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown format %q; valid formats are %s", value, strings.Join(table.Formats, ", "))
+	case "max_width":
+		w, err := strconv.Atoi(value)
+		if err != nil || w < 0 {
+			return fmt.Errorf("max_width requires a non-negative integer; got %q", value)
+		}
+		o.MaxWidth = w
+		return nil
+	default:
+		return fmt.Errorf("unknown setting %q; valid settings are format and max_width", name)
+	}
+}
+
+// Print writes the table to the standard output.
+func (o *TableOutput) Print(t *table.Table) error {
+	w, err := table.NewWriter(os.Stdout, o.Format, o.MaxWidth)
+	if err != nil {
+		return err
+	}
+	return t.Write(w)
+}
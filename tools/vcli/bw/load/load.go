@@ -19,10 +19,16 @@ package load
 import (
 	"fmt"
 	"log"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 
+	bwio "github.com/google/badwolf/io"
+	"github.com/google/badwolf/io/rdf"
 	"github.com/google/badwolf/storage"
 	"github.com/google/badwolf/tools/vcli/bw/command"
 	"github.com/google/badwolf/tools/vcli/bw/io"
@@ -33,7 +39,7 @@ import (
 // New creates the help command.
 func New(store storage.Store, bulkSize, builderSize int) *command.Command {
 	cmd := &command.Command{
-		UsageLine: "load <file_path> <graph_names_separated_by_commas>",
+		UsageLine: "load [--format=bw|jsonl|binary|quads|ntriples|turtle|jsonld] [--mapping=<mapping_file>] [--batch_size=<n>] [--workers=<n>] [--on_error=abort|skip] [--progress] <file_path> <graph_names_separated_by_commas>",
 		Short:     "load triples in bulk stored in a file.",
 		Long: `Loads all the triples stored in a file into the provided graphs.
 Graph names need to be separated by commands with no whitespaces. Each triple
@@ -41,7 +47,28 @@ needs to placed in a single line. Each triple needs to be formated so it can be
 parsed as indicated in the documetation (see https://github.com/google/badwolf).
 All data in the file will be treated as triples. A line starting with # will
 be treated as a commented line. If the load fails you may end up with partially
-loaded data.
+loaded data. Gzip compressed files are transparently decompressed.
+
+Lines are streamed in batches of --batch_size lines, bulk_triple_op_size by
+default, and parsed concurrently by --workers parsers, the number of CPUs by
+default. Lines that cannot be parsed are reported with their line number and
+either abort the load (--on_error=abort, the default) or are skipped
+(--on_error=skip). The --progress flag periodically prints the number of lines
+processed.
+
+The --format flag allows loading JSON Lines (jsonl), N-Triples (ntriples),
+Turtle (turtle), or JSON-LD (jsonld) files instead of BadWolf triples (bw).
+JSON Lines files contain one JSON encoded triple per line. Binary triple
+files (binary), as written by the export command, are also detected
+automatically. RDF terms are mapped to BadWolf terms using the JSON mapping
+file provided via the --mapping flag, if any. RDF triples are added in
+batches of --batch_size triples, validated as the batches of the other
+formats.
+
+BadWolf quads files (quads) contain triples followed by a tab and the name of
+the graph they belong to. Graph names are taken from the file instead of the
+command line, and missing graphs are created. Quads files are read as a
+single batch; no triple is added if any line cannot be parsed.
 `,
 	}
 	cmd.Run = func(ctx context.Context, args []string) int {
@@ -52,71 +79,161 @@ loaded data.
 
 // Eval loads the triples in the file against as indicated by the command.
 func Eval(ctx context.Context, usage string, args []string, store storage.Store, bulkSize, builderSize int) int {
+	flags, args := io.ExtractFlags(args)
+	if flags["format"] == "quads" {
+		return evalQuads(ctx, usage, args, store, literal.NewBoundedBuilder(builderSize))
+	}
 	if len(args) < 3 {
 		log.Printf("[ERROR] Missing required file path and/or graph names.\n\n%s", usage)
 		return 2
 	}
 	graphs, lb := strings.Split(args[len(args)-1], ","), literal.NewBoundedBuilder(builderSize)
-	trplsChan, errChan, doneChan := make(chan *triple.Triple), make(chan error), make(chan bool)
 	path := args[len(args)-2]
-	go storeTriple(ctx, store, graphs, bulkSize, trplsChan, errChan, doneChan)
-	cnt, err := io.ProcessLines(path, func(line string) error {
-		t, err := triple.Parse(line, lb)
+	parse := triple.Parse
+	if flags["format"] == "jsonl" {
+		parse = func(line string, b literal.Builder) (*triple.Triple, error) {
+			return bwio.TripleFromJSON([]byte(line), b)
+		}
+	}
+	opts, err := loaderOptions(flags, bulkSize)
+	if err != nil {
+		log.Printf("[ERROR] %v\n\n%s", err, usage)
+		return 2
+	}
+	opts.Parse = parse
+	var sgs []storage.Graph
+	for _, graph := range graphs {
+		g, err := store.Graph(ctx, graph)
 		if err != nil {
-			return err
+			log.Printf("[ERROR] Failed to retrieve graph %q. %v\n", graph, err)
+			return 2
 		}
-		trplsChan <- t
-		return <-errChan
-	})
+		sgs = append(sgs, g)
+	}
+	switch format := flags["format"]; format {
+	case "", "bw", "binary", "jsonl":
+	default:
+		return evalRDF(ctx, path, sgs, graphs, format, flags["mapping"], opts.BatchSize, lb)
+	}
+	f, err := os.Open(path)
 	if err != nil {
-		log.Printf("[ERROR] Failed to process file %q. Ivalid triple on line %d. %v\n", path, cnt, err)
+		log.Printf("[ERROR] Failed to open file %q. %v\n", path, err)
 		return 2
 	}
-	doneChan <- true
-	if err := <-errChan; err != nil {
-		log.Printf("[ERROR] Failed to process file %q. Ivalid triple on line %d. %v\n", path, cnt, err)
+	defer f.Close()
+	p, err := bwio.Load(ctx, f, sgs, lb, opts)
+	if err != nil {
+		log.Printf("[ERROR] Failed to process file %q. %v\n", path, err)
 		return 2
 	}
-	fmt.Printf("Successfully processed %d lines from file %q.\nTriples loaded into graphs:\n\t- %s\n", cnt, path, strings.Join(graphs, "\n\t- "))
+	if p.Skipped > 0 {
+		fmt.Printf("Skipped %d invalid lines.\n", p.Skipped)
+	}
+	fmt.Printf("Successfully processed %d lines from file %q.\nTriples loaded into graphs:\n\t- %s\n", p.Lines, path, strings.Join(graphs, "\n\t- "))
 	return 0
 }
 
-var workingTrpls []*triple.Triple
-
-func flush(ctx context.Context, graphs []string, store storage.Store) error {
-	defer func() {
-		workingTrpls = nil
-	}()
-	if len(workingTrpls) > 0 {
-		for _, graph := range graphs {
-			g, err := store.Graph(ctx, graph)
-			if err != nil {
-				return err
-			}
-			if err := g.AddTriples(ctx, workingTrpls); err != nil {
-				return err
+// loaderOptions returns the loader options for the provided flags.
+func loaderOptions(flags map[string]string, bulkSize int) (*bwio.LoaderOptions, error) {
+	opts := &bwio.LoaderOptions{BatchSize: bulkSize}
+	for _, f := range []struct {
+		name string
+		v    *int
+	}{{"batch_size", &opts.BatchSize}, {"workers", &opts.Workers}} {
+		s, ok := flags[f.name]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("--%s requires a positive integer; got %q", f.name, s)
+		}
+		*f.v = n
+	}
+	if s, ok := flags["on_error"]; ok {
+		m, err := bwio.ParseErrorMode(s)
+		if err != nil {
+			return nil, err
+		}
+		opts.Mode = m
+	}
+	opts.OnError = func(e *bwio.LineError) {
+		log.Printf("[WARNING] Skipped %v\n", e)
+	}
+	if flags["progress"] == "true" {
+		last := time.Now()
+		opts.OnProgress = func(p bwio.Progress) {
+			if time.Since(last) < time.Second {
+				return
 			}
+			last = time.Now()
+			log.Printf("Processed %d lines; %d triples loaded, %d lines skipped.\n", p.Lines, p.Triples, p.Skipped)
 		}
 	}
-	return nil
+	return opts, nil
 }
 
-func storeTriple(ctx context.Context, store storage.Store, graphs []string, bulkSize int, trplChan <-chan *triple.Triple, errChan chan<- error, doneChan <-chan bool) {
-	for {
-		select {
-		case trpl := <-trplChan:
-			if len(workingTrpls) < bulkSize {
-				workingTrpls = append(workingTrpls, trpl)
-				errChan <- nil
-			} else {
-				err := flush(ctx, graphs, store)
-				workingTrpls = append(workingTrpls, trpl)
-				errChan <- err
-			}
-		case <-doneChan:
-			err := flush(ctx, graphs, store)
-			errChan <- err
-			return
-		}
+// evalQuads loads the quads stored in a file into the graphs they name.
+func evalQuads(ctx context.Context, usage string, args []string, store storage.Store, lb literal.Builder) int {
+	if len(args) < 2 || strings.HasPrefix(args[len(args)-1], "?") {
+		log.Printf("[ERROR] Quads files require the file path and no graph names.\n\n%s", usage)
+		return 2
+	}
+	path := args[len(args)-1]
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("[ERROR] Failed to open file %q. %v\n", path, err)
+		return 2
+	}
+	defer f.Close()
+	r, err := bwio.Decompress(f)
+	if err != nil {
+		log.Printf("[ERROR] Failed to read file %q. %v\n", path, err)
+		return 2
+	}
+	cnts, err := bwio.ReadQuadsIntoStore(ctx, store, r, lb)
+	if err != nil {
+		log.Printf("[ERROR] Failed to process file %q. %v\n", path, err)
+		return 2
+	}
+	var gs []string
+	for g, cnt := range cnts {
+		gs = append(gs, fmt.Sprintf("%s (%d triples)", g, cnt))
+	}
+	sort.Strings(gs)
+	fmt.Printf("Successfully processed file %q.\nTriples loaded into graphs:\n\t- %s\n", path, strings.Join(gs, "\n\t- "))
+	return 0
+}
+
+// evalRDF loads the triples stored in an RDF file into the provided graphs
+// in batches of batchSize triples.
+func evalRDF(ctx context.Context, path string, sgs []storage.Graph, graphs []string, format, mapping string, batchSize int, lb literal.Builder) int {
+	f, err := rdf.ParseFormat(format)
+	if err != nil {
+		log.Printf("[ERROR] %v\n", err)
+		return 2
 	}
+	m, err := io.ReadMapping(mapping)
+	if err != nil {
+		log.Printf("[ERROR] Failed to read mapping file %q. %v\n", mapping, err)
+		return 2
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		log.Printf("[ERROR] Failed to open file %q. %v\n", path, err)
+		return 2
+	}
+	defer r.Close()
+	dr, err := bwio.Decompress(r)
+	if err != nil {
+		log.Printf("[ERROR] Failed to read file %q. %v\n", path, err)
+		return 2
+	}
+	cnt, err := rdf.Load(ctx, dr, sgs, f, m, lb, batchSize)
+	if err != nil {
+		log.Printf("[ERROR] Failed to process %s file %q. %v\n", f, path, err)
+		return 2
+	}
+	fmt.Printf("Successfully processed %d triples from file %q.\nTriples loaded into graphs:\n\t- %s\n", cnt, path, strings.Join(graphs, "\n\t- "))
+	return 0
 }
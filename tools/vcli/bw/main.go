@@ -26,7 +26,6 @@ import (
 	"github.com/google/badwolf/storage"
 	"github.com/google/badwolf/storage/memory"
 	"github.com/google/badwolf/tools/vcli/bw/common"
-	"github.com/google/badwolf/tools/vcli/bw/repl"
 )
 
 var (
@@ -55,5 +54,5 @@ func registerDrivers() {
 func main() {
 	flag.Parse()
 	registerDrivers()
-	os.Exit(common.Run(*driver, flag.Args(), registeredDrivers, *bqlChannelSize, *bulkTripleOpSize, *bulkTripleBuilderSize, repl.SimpleReadLine))
+	os.Exit(common.Run(*driver, flag.Args(), registeredDrivers, *bqlChannelSize, *bulkTripleOpSize, *bulkTripleBuilderSize, nil))
 }
@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package patch contains the command allowing to apply patches created by
+// the diff command to graphs.
+package patch
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	bwdiff "github.com/google/badwolf/storage/diff"
+	"github.com/google/badwolf/tools/vcli/bw/command"
+	"github.com/google/badwolf/triple/literal"
+)
+
+// New creates the patch command.
+func New(store storage.Store, builderSize int) *command.Command {
+	cmd := &command.Command{
+		UsageLine: "patch <patch_file_path> <graph_names_separated_by_commas>",
+		Short:     "applies a patch to the provided graphs.",
+		Long: `Applies the patch stored in the provided file to the provided graphs.
+Graph names need to be separated by commas with no whitespaces. The whole
+patch is parsed before any change is made. Triples prefixed by "- " are
+removed first and then triples prefixed by "+ " are added. If applying the
+patch fails you may end up with partially patched graphs.
+`,
+	}
+	cmd.Run = func(ctx context.Context, args []string) int {
+		return Eval(ctx, cmd.UsageLine+"\n\n"+cmd.Long, args, store, builderSize)
+	}
+	return cmd
+}
+
+// Eval applies the patch as indicated by the command.
+func Eval(ctx context.Context, usage string, args []string, store storage.Store, builderSize int) int {
+	if len(args) < 3 {
+		log.Printf("[ERROR] Missing required patch file path and/or graph names.\n\n%s", usage)
+		return 2
+	}
+	path, graphs := args[len(args)-2], strings.Split(args[len(args)-1], ",")
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("[ERROR] Failed to open file %q. %v\n", path, err)
+		return 2
+	}
+	d, err := bwdiff.Parse(f, literal.NewBoundedBuilder(builderSize))
+	f.Close()
+	if err != nil {
+		log.Printf("[ERROR] Failed to read file %q. %v\n", path, err)
+		return 2
+	}
+	for _, gr := range graphs {
+		g, err := store.Graph(ctx, gr)
+		if err != nil {
+			log.Printf("[ERROR] Failed to retrieve graph %q. %v\n", gr, err)
+			return 2
+		}
+		if err := d.Apply(ctx, g); err != nil {
+			log.Printf("[ERROR] Failed to patch graph %q. %v\n", gr, err)
+			return 2
+		}
+	}
+	fmt.Printf("Successfully applied patch %q: %d triples added, %d triples removed in graphs:\n\t- %s\n", path, len(d.Added), len(d.Removed), strings.Join(graphs, "\n\t- "))
+	return 0
+}
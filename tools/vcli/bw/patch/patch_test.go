@@ -0,0 +1,66 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package patch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+)
+
+func TestCommand(t *testing.T) {
+	ctx, s := context.Background(), memory.NewStore()
+	g, err := s.NewGraph(ctx, "?g")
+	if err != nil {
+		t.Fatalf("s.NewGraph failed with error %v", err)
+	}
+	old, err := triple.Parse(`/u<a> "knows"@[] /u<b>`, literal.DefaultBuilder())
+	if err != nil {
+		t.Fatalf("triple.Parse failed with error %v", err)
+	}
+	if err := g.AddTriples(ctx, []*triple.Triple{old}); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "p.patch")
+	p := "- /u<a>\t\"knows\"@[]\t/u<b>\n+ /u<a>\t\"knows\"@[]\t/u<c>\n"
+	if err := os.WriteFile(path, []byte(p), 0600); err != nil {
+		t.Fatalf("os.WriteFile failed with error %v", err)
+	}
+
+	// The arguments are passed as provided by common.Eval.
+	if got := New(s, 1000).Run(ctx, []string{"patch", path, "?g"}); got != 0 {
+		t.Fatalf("patch command returned %d; want 0", got)
+	}
+	ts := make(chan *triple.Triple)
+	go g.Triples(ctx, storage.DefaultLookup, ts)
+	var got []string
+	for trpl := range ts {
+		got = append(got, trpl.String())
+	}
+	if want := "/u<a>\t\"knows\"@[]\t/u<c>"; len(got) != 1 || got[0] != want {
+		t.Errorf("patch command left triples %q; want [%q]", got, want)
+	}
+
+	if got := New(s, 1000).Run(ctx, []string{"patch", path}); got != 2 {
+		t.Errorf("patch command with missing arguments returned %d; want 2", got)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repl
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// maxPredicateScan bounds the number of triples read from each graph to
+// collect the predicate IDs used for completion.
+const maxPredicateScan = 10000
+
+// keywords contains the BQL keywords and console commands offered as
+// completions.
+var keywords = []string{
+	"after", "and", "as", "asc", "at", "before", "between", "by", "construct",
+	"count", "covariant", "create", "csv", "data", "deconstruct", "delete",
+	"desc", "diff", "distinct", "drop", "edit", "export", "from", "graph",
+	"graphs", "group", "having", "help", "id", "in", "insert", "into",
+	"limit", "load", "not", "or", "order", "patch", "quit", "reified", "run",
+	"select", "set", "show", "start", "stop", "sum", "tracing", "type", "where",
+}
+
+// Completer returns the words that complete the provided prefix.
+type Completer func(prefix string) []string
+
+// NewCompleter returns a completer for BQL keywords, the names of the graphs
+// in the store, and the IDs of the predicates used in them. Graph names
+// complete prefixes starting with "?" and predicates prefixes starting with
+// a double quote. The store is read every time a completion is requested so
+// the completions reflect the statements run so far.
+func NewCompleter(ctx context.Context, s storage.Store) Completer {
+	return func(prefix string) []string {
+		switch {
+		case strings.HasPrefix(prefix, "?"):
+			return matching(prefix, graphNames(ctx, s))
+		case strings.HasPrefix(prefix, `"`):
+			return matching(prefix, predicateIDs(ctx, s))
+		default:
+			ks := keywords
+			if prefix != "" && strings.ToUpper(prefix) == prefix {
+				ks = make([]string, 0, len(keywords))
+				for _, k := range keywords {
+					ks = append(ks, strings.ToUpper(k))
+				}
+			}
+			return matching(prefix, ks)
+		}
+	}
+}
+
+// matching returns the sorted unique candidates starting with the prefix.
+func matching(prefix string, cands []string) []string {
+	seen, res := make(map[string]bool), []string{}
+	for _, c := range cands {
+		if strings.HasPrefix(c, prefix) && !seen[c] {
+			seen[c] = true
+			res = append(res, c)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// graphNames returns the names of the graphs in the store. Errors are
+// ignored since they only reduce the completions offered.
+func graphNames(ctx context.Context, s storage.Store) []string {
+	names := make(chan string)
+	go s.GraphNames(ctx, names)
+	var res []string
+	for n := range names {
+		res = append(res, n)
+	}
+	return res
+}
+
+// predicateIDs returns the predicates used in the graphs of the store
+// formatted as they are written in BQL statements. Immutable predicates are
+// complete, while temporal ones leave the time anchor open.
+func predicateIDs(ctx context.Context, s storage.Store) []string {
+	var res []string
+	lo := &storage.LookupOptions{MaxElements: maxPredicateScan}
+	for _, gn := range graphNames(ctx, s) {
+		g, err := s.Graph(ctx, gn)
+		if err != nil {
+			continue
+		}
+		ts := make(chan *triple.Triple)
+		go g.Triples(ctx, lo, ts)
+		for t := range ts {
+			p := t.Predicate()
+			if p.Type() == predicate.Immutable {
+				res = append(res, `"`+string(p.ID())+`"@[]`)
+			} else {
+				res = append(res, `"`+string(p.ID())+`"@[`)
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repl
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+)
+
+func TestMatching(t *testing.T) {
+	table := []struct {
+		prefix string
+		cands  []string
+		want   []string
+	}{
+		{"", nil, []string{}},
+		{"s", []string{"show", "select", "drop", "set"}, []string{"select", "set", "show"}},
+		{"?g", []string{"?g2", "?a", "?g1", "?g2"}, []string{"?g1", "?g2"}},
+		{"x", []string{"show"}, []string{}},
+		{"show", []string{"show"}, []string{"show"}},
+	}
+	for _, entry := range table {
+		if got := matching(entry.prefix, entry.cands); !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("matching(%q, %q) returned %q; want %q", entry.prefix, entry.cands, got, entry.want)
+		}
+	}
+}
+
+func TestNewCompleter(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore()
+	for _, gn := range []string{"?family", "?friends"} {
+		if _, err := s.NewGraph(ctx, gn); err != nil {
+			t.Fatalf("s.NewGraph(_, %q) failed with error %v", gn, err)
+		}
+	}
+	g, err := s.Graph(ctx, "?family")
+	if err != nil {
+		t.Fatalf("s.Graph failed with error %v", err)
+	}
+	var ts []*triple.Triple
+	for _, l := range []string{
+		`/u<joe> "parent_of"@[] /u<mary>`,
+		`/u<joe> "parent_of"@[] /u<peter>`,
+		`/u<joe> "met"@[2016-04-10T04:21:00Z] /u<mary>`,
+	} {
+		trpl, err := triple.Parse(l, nil)
+		if err != nil {
+			t.Fatalf("triple.Parse(%q) failed with error %v", l, err)
+		}
+		ts = append(ts, trpl)
+	}
+	if err := g.AddTriples(ctx, ts); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+
+	complete := NewCompleter(ctx, s)
+	table := []struct {
+		prefix string
+		want   []string
+	}{
+		{"?f", []string{"?family", "?friends"}},
+		{"?fa", []string{"?family"}},
+		{`"p`, []string{`"parent_of"@[]`}},
+		{`"m`, []string{`"met"@[`}},
+		{"sel", []string{"select"}},
+		{"SEL", []string{"SELECT"}},
+		{"gr", []string{"graph", "graphs", "group"}},
+		{"?x", []string{}},
+	}
+	for _, entry := range table {
+		if got := complete(entry.prefix); !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("complete(%q) returned %q; want %q", entry.prefix, got, entry.want)
+		}
+	}
+}
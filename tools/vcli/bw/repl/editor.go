@@ -0,0 +1,486 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// continuationPrompt is shown while a statement spans several lines.
+	continuationPrompt = "  -> "
+	// maxHistory is the number of statements kept in the history file.
+	maxHistory = 1000
+	// newlineMark is displayed in place of the newlines of a line.
+	newlineMark = "\u21b5"
+)
+
+// errInterrupted is returned when the user discards the input with Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// HistoryFile returns the default path of the REPL history, ~/.bw_history.
+// It returns an empty path if the home directory is unknown.
+func HistoryFile() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".bw_history")
+}
+
+// lineEditor reads statements from a terminal providing line editing,
+// history, and completion. It expects the terminal to be in raw mode while
+// statements are read.
+type lineEditor struct {
+	r           *bufio.Reader
+	out         io.Writer
+	complete    Completer
+	edit        func(stm string) (string, error)
+	historyPath string
+	history     []string
+}
+
+// newLineEditor returns a line editor reading keys from r and echoing the
+// input to out. The previous statement is edited with editStatement.
+func newLineEditor(r io.Reader, out io.Writer, complete Completer, historyPath string) *lineEditor {
+	return &lineEditor{
+		r:           bufio.NewReader(r),
+		out:         out,
+		complete:    complete,
+		edit:        editStatement,
+		historyPath: historyPath,
+	}
+}
+
+// NewLineEditor returns a ReadLiner that reads statements from the terminal
+// with line editing. Statements can span several lines and are returned once
+// terminated by ";". Past statements are kept in the file at historyPath,
+// if provided, and can be recalled with the arrow keys. Pressing tab
+// completes the word under the cursor using the completer, if provided.
+// Entering "!!" runs the previous statement again, and "edit" opens it in
+// $EDITOR and loads the result for editing. If the standard input is not a
+// terminal it behaves like SimpleReadLine.
+func NewLineEditor(complete Completer, historyPath string) ReadLiner {
+	return func(done chan bool) <-chan string {
+		fd := os.Stdin.Fd()
+		restore, err := makeRaw(fd)
+		if err != nil {
+			return SimpleReadLine(done)
+		}
+		restore()
+		e := newLineEditor(os.Stdin, os.Stdout, complete, historyPath)
+		// The terminal is only in raw mode while a statement is typed, so
+		// the output of the statements and the editor run by "edit" see it
+		// in its usual mode.
+		raw := func() error {
+			restore, err = makeRaw(fd)
+			return err
+		}
+		e.edit = func(stm string) (string, error) {
+			restore()
+			defer raw()
+			return editStatement(stm)
+		}
+		e.loadHistory()
+		c := make(chan string)
+		go func() {
+			defer close(c)
+			for {
+				if err := raw(); err != nil {
+					return
+				}
+				stm, err := e.readStatement()
+				restore()
+				if err != nil {
+					return
+				}
+				e.addHistory(stm)
+				c <- stm
+				if <-done {
+					return
+				}
+			}
+		}()
+		return c
+	}
+}
+
+// readStatement reads lines until they form a statement terminated by ";".
+// Continuation lines are joined with newlines, so string literals spanning
+// several lines are preserved.
+func (e *lineEditor) readStatement() (string, error) {
+	stm, p, initial := "", prompt, ""
+	for {
+		l, err := e.readLine(p, initial)
+		initial = ""
+		if err == errInterrupted {
+			stm, p = "", prompt
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if stm == "" {
+			switch strings.TrimSuffix(strings.TrimSpace(l), ";") {
+			case "":
+				continue
+			case "!!":
+				if prev, ok := e.previous(); ok {
+					fmt.Fprint(e.out, strings.Replace(prev, "\n", "\r\n", -1)+"\r\n")
+					return prev, nil
+				}
+				fmt.Fprint(e.out, "[ERROR] No previous statement\r\n")
+				continue
+			case "edit":
+				if initial, err = e.editPrevious(); err != nil {
+					fmt.Fprintf(e.out, "[ERROR] %v\r\n", err)
+				}
+				continue
+			}
+			stm = strings.TrimLeftFunc(l, unicode.IsSpace)
+		} else {
+			stm += "\n" + l
+		}
+		if t := strings.TrimRightFunc(stm, unicode.IsSpace); strings.HasSuffix(t, ";") {
+			return t, nil
+		}
+		p = continuationPrompt
+	}
+}
+
+// readLine reads a line from the terminal, starting with the provided
+// initial content. Newlines in the line, as found in recalled or edited
+// multi-line statements, are displayed as newlineMark.
+func (e *lineEditor) readLine(p, initial string) (string, error) {
+	buf, pos := []rune(initial), len([]rune(initial))
+	hist, pending := len(e.history), ""
+	render := func() {
+		fmt.Fprintf(e.out, "\r\x1b[K%s%s", p, strings.Replace(string(buf), "\n", newlineMark, -1))
+		if n := len(buf) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+	recall := func(i int) {
+		if i < 0 || i > len(e.history) {
+			return
+		}
+		if hist == len(e.history) {
+			pending = string(buf)
+		}
+		hist = i
+		if hist == len(e.history) {
+			buf = []rune(pending)
+		} else {
+			buf = []rune(e.history[hist])
+		}
+		pos = len(buf)
+	}
+	render()
+	for {
+		r, _, err := e.r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C discards the input.
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D ends the session on an empty line.
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf, pos = buf[pos:], 0
+		case 23: // Ctrl-W
+			start := wordStart(buf, pos)
+			buf, pos = append(buf[:start], buf[pos:]...), start
+		case 16: // Ctrl-P
+			recall(hist - 1)
+		case 14: // Ctrl-N
+			recall(hist + 1)
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf, pos = append(buf[:pos-1], buf[pos:]...), pos-1
+			}
+		case '\t':
+			buf, pos = e.completeWord(buf, pos)
+		case 27:
+			switch e.escape() {
+			case "A":
+				recall(hist - 1)
+			case "B":
+				recall(hist + 1)
+			case "C":
+				if pos < len(buf) {
+					pos++
+				}
+			case "D":
+				if pos > 0 {
+					pos--
+				}
+			case "H", "1~", "7~":
+				pos = 0
+			case "F", "4~", "8~":
+				pos = len(buf)
+			case "3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		render()
+	}
+}
+
+// escape reads the rest of an escape sequence and returns its final part,
+// for instance "A" for the up arrow or "3~" for the delete key.
+func (e *lineEditor) escape() string {
+	r, _, err := e.r.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return ""
+	}
+	var seq []rune
+	for {
+		r, _, err := e.r.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, r)
+		if r < '0' || r > '9' {
+			return string(seq)
+		}
+	}
+}
+
+// isWordSeparator returns true for the runes that delimit the words to
+// complete.
+func isWordSeparator(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("{}(),;", r)
+}
+
+// wordStart returns the position where the space separated word before pos
+// starts, skipping the spaces that follow it.
+func wordStart(buf []rune, pos int) int {
+	start := pos
+	for start > 0 && unicode.IsSpace(buf[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(buf[start-1]) {
+		start--
+	}
+	return start
+}
+
+// completeWord completes the word before the cursor. A single candidate
+// replaces the word. Multiple candidates extend it to their longest common
+// prefix, or are listed if it cannot be extended.
+func (e *lineEditor) completeWord(buf []rune, pos int) ([]rune, int) {
+	if e.complete == nil {
+		return buf, pos
+	}
+	start := pos
+	for start > 0 && !isWordSeparator(buf[start-1]) {
+		start--
+	}
+	prefix := string(buf[start:pos])
+	cands := e.complete(prefix)
+	var word string
+	switch len(cands) {
+	case 0:
+		fmt.Fprint(e.out, "\a")
+		return buf, pos
+	case 1:
+		word = cands[0] + " "
+	default:
+		word = commonPrefix(cands)
+		if word == prefix {
+			fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(cands, "  "))
+			return buf, pos
+		}
+	}
+	rest := append([]rune(word), buf[pos:]...)
+	return append(buf[:start], rest...), start + len([]rune(word))
+}
+
+// commonPrefix returns the longest prefix shared by all the strings.
+func commonPrefix(ss []string) string {
+	p := ss[0]
+	for _, s := range ss[1:] {
+		for !strings.HasPrefix(s, p) {
+			p = p[:len(p)-1]
+		}
+	}
+	return p
+}
+
+// previous returns the last statement in the history.
+func (e *lineEditor) previous() (string, bool) {
+	if len(e.history) == 0 {
+		return "", false
+	}
+	return e.history[len(e.history)-1], true
+}
+
+// editPrevious returns the previous statement once edited.
+func (e *lineEditor) editPrevious() (string, error) {
+	prev, ok := e.previous()
+	if !ok {
+		return "", errors.New("no previous statement")
+	}
+	return e.edit(prev)
+}
+
+// editStatement opens the statement in the editor set by $VISUAL or
+// $EDITOR, vi by default, and returns the edited statement.
+func editStatement(stm string) (string, error) {
+	f, err := os.CreateTemp("", "bw-*.bql")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintln(f, stm)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return "", err
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := append(strings.Fields(editor), f.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run editor %q; %v", editor, err)
+	}
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// loadHistory reads the history file. Missing files are ignored.
+func (e *lineEditor) loadHistory() {
+	if e.historyPath == "" {
+		return
+	}
+	b, err := os.ReadFile(e.historyPath)
+	if err != nil {
+		return
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			e.history = append(e.history, decodeHistory(l))
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+		e.writeHistory()
+	}
+}
+
+// encodeHistory returns the line storing the statement in the history file.
+// Statements spanning several lines are stored quoted. BQL statements never
+// start with a double quote, so other statements are stored as they are.
+func encodeHistory(stm string) string {
+	if strings.Contains(stm, "\n") {
+		return strconv.Quote(stm)
+	}
+	return stm
+}
+
+// decodeHistory returns the statement stored in a line of the history file.
+func decodeHistory(l string) string {
+	if strings.HasPrefix(l, `"`) {
+		if stm, err := strconv.Unquote(l); err == nil {
+			return stm
+		}
+	}
+	return l
+}
+
+// writeHistory replaces the history file with the statements in memory.
+func (e *lineEditor) writeHistory() {
+	var b strings.Builder
+	for _, stm := range e.history {
+		b.WriteString(encodeHistory(stm))
+		b.WriteString("\n")
+	}
+	os.WriteFile(e.historyPath, []byte(b.String()), 0600)
+}
+
+// addHistory appends the statement to the history unless it repeats the
+// previous one. The history is truncated once it doubles the maximum size.
+func (e *lineEditor) addHistory(stm string) {
+	if prev, ok := e.previous(); ok && prev == stm {
+		return
+	}
+	e.history = append(e.history, stm)
+	if e.historyPath == "" {
+		return
+	}
+	if len(e.history) > 2*maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+		e.writeHistory()
+		return
+	}
+	f, err := os.OpenFile(e.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, encodeHistory(stm))
+	f.Close()
+}
@@ -0,0 +1,276 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadStatement(t *testing.T) {
+	edited := "SELECT ?a\nFROM ?g"
+	table := []struct {
+		name    string
+		history []string
+		input   string
+		want    []string
+	}{
+		{
+			name:  "single line",
+			input: "  show graphs ;  \r",
+			want:  []string{"show graphs ;"},
+		},
+		{
+			name:  "continuation lines keep their newlines",
+			input: "select ?o from ?g\r\r  where {/u<a> \"says\"@[] \"a\r  b\"^^type:text};\r",
+			want:  []string{"select ?o from ?g\n\n  where {/u<a> \"says\"@[] \"a\n  b\"^^type:text};"},
+		},
+		{
+			name:  "empty lines before a statement are skipped",
+			input: "\r  \rshow graphs;\r",
+			want:  []string{"show graphs;"},
+		},
+		{
+			name:  "several statements",
+			input: "create graph ?a;\rdrop graph ?a;\r",
+			want:  []string{"create graph ?a;", "drop graph ?a;"},
+		},
+		{
+			name:  "Ctrl-C discards the statement",
+			input: "select ?a\r from\x03show graphs;\r",
+			want:  []string{"show graphs;"},
+		},
+		{
+			name:  "line editing",
+			input: "shw\x02o\x05 graphs;\x01\x06\x06\x06\x06\x0b;\r",
+			want:  []string{"show;"},
+		},
+		{
+			name:  "arrows and delete",
+			input: "show grahps;\x1b[D\x1b[D\x1b[D\x1b[3~\x1b[Dp\x1b[H\x1b[F\r",
+			want:  []string{"show graphs;"},
+		},
+		{
+			name:  "backspace and Ctrl-W",
+			input: "show foo bar\x17\x17graphs;x\x7f\r",
+			want:  []string{"show graphs;"},
+		},
+		{
+			name:    "history recall",
+			history: []string{"create graph ?a;", "show graphs;"},
+			input:   "\x1b[A\x1b[A\x1b[B\r",
+			want:    []string{"show graphs;"},
+		},
+		{
+			name:    "history recall keeps the pending line",
+			history: []string{"show graphs;"},
+			input:   "drop\x10\x0e graph ?a;\r",
+			want:    []string{"drop graph ?a;"},
+		},
+		{
+			name:    "!! runs the previous statement",
+			history: []string{"create graph ?a;", "show graphs;"},
+			input:   " !! \r!!;\r",
+			want:    []string{"show graphs;", "show graphs;"},
+		},
+		{
+			name:  "!! without history",
+			input: "!!\rshow graphs;\r",
+			want:  []string{"show graphs;"},
+		},
+		{
+			name:    "edit loads the edited statement",
+			history: []string{"select ?a from ?g;"},
+			input:   "edit\r;\r",
+			want:    []string{edited + ";"},
+		},
+		{
+			name:  "edit without history",
+			input: "edit\rshow graphs;\r",
+			want:  []string{"show graphs;"},
+		},
+	}
+	for _, entry := range table {
+		var out bytes.Buffer
+		e := newLineEditor(strings.NewReader(entry.input), &out, nil, "")
+		e.history = entry.history
+		e.edit = func(stm string) (string, error) {
+			return edited, nil
+		}
+		var got []string
+		for {
+			stm, err := e.readStatement()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: readStatement failed with error %v", entry.name, err)
+			}
+			got = append(got, stm)
+		}
+		if !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("%s: readStatement returned %q; want %q", entry.name, got, entry.want)
+		}
+	}
+}
+
+func TestReadStatementEndsOnCtrlD(t *testing.T) {
+	e := newLineEditor(strings.NewReader("show\x04\x01\x04\x04\x04\x04\x04show graphs;\r"), &bytes.Buffer{}, nil, "")
+	if _, err := e.readStatement(); err != io.EOF {
+		t.Errorf("readStatement returned error %v; want %v", err, io.EOF)
+	}
+}
+
+func TestReadStatementEditError(t *testing.T) {
+	var out bytes.Buffer
+	e := newLineEditor(strings.NewReader("edit\rshow graphs;\r"), &out, nil, "")
+	e.history = []string{"show graphs;"}
+	e.edit = func(stm string) (string, error) {
+		return "", errors.New("no editor")
+	}
+	if stm, err := e.readStatement(); err != nil || stm != "show graphs;" {
+		t.Errorf("readStatement returned (%q, %v); want (%q, nil)", stm, err, "show graphs;")
+	}
+	if !strings.Contains(out.String(), "[ERROR] no editor") {
+		t.Errorf("readStatement should report the editor error; got %q", out.String())
+	}
+}
+
+func TestCompleteWord(t *testing.T) {
+	complete := func(prefix string) []string {
+		return matching(prefix, []string{"select", "set", "show", "?family", "?friends"})
+	}
+	table := []struct {
+		buf, want string
+		pos, wPos int
+		out       string
+	}{
+		{buf: "sel", pos: 3, want: "select ", wPos: 7},
+		{buf: "se", pos: 2, want: "se", wPos: 2, out: "\r\nselect  set\r\n"},
+		{buf: "from {?f", pos: 8, want: "from {?f", wPos: 8, out: "\r\n?family  ?friends\r\n"},
+		{buf: "from ?fa;", pos: 8, want: "from ?family ;", wPos: 13},
+		{buf: "sh graphs;", pos: 2, want: "show  graphs;", wPos: 5},
+		{buf: "xyz", pos: 3, want: "xyz", wPos: 3, out: "\a"},
+	}
+	for _, entry := range table {
+		var out bytes.Buffer
+		e := newLineEditor(strings.NewReader(""), &out, complete, "")
+		got, pos := e.completeWord([]rune(entry.buf), entry.pos)
+		if string(got) != entry.want || pos != entry.wPos {
+			t.Errorf("completeWord(%q, %d) returned (%q, %d); want (%q, %d)", entry.buf, entry.pos, string(got), pos, entry.want, entry.wPos)
+		}
+		if out.String() != entry.out {
+			t.Errorf("completeWord(%q, %d) wrote %q; want %q", entry.buf, entry.pos, out.String(), entry.out)
+		}
+	}
+}
+
+func TestCompleteWordWithoutCompleter(t *testing.T) {
+	e := newLineEditor(strings.NewReader(""), &bytes.Buffer{}, nil, "")
+	if got, pos := e.completeWord([]rune("sel"), 3); string(got) != "sel" || pos != 3 {
+		t.Errorf("completeWord without completer returned (%q, %d); want (%q, 3)", string(got), pos, "sel")
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	table := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"select"}, "select"},
+		{[]string{"select", "set"}, "se"},
+		{[]string{"?family", "?friends", "?foo"}, "?f"},
+		{[]string{"show", "drop"}, ""},
+		{[]string{"graph", "graphs"}, "graph"},
+	}
+	for _, entry := range table {
+		if got := commonPrefix(entry.in); got != entry.want {
+			t.Errorf("commonPrefix(%q) returned %q; want %q", entry.in, got, entry.want)
+		}
+	}
+}
+
+func TestWordStart(t *testing.T) {
+	table := []struct {
+		buf  string
+		pos  int
+		want int
+	}{
+		{"", 0, 0},
+		{"show", 4, 0},
+		{"show graphs", 11, 5},
+		{"show graphs  ", 13, 5},
+		{"show graphs", 7, 5},
+		{"show graphs", 5, 0},
+		{"from\n?g", 7, 5},
+	}
+	for _, entry := range table {
+		if got := wordStart([]rune(entry.buf), entry.pos); got != entry.want {
+			t.Errorf("wordStart(%q, %d) returned %d; want %d", entry.buf, entry.pos, got, entry.want)
+		}
+	}
+}
+
+func TestAddHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	e := newLineEditor(strings.NewReader(""), &bytes.Buffer{}, nil, path)
+	e.addHistory("show graphs;")
+	e.addHistory("show graphs;")
+	if want := []string{"show graphs;"}; !reflect.DeepEqual(e.history, want) {
+		t.Errorf("addHistory should skip repeated statements; got %q, want %q", e.history, want)
+	}
+	for i := 0; i < 2*maxHistory; i++ {
+		e.addHistory(fmt.Sprintf("create graph ?g%d;", i))
+	}
+	if got, want := len(e.history), maxHistory; got != want {
+		t.Fatalf("addHistory kept %d statements; want %d", got, want)
+	}
+	last := fmt.Sprintf("create graph ?g%d;", 2*maxHistory-1)
+	if got := e.history[len(e.history)-1]; got != last {
+		t.Errorf("addHistory kept %q as the last statement; want %q", got, last)
+	}
+
+	multi := "select ?a\nfrom ?g\nwhere {?a \"p\"@[] \"x\\ny\"^^type:text};"
+	e.addHistory(multi)
+	// Loading the history keeps the last maxHistory statements.
+	loaded := newLineEditor(strings.NewReader(""), &bytes.Buffer{}, nil, path)
+	loaded.loadHistory()
+	if want := e.history[1:]; !reflect.DeepEqual(loaded.history, want) {
+		t.Errorf("loadHistory returned %d statements ending in %q; want %d ending in %q", len(loaded.history), loaded.history[len(loaded.history)-1], len(want), multi)
+	}
+}
+
+func TestHistoryEncoding(t *testing.T) {
+	for _, stm := range []string{
+		"show graphs;",
+		"select ?a\nfrom ?g;",
+		`insert data into ?g {/u<a> "p"@[] "a\b"^^type:text};`,
+		"insert data into ?g {/u<a> \"p\"@[] \"a\n\\\"b\"^^type:text};",
+	} {
+		l := encodeHistory(stm)
+		if strings.Contains(l, "\n") {
+			t.Errorf("encodeHistory(%q) returned %q spanning several lines", stm, l)
+		}
+		if got := decodeHistory(l); got != stm {
+			t.Errorf("decodeHistory(encodeHistory(%q)) returned %q", stm, got)
+		}
+	}
+}
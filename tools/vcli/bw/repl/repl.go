@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"strings"
 	"time"
@@ -33,23 +34,50 @@ import (
 	"github.com/google/badwolf/bql/version"
 	"github.com/google/badwolf/storage"
 	"github.com/google/badwolf/tools/vcli/bw/command"
+	"github.com/google/badwolf/tools/vcli/bw/csv"
+	"github.com/google/badwolf/tools/vcli/bw/diff"
 	"github.com/google/badwolf/tools/vcli/bw/export"
 	bio "github.com/google/badwolf/tools/vcli/bw/io"
 	"github.com/google/badwolf/tools/vcli/bw/load"
+	"github.com/google/badwolf/tools/vcli/bw/patch"
 )
 
 const prompt = "bql> "
 
-// New create the version command.
+// New create the version command. If no ReadLiner is provided, the REPL uses
+// a line editor that completes BQL keywords, graph names, and predicates
+// from the driver and keeps its history in HistoryFile.
 func New(driver storage.Store, chanSize, bulkSize, builderSize int, rl ReadLiner, done chan bool) *command.Command {
 	return &command.Command{
 		Run: func(ctx context.Context, args []string) int {
-			REPL(driver, os.Stdin, rl, chanSize, bulkSize, builderSize, done)
+			flags, _ := bio.ExtractFlags(args)
+			out, err := bio.NewTableOutput(flags)
+			if err != nil {
+				log.Printf("[ERROR] %v\n\n", err)
+				return 2
+			}
+			if rl == nil {
+				rl = NewLineEditor(NewCompleter(ctx, driver), HistoryFile())
+			}
+			REPL(driver, os.Stdin, rl, chanSize, bulkSize, builderSize, out, done)
 			return 0
 		},
-		UsageLine: "bql",
+		UsageLine: "bql [--format=ascii|csv|tsv|json|ndjson|markdown] [--max_width=<n>]",
 		Short:     "starts a REPL to run BQL statements.",
-		Long:      "Starts a REPL from the command line to accept BQL statements. Type quit; to leave the REPL.",
+		Long: `Starts a REPL from the command line to accept BQL statements. Type quit; to
+leave the REPL.
+
+Statements can span several lines and run once terminated by ";". The
+arrow keys move the cursor and recall past statements, which are kept in
+~/.bw_history. Tab completes BQL keywords, graph names, and predicates.
+Entering !! runs the previous statement again, and edit opens it in
+$EDITOR to change it before running it.
+
+Results are printed as aligned ASCII tables whose cells are truncated to
+--max_width characters, 60 by default or unlimited if 0. The --format flag
+prints them as CSV, TSV, JSON, NDJSON, or Markdown instead. Both settings
+can be changed during the session with set format <format>; and
+set max_width <n>;.`,
 	}
 }
 
@@ -67,7 +95,7 @@ func SimpleReadLine(done chan bool) <-chan string {
 		defer close(c)
 		scanner := bufio.NewScanner(os.Stdin)
 		cmd := ""
-		fmt.Print("bql> ")
+		fmt.Print(prompt)
 		for {
 			if !scanner.Scan() {
 				break
@@ -79,15 +107,19 @@ func SimpleReadLine(done chan bool) <-chan string {
 					break
 				}
 				cmd = ""
-				fmt.Print("bql> ")
+				fmt.Print(prompt)
 			}
 		}
 	}()
 	return c
 }
 
-// REPL starts a read-evaluation-print-loop to run BQL commands.
-func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize, builderSize int, done chan bool) int {
+// REPL starts a read-evaluation-print-loop to run BQL commands. Results are
+// printed using the provided output, or as ASCII tables if nil.
+func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize, builderSize int, out *bio.TableOutput, done chan bool) int {
+	if out == nil {
+		out = &bio.TableOutput{Format: table.ASCII, MaxWidth: table.DefaultMaxWidth}
+	}
 	var tracer io.Writer
 	ctx, isTracingToFile, sessionStart := context.Background(), false, time.Now()
 
@@ -119,8 +151,25 @@ func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize
 			done <- false
 			continue
 		}
+		if l == "set;" || strings.HasPrefix(l, "set ") {
+			args := strings.Fields(strings.TrimSuffix(l, ";"))
+			switch len(args) {
+			case 1:
+				fmt.Printf("format %s\nmax_width %d\n", out.Format, out.MaxWidth)
+			case 3:
+				if err := out.Set(args[1], args[2]); err != nil {
+					fmt.Printf("[ERROR] %v\n", err)
+				} else {
+					fmt.Println("[OK]")
+				}
+			default:
+				fmt.Println("Invalid syntax\n\tset [format|max_width <value>]")
+			}
+			done <- false
+			continue
+		}
 		if strings.HasPrefix(l, "start tracing") {
-			args := strings.Split(strings.TrimSpace(l)[:len(l)-1], " ")
+			args := strings.Fields(l[:len(l)-1])
 			switch len(args) {
 			case 2:
 				// Start tracing to the console.
@@ -149,9 +198,27 @@ func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize
 			done <- false
 			continue
 		}
+		if strings.HasPrefix(l, "csv") {
+			now := time.Now()
+			args := strings.Fields("bw " + l[:len(l)-1])
+			usage := "Wrong syntax\n\n\tcsv <mapping_file> <csv_file_path> <graph_names_separated_by_commas>\n"
+			csv.Eval(ctx, usage, args, driver, bulkSize, builderSize)
+			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
+			done <- false
+			continue
+		}
+		if strings.HasPrefix(l, "diff") {
+			now := time.Now()
+			args := strings.Fields("bw " + l[:len(l)-1])
+			usage := "Wrong syntax\n\n\tdiff <from_graph> <to_graph> <patch_file_path>\n"
+			diff.Eval(ctx, usage, args, driver)
+			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
+			done <- false
+			continue
+		}
 		if strings.HasPrefix(l, "export") {
 			now := time.Now()
-			args := strings.Split("bw "+strings.TrimSpace(l)[:len(l)-1], " ")
+			args := strings.Fields("bw " + l[:len(l)-1])
 			usage := "Wrong syntax\n\n\tload <graph_names_separated_by_commas> <file_path>\n"
 			export.Eval(ctx, usage, args, driver, bulkSize)
 			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
@@ -160,13 +227,22 @@ func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize
 		}
 		if strings.HasPrefix(l, "load") {
 			now := time.Now()
-			args := strings.Split("bw "+strings.TrimSpace(l[:len(l)-1]), " ")
+			args := strings.Fields("bw " + l[:len(l)-1])
 			usage := "Wrong syntax\n\n\tload <file_path> <graph_names_separated_by_commas>\n"
 			load.Eval(ctx, usage, args, driver, bulkSize, builderSize)
 			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
 			done <- false
 			continue
 		}
+		if strings.HasPrefix(l, "patch") {
+			now := time.Now()
+			args := strings.Fields("bw " + l[:len(l)-1])
+			usage := "Wrong syntax\n\n\tpatch <patch_file_path> <graph_names_separated_by_commas>\n"
+			patch.Eval(ctx, usage, args, driver, builderSize)
+			fmt.Println("[OK] Time spent: ", time.Now().Sub(now))
+			done <- false
+			continue
+		}
 		if strings.HasPrefix(l, "desc") {
 			pln, err := planBQL(ctx, l[4:], driver, chanSize, bulkSize, nil)
 			if err != nil {
@@ -200,7 +276,9 @@ func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize
 			fmt.Println()
 		} else {
 			if len(table.Bindings()) > 0 {
-				fmt.Println(table.String())
+				if err := out.Print(table); err != nil {
+					fmt.Printf("[ERROR] %v\n", err)
+				}
 			}
 			fmt.Printf("[OK] %d rows retrieved. BQL time: %v. Display time: %v\n",
 				table.NumRows(), bqlDiff, time.Now().Sub(now)-bqlDiff)
@@ -213,10 +291,20 @@ func REPL(driver storage.Store, input *os.File, rl ReadLiner, chanSize, bulkSize
 // printHelp prints help for the console commands.
 func printHelp() {
 	fmt.Println("help                                                  - prints help for the bw console.")
+	fmt.Println("!!                                                    - runs the previous statement again.")
+	fmt.Println("csv <mapping_file> <csv_file_path> <graph_names>      - imports the rows of a CSV file into the specified graphs.")
+	fmt.Println("diff <from_graph> <to_graph> <patch_file_path>        - writes the patch that turns a graph into another.")
+	fmt.Println("edit                                                  - edits the previous statement in $EDITOR before running it.")
 	fmt.Println("export <graph_names_separated_by_commas> <file_path>  - dumps triples from graphs into a file path.")
+	fmt.Println("                                                        --format=dot|graphml renders graphs for visualization.")
 	fmt.Println("desc <BQL>                                            - prints the execution plan for a BQL statement.")
 	fmt.Println("load <file_path> <graph_names_separated_by_commas>    - load triples into the specified graphs.")
+	fmt.Println("                                                        --format=bw|jsonl|binary|quads|ntriples|turtle|jsonld and --mapping=<file>")
+	fmt.Println("                                                        are also accepted by load and export.")
+	fmt.Println("patch <patch_file_path> <graph_names>                 - applies a patch to the specified graphs.")
 	fmt.Println("run <file_with_bql_statements>                        - runs all the BQL statements in the file.")
+	fmt.Println("set [format|max_width <value>]                        - prints or changes how results are printed.")
+	fmt.Println("                                                        Formats are ascii, csv, tsv, json, ndjson, and markdown.")
 	fmt.Println("start tracing [trace_file]                            - starts tracing queries.")
 	fmt.Println("stop tracing                                          - stops tracing queries.")
 	fmt.Println("quit                                                  - quits the console.")
@@ -225,7 +313,7 @@ func printHelp() {
 
 // runBQLFromFile loads all the statements in the file and runs them.
 func runBQLFromFile(ctx context.Context, driver storage.Store, chanSize, bulkSize int, line string, w io.Writer) (string, int, error) {
-	ss := strings.Split(strings.TrimSpace(line), " ")
+	ss := strings.Fields(line)
 	if len(ss) != 2 {
 		return "", 0, fmt.Errorf("wrong syntax: run <file_with_bql_statements>")
 	}
@@ -35,11 +35,19 @@ import (
 // New creates the help command.
 func New(store storage.Store, chanSize, bulkSize int) *command.Command {
 	cmd := &command.Command{
-		UsageLine: "run file_path",
+		UsageLine: "run [--format=ascii|csv|tsv|json|ndjson|markdown] [--max_width=<n>] file_path",
 		Short:     "runs BQL statements.",
 		Long: `Runs all the commands listed in the provided file. Lines in the
 the file starting with # will be ignored. All statements will be run
 sequentially.
+
+Results are printed as aligned ASCII tables whose cells are truncated to
+--max_width characters, 60 by default or unlimited if 0. The --format flag
+prints them as CSV, TSV, JSON, NDJSON, or Markdown instead. With those
+formats only the results of statements with bindings are written to the
+standard output, and failures are reported on the standard error, so the
+output can be piped to other tools. The command then exits with status 1 if
+any statement fails.
 `,
 	}
 	cmd.Run = func(ctx context.Context, args []string) int {
@@ -50,18 +58,38 @@ sequentially.
 
 // runCommand runs all the BQL statements available in the file.
 func runCommand(ctx context.Context, cmd *command.Command, args []string, store storage.Store, chanSize, bulkSize int) int {
+	flags, args := io.ExtractFlags(args)
 	if len(args) < 2 {
 		log.Printf("[ERROR] Missing required file path. ")
 		cmd.Usage()
 		return 2
 	}
+	out, err := io.NewTableOutput(flags)
+	if err != nil {
+		log.Printf("[ERROR] %v\n\n", err)
+		return 2
+	}
 	file := strings.TrimSpace(args[len(args)-1])
 	lines, err := io.GetStatementsFromFile(file)
 	if err != nil {
 		log.Printf("[ERROR] Failed to read file %s\n\n\t%v\n\n", file, err)
 		return 2
 	}
-	fmt.Printf("Processing file %s\n\n", args[len(args)-1])
+	if out.Format != table.ASCII {
+		rc := 0
+		for _, stm := range lines {
+			tbl, err := BQL(ctx, stm, store, chanSize, bulkSize)
+			if err == nil && len(tbl.Bindings()) > 0 {
+				err = out.Print(tbl)
+			}
+			if err != nil {
+				log.Printf("[FAIL] %v on\n%s\n\n", err, stm)
+				rc = 1
+			}
+		}
+		return rc
+	}
+	fmt.Printf("Processing file %s\n\n", file)
 	for idx, stm := range lines {
 		fmt.Printf("Processing statement (%d/%d):\n%s\n\n", idx+1, len(lines), stm)
 		tbl, err := BQL(ctx, stm, store, chanSize, bulkSize)
@@ -71,7 +99,10 @@ func runCommand(ctx context.Context, cmd *command.Command, args []string, store
 		}
 		fmt.Println("Result:")
 		if tbl.NumRows() > 0 {
-			fmt.Println(tbl)
+			if err := out.Print(tbl); err != nil {
+				fmt.Printf("[FAIL] %v\n\n", err)
+				continue
+			}
 		}
 		fmt.Printf("OK\n\n")
 	}
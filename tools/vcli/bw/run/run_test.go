@@ -0,0 +1,49 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage/memory"
+)
+
+func TestCommandExitCode(t *testing.T) {
+	ctx := context.Background()
+	table := []struct {
+		format string
+		stms   string
+		want   int
+	}{
+		{"csv", "CREATE GRAPH ?a;\n", 0},
+		{"csv", "CREATE GRAPH ?a;\nDROP GRAPH ?missing;\n", 1},
+		{"ascii", "CREATE GRAPH ?a;\nDROP GRAPH ?missing;\n", 0},
+		{"xml", "CREATE GRAPH ?a;\n", 2},
+	}
+	for _, entry := range table {
+		path := filepath.Join(t.TempDir(), "stms.bql")
+		if err := os.WriteFile(path, []byte(entry.stms), 0600); err != nil {
+			t.Fatalf("os.WriteFile failed with error %v", err)
+		}
+		cmd := New(memory.NewStore(), 0, 1000)
+		if got := cmd.Run(ctx, []string{"run", "--format=" + entry.format, path}); got != entry.want {
+			t.Errorf("run --format=%s on %q returned %d; want %d", entry.format, entry.stms, got, entry.want)
+		}
+	}
+}
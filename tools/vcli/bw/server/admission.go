@@ -0,0 +1,391 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/acl"
+	"github.com/google/badwolf/bql/metrics"
+	"github.com/google/badwolf/bql/planner"
+	"github.com/google/badwolf/bql/table"
+)
+
+// queriesPath is the prefix of the query registry resources.
+const queriesPath = "/queries"
+
+// statusCanceled is the status reported for statements canceled while
+// running, as used by nginx and gRPC gateways.
+const statusCanceled = 499
+
+// admission bounds the number of statements running concurrently and the
+// resources each of them can use.
+type admission struct {
+	// slots contains a token per running statement. It is nil if the number
+	// of concurrent statements is not bounded.
+	slots chan bool
+	// timeout is the maximum time a statement waits for a slot. Zero waits
+	// until the statement context is done.
+	timeout time.Duration
+	// maxRows is the maximum number of rows of a result. Zero disables it.
+	maxRows int
+	// maxResultBytes is the maximum estimated size of the rows returned by a
+	// statement. Zero disables it.
+	maxResultBytes int64
+	// maxUpload is the maximum size of an uploaded body, both compressed and
+	// decompressed.
+	maxUpload int64
+	// standing contains a token per registered standing query.
+	standing chan bool
+}
+
+const (
+	// defaultMaxUpload is the default maximum size of uploaded triples.
+	defaultMaxUpload = 1 << 30
+	// defaultMaxStanding is the default maximum number of standing queries.
+	defaultMaxStanding = 64
+)
+
+// newAdmission returns the admission control configured via the
+// --max_queries, --queue_timeout, --max_rows, --max_result_bytes,
+// --max_upload, and --max_standing flags.
+func newAdmission(flags map[string]string) (*admission, error) {
+	a := &admission{
+		maxUpload: defaultMaxUpload,
+		standing:  make(chan bool, defaultMaxStanding),
+	}
+	for _, f := range []struct {
+		name string
+		set  func(n int64)
+	}{
+		{"max_queries", func(n int64) { a.slots = make(chan bool, n) }},
+		{"max_rows", func(n int64) { a.maxRows = int(n) }},
+		{"max_result_bytes", func(n int64) { a.maxResultBytes = n }},
+		{"max_upload", func(n int64) { a.maxUpload = n }},
+		{"max_standing", func(n int64) { a.standing = make(chan bool, n) }},
+	} {
+		s, ok := flags[f.name]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("--%s requires a positive integer; got %q", f.name, s)
+		}
+		f.set(n)
+	}
+	if s, ok := flags["queue_timeout"]; ok {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("--queue_timeout requires a valid duration; got %q", s)
+		}
+		a.timeout = d
+	}
+	return a, nil
+}
+
+// acquire waits for a free slot. It returns the function releasing the slot
+// or a *statementError if no slot was freed in time.
+func (a *admission) acquire(ctx context.Context) (func(), error) {
+	return a.wait(ctx, a.timeout)
+}
+
+// wait waits up to the provided timeout for a free slot. Zero waits until
+// the context is done.
+func (a *admission) wait(ctx context.Context, timeout time.Duration) (func(), error) {
+	if a.slots == nil {
+		return func() {}, nil
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case a.slots <- true:
+		return func() { <-a.slots }, nil
+	case <-expired:
+		return nil, &statementError{http.StatusServiceUnavailable, "overloaded", fmt.Errorf("[ERROR] Too many queries running; no slot freed after waiting %v", timeout)}
+	case <-ctx.Done():
+		return nil, executionError(ctx, ctx.Err())
+	}
+}
+
+// register reserves a slot for a standing query. It returns the function
+// releasing the slot or a *statementError if every slot is taken. Standing
+// queries do not wait for a slot since they hold it until they end.
+func (a *admission) register() (func(), error) {
+	if a.standing == nil {
+		return func() {}, nil
+	}
+	select {
+	case a.standing <- true:
+		return func() { <-a.standing }, nil
+	default:
+		return nil, &statementError{http.StatusServiceUnavailable, "overloaded", fmt.Errorf("[ERROR] Too many standing queries; the limit is %d", cap(a.standing))}
+	}
+}
+
+// rowSize returns the estimated size in bytes of the row.
+func rowSize(r table.Row) int64 {
+	n := int64(0)
+	for k, c := range r {
+		n += int64(len(k) + len(c.String()))
+	}
+	return n
+}
+
+// runningQuery describes a statement registered in the query registry.
+type runningQuery struct {
+	ID      string `json:"id"`
+	Query   string `json:"query"`
+	User    string `json:"user,omitempty"`
+	Started string `json:"started"`
+	Rows    int64  `json:"rows"`
+
+	rows   int64
+	bytes  int64
+	cancel context.CancelFunc
+}
+
+// queryRegistry keeps track of the running statements.
+type queryRegistry struct {
+	mu   sync.Mutex
+	next uint64
+	qs   map[string]*runningQuery
+}
+
+// newQueryRegistry returns an empty registry.
+func newQueryRegistry() *queryRegistry {
+	return &queryRegistry{qs: make(map[string]*runningQuery)}
+}
+
+// add registers the statement and returns it along with the context to run
+// it. Canceling the query cancels the returned context.
+func (qr *queryRegistry) add(ctx context.Context, q, user string) (*runningQuery, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	qr.mu.Lock()
+	defer qr.mu.Unlock()
+	qr.next++
+	rq := &runningQuery{
+		ID:      strconv.FormatUint(qr.next, 10),
+		Query:   q,
+		User:    user,
+		Started: time.Now().Format(time.RFC3339Nano),
+		cancel:  cancel,
+	}
+	qr.qs[rq.ID] = rq
+	return rq, ctx
+}
+
+// remove unregisters the query and releases its context.
+func (qr *queryRegistry) remove(rq *runningQuery) {
+	qr.mu.Lock()
+	delete(qr.qs, rq.ID)
+	qr.mu.Unlock()
+	rq.cancel()
+}
+
+// cancelAll cancels every running query.
+func (qr *queryRegistry) cancelAll() {
+	qr.mu.Lock()
+	defer qr.mu.Unlock()
+	for _, rq := range qr.qs {
+		rq.cancel()
+	}
+}
+
+// get returns the registered query with the provided ID.
+func (qr *queryRegistry) get(id string) (*runningQuery, bool) {
+	qr.mu.Lock()
+	defer qr.mu.Unlock()
+	rq, ok := qr.qs[id]
+	return rq, ok
+}
+
+// list returns a snapshot of the queries for which visible returns true,
+// sorted by ID.
+func (qr *queryRegistry) list(visible func(*runningQuery) bool) []*runningQuery {
+	qr.mu.Lock()
+	defer qr.mu.Unlock()
+	res := []*runningQuery{}
+	for _, rq := range qr.qs {
+		if !visible(rq) {
+			continue
+		}
+		// The counters are updated while the query runs, so the snapshot is
+		// built field by field instead of copying the query.
+		res = append(res, &runningQuery{
+			ID:      rq.ID,
+			Query:   rq.Query,
+			User:    rq.User,
+			Started: rq.Started,
+			Rows:    atomic.LoadInt64(&rq.rows),
+		})
+	}
+	sort.Sort(byQueryID(res))
+	return res
+}
+
+// byQueryID sorts queries by their numeric ID.
+type byQueryID []*runningQuery
+
+func (b byQueryID) Len() int      { return len(b) }
+func (b byQueryID) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byQueryID) Less(i, j int) bool {
+	if len(b[i].ID) != len(b[j].ID) {
+		return len(b[i].ID) < len(b[j].ID)
+	}
+	return b[i].ID < b[j].ID
+}
+
+// count accounts for the provided row and returns a *statementError if the
+// result exceeds the configured caps. The caps only apply to the rows
+// returned; they do not bound the intermediate tables built by the planner.
+func (rq *runningQuery) count(a *admission, r table.Row) error {
+	rows := atomic.AddInt64(&rq.rows, 1)
+	if a.maxRows > 0 && rows > int64(a.maxRows) {
+		return &statementError{http.StatusUnprocessableEntity, "row_limit_exceeded", fmt.Errorf("[ERROR] Query result exceeded the limit of %d rows", a.maxRows)}
+	}
+	if a.maxResultBytes > 0 {
+		if bytes := atomic.AddInt64(&rq.bytes, rowSize(r)); bytes > a.maxResultBytes {
+			return &statementError{http.StatusUnprocessableEntity, "result_size_exceeded", fmt.Errorf("[ERROR] Query result exceeded the limit of %d bytes", a.maxResultBytes)}
+		}
+	}
+	return nil
+}
+
+// execute waits for the statement to be admitted, registers it, and runs
+// the plan within the configured caps, writing its result to the table
+// writer. Rows are written as they are produced if the executor implements
+// planner.Streamer, and the writer is flushed once the statement succeeds.
+// Errors returned by the writer are returned unchanged; other errors are
+// returned as *statementError.
+func (s *serverConfig) execute(ctx context.Context, q, user string, pln planner.Executor, tw table.Writer) error {
+	release, err := s.admission.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	rq, ctx := s.queries.add(ctx, q, user)
+	defer s.queries.remove(rq)
+	pln = metrics.NewExecutor(pln, s.metrics)
+
+	st, ok := pln.(planner.Streamer)
+	if !ok {
+		t, err := pln.Execute(ctx)
+		if err != nil {
+			return executionError(ctx, err)
+		}
+		if err := tw.WriteHeader(t.Bindings()); err != nil {
+			return err
+		}
+		for _, r := range t.Rows() {
+			if err := rq.count(s.admission, r); err != nil {
+				return err
+			}
+			if err := tw.WriteRow(r); err != nil {
+				return err
+			}
+		}
+		return tw.Flush()
+	}
+
+	if err := tw.WriteHeader(st.OutputBindings()); err != nil {
+		return err
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rs, errc := make(chan table.Row, s.chanSize), make(chan error, 1)
+	go func() {
+		errc <- st.Stream(sctx, rs)
+	}()
+	var rErr error
+	for r := range rs {
+		if rErr != nil {
+			continue
+		}
+		if rErr = rq.count(s.admission, r); rErr == nil {
+			rErr = tw.WriteRow(r)
+		}
+		if rErr != nil {
+			cancel()
+		}
+	}
+	err = <-errc
+	if rErr != nil {
+		return rErr
+	}
+	if err != nil {
+		return executionError(ctx, err)
+	}
+	return tw.Flush()
+}
+
+// operator returns true if the user of the request can see and cancel the
+// queries of every user.
+func (s *serverConfig) operator(r *http.Request) bool {
+	return s.acl.Allowed(userOf(r), acl.Any, acl.Admin)
+}
+
+// queriesResponse lists the running queries.
+type queriesResponse struct {
+	Version string          `json:"version"`
+	Queries []*runningQuery `json:"queries"`
+}
+
+// queriesHandler serves the query registry resources:
+//
+//	GET    /queries      lists the running queries.
+//	DELETE /queries/{id} cancels a running query.
+//
+// Users only see and cancel their own queries unless they are granted admin
+// permission on every graph.
+func (s *serverConfig) queriesHandler(w http.ResponseWriter, r *http.Request) {
+	user, all := userOf(r), s.operator(r)
+	visible := func(rq *runningQuery) bool {
+		return all || rq.User == user
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, queriesPath), "/")
+	if id == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+		writeResource(w, http.StatusOK, &queriesResponse{Version: apiVersion, Queries: s.queries.list(visible)})
+		return
+	}
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r, http.MethodDelete)
+		return
+	}
+	rq, ok := s.queries.get(id)
+	if !ok || !visible(rq) {
+		writeError(w, http.StatusNotFound, "query_not_found", fmt.Errorf("no running query with ID %q", id))
+		return
+	}
+	rq.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,338 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/acl"
+	"github.com/google/badwolf/bql/semantic"
+)
+
+// errNoCredentials is returned by authenticators when the request does not
+// carry credentials for their scheme.
+var errNoCredentials = errors.New("no credentials provided")
+
+// authenticator identifies the user issuing a request.
+type authenticator interface {
+	// Authenticate returns the user issuing the request. It returns
+	// errNoCredentials if the request carries no credentials for the scheme
+	// of the authenticator.
+	Authenticate(r *http.Request) (string, error)
+
+	// Challenge returns the WWW-Authenticate challenge of the scheme.
+	Challenge() string
+}
+
+// readCredentials calls fn with the fields of each line of the file split
+// by sep. Empty lines and lines starting with # are ignored.
+func readCredentials(r io.Reader, sep string, fn func(fs []string) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var fs []string
+		if sep == "" {
+			fs = strings.Fields(text)
+		} else {
+			fs = strings.SplitN(text, sep, 2)
+		}
+		if len(fs) != 2 {
+			return fmt.Errorf("line %d: expected a user and its credentials", line)
+		}
+		if err := fn(fs); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// tokenAuthenticator authenticates requests carrying a static bearer token.
+type tokenAuthenticator struct {
+	tokens map[string]string
+}
+
+// newTokenAuthenticator reads the tokens on the reader. Each line contains a
+// user and its token separated by spaces.
+func newTokenAuthenticator(r io.Reader) (*tokenAuthenticator, error) {
+	a := &tokenAuthenticator{tokens: make(map[string]string)}
+	err := readCredentials(r, "", func(fs []string) error {
+		a.tokens[fs[1]] = fs[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authenticate returns the user owning the bearer token of the request.
+func (a *tokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return "", errNoCredentials
+	}
+	tkn, user := []byte(strings.TrimSpace(h[len("Bearer "):])), ""
+	for t, u := range a.tokens {
+		if subtle.ConstantTimeCompare(tkn, []byte(t)) == 1 {
+			user = u
+		}
+	}
+	if user == "" {
+		return "", fmt.Errorf("invalid bearer token")
+	}
+	return user, nil
+}
+
+// Challenge returns the bearer challenge.
+func (a *tokenAuthenticator) Challenge() string {
+	return `Bearer realm="badwolf"`
+}
+
+// htpasswdAuthenticator authenticates requests using basic authentication
+// against the users in an htpasswd file.
+type htpasswdAuthenticator struct {
+	hashes map[string]string
+}
+
+// newHtpasswdAuthenticator reads the htpasswd file on the reader. Only the
+// SHA-1 ({SHA}) and Apache MD5 ($apr1$) hashes are supported.
+func newHtpasswdAuthenticator(r io.Reader) (*htpasswdAuthenticator, error) {
+	a := &htpasswdAuthenticator{hashes: make(map[string]string)}
+	err := readCredentials(r, ":", func(fs []string) error {
+		if !strings.HasPrefix(fs[1], "{SHA}") && !strings.HasPrefix(fs[1], "$apr1$") {
+			return fmt.Errorf("unsupported password hash for user %q; only {SHA} and $apr1$ are supported", fs[0])
+		}
+		a.hashes[fs[0]] = fs[1]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authenticate returns the user of the basic authentication credentials.
+func (a *htpasswdAuthenticator) Authenticate(r *http.Request) (string, error) {
+	user, pw, ok := r.BasicAuth()
+	if !ok {
+		return "", errNoCredentials
+	}
+	h, ok := a.hashes[user]
+	if !ok || subtle.ConstantTimeCompare([]byte(hashPassword(pw, h)), []byte(h)) != 1 {
+		return "", fmt.Errorf("invalid user or password")
+	}
+	return user, nil
+}
+
+// Challenge returns the basic authentication challenge.
+func (a *htpasswdAuthenticator) Challenge() string {
+	return `Basic realm="badwolf"`
+}
+
+// hashPassword hashes the password using the scheme and salt of the
+// provided htpasswd hash.
+func hashPassword(pw, hash string) string {
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(pw))
+		return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	salt := strings.TrimPrefix(hash, "$apr1$")
+	if i := strings.Index(salt, "$"); i >= 0 {
+		salt = salt[:i]
+	}
+	return apr1(pw, salt)
+}
+
+// apr1 returns the Apache MD5 crypt hash of the password.
+func apr1(pw, salt string) string {
+	const (
+		magic  = "$apr1$"
+		itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	)
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	alt := md5.Sum([]byte(pw + salt + pw))
+	h := md5.New()
+	io.WriteString(h, pw+magic+salt)
+	for i := len(pw); i > 0; i -= 16 {
+		n := i
+		if n > 16 {
+			n = 16
+		}
+		h.Write(alt[:n])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write([]byte{pw[0]})
+		}
+	}
+	sum := h.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 == 1 {
+			io.WriteString(h, pw)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			io.WriteString(h, salt)
+		}
+		if i%7 != 0 {
+			io.WriteString(h, pw)
+		}
+		if i&1 == 1 {
+			h.Write(sum)
+		} else {
+			io.WriteString(h, pw)
+		}
+		sum = h.Sum(nil)
+	}
+	var buf []byte
+	enc := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			buf = append(buf, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	enc(sum[0], sum[6], sum[12], 4)
+	enc(sum[1], sum[7], sum[13], 4)
+	enc(sum[2], sum[8], sum[14], 4)
+	enc(sum[3], sum[9], sum[15], 4)
+	enc(sum[4], sum[10], sum[5], 4)
+	enc(0, 0, sum[11], 2)
+	return magic + salt + "$" + string(buf)
+}
+
+// userKey is the context key of the authenticated user.
+type userKey struct{}
+
+// userOf returns the authenticated user of the request.
+func userOf(r *http.Request) string {
+	u, _ := r.Context().Value(userKey{}).(string)
+	return u
+}
+
+// authenticated wraps the handler requiring the requests to be authenticated
+// by one of the configured authenticators. If none is configured requests
+// are handled anonymously.
+func (s *serverConfig) authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.auths) == 0 {
+			h(w, r)
+			return
+		}
+		for _, a := range s.auths {
+			user, err := a.Authenticate(r)
+			if err == errNoCredentials {
+				continue
+			}
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, "unauthorized", err)
+				return
+			}
+			h(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+			return
+		}
+		for _, a := range s.auths {
+			w.Header().Add("WWW-Authenticate", a.Challenge())
+		}
+		writeError(w, http.StatusUnauthorized, "unauthorized", errNoCredentials)
+	}
+}
+
+// authorizer returns the function checking that the user of the request is
+// allowed to run a statement.
+func (s *serverConfig) authorizer(r *http.Request) func(*semantic.Statement) error {
+	user := userOf(r)
+	return func(stm *semantic.Statement) error {
+		if err := s.replica.check(stm); err != nil {
+			return err
+		}
+		return s.acl.Check(user, stm)
+	}
+}
+
+// allowed reports a forbidden error and returns false if the user of the
+// request lacks the permission on the graph.
+func (s *serverConfig) allowed(w http.ResponseWriter, r *http.Request, graph string, p acl.Permission) bool {
+	if s.acl.Allowed(userOf(r), graph, p) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, "forbidden", &acl.DeniedError{User: userOf(r), Graph: graph, Permission: p})
+	return false
+}
+
+// loadAuth configures the authenticators and the ACL provided via the
+// --tokens, --htpasswd, and --acl flags.
+func (s *serverConfig) loadAuth(flags map[string]string) error {
+	for _, f := range []struct {
+		flag string
+		load func(io.Reader) error
+	}{
+		{"tokens", func(r io.Reader) error {
+			a, err := newTokenAuthenticator(r)
+			if err == nil {
+				s.auths = append(s.auths, a)
+			}
+			return err
+		}},
+		{"htpasswd", func(r io.Reader) error {
+			a, err := newHtpasswdAuthenticator(r)
+			if err == nil {
+				s.auths = append(s.auths, a)
+			}
+			return err
+		}},
+		{"acl", func(r io.Reader) error {
+			a, err := acl.Parse(r)
+			s.acl = a
+			return err
+		}},
+	} {
+		path, ok := flags[f.flag]
+		if !ok {
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open --%s file %q; %v", f.flag, path, err)
+		}
+		err = f.load(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read --%s file %q; %v", f.flag, path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// readyTimeout bounds the time the store has to list its graphs when
+// checking whether the server is ready.
+const readyTimeout = 5 * time.Second
+
+// healthResponse reports the status of the server.
+type healthResponse struct {
+	Version string `json:"version"`
+	Status  string `json:"status"`
+}
+
+// metricsHandler writes the collected metrics using the Prometheus text
+// exposition format.
+func (s *serverConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := s.metrics.WriteText(r.Context(), w, s.backend); err != nil {
+		log.Printf("[%v] Failed to write metrics; %v\n", time.Now(), err)
+	}
+}
+
+// healthzHandler reports that the server is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeResource(w, http.StatusOK, &healthResponse{Version: apiVersion, Status: "ok"})
+}
+
+// readyzHandler reports whether the store is able to serve requests by
+// listing its graphs. Replicas are not ready until they load the snapshot of
+// the primary.
+func (s *serverConfig) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.replica.isReady() {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", fmt.Errorf("replica has not loaded the snapshot of %s yet", s.replica.primary))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	names, errc := make(chan string), make(chan error, 1)
+	go func() {
+		errc <- s.backend.GraphNames(ctx, names)
+	}()
+	for range names {
+	}
+	if err := <-errc; err != nil {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", fmt.Errorf("store is not ready; %v", err))
+		return
+	}
+	writeResource(w, http.StatusOK, &healthResponse{Version: apiVersion, Status: "ok"})
+}
@@ -0,0 +1,160 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// defaultShutdownTimeout is the time in-flight requests are given to finish
+// once the server is asked to stop.
+const defaultShutdownTimeout = 30 * time.Second
+
+// listenConfig contains how the server listens for requests and how it is
+// shut down.
+type listenConfig struct {
+	addr            string
+	certFile        string
+	keyFile         string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	shutdownTimeout time.Duration
+}
+
+// newListenConfig returns the listen configuration for the provided port
+// set via the --host, --tls_cert, --tls_key, --read_timeout,
+// --write_timeout, and --shutdown_timeout flags.
+func newListenConfig(flags map[string]string, port string) (*listenConfig, error) {
+	lc := &listenConfig{
+		addr:            net.JoinHostPort(flags["host"], port),
+		certFile:        flags["tls_cert"],
+		keyFile:         flags["tls_key"],
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+	if (lc.certFile == "") != (lc.keyFile == "") {
+		return nil, fmt.Errorf("--tls_cert and --tls_key must be provided together")
+	}
+	for _, f := range []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"read_timeout", &lc.readTimeout},
+		{"write_timeout", &lc.writeTimeout},
+		{"shutdown_timeout", &lc.shutdownTimeout},
+	} {
+		s, ok := flags[f.name]
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("--%s requires a valid duration; got %q", f.name, s)
+		}
+		*f.d = d
+	}
+	return lc, nil
+}
+
+// streamContext returns a context for long lived streams that is canceled
+// when the request is done or the server starts shutting down. Streams are
+// not bound by --write_timeout, so the write deadline of the response is
+// cleared.
+func (s *serverConfig) streamContext(w http.ResponseWriter, r *http.Request) (context.Context, context.CancelFunc) {
+	// Writers that do not support deadlines are not bound by one either.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	ctx, cancel := context.WithCancel(r.Context())
+	go func() {
+		select {
+		case <-s.streams.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// serve serves requests using the provided handler until the server fails
+// or the process receives SIGINT or SIGTERM. It returns the exit code of the
+// command.
+func (s *serverConfig) serve(lc *listenConfig, h http.Handler) int {
+	l, err := net.Listen("tcp", lc.addr)
+	if err != nil {
+		log.Printf("[%v] Failed to listen on %s; %v\n", time.Now(), lc.addr, err)
+		return 2
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	return s.serveListener(lc, l, h, sigc)
+}
+
+// serveListener serves requests accepted by the listener until the server
+// fails or a signal is received. On a signal it stops accepting
+// connections, ends the change feed and standing query streams, waits for
+// the in-flight requests to finish, and closes the store. Queries still
+// running once the shutdown timeout expires are canceled. It returns the
+// exit code of the command.
+func (s *serverConfig) serveListener(lc *listenConfig, l net.Listener, h http.Handler, sigc <-chan os.Signal) int {
+	srv := &http.Server{
+		Handler:      h,
+		ReadTimeout:  lc.readTimeout,
+		WriteTimeout: lc.writeTimeout,
+	}
+	srv.RegisterOnShutdown(s.stopStreams)
+	errc := make(chan error, 1)
+	go func() {
+		if lc.certFile != "" {
+			errc <- srv.ServeTLS(l, lc.certFile, lc.keyFile)
+			return
+		}
+		errc <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errc:
+		log.Printf("[%v] Failed to serve on %s; %v\n", time.Now(), l.Addr(), err)
+		return 2
+	case sig := <-sigc:
+		log.Printf("[%v] Received %v; draining in-flight requests\n", time.Now(), sig)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lc.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("[%v] Requests did not finish within %v; canceling them\n", time.Now(), lc.shutdownTimeout)
+		s.queries.cancelAll()
+		srv.Close()
+	}
+	<-errc
+
+	code := 0
+	if c, ok := s.backend.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("[%v] Failed to close the store; %v\n", time.Now(), err)
+			code = 1
+		}
+	}
+	log.Printf("[%v] Server stopped\n", time.Now())
+	return code
+}
@@ -0,0 +1,358 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/triple"
+)
+
+// blockingStore returns graphs whose additions wait until released, and
+// records whether it was closed.
+type blockingStore struct {
+	storage.Store
+	started chan bool
+	release chan bool
+	closed  bool
+}
+
+func (s *blockingStore) Graph(ctx context.Context, id string) (storage.Graph, error) {
+	g, err := s.Store.Graph(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &blockingGraph{g, s}, nil
+}
+
+// Close marks the store as closed.
+func (s *blockingStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+// blockingGraph waits until its store is released to add triples.
+type blockingGraph struct {
+	storage.Graph
+	s *blockingStore
+}
+
+func (g *blockingGraph) AddTriples(ctx context.Context, ts []*triple.Triple) error {
+	g.s.started <- true
+	<-g.s.release
+	return g.Graph.AddTriples(ctx, ts)
+}
+
+// startServer serves the server on a local port configured via the provided
+// flags. It returns the URL of the server and a function that signals the
+// server to stop and returns its exit code.
+func startServer(t *testing.T, s *serverConfig, flags map[string]string) (string, func() int) {
+	lc, err := newListenConfig(flags, "0")
+	if err != nil {
+		t.Fatalf("newListenConfig(%v) failed with error %v", flags, err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed with error %v", err)
+	}
+	sigc, codec := make(chan os.Signal, 1), make(chan int, 1)
+	go func() {
+		codec <- s.serveListener(lc, l, s.handler(), sigc)
+	}()
+	stopped := false
+	stop := func() int {
+		if !stopped {
+			stopped = true
+			sigc <- syscall.SIGTERM
+		}
+		select {
+		case code := <-codec:
+			return code
+		case <-time.After(10 * time.Second):
+			t.Fatalf("the server did not stop after receiving SIGTERM")
+		}
+		return 0
+	}
+	t.Cleanup(func() {
+		if !stopped {
+			stop()
+		}
+	})
+	scheme := "http"
+	if lc.certFile != "" {
+		scheme = "https"
+	}
+	return scheme + "://" + l.Addr().String(), stop
+}
+
+// post posts the statements to the /bql endpoint of the server.
+func post(t *testing.T, u, bql string) {
+	resp, err := http.PostForm(u+"/bql", url.Values{"bqlQuery": {bql}})
+	if err != nil {
+		t.Fatalf("POST /bql failed with error %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /bql returned %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+// writeCertificate writes a self signed certificate for 127.0.0.1 and its
+// key. It returns their paths and the certificate.
+func writeCertificate(t *testing.T) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey failed with error %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "badwolf"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed with error %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed with error %v", err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey failed with error %v", err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	for _, f := range []struct {
+		path  string
+		block *pem.Block
+	}{
+		{certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der}},
+		{keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}},
+	} {
+		if err := os.WriteFile(f.path, pem.EncodeToMemory(f.block), 0600); err != nil {
+			t.Fatalf("os.WriteFile failed with error %v", err)
+		}
+	}
+	return certFile, keyFile, cert
+}
+
+func TestNewListenConfig(t *testing.T) {
+	for _, flags := range []map[string]string{
+		{"tls_cert": "server.crt"},
+		{"tls_key": "server.key"},
+		{"write_timeout": "soon"},
+		{"shutdown_timeout": "-1s"},
+	} {
+		if _, err := newListenConfig(flags, "1234"); err == nil {
+			t.Errorf("newListenConfig(%v) should have failed", flags)
+		}
+	}
+	lc, err := newListenConfig(map[string]string{"host": "127.0.0.1", "read_timeout": "1s"}, "1234")
+	if err != nil {
+		t.Fatalf("newListenConfig failed with error %v", err)
+	}
+	if got, want := *lc, (listenConfig{addr: "127.0.0.1:1234", readTimeout: time.Second, shutdownTimeout: defaultShutdownTimeout}); got != want {
+		t.Errorf("newListenConfig returned %+v; want %+v", got, want)
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, cert := writeCertificate(t)
+	s, err := newServerConfig(context.Background(), memory.NewStore(), nil, 0, 1000)
+	if err != nil {
+		t.Fatalf("newServerConfig failed with error %v", err)
+	}
+	u, stop := startServer(t, s, map[string]string{"tls_cert": certFile, "tls_key": keyFile})
+	if !strings.HasPrefix(u, "https://") {
+		t.Fatalf("the server listens on %q; want an HTTPS URL", u)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.PostForm(u+"/bql", url.Values{"bqlQuery": {"CREATE GRAPH ?g;"}})
+	if err != nil {
+		t.Fatalf("POST /bql over HTTPS failed with error %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /bql over HTTPS returned %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+	if resp, err := http.Get(u + "/healthz"); err == nil {
+		resp.Body.Close()
+		t.Errorf("GET /healthz with an untrusted certificate should have failed")
+	}
+	if resp, err := http.Get(strings.Replace(u, "https://", "http://", 1) + "/healthz"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("GET /healthz over plain HTTP returned %d; want the request to be rejected", resp.StatusCode)
+		}
+	}
+	if code := stop(); code != 0 {
+		t.Errorf("the server exited with code %d; want 0", code)
+	}
+}
+
+func TestStreamsIgnoreWriteTimeout(t *testing.T) {
+	s, err := newServerConfig(context.Background(), memory.NewStore(), nil, 0, 1000)
+	if err != nil {
+		t.Fatalf("newServerConfig failed with error %v", err)
+	}
+	u, _ := startServer(t, s, map[string]string{"write_timeout": "100ms"})
+	post(t, u, "CREATE GRAPH ?g;")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var scanners []*bufio.Scanner
+	for _, path := range []string{
+		"/changes?from=1",
+		"/standing?" + url.Values{"bqlQuery": {`SELECT ?o FROM ?g WHERE {/u<a> "knows"@[] ?o};`}}.Encode(),
+	} {
+		req, err := http.NewRequest(http.MethodGet, u+path, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest failed with error %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatalf("GET %s failed with error %v", path, err)
+		}
+		defer resp.Body.Close()
+		scanners = append(scanners, bufio.NewScanner(resp.Body))
+	}
+	readEvents(t, scanners[0], 1)
+	readEvents(t, scanners[1], 1)
+
+	// Outlive the write timeout before the next events are written.
+	time.Sleep(300 * time.Millisecond)
+	post(t, u, `INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b>};`)
+	if got, want := readEvents(t, scanners[0], 1), []string{"2/add"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/changes returned events %v after the write timeout; want %v", got, want)
+	}
+	if got, want := readEvents(t, scanners[1], 1), []string{"2/change"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/standing returned events %v after the write timeout; want %v", got, want)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	store := &blockingStore{Store: memory.NewStore(), started: make(chan bool), release: make(chan bool)}
+	s, err := newServerConfig(context.Background(), store, nil, 0, 1000)
+	if err != nil {
+		t.Fatalf("newServerConfig failed with error %v", err)
+	}
+	u, stop := startServer(t, s, nil)
+	post(t, u, "CREATE GRAPH ?g;")
+	stream, err := http.Get(u + "/changes?from=1")
+	if err != nil {
+		t.Fatalf("GET /changes failed with error %v", err)
+	}
+	defer stream.Body.Close()
+	scanner := bufio.NewScanner(stream.Body)
+	readEvents(t, scanner, 1)
+
+	statusc := make(chan int, 1)
+	go func() {
+		resp, err := http.PostForm(u+"/bql", url.Values{"bqlQuery": {`INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b>};`}})
+		if err != nil {
+			statusc <- 0
+			return
+		}
+		resp.Body.Close()
+		statusc <- resp.StatusCode
+	}()
+	<-store.started
+	codec := make(chan int, 1)
+	go func() {
+		codec <- stop()
+	}()
+
+	// The change feed stream ends as soon as the shutdown starts, while the
+	// in-flight statement is allowed to finish.
+	for scanner.Scan() {
+	}
+	select {
+	case code := <-codec:
+		t.Fatalf("the server exited with code %d before the in-flight statement finished", code)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if resp, err := http.Get(u + "/healthz"); err == nil {
+		resp.Body.Close()
+		t.Errorf("GET /healthz succeeded while shutting down; want new connections to be refused")
+	}
+	if store.closed {
+		t.Errorf("the store was closed before the in-flight statement finished")
+	}
+	store.release <- true
+	if status := <-statusc; status != http.StatusOK {
+		t.Errorf("the in-flight statement returned %d; want %d", status, http.StatusOK)
+	}
+	if code := <-codec; code != 0 {
+		t.Errorf("the server exited with code %d; want 0", code)
+	}
+	if !store.closed {
+		t.Errorf("the store was not closed once the server stopped")
+	}
+}
+
+func TestShutdownTimeoutCancelsQueries(t *testing.T) {
+	store := &blockingStore{Store: memory.NewStore(), started: make(chan bool), release: make(chan bool)}
+	s, err := newServerConfig(context.Background(), store, nil, 0, 1000)
+	if err != nil {
+		t.Fatalf("newServerConfig failed with error %v", err)
+	}
+	u, stop := startServer(t, s, map[string]string{"shutdown_timeout": "50ms"})
+	post(t, u, "CREATE GRAPH ?g;")
+	go func() {
+		resp, err := http.PostForm(u+"/bql", url.Values{"bqlQuery": {`INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b>};`}})
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-store.started
+	defer close(store.release)
+	if code := stop(); code != 0 {
+		t.Errorf("the server exited with code %d; want 0", code)
+	}
+	if !store.closed {
+		t.Errorf("the store was not closed once the shutdown timeout expired")
+	}
+}
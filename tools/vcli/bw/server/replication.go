@@ -0,0 +1,377 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/acl"
+	"github.com/google/badwolf/bql/semantic"
+	bwio "github.com/google/badwolf/io"
+	"github.com/google/badwolf/storage/feed"
+	"github.com/google/badwolf/triple/literal"
+)
+
+const (
+	// logPath is the prefix of the mutation log resources.
+	logPath = "/log"
+	// snapshotEnd is the operation of the record closing a snapshot. Its
+	// sequence number is the one of the last event included in the snapshot.
+	snapshotEnd = "end"
+	// retryDelay is the time a replica waits before reconnecting to the
+	// primary after a failure.
+	retryDelay = time.Second
+)
+
+var (
+	// errReadOnly is returned for the mutations sent to a replica.
+	errReadOnly = errors.New("[ERROR] Replicas are read-only; send mutations to the primary")
+	// errLogGone is returned when the primary no longer retains the events
+	// the replica needs.
+	errLogGone = errors.New("the primary no longer retains the requested events")
+)
+
+// logHandler serves the mutation log to replicas:
+//
+//	GET /log?from={seq}  streams the events starting at seq as NDJSON.
+//	GET /log/snapshot    streams the events recreating the store followed
+//	                     by a record with the sequence number it reflects.
+//
+// Only users granted admin permission on every graph can read the log.
+func (s *serverConfig) logHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if !s.allowed(w, r, acl.Any, acl.Admin) {
+		return
+	}
+	sw, err := newStreamWriter(w, streamNDJSON)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, logPath), "/") {
+	case "":
+		s.streamLog(w, r, sw)
+	case "snapshot":
+		s.streamSnapshot(r, sw)
+	default:
+		writeError(w, http.StatusNotFound, "not_found", fmt.Errorf("unknown resource %q", r.URL.Path))
+	}
+}
+
+// streamLog streams the events starting at the sequence number provided via
+// the "from" parameter. The stream ends if the replica falls behind the
+// retained events.
+func (s *serverConfig) streamLog(w http.ResponseWriter, r *http.Request, sw *streamWriter) {
+	from, err := strconv.ParseUint(r.FormValue("from"), 10, 64)
+	if err != nil || from == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Errorf("invalid from sequence number %q", r.FormValue("from")))
+		return
+	}
+	ctx, cancel := s.streamContext(w, r)
+	defer cancel()
+	sub, err := s.feed.Subscribe(ctx, from)
+	if err != nil {
+		writeError(w, http.StatusGone, "gone", err)
+		return
+	}
+	sw.start()
+	for e := range sub.Events {
+		le, err := newLogEvent(e)
+		if err == nil {
+			err = sw.write("event", le)
+		}
+		if err != nil {
+			log.Printf("[%v] Failed to ship event %d; %v\n", time.Now(), e.Seq, err)
+			return
+		}
+	}
+	if err := sub.Err(); err != nil {
+		log.Printf("[%v] Log stream ended; %v\n", time.Now(), err)
+	}
+}
+
+// streamSnapshot streams a snapshot of the store followed by a record with
+// the sequence number the snapshot reflects. Mutations of a graph are
+// blocked while its triples are streamed.
+func (s *serverConfig) streamSnapshot(r *http.Request, sw *streamWriter) {
+	ctx, cancel := s.streamContext(sw.w, r)
+	defer cancel()
+	sw.start()
+	seq, err := s.feed.Snapshot(ctx, func(e *feed.Event) error {
+		le, err := newLogEvent(e)
+		if err != nil {
+			return err
+		}
+		return sw.write("event", le)
+	})
+	if err != nil {
+		// The missing end record tells the replica the snapshot failed.
+		log.Printf("[%v] Failed to stream snapshot; %v\n", time.Now(), err)
+		return
+	}
+	sw.write(snapshotEnd, &logEvent{Seq: seq, Op: snapshotEnd})
+}
+
+// logEvent contains the representation of an event in the mutation log.
+// Unlike the change feed, which renders triples as text for people to read,
+// triples are encoded as JSON Lines records so literals and time anchors
+// reach the replicas unchanged.
+type logEvent struct {
+	Seq    uint64          `json:"seq"`
+	Op     string          `json:"op"`
+	Graph  string          `json:"graph,omitempty"`
+	Triple json.RawMessage `json:"triple,omitempty"`
+	Time   string          `json:"time,omitempty"`
+}
+
+// newLogEvent returns the log representation of the event.
+func newLogEvent(e *feed.Event) (*logEvent, error) {
+	le := &logEvent{
+		Seq:   e.Seq,
+		Op:    e.Op.String(),
+		Graph: e.Graph,
+		Time:  e.Time.Format(time.RFC3339Nano),
+	}
+	if e.Triple != nil {
+		t, err := bwio.TripleToJSON(e.Triple)
+		if err != nil {
+			return nil, err
+		}
+		le.Triple = t
+	}
+	return le, nil
+}
+
+// event returns the event described by the log representation.
+func (le *logEvent) event() (*feed.Event, error) {
+	op, err := feed.ParseOp(le.Op)
+	if err != nil {
+		return nil, err
+	}
+	e := &feed.Event{Seq: le.Seq, Op: op, Graph: le.Graph}
+	if len(le.Triple) > 0 {
+		if e.Triple, err = bwio.TripleFromJSON(le.Triple, literal.DefaultBuilder()); err != nil {
+			return nil, err
+		}
+	}
+	if e.Time, err = time.Parse(time.RFC3339Nano, le.Time); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// replica pulls the mutation log of a primary server and applies it to the
+// local store.
+type replica struct {
+	primary string
+	token   string
+	client  *http.Client
+	// ready is set to 1 once the snapshot of the primary has been loaded.
+	ready int32
+}
+
+// newReplica returns the replica configured via the --primary and
+// --primary_token flags. It returns nil if the server is not a replica.
+func newReplica(flags map[string]string) (*replica, error) {
+	p, ok := flags["primary"]
+	if !ok {
+		return nil, nil
+	}
+	u, err := url.Parse(p)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("--primary requires an http or https URL; got %q", p)
+	}
+	return &replica{
+		primary: strings.TrimSuffix(p, "/"),
+		token:   flags["primary_token"],
+		client:  &http.Client{},
+	}, nil
+}
+
+// check returns a *statementError if the statement mutates the store and
+// the server is a replica.
+func (rp *replica) check(stm *semantic.Statement) error {
+	if rp == nil {
+		return nil
+	}
+	switch stm.Type() {
+	case semantic.Query, semantic.Show:
+		return nil
+	default:
+		return &statementError{http.StatusForbidden, "read_only", errReadOnly}
+	}
+}
+
+// isReady returns true if the server is not a replica or it loaded the
+// snapshot of the primary.
+func (rp *replica) isReady() bool {
+	return rp == nil || atomic.LoadInt32(&rp.ready) == 1
+}
+
+// get requests the provided log resource from the primary.
+func (rp *replica) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, rp.primary+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rp.token != "" {
+		req.Header.Set("Authorization", "Bearer "+rp.token)
+	}
+	resp, err := rp.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, errLogGone
+	}
+	er := &errorResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(er); err != nil || er.Error == nil {
+		return nil, fmt.Errorf("primary returned status %d", resp.StatusCode)
+	}
+	return nil, fmt.Errorf("primary returned status %d; %s", resp.StatusCode, er.Error.Message)
+}
+
+// replicate keeps the store in sync with the primary until the context is
+// done. It loads a snapshot of the primary and then applies the events that
+// follow it, reconnecting on failures. If the primary no longer retains the
+// events the replica needs, the store is reloaded from a new snapshot.
+func (s *serverConfig) replicate(ctx context.Context) {
+	var next uint64
+	for ctx.Err() == nil {
+		var err error
+		if next == 0 {
+			var seq uint64
+			if seq, err = s.resync(ctx); err == nil {
+				log.Printf("[%v] Loaded snapshot of %s at sequence number %d\n", time.Now(), s.replica.primary, seq)
+				next = seq + 1
+				atomic.StoreInt32(&s.replica.ready, 1)
+			}
+		}
+		if err == nil {
+			err = s.follow(ctx, &next)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == errLogGone {
+			log.Printf("[%v] %s no longer retains event %d; reloading the store\n", time.Now(), s.replica.primary, next)
+			next = 0
+			continue
+		}
+		log.Printf("[%v] Replication from %s failed; %v\n", time.Now(), s.replica.primary, err)
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// resync replaces the contents of the store with a snapshot of the primary
+// and returns the sequence number the snapshot reflects.
+func (s *serverConfig) resync(ctx context.Context) (uint64, error) {
+	atomic.StoreInt32(&s.replica.ready, 0)
+	names, errc := make(chan string, s.chanSize), make(chan error, 1)
+	go func() {
+		errc <- s.store.GraphNames(ctx, names)
+	}()
+	var gns []string
+	for n := range names {
+		gns = append(gns, n)
+	}
+	if err := <-errc; err != nil {
+		return 0, err
+	}
+	for _, gn := range gns {
+		if err := s.store.DeleteGraph(ctx, gn); err != nil {
+			return 0, err
+		}
+	}
+
+	resp, err := s.replica.get(ctx, logPath+"/snapshot")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		le := &logEvent{}
+		if err := dec.Decode(le); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("snapshot ended before its end record")
+			}
+			return 0, err
+		}
+		if le.Op == snapshotEnd {
+			return le.Seq, nil
+		}
+		e, err := le.event()
+		if err != nil {
+			return 0, err
+		}
+		if err := feed.Apply(ctx, s.store, e); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// follow applies the events of the primary starting at next, updating it
+// as events are applied, until the log stream fails.
+func (s *serverConfig) follow(ctx context.Context, next *uint64) error {
+	resp, err := s.replica.get(ctx, fmt.Sprintf("%s?from=%d", logPath, *next))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		le := &logEvent{}
+		if err := dec.Decode(le); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("primary closed the log stream")
+			}
+			return err
+		}
+		e, err := le.event()
+		if err != nil {
+			return err
+		}
+		if e.Seq != *next {
+			return fmt.Errorf("received event %d while expecting event %d", e.Seq, *next)
+		}
+		if err := feed.Apply(ctx, s.store, e); err != nil {
+			return err
+		}
+		*next++
+	}
+}
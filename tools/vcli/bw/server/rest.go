@@ -0,0 +1,422 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/acl"
+	bwio "github.com/google/badwolf/io"
+	"github.com/google/badwolf/io/rdf"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/schema"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+)
+
+// graphsPath is the prefix of the graph resources.
+const graphsPath = "/graphs"
+
+// errorResponse contains the error returned by the resource endpoints.
+type errorResponse struct {
+	Version string       `json:"version"`
+	Error   *errorObject `json:"error"`
+}
+
+// graphsResponse lists the graphs available in the store.
+type graphsResponse struct {
+	Version string   `json:"version"`
+	Graphs  []string `json:"graphs"`
+}
+
+// graphResponse describes a graph, and the triples uploaded to it if any.
+type graphResponse struct {
+	Version string `json:"version"`
+	Graph   string `json:"graph"`
+	Triples *int   `json:"triples,omitempty"`
+}
+
+// graphRequest contains the graph to create.
+type graphRequest struct {
+	Name string `json:"name"`
+}
+
+// writeError writes a JSON response that only contains the provided error.
+func writeError(w http.ResponseWriter, status int, code string, err error) {
+	log.Printf("[%s] %v\n", time.Now(), err)
+	writeResource(w, status, &errorResponse{
+		Version: apiVersion,
+		Error:   &errorObject{Code: code, Message: err.Error()},
+	})
+}
+
+// writeResource writes the provided value as a JSON response.
+func writeResource(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[%s] Failed to write JSON response; %v", time.Now(), err)
+	}
+}
+
+// methodNotAllowed reports that the request method is not supported by the
+// resource.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Errorf("invalid %s request on %q endpoint. Only %s request are accepted", r.Method, r.URL.Path, strings.Join(allowed, " and ")))
+}
+
+// graphName returns the graph name for the provided path segment. The
+// leading ? of the graph name is optional.
+func graphName(s string) (string, error) {
+	if !strings.HasPrefix(s, "?") {
+		s = "?" + s
+	}
+	if len(s) < 2 || strings.ContainsAny(s, " \t\n/") {
+		return "", fmt.Errorf("invalid graph name %q", s)
+	}
+	return s, nil
+}
+
+// graphsHandler serves the graph resources:
+//
+//	GET    /graphs                 lists the graphs.
+//	POST   /graphs                 creates a graph.
+//	GET    /graphs/{graph}         describes a graph.
+//	DELETE /graphs/{graph}         deletes a graph.
+//	GET    /graphs/{graph}/triples exports the triples of a graph or a node.
+//	POST   /graphs/{graph}/triples uploads triples to a graph.
+func (s *serverConfig) graphsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && s.replica != nil {
+		writeError(w, http.StatusForbidden, "read_only", errReadOnly)
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, graphsPath), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			s.listGraphs(w, r)
+		case http.MethodPost:
+			s.createGraph(w, r)
+		default:
+			methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "triples") {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Errorf("unknown resource %q", r.URL.Path))
+		return
+	}
+	gn, err := graphName(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_graph", err)
+		return
+	}
+	if !s.allowed(w, r, gn, requiredPermission(r.Method, len(parts) == 2)) {
+		return
+	}
+	ctx := r.Context()
+	g, err := s.store.Graph(ctx, gn)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "graph_not_found", err)
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			writeResource(w, http.StatusOK, &graphResponse{Version: apiVersion, Graph: gn})
+		case http.MethodDelete:
+			if err := s.store.DeleteGraph(ctx, gn); err != nil {
+				writeError(w, http.StatusInternalServerError, "internal", err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(w, r, http.MethodGet, http.MethodDelete)
+		}
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.downloadTriples(ctx, w, r, g)
+	case http.MethodPost:
+		s.uploadTriples(ctx, w, r, g)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+// requiredPermission returns the permission needed to run the request
+// method on a graph, or on its triples.
+func requiredPermission(method string, triples bool) acl.Permission {
+	switch {
+	case method == http.MethodGet:
+		return acl.Read
+	case triples:
+		return acl.Write
+	default:
+		return acl.Admin
+	}
+}
+
+// listGraphs returns the sorted names of the graphs in the store the user
+// can read.
+func (s *serverConfig) listGraphs(w http.ResponseWriter, r *http.Request) {
+	names, errc := make(chan string, s.chanSize), make(chan error, 1)
+	go func() {
+		errc <- s.store.GraphNames(r.Context(), names)
+	}()
+	res := &graphsResponse{Version: apiVersion, Graphs: []string{}}
+	for n := range names {
+		if s.acl.Allowed(userOf(r), n, acl.Read) {
+			res.Graphs = append(res.Graphs, n)
+		}
+	}
+	if err := <-errc; err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	sort.Strings(res.Graphs)
+	writeResource(w, http.StatusOK, res)
+}
+
+// createGraph creates the graph named in the request. The name can be
+// provided as a JSON body or as the name form parameter.
+func (s *serverConfig) createGraph(w http.ResponseWriter, r *http.Request) {
+	req := &graphRequest{}
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mt == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", fmt.Errorf("invalid JSON request body; %v", err))
+			return
+		}
+	} else {
+		req.Name = r.FormValue("name")
+	}
+	gn, err := graphName(strings.TrimSpace(req.Name))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_graph", err)
+		return
+	}
+	if !s.allowed(w, r, gn, acl.Admin) {
+		return
+	}
+	ctx := r.Context()
+	if _, err := s.store.Graph(ctx, gn); err == nil {
+		writeError(w, http.StatusConflict, "graph_exists", fmt.Errorf("graph %q already exists", gn))
+		return
+	}
+	if _, err := s.store.NewGraph(ctx, gn); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	w.Header().Set("Location", graphsPath+"/"+url.PathEscape(gn))
+	writeResource(w, http.StatusCreated, &graphResponse{Version: apiVersion, Graph: gn})
+}
+
+// uploadTriples adds the triples in the request body to the graph. The
+// serialization is provided via the format query parameter and gzip
+// compressed bodies are transparently decompressed. Uploads are admitted and
+// registered as any other statement, and both the body and its decompressed
+// contents are bounded by --max_upload.
+func (s *serverConfig) uploadTriples(ctx context.Context, w http.ResponseWriter, r *http.Request, g storage.Graph) {
+	format := r.URL.Query().Get("format")
+	var (
+		f      rdf.Format
+		native = format == "" || format == "bw" || format == "binary" || format == "jsonl"
+	)
+	if !native {
+		var err error
+		if f, err = rdf.ParseFormat(format); err != nil {
+			writeError(w, http.StatusUnsupportedMediaType, "invalid_format", err)
+			return
+		}
+	}
+	release, err := s.admission.acquire(ctx)
+	if err != nil {
+		se := err.(*statementError)
+		writeError(w, se.status, se.code, se.err)
+		return
+	}
+	defer release()
+	rq, ctx := s.queries.add(ctx, r.Method+" "+r.URL.Path, userOf(r))
+	defer s.queries.remove(rq)
+
+	var (
+		cnt  int
+		body = &bwio.LimitedReader{R: r.Body, N: s.admission.maxUpload}
+		dr   *bwio.LimitedReader
+		b    = literal.DefaultBuilder()
+	)
+	if native {
+		opts := &bwio.LoaderOptions{BatchSize: s.bulkSize, MaxBytes: s.admission.maxUpload}
+		if format == "jsonl" {
+			opts.Parse = func(line string, b literal.Builder) (*triple.Triple, error) {
+				return bwio.TripleFromJSON([]byte(line), b)
+			}
+		}
+		var p bwio.Progress
+		p, err = bwio.Load(ctx, body, []storage.Graph{g}, b, opts)
+		cnt = p.Triples
+	} else {
+		var ur io.Reader
+		if ur, err = bwio.Decompress(body); err == nil {
+			dr = &bwio.LimitedReader{R: ur, N: s.admission.maxUpload}
+			cnt, err = rdf.Load(ctx, dr, []storage.Graph{g}, f, nil, b, s.bulkSize)
+		}
+	}
+	if err != nil {
+		var (
+			ve *schema.ValidationError
+			be *bwio.BatchError
+		)
+		switch {
+		case body.Exceeded() || (dr != nil && dr.Exceeded()) || err == bwio.ErrTooLarge:
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", fmt.Errorf("the uploaded triples exceed the limit of %d bytes", s.admission.maxUpload))
+		case errors.As(err, &ve):
+			writeError(w, http.StatusUnprocessableEntity, "schema_violation", err)
+		case errors.As(err, &be):
+			// The batch was parsed, but the store failed to add it.
+			writeError(w, http.StatusInternalServerError, "internal", err)
+		default:
+			writeError(w, http.StatusBadRequest, "parse_error", err)
+		}
+		return
+	}
+	writeResource(w, http.StatusOK, &graphResponse{Version: apiVersion, Graph: g.ID(ctx), Triples: &cnt})
+}
+
+// tripleWriter returns the function writing triples in the provided format,
+// the function to call once all triples are written, and the content type
+// of the serialization.
+func tripleWriter(w io.Writer, format, gn string) (func(*triple.Triple) error, func() error, string, error) {
+	switch format {
+	case "", "bw":
+		return func(t *triple.Triple) error {
+			_, err := io.WriteString(w, t.String()+"\n")
+			return err
+		}, func() error { return nil }, "text/plain; charset=utf-8", nil
+	case "jsonl":
+		return func(t *triple.Triple) error {
+			bs, err := bwio.TripleToJSON(t)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(append(bs, '\n'))
+			return err
+		}, func() error { return nil }, "application/x-ndjson", nil
+	case "quads":
+		return func(t *triple.Triple) error {
+			_, err := io.WriteString(w, bwio.QuadString(t, gn)+"\n")
+			return err
+		}, func() error { return nil }, "text/plain; charset=utf-8", nil
+	case "binary":
+		e := triple.NewEncoder(w)
+		return e.Encode, e.Flush, "application/octet-stream", nil
+	}
+	f, err := rdf.ParseFormat(format)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	ct := map[rdf.Format]string{
+		rdf.NTriples: "application/n-triples",
+		rdf.Turtle:   "text/turtle",
+		rdf.JSONLD:   "application/ld+json",
+	}[f]
+	rw := rdf.NewWriter(w, f, nil)
+	return rw.Write, rw.Close, ct, nil
+}
+
+// downloadTriples writes the triples of the graph in the format provided via
+// the format query parameter. If the node query parameter is provided only
+// the triples with that node as subject or object are written.
+func (s *serverConfig) downloadTriples(ctx context.Context, w http.ResponseWriter, r *http.Request, g storage.Graph) {
+	q := r.URL.Query()
+	srcs := []func(chan<- *triple.Triple) error{
+		func(ts chan<- *triple.Triple) error {
+			return g.Triples(ctx, storage.DefaultLookup, ts)
+		},
+	}
+	if ns := q.Get("node"); ns != "" {
+		n, err := node.Parse(ns)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_node", err)
+			return
+		}
+		srcs = []func(chan<- *triple.Triple) error{
+			func(ts chan<- *triple.Triple) error {
+				return g.TriplesForSubject(ctx, n, storage.DefaultLookup, ts)
+			},
+			func(ts chan<- *triple.Triple) error {
+				return g.TriplesForObject(ctx, triple.NewNodeObject(n), storage.DefaultLookup, ts)
+			},
+		}
+	}
+	gn := g.ID(ctx)
+	write, closeWriter, ct, err := tripleWriter(w, q.Get("format"), gn)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_format", err)
+		return
+	}
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(http.StatusOK)
+
+	// Triples with the node as subject and object are only written once.
+	seen := make(map[string]bool)
+	for _, src := range srcs {
+		ts, errc := make(chan *triple.Triple, s.chanSize), make(chan error, 1)
+		go func(src func(chan<- *triple.Triple) error) {
+			errc <- src(ts)
+		}(src)
+		var wErr error
+		for t := range ts {
+			if wErr != nil {
+				continue
+			}
+			if len(srcs) > 1 {
+				id := t.UUID().String()
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+			}
+			wErr = write(t)
+		}
+		if err := <-errc; err != nil {
+			log.Printf("[%s] Failed to retrieve triples of graph %q; %v", time.Now(), gn, err)
+			return
+		}
+		if wErr != nil {
+			log.Printf("[%s] Failed to write triples of graph %q; %v", time.Now(), gn, wErr)
+			return
+		}
+	}
+	if err := closeWriter(); err != nil {
+		log.Printf("[%s] Failed to write triples of graph %q; %v", time.Now(), gn, err)
+	}
+}
@@ -17,32 +17,96 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
+	"mime"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/google/badwolf/bql/acl"
 	"github.com/google/badwolf/bql/grammar"
+	"github.com/google/badwolf/bql/lexer"
+	"github.com/google/badwolf/bql/metrics"
 	"github.com/google/badwolf/bql/planner"
 	"github.com/google/badwolf/bql/semantic"
+	"github.com/google/badwolf/bql/standing"
 	"github.com/google/badwolf/bql/table"
 	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/feed"
 	"github.com/google/badwolf/tools/vcli/bw/command"
+	bio "github.com/google/badwolf/tools/vcli/bw/io"
 )
 
 // New creates the help command.
 func New(store storage.Store, chanSize, bulkSize int) *command.Command {
 	cmd := &command.Command{
-		UsageLine: "server port",
+		UsageLine: "server [--host=<host>] [--tls_cert=<cert_file> --tls_key=<key_file>] [--read_timeout=<duration>] [--write_timeout=<duration>] [--shutdown_timeout=<duration>] [--primary=<url> [--primary_token=<token>]] [--tokens=<tokens_file>] [--htpasswd=<htpasswd_file>] [--acl=<acl_file>] [--max_queries=<n>] [--queue_timeout=<duration>] [--max_rows=<n>] [--max_result_bytes=<bytes>] [--max_upload=<bytes>] [--max_standing=<n>] port",
 		Short:     "runs a BQL endoint.",
 		Long: `Runs a BQL endpoint with the provided driver. It allows running
-all BQL queries and returns a JSON table with the results.`,
+all BQL queries and returns a versioned JSON object with the outcome of each
+statement. Statements can be posted as the "bqlQuery" form parameter or as
+a JSON body with the "query" and optional "timeout" fields.
+
+The server listens on every interface unless --host is provided, and serves
+HTTPS if --tls_cert and --tls_key are provided. The --read_timeout and
+--write_timeout flags bound the time spent reading requests and writing
+responses, except for the /changes, /standing, and /log streams. On SIGINT or SIGTERM the server stops accepting connections,
+waits up to --shutdown_timeout (30s by default) for in-flight requests to
+finish, and closes the store.
+
+Setting the "stream" parameter to "ndjson" or "sse" streams the rows of each
+statement as they are produced, preceded by a header record with its
+bindings and followed by a trailer record with its outcome.
+
+The /graphs endpoint lists and creates graphs. Graphs can be deleted via
+/graphs/{graph}, and their triples uploaded and exported in any supported
+serialization via /graphs/{graph}/triples. The "node" parameter restricts
+the export to the triples of a node.
+
+The --tokens and --htpasswd flags require requests to authenticate with a
+bearer token or basic authentication credentials. The --acl flag restricts
+the graphs each user can read, write, or administer.
+
+The --max_queries flag bounds the number of statements running
+concurrently, and --queue_timeout how long statements wait to be admitted.
+The --max_rows and --max_result_bytes flags cap the size of the rows
+returned by each statement, and --max_upload (1GiB by default) the size of
+uploaded triples. The /queries
+endpoint lists the running queries and uploads, and /queries/{id} cancels
+one of them when deleted.
+
+The /metrics endpoint reports statement counts, latencies, errors, and rows
+returned by statement type, storage calls by method, and the number of
+triples of each graph using the Prometheus text format. The /healthz and
+/readyz endpoints report whether the server is alive and whether its store
+is able to serve requests.
+
+The /log endpoint streams the ordered mutation log of the server, and
+/log/snapshot the current contents of its store. Servers started with
+--primary=<url> are read-only replicas: they load a snapshot of the primary,
+apply its log to their own store, and reject every mutation. The
+--primary_token flag provides the bearer token used to read the log.
+
+The /changes endpoint streams the mutations applied through the server as
+server-sent events. Clients can resume a stream by providing the sequence
+number of the first event they want via the "from" parameter or by
+reconnecting with the Last-Event-ID header.
+
+The /standing endpoint registers the SELECT query provided via the
+"bqlQuery" parameter as a standing query. It streams the rows added to and
+removed from its result set as server-sent events every time the queried
+graphs change through the server. Standing queries are fully evaluated again
+on every relevant change, and every evaluation takes one of the --max_queries
+slots. At most --max_standing (64 by default) standing queries can be
+registered at once. They are listed in /queries, and they end with an error
+event once their result exceeds --max_rows or an evaluation takes more than a
+minute.`,
 	}
 	cmd.Run = func(ctx context.Context, args []string) int {
 		return runServer(ctx, cmd, args, store, chanSize, bulkSize)
@@ -52,14 +116,30 @@ all BQL queries and returns a JSON table with the results.`,
 
 // serverConfig wraps the information that defines the server.
 type serverConfig struct {
-	store    storage.Store
-	chanSize int
-	bulkSize int
+	store     storage.Store
+	feed      *feed.Store
+	chanSize  int
+	bulkSize  int
+	auths     []authenticator
+	acl       *acl.ACL
+	admission *admission
+	queries   *queryRegistry
+	// backend is the uninstrumented store used to count the triples of each
+	// graph when reporting metrics.
+	backend storage.Store
+	metrics *metrics.Metrics
+	// streams is canceled when the server starts shutting down to end the
+	// change feed and standing query streams.
+	streams     context.Context
+	stopStreams context.CancelFunc
+	// replica is nil unless the server replicates a primary server.
+	replica *replica
 }
 
 // runServer runs the simple BQL endpoint.
 func runServer(ctx context.Context, cmd *command.Command, args []string, store storage.Store, chanSize, bulkSize int) int {
 	// Check parameters.
+	flags, args := bio.ExtractFlags(args)
 	if len(args) < 2 {
 		log.Printf("[%v] Missing required port number. ", time.Now())
 		cmd.Usage()
@@ -68,156 +148,611 @@ func runServer(ctx context.Context, cmd *command.Command, args []string, store s
 
 	// Validate port number.
 	p := strings.TrimSpace(args[len(args)-1])
-	port, err := strconv.Atoi(p)
-	if err != nil {
+	if _, err := strconv.Atoi(p); err != nil {
 		log.Printf("[%v] Invalid port number %q; %v\n", time.Now(), p, err)
 		return 2
 	}
+	lc, err := newListenConfig(flags, p)
+	if err != nil {
+		log.Printf("[%v] %v\n", time.Now(), err)
+		return 2
+	}
 
 	// Start the server.
-	log.Printf("[%v] Starting server at port %d\n", time.Now(), port)
+	log.Printf("[%v] Starting server at %s\n", time.Now(), lc.addr)
+	s, err := newServerConfig(ctx, store, flags, chanSize, bulkSize)
+	if err != nil {
+		log.Printf("[%v] %v\n", time.Now(), err)
+		return 2
+	}
+	if s.replica != nil {
+		log.Printf("[%v] Replicating %s\n", time.Now(), s.replica.primary)
+		go s.replicate(s.streams)
+	}
+	return s.serve(lc, s.handler())
+}
+
+// newServerConfig returns the server for the provided store configured via
+// the command flags. Streams end when the context is done.
+func newServerConfig(ctx context.Context, store storage.Store, flags map[string]string, chanSize, bulkSize int) (*serverConfig, error) {
+	m := metrics.New()
+	fs := feed.NewStore(metrics.NewStore(store, m), feed.DefaultRetention)
 	s := &serverConfig{
-		store:    store,
+		store:    fs,
+		feed:     fs,
 		chanSize: chanSize,
 		bulkSize: bulkSize,
+		backend:  store,
+		metrics:  m,
+		queries:  newQueryRegistry(),
 	}
-	http.HandleFunc("/bql", s.bqlHandler)
-	http.HandleFunc("/", defaultHandler)
-	if err := http.ListenAndServe(":"+p, nil); err != nil {
-		log.Printf("[%v] Failed to start server on port %s; %v", time.Now(), p, err)
-		return 2
+	s.streams, s.stopStreams = context.WithCancel(ctx)
+	if err := s.loadAuth(flags); err != nil {
+		return nil, err
+	}
+	var err error
+	if s.admission, err = newAdmission(flags); err != nil {
+		return nil, err
 	}
-	return 0
+	if s.replica, err = newReplica(flags); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-// bqlHandler imPathUnescapeplements the handler to server BQL requests.
-func (s *serverConfig) bqlHandler(w http.ResponseWriter, r *http.Request) {
+// handler returns the handler serving the endpoints of the server.
+func (s *serverConfig) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bql", s.authenticated(s.bqlHandler))
+	mux.HandleFunc("/changes", s.authenticated(s.changesHandler))
+	mux.HandleFunc("/standing", s.authenticated(s.standingHandler))
+	mux.HandleFunc(graphsPath, s.authenticated(s.graphsHandler))
+	mux.HandleFunc(graphsPath+"/", s.authenticated(s.graphsHandler))
+	mux.HandleFunc(queriesPath, s.authenticated(s.queriesHandler))
+	mux.HandleFunc(queriesPath+"/", s.authenticated(s.queriesHandler))
+	mux.HandleFunc(logPath, s.authenticated(s.logHandler))
+	mux.HandleFunc(logPath+"/", s.authenticated(s.logHandler))
+	mux.HandleFunc("/metrics", s.authenticated(s.metricsHandler))
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", s.readyzHandler)
+	mux.HandleFunc("/", defaultHandler)
+	return mux
+}
+
+// apiVersion is the version of the JSON schema used by the /bql endpoint.
+const apiVersion = "1"
+
+// standingTimeout bounds every evaluation of a standing query.
+const standingTimeout = time.Minute
+
+// bqlRequest contains the JSON request body accepted by the /bql endpoint.
+type bqlRequest struct {
+	Query   string `json:"query"`
+	Timeout string `json:"timeout,omitempty"`
+	Stream  string `json:"stream,omitempty"`
+}
+
+// bqlResponse contains the JSON response of the /bql endpoint.
+type bqlResponse struct {
+	Version string       `json:"version"`
+	Results []*result    `json:"results"`
+	Error   *errorObject `json:"error,omitempty"`
+}
+
+// errorObject describes why a request or a statement failed.
+type errorObject struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// jsonTable contains the JSON representation of a table using typed cells
+// as returned by table.CellToJSON.
+type jsonTable struct {
+	Bindings []string                     `json:"bindings"`
+	Rows     []map[string]json.RawMessage `json:"rows"`
+}
+
+// tableToJSON returns the JSON representation of the table.
+func tableToJSON(t *table.Table) (*jsonTable, error) {
+	jt := &jsonTable{
+		Bindings: []string{},
+		Rows:     []map[string]json.RawMessage{},
+	}
+	for _, b := range t.Bindings() {
+		if b != "" {
+			jt.Bindings = append(jt.Bindings, b)
+		}
+	}
+	for _, r := range t.Rows() {
+		jr, err := table.RowToJSON(jt.Bindings, r)
+		if err != nil {
+			return nil, err
+		}
+		jt.Rows = append(jt.Rows, jr)
+	}
+	return jt, nil
+}
+
+// writeJSON writes the provided response with the given HTTP status code.
+func writeJSON(w http.ResponseWriter, status int, res *bqlResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("[%s] Failed to write JSON response; %v", time.Now(), err)
+	}
+}
+
+// writeJSONError writes a response that only contains the provided error.
+func writeJSONError(w http.ResponseWriter, status int, code string, err error) {
+	log.Printf("[%s] %v\n", time.Now(), err)
+	writeJSON(w, status, &bqlResponse{
+		Version: apiVersion,
+		Results: []*result{},
+		Error:   &errorObject{Code: code, Message: err.Error()},
+	})
+}
+
+// parseBQLRequest returns the queries and the options provided on the
+// request. Requests may provide a JSON body or a form encoded bqlQuery.
+func parseBQLRequest(r *http.Request) ([]string, *bqlRequest, error) {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mt == "application/json" {
+		req := &bqlRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON request body; %v", err)
+		}
+		return getQueries([]string{req.Query}), req, nil
+	}
 	if err := r.ParseForm(); err != nil {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		reportError(w, r, err)
-		return
+		return nil, nil, err
 	}
+	return getQueries(r.PostForm["bqlQuery"]), &bqlRequest{Timeout: r.FormValue("timeout"), Stream: r.FormValue("stream")}, nil
+}
+
+// bqlHandler implements the handler to server BQL requests.
+func (s *serverConfig) bqlHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		reportError(w, r, fmt.Errorf("invalid %s request on %q endpoint. Only POST request are accepted", r.Method, r.URL.Path))
-		log.Printf("[%s] Invalid request: %#v\n", time.Now(), r)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Errorf("invalid %s request on %q endpoint. Only POST request are accepted", r.Method, r.URL.Path))
 		return
 	}
+	qs, req, err := parseBQLRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", err)
+		return
+	}
+	var sw *streamWriter
+	if req.Stream != "" {
+		if sw, err = newStreamWriter(w, req.Stream); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request", err)
+			return
+		}
+	}
 
 	// Run the query.
 	var (
 		ctx    context.Context
 		cancel context.CancelFunc
 	)
-	timeout, err := time.ParseDuration(r.FormValue("timeout"))
+	// The context is canceled when the client disconnects, releasing the
+	// admission slot of the running statement.
+	timeout, err := time.ParseDuration(req.Timeout)
 	if err == nil {
 		// The request has a timeout, so create a context that is
 		// canceled automatically when the timeout expires.
-		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		ctx, cancel = context.WithTimeout(r.Context(), timeout)
 	} else {
-		ctx, cancel = context.WithCancel(context.Background())
+		ctx, cancel = context.WithCancel(r.Context())
 	}
 	defer cancel() // Cancel ctx as soon as handleSearch returns.
 
-	var res []*result
-	for _, q := range getQueries(r.PostForm["bqlQuery"]) {
-		if nq, err := url.QueryUnescape(q); err == nil {
-			q = strings.Replace(strings.Replace(nq, "\n", " ", -1), "\r", " ", -1)
+	if sw != nil {
+		s.streamStatements(ctx, sw, r, qs)
+		return
+	}
+	res := &bqlResponse{
+		Version: apiVersion,
+		Results: []*result{},
+	}
+	for _, q := range qs {
+		res.Results = append(res.Results, s.runStatement(ctx, r, q))
+	}
+	writeJSON(w, responseStatus(res.Results), res)
+}
+
+// responseStatus returns the status of a response with the provided
+// results. The outcome of each statement is reported in its result, so the
+// response succeeds unless every statement failed, in which case it carries
+// the status of the first one.
+func responseStatus(rs []*result) int {
+	for _, r := range rs {
+		if r.Error == nil {
+			return http.StatusOK
 		}
-		t, err := BQL(ctx, q, s.store, s.chanSize, s.bulkSize)
-		r := &result{
-			Q: q,
-			T: t,
+	}
+	if len(rs) == 0 {
+		return http.StatusOK
+	}
+	return rs[0].Status
+}
+
+// runStatement runs the provided statement on behalf of the user of the
+// request and returns its result.
+func (s *serverConfig) runStatement(ctx context.Context, req *http.Request, q string) *result {
+	r := &result{
+		Query:  q,
+		Status: http.StatusOK,
+		Msg:    "[OK]",
+	}
+	c := &table.Collector{}
+	pln, err := newPlan(ctx, q, s.store, s.chanSize, s.bulkSize, s.authorizer(req))
+	if err == nil {
+		err = s.execute(ctx, q, userOf(req), pln, c)
+	}
+	if t := c.Table(); err == nil && t != nil {
+		jt, jErr := tableToJSON(t)
+		if jErr != nil {
+			err = &statementError{status: http.StatusInternalServerError, code: "invalid_result", err: jErr}
 		}
+		r.Table = jt
+	}
+	if err != nil {
+		log.Printf("[%s] %q failed; %v", time.Now(), q, err.Error())
+		r.Msg, r.Table = err.Error(), nil
+		r.Status, r.Error = statusOf(err)
+	}
+	return r
+}
+
+// statusOf returns the status code and the error object for the error.
+func statusOf(err error) (int, *errorObject) {
+	eo := &errorObject{Code: "internal", Message: err.Error()}
+	if se, ok := err.(*statementError); ok {
+		eo.Code = se.code
+		return se.status, eo
+	}
+	return http.StatusInternalServerError, eo
+}
+
+// changeEvent contains the JSON representation of a change feed event.
+type changeEvent struct {
+	Seq    uint64 `json:"seq"`
+	Op     string `json:"op"`
+	Graph  string `json:"graph"`
+	Triple string `json:"triple,omitempty"`
+	Time   string `json:"time"`
+}
+
+// newChangeEvent returns the JSON friendly version of the event.
+func newChangeEvent(e *feed.Event) *changeEvent {
+	ce := &changeEvent{
+		Seq:   e.Seq,
+		Op:    e.Op.String(),
+		Graph: e.Graph,
+		Time:  e.Time.Format(time.RFC3339Nano),
+	}
+	if e.Triple != nil {
+		ce.Triple = e.Triple.String()
+	}
+	return ce
+}
+
+// changesHandler streams the store change feed as server-sent events.
+func (s *serverConfig) changesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "internal", fmt.Errorf("streaming is not supported by the connection"))
+		return
+	}
+	var from uint64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		seq, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", fmt.Errorf("invalid Last-Event-ID %q; %v", id, err))
+			return
+		}
+		from = seq + 1
+	}
+	if f := r.FormValue("from"); f != "" {
+		seq, err := strconv.ParseUint(f, 10, 64)
 		if err != nil {
-			log.Printf("[%s] %q failed; %v", time.Now(), q, err.Error())
-			r.Msg = err.Error()
-		} else {
-			r.Msg = "[OK]"
+			writeError(w, http.StatusBadRequest, "invalid_request", fmt.Errorf("invalid from sequence number %q; %v", f, err))
+			return
 		}
-		res = append(res, r)
+		from = seq
+	}
+	ctx, cancel := s.streamContext(w, r)
+	defer cancel()
+	sub, err := s.feed.Subscribe(ctx, from)
+	if err != nil {
+		writeError(w, http.StatusGone, "gone", err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`[`))
-	cnt := len(res)
-	for _, r := range res {
-		w.Write([]byte(`{ "query": "`))
-		w.Write([]byte(strings.Replace(r.Q, `"`, `\"`, -1)))
-		w.Write([]byte(`", "msg": "`))
-		w.Write([]byte(strings.Replace(r.Msg, `"`, `\"`, -1)))
-		w.Write([]byte(`", "table": `))
-		if r.T == nil {
-			w.Write([]byte(`{}`))
-		} else {
-			r.T.ToJSON(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	user := userOf(r)
+	for e := range sub.Events {
+		if !s.acl.Allowed(user, e.Graph, acl.Read) {
+			continue
 		}
-		w.Write([]byte(` }`))
-		if cnt > 1 {
-			w.Write([]byte(`, `))
+		data, err := json.Marshal(newChangeEvent(e))
+		if err != nil {
+			log.Printf("[%s] Failed to marshal event %v; %v", time.Now(), e, err)
+			return
 		}
-		cnt--
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Op, data)
+		flusher.Flush()
 	}
-	w.Write([]byte(`]`))
+	if err := sub.Err(); err != nil {
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+}
 
+// standingChange contains the JSON representation of a standing query
+// change. Rows use the typed cells of the /bql endpoint.
+type standingChange struct {
+	Version string                       `json:"version"`
+	Seq     uint64                       `json:"seq"`
+	Added   []map[string]json.RawMessage `json:"added,omitempty"`
+	Removed []map[string]json.RawMessage `json:"removed,omitempty"`
+}
+
+// rowsToJSON returns the typed JSON representation of the provided rows.
+// Anonymous bindings are omitted.
+func rowsToJSON(rs []table.Row) ([]map[string]json.RawMessage, error) {
+	var res []map[string]json.RawMessage
+	for _, r := range rs {
+		bs := make([]string, 0, len(r))
+		for b := range r {
+			if b != "" {
+				bs = append(bs, b)
+			}
+		}
+		jr, err := table.RowToJSON(bs, r)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, jr)
+	}
+	return res, nil
+}
+
+// standingHandler registers a standing query and streams the changes of its
+// result set as server-sent events.
+func (s *serverConfig) standingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "internal", fmt.Errorf("streaming is not supported by the connection"))
+		return
+	}
+	bql := strings.TrimSpace(r.FormValue("bqlQuery"))
+	if !strings.HasSuffix(bql, ";") {
+		bql += ";"
+	}
+	if _, err := parseStatement(bql, s.authorizer(r)); err != nil {
+		status, eo := statusOf(err)
+		writeError(w, status, eo.Code, err)
+		return
+	}
+	unregister, err := s.admission.register()
+	if err != nil {
+		status, eo := statusOf(err)
+		writeError(w, status, eo.Code, err)
+		return
+	}
+	defer unregister()
+	ctx, cancel := s.streamContext(w, r)
+	defer cancel()
+	rq, ctx := s.queries.add(ctx, bql, userOf(r))
+	defer s.queries.remove(rq)
+
+	// The initial evaluation is admitted like any other statement. Later
+	// evaluations are triggered by mutations, so they wait for a slot as long
+	// as needed instead of failing the query.
+	var (
+		admitted bool
+		admitErr error
+	)
+	limits := standing.Limits{
+		MaxRows: s.admission.maxRows,
+		Timeout: standingTimeout,
+		Admit: func(ctx context.Context) (func(), error) {
+			if admitted {
+				return s.admission.wait(ctx, 0)
+			}
+			admitted = true
+			release, err := s.admission.acquire(ctx)
+			admitErr = err
+			return release, err
+		},
+	}
+	q, err := standing.Register(ctx, s.feed, bql, s.chanSize, s.bulkSize, limits)
+	if se, ok := admitErr.(*statementError); ok {
+		writeError(w, se.status, se.code, se.err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for c := range q.Changes {
+		sc := &standingChange{Version: apiVersion, Seq: c.Seq}
+		var data []byte
+		sc.Added, err = rowsToJSON(c.Added)
+		if err == nil {
+			sc.Removed, err = rowsToJSON(c.Removed)
+		}
+		if err == nil {
+			data, err = json.Marshal(sc)
+		}
+		if err != nil {
+			log.Printf("[%s] Failed to marshal standing query change; %v", time.Now(), err)
+			return
+		}
+		fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", c.Seq, data)
+		flusher.Flush()
+	}
+	if err := q.Err(); err != nil {
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
 }
 
-// result contains a query and its outcome.
+// result contains a statement and its outcome. Status contains the HTTP
+// status code that describes the outcome of the statement.
 type result struct {
-	Q   string       `json:"q,omitempty"`
-	Msg string       `json:"msg,omitempty"`
-	T   *table.Table `json:"table,omitempty"`
+	Query  string       `json:"query"`
+	Status int          `json:"status"`
+	Msg    string       `json:"msg"`
+	Error  *errorObject `json:"error,omitempty"`
+	Table  *jsonTable   `json:"table,omitempty"`
+}
+
+// statementError contains the reason a statement failed, and the status
+// code and error code reported for it.
+type statementError struct {
+	status int
+	code   string
+	err    error
+}
+
+// Error returns the error message.
+func (e *statementError) Error() string {
+	return e.err.Error()
 }
 
 // getQueries retuns the list of queries found. It will split them if needed.
+// Statements are split at the semicolons found by the BQL lexer, so the ones
+// inside literals, predicates, or node IDs are preserved. Past a lexing
+// error the rest of the input is split at every semicolon.
 func getQueries(raw []string) []string {
 	var res []string
+	add := func(q string) {
+		if nq := strings.TrimSpace(q); len(nq) > 0 {
+			res = append(res, nq+";")
+		}
+	}
 
 	for _, q := range raw {
-		for _, qs := range strings.Split(q, ";") {
-			if nq := strings.TrimSpace(qs); len(nq) > 0 {
-				res = append(res, nq+";")
+		start, pos, failed := 0, 0, false
+		for tkn := range lexer.New(q, 0) {
+			if failed || tkn.Type == lexer.ItemEOF {
+				continue
 			}
+			if tkn.Type == lexer.ItemError {
+				failed = true
+				continue
+			}
+			// Tokens are only separated by the characters the lexer skips.
+			pos += strings.Index(q[pos:], tkn.Text) + len(tkn.Text)
+			if tkn.Type == lexer.ItemSemicolon {
+				add(q[start : pos-1])
+				start = pos
+			}
+		}
+		if !failed {
+			add(q[start:])
+			continue
+		}
+		for _, qs := range strings.Split(q[start:], ";") {
+			add(qs)
 		}
 	}
 
 	return res
 }
 
-// BQL attempts to execute the provided query against the given store.
+// BQL attempts to execute the provided query against the given store. The
+// errors returned describe the stage at which the statement failed.
 func BQL(ctx context.Context, bql string, s storage.Store, chanSize, bulkSize int) (*table.Table, error) {
+	pln, err := newPlan(ctx, bql, s, chanSize, bulkSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := pln.Execute(ctx)
+	if err != nil {
+		return nil, executionError(ctx, err)
+	}
+	return res, nil
+}
+
+// parseStatement parses the provided query. If authorize is provided it
+// needs to allow running the statement.
+func parseStatement(bql string, authorize func(*semantic.Statement) error) (*semantic.Statement, error) {
 	p, err := grammar.NewParser(grammar.SemanticBQL())
 	if err != nil {
-		return nil, fmt.Errorf("[ERROR] Failed to initilize a valid BQL parser")
+		return nil, &statementError{http.StatusInternalServerError, "internal", fmt.Errorf("[ERROR] Failed to initilize a valid BQL parser")}
 	}
 	stm := &semantic.Statement{}
 	if err := p.Parse(grammar.NewLLk(bql, 1), stm); err != nil {
-		return nil, fmt.Errorf("[ERROR] Failed to parse BQL statement with error %v", err)
+		return nil, &statementError{http.StatusBadRequest, "parse_error", fmt.Errorf("[ERROR] Failed to parse BQL statement with error %v", err)}
 	}
-	pln, err := planner.New(ctx, s, stm, chanSize, bulkSize, nil)
+	if authorize != nil {
+		if err := authorize(stm); err != nil {
+			if se, ok := err.(*statementError); ok {
+				return nil, se
+			}
+			return nil, &statementError{http.StatusForbidden, "forbidden", fmt.Errorf("[ERROR] Not allowed to run BQL statement; %v", err)}
+		}
+	}
+	return stm, nil
+}
+
+// newPlan parses the provided query and returns its execution plan. The
+// statement is authorized before the plan is created.
+func newPlan(ctx context.Context, bql string, s storage.Store, chanSize, bulkSize int, authorize func(*semantic.Statement) error) (planner.Executor, error) {
+	stm, err := parseStatement(bql, authorize)
 	if err != nil {
-		return nil, fmt.Errorf("[ERROR] Should have not failed to create a plan using memory.DefaultStorage for statement %v with error %v", stm, err)
+		return nil, err
 	}
-	res, err := pln.Execute(ctx)
+	pln, err := planner.New(ctx, s, stm, chanSize, bulkSize, nil)
 	if err != nil {
-		return nil, fmt.Errorf("[ERROR] Failed to execute BQL statement with error %v", err)
+		return nil, &statementError{http.StatusBadRequest, "plan_error", fmt.Errorf("[ERROR] Should have not failed to create a plan using memory.DefaultStorage for statement %v with error %v", stm, err)}
 	}
-	return res, nil
+	return pln, nil
+}
+
+// executionError returns the statement error for a plan that failed to
+// execute.
+func executionError(ctx context.Context, err error) error {
+	err = fmt.Errorf("[ERROR] Failed to execute BQL statement with error %v", err)
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return &statementError{http.StatusGatewayTimeout, "timeout", err}
+	case context.Canceled:
+		return &statementError{statusCanceled, "canceled", err}
+	}
+	return &statementError{http.StatusInternalServerError, "execution_error", err}
 }
 
 // defaultHandler implements the handler to server BQL requests.
 func defaultHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Errorf("unknown resource %q", r.URL.Path))
+		return
+	}
 	if err := defaultEntryTemplate.Execute(w, nil); err != nil {
-		reportError(w, r, err)
+		log.Printf("[%s] %v\n", time.Now(), err)
 	}
 }
 
-// reportError reports the given error.
-func reportError(w http.ResponseWriter, r *http.Request, err error) {
-	w.WriteHeader(http.StatusInternalServerError)
-	log.Printf("[%s] %v\n", time.Now(), err)
-	errorTemplate.Execute(w, err)
-}
-
 // Templates
 
 var (
@@ -237,14 +772,4 @@ var (
 		
 	</body>
 	</html>`))
-
-	errorTemplate = template.Must(template.New("error").Parse(`
-	<html>
-	<head>
-		<title>BadWolf - Endpoint error</title>
-	</head>
-	<body>
-		<b>{{.}}</b>
-	</body>
-	</html>`))
 )
@@ -0,0 +1,995 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/table"
+	bwio "github.com/google/badwolf/io"
+	"github.com/google/badwolf/storage"
+	"github.com/google/badwolf/storage/memory"
+	"github.com/google/badwolf/storage/schema"
+	"github.com/google/badwolf/triple"
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// newTestServer returns a server backed by a volatile store configured via
+// the provided flags.
+func newTestServer(t *testing.T, flags map[string]string) (*serverConfig, *httptest.Server) {
+	return newTestServerWithStore(t, memory.NewStore(), flags)
+}
+
+// newTestServerWithStore returns a server backed by the provided store
+// configured via the provided flags.
+func newTestServerWithStore(t *testing.T, store storage.Store, flags map[string]string) (*serverConfig, *httptest.Server) {
+	s, err := newServerConfig(context.Background(), store, flags, 0, 1000)
+	if err != nil {
+		t.Fatalf("newServerConfig(%v) failed with error %v", flags, err)
+	}
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.stopStreams()
+	})
+	return s, ts
+}
+
+// writeTempFile writes the content into a temporary file and returns its
+// path.
+func writeTempFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("os.WriteFile failed with error %v", err)
+	}
+	return path
+}
+
+// do sends the request and returns the response status and body.
+func do(t *testing.T, req *http.Request) (int, []byte) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed with error %v", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read the response of %s %s; %v", req.Method, req.URL, err)
+	}
+	return resp.StatusCode, b
+}
+
+// newFormRequest returns a form encoded POST request.
+func newFormRequest(t *testing.T, u string, form url.Values, token string) *http.Request {
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("http.NewRequest failed with error %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// postBQL posts the statements to the /bql endpoint and returns the decoded
+// response. The response must be valid JSON.
+func postBQL(t *testing.T, ts *httptest.Server, bql, token string) *bqlResponse {
+	status, b := do(t, newFormRequest(t, ts.URL+"/bql", url.Values{"bqlQuery": {bql}}, token))
+	return decodeBQL(t, status, b)
+}
+
+// decodeBQL decodes a /bql response to a valid request. Its status needs to
+// be 200 unless every statement failed.
+func decodeBQL(t *testing.T, status int, b []byte) *bqlResponse {
+	if !json.Valid(b) {
+		t.Fatalf("/bql returned status %d and invalid JSON:\n%s", status, b)
+	}
+	res := &bqlResponse{}
+	if err := json.Unmarshal(b, res); err != nil {
+		t.Fatalf("json.Unmarshal failed with error %v", err)
+	}
+	if want := responseStatus(res.Results); status != want || res.Error != nil {
+		t.Fatalf("/bql returned status %d; want %d. Body:\n%s", status, want, b)
+	}
+	if res.Version != apiVersion {
+		t.Errorf("/bql returned version %q; want %q", res.Version, apiVersion)
+	}
+	return res
+}
+
+// statuses returns the status and the error code of each result.
+func statuses(res *bqlResponse) []string {
+	var ss []string
+	for _, r := range res.Results {
+		code := ""
+		if r.Error != nil {
+			code = r.Error.Code
+		}
+		ss = append(ss, http.StatusText(r.Status)+"/"+code)
+	}
+	return ss
+}
+
+func TestBQLTypedCellsRoundTrip(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	ctx := context.Background()
+	g, err := s.store.NewGraph(ctx, "?g")
+	if err != nil {
+		t.Fatalf("s.store.NewGraph failed with error %v", err)
+	}
+	text := "line\n\"quoted\"\t\\ and \x01\x1f"
+	n, err := node.Parse("/u<john>")
+	if err != nil {
+		t.Fatalf("node.Parse failed with error %v", err)
+	}
+	p, err := predicate.Parse(`"says"@[]`)
+	if err != nil {
+		t.Fatalf("predicate.Parse failed with error %v", err)
+	}
+	l, err := literal.DefaultBuilder().Build(literal.Text, text)
+	if err != nil {
+		t.Fatalf("literal.Build failed with error %v", err)
+	}
+	trpl, err := triple.New(n, p, triple.NewLiteralObject(l))
+	if err != nil {
+		t.Fatalf("triple.New failed with error %v", err)
+	}
+	if err := g.AddTriples(ctx, []*triple.Triple{trpl}); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+
+	res := postBQL(t, ts, `SELECT ?s, ?p, ?o FROM ?g WHERE {?s ?p ?o};`, "")
+	if len(res.Results) != 1 || res.Results[0].Table == nil {
+		t.Fatalf("/bql returned %+v; want a single table", res.Results)
+	}
+	tbl := res.Results[0].Table
+	if want := []string{"?s", "?p", "?o"}; !reflect.DeepEqual(tbl.Bindings, want) {
+		t.Errorf("/bql returned bindings %v; want %v", tbl.Bindings, want)
+	}
+	if len(tbl.Rows) != 1 {
+		t.Fatalf("/bql returned %d rows; want 1", len(tbl.Rows))
+	}
+	row := tbl.Rows[0]
+	if got, want := string(row["?s"]), `{"node":{"type":"/u","id":"john"}}`; got != want {
+		t.Errorf("/bql returned subject %s; want %s", got, want)
+	}
+	if got, want := string(row["?p"]), `{"predicate":{"id":"says"}}`; got != want {
+		t.Errorf("/bql returned predicate %s; want %s", got, want)
+	}
+	var o struct {
+		Literal struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"literal"`
+	}
+	if err := json.Unmarshal(row["?o"], &o); err != nil {
+		t.Fatalf("json.Unmarshal(%s) failed with error %v", row["?o"], err)
+	}
+	if o.Literal.Type != "text" || o.Literal.Value != text {
+		t.Errorf("/bql returned literal %s; want a text literal with value %q", row["?o"], text)
+	}
+}
+
+func TestBQLStatementStatus(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	res := postBQL(t, ts, `CREATE GRAPH ?a; SELECT bad; SELECT ?s FROM ?a WHERE {?s ?p ?o};`, "")
+	want := []string{"OK/", "Bad Request/parse_error", "OK/"}
+	if got := statuses(res); !reflect.DeepEqual(got, want) {
+		t.Errorf("/bql returned statuses %v; want %v", got, want)
+	}
+	if r := res.Results[1]; r.Table != nil || r.Msg != r.Error.Message || r.Query != "SELECT bad;" {
+		t.Errorf("/bql returned the failed statement as %+v; want its query, no table, and its error message", r)
+	}
+	if r := res.Results[2]; r.Table == nil || len(r.Table.Rows) != 0 || r.Msg != "[OK]" {
+		t.Errorf("/bql returned the empty query as %+v; want an empty table", r)
+	}
+}
+
+func TestBQLAllStatementsFailed(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	for _, entry := range []struct {
+		bql  string
+		want int
+	}{
+		{`SELECT bad;`, http.StatusBadRequest},
+		{`SELECT bad; SELECT ?s FROM ?missing WHERE {?s ?p ?o};`, http.StatusBadRequest},
+		{`SELECT ?s FROM ?missing WHERE {?s ?p ?o}; SELECT bad;`, http.StatusInternalServerError},
+		{`CREATE GRAPH ?g; SELECT bad;`, http.StatusOK},
+	} {
+		status, b := do(t, newFormRequest(t, ts.URL+"/bql", url.Values{"bqlQuery": {entry.bql}}, ""))
+		if status != entry.want {
+			t.Errorf("/bql returned status %d for %q; want %d. Body:\n%s", status, entry.bql, entry.want, b)
+		}
+		decodeBQL(t, status, b)
+	}
+}
+
+func TestBQLFormKeepsStatementsVerbatim(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	text := "first line; still the first statement\nsecond line with %41 and +"
+	res := postBQL(t, ts, "CREATE GRAPH ?g;\nINSERT DATA INTO ?g {\n\t/u<a;b> \"says\"@[] \""+text+"\"^^type:text\n};", "")
+	if got, want := statuses(res), []string{"OK/", "OK/"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("/bql returned statuses %v; want %v", got, want)
+	}
+	res = postBQL(t, ts, `SELECT ?s, ?o FROM ?g WHERE {?s "says"@[] ?o};`, "")
+	if len(res.Results) != 1 || res.Results[0].Table == nil || len(res.Results[0].Table.Rows) != 1 {
+		t.Fatalf("/bql returned %+v; want a single row", res.Results)
+	}
+	row := res.Results[0].Table.Rows[0]
+	if got, want := string(row["?s"]), `{"node":{"type":"/u","id":"a;b"}}`; got != want {
+		t.Errorf("/bql returned subject %s; want %s", got, want)
+	}
+	var o struct {
+		Literal struct {
+			Value string `json:"value"`
+		} `json:"literal"`
+	}
+	if err := json.Unmarshal(row["?o"], &o); err != nil {
+		t.Fatalf("json.Unmarshal(%s) failed with error %v", row["?o"], err)
+	}
+	if o.Literal.Value != text {
+		t.Errorf("/bql stored the literal %q; want %q", o.Literal.Value, text)
+	}
+}
+
+func TestGetQueries(t *testing.T) {
+	for _, entry := range []struct {
+		raw  []string
+		want []string
+	}{
+		{[]string{"SHOW GRAPHS; SHOW GRAPHS"}, []string{"SHOW GRAPHS;", "SHOW GRAPHS;"}},
+		{[]string{" ; ", "SHOW GRAPHS;\n"}, []string{"SHOW GRAPHS;"}},
+		{
+			[]string{"INSERT DATA INTO ?g {/u<a;b> \"p;q\"@[] \"x;\ny\"^^type:text}; DROP GRAPH ?g;"},
+			[]string{"INSERT DATA INTO ?g {/u<a;b> \"p;q\"@[] \"x;\ny\"^^type:text};", "DROP GRAPH ?g;"},
+		},
+		// Past a lexing error the input is split at every semicolon.
+		{[]string{"SHOW GRAPHS; \"bad; SHOW GRAPHS"}, []string{"SHOW GRAPHS;", "\"bad;", "SHOW GRAPHS;"}},
+	} {
+		if got := getQueries(entry.raw); !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("getQueries(%q) returned %q; want %q", entry.raw, got, entry.want)
+		}
+	}
+}
+
+func TestBQLJSONAndFormBodies(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	postBQL(t, ts, `CREATE GRAPH ?g; INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b>};`, "")
+	q := `SELECT ?o FROM ?g WHERE {/u<a> "knows"@[] ?o};`
+	form := postBQL(t, ts, q, "")
+
+	body, err := json.Marshal(&bqlRequest{Query: q})
+	if err != nil {
+		t.Fatalf("json.Marshal failed with error %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/bql", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("http.NewRequest failed with error %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	status, b := do(t, req)
+	js := decodeBQL(t, status, b)
+	if !reflect.DeepEqual(js, form) {
+		t.Errorf("JSON body returned %+v; want the form result %+v", js, form)
+	}
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/bql", strings.NewReader("{"))
+	if err != nil {
+		t.Fatalf("http.NewRequest failed with error %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if status, b := do(t, req); status != http.StatusBadRequest || !strings.Contains(string(b), `"invalid_request"`) {
+		t.Errorf("invalid JSON body returned %d %s; want %d invalid_request", status, b, http.StatusBadRequest)
+	}
+}
+
+func TestBQLMethodNotAllowed(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/bql", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest failed with error %v", err)
+	}
+	status, b := do(t, req)
+	res := &bqlResponse{}
+	if err := json.Unmarshal(b, res); err != nil {
+		t.Fatalf("json.Unmarshal(%s) failed with error %v", b, err)
+	}
+	if status != http.StatusMethodNotAllowed || res.Error == nil || res.Error.Code != "method_not_allowed" {
+		t.Errorf("GET /bql returned %d %s; want %d method_not_allowed", status, b, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestBQLCanceledRequest(t *testing.T) {
+	s, _ := newTestServer(t, map[string]string{"max_queries": "1"})
+	// Take the only slot so the statement waits until its request is
+	// canceled.
+	s.admission.slots <- true
+	defer func() { <-s.admission.slots }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodPost, "/bql", strings.NewReader(url.Values{"bqlQuery": {"CREATE GRAPH ?g;"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.bqlHandler(rec, req.WithContext(ctx))
+	res := decodeBQL(t, rec.Code, rec.Body.Bytes())
+	if len(res.Results) != 1 || res.Results[0].Status != statusCanceled || res.Results[0].Error.Code != "canceled" {
+		t.Errorf("/bql returned %s for a canceled request; want status %d canceled", rec.Body.String(), statusCanceled)
+	}
+}
+
+func TestAuthenticationAndACL(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{
+		"tokens": writeTempFile(t, "alice alice-token\nbob bob-token\n"),
+		"acl":    writeTempFile(t, "alice * admin\nbob ?g read\n"),
+	})
+
+	status, b := do(t, newFormRequest(t, ts.URL+"/bql", url.Values{"bqlQuery": {"CREATE GRAPH ?g;"}}, ""))
+	if status != http.StatusUnauthorized || !strings.Contains(string(b), `"unauthorized"`) {
+		t.Errorf("anonymous request returned %d %s; want %d unauthorized", status, b, http.StatusUnauthorized)
+	}
+	status, _ = do(t, newFormRequest(t, ts.URL+"/bql", url.Values{"bqlQuery": {"CREATE GRAPH ?g;"}}, "wrong"))
+	if status != http.StatusUnauthorized {
+		t.Errorf("request with an invalid token returned %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	res := postBQL(t, ts, `CREATE GRAPH ?g; INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b>};`, "alice-token")
+	if got, want := statuses(res), []string{"OK/", "OK/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alice got statuses %v; want %v", got, want)
+	}
+	res = postBQL(t, ts, `SELECT ?o FROM ?g WHERE {?s ?p ?o}; INSERT DATA INTO ?g {/u<a> "knows"@[] /u<c>};`, "bob-token")
+	if got, want := statuses(res), []string{"OK/", "Forbidden/forbidden"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bob got statuses %v; want %v", got, want)
+	}
+
+	req := newFormRequest(t, ts.URL+graphsPath, url.Values{"name": {"h"}}, "bob-token")
+	if status, b := do(t, req); status != http.StatusForbidden || !strings.Contains(string(b), `"forbidden"`) {
+		t.Errorf("bob creating a graph returned %d %s; want %d forbidden", status, b, http.StatusForbidden)
+	}
+}
+
+func TestGraphResourceStatusCodes(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	create := func() (int, []byte) {
+		return do(t, newFormRequest(t, ts.URL+graphsPath, url.Values{"name": {"g"}}, ""))
+	}
+	if status, b := create(); status != http.StatusCreated {
+		t.Errorf("POST %s returned %d %s; want %d", graphsPath, status, b, http.StatusCreated)
+	}
+	if status, b := create(); status != http.StatusConflict || !strings.Contains(string(b), `"graph_exists"`) {
+		t.Errorf("POST %s for an existing graph returned %d %s; want %d graph_exists", graphsPath, status, b, http.StatusConflict)
+	}
+	for _, entry := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, graphsPath + "/g", http.StatusOK},
+		{http.MethodGet, graphsPath + "/missing", http.StatusNotFound},
+		{http.MethodGet, graphsPath + "/g/unknown", http.StatusNotFound},
+		{http.MethodPut, graphsPath + "/g", http.StatusMethodNotAllowed},
+		{http.MethodDelete, graphsPath + "/g", http.StatusNoContent},
+		{http.MethodGet, graphsPath + "/g", http.StatusNotFound},
+		{http.MethodDelete, queriesPath + "/42", http.StatusNotFound},
+		{http.MethodGet, "/unknown", http.StatusNotFound},
+	} {
+		req, err := http.NewRequest(entry.method, ts.URL+entry.path, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest failed with error %v", err)
+		}
+		if status, b := do(t, req); status != entry.want {
+			t.Errorf("%s %s returned %d %s; want %d", entry.method, entry.path, status, b, entry.want)
+		}
+	}
+}
+
+// failingGraph fails to add any triple.
+type failingGraph struct {
+	storage.Graph
+}
+
+func (g *failingGraph) AddTriples(ctx context.Context, ts []*triple.Triple) error {
+	return errors.New("disk full")
+}
+
+// failingStore returns graphs that fail to add any triple.
+type failingStore struct {
+	storage.Store
+}
+
+func (s *failingStore) Graph(ctx context.Context, id string) (storage.Graph, error) {
+	g, err := s.Store.Graph(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &failingGraph{g}, nil
+}
+
+func TestUploadTriplesStatusCodes(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	for _, gn := range []string{"?schema", "?failing"} {
+		if _, err := store.NewGraph(ctx, gn); err != nil {
+			t.Fatalf("store.NewGraph failed with error %v", err)
+		}
+	}
+	g, err := store.Graph(ctx, "?schema")
+	if err != nil {
+		t.Fatalf("store.Graph failed with error %v", err)
+	}
+	ss := schema.NewStore(&failingStore{store})
+	if err := ss.Register("?schema", &schema.Schema{
+		Predicates: []*schema.Predicate{{ID: "knows", Cardinality: schema.SingleValued}},
+	}); err != nil {
+		t.Fatalf("schema.Store.Register failed with error %v", err)
+	}
+	var ts []*triple.Triple
+	for _, l := range []string{`/u<a> "knows"@[] /u<b>`, `/u<a> "knows"@[] /u<c>`} {
+		trpl, err := triple.Parse(l, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse(%q) failed with error %v", l, err)
+		}
+		ts = append(ts, trpl)
+	}
+	if err := g.AddTriples(ctx, ts[:1]); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	_, srv := newTestServerWithStore(t, ss, nil)
+
+	upload := func(gn, format string, body io.Reader) (int, []byte) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+graphsPath+"/"+gn+"/triples?format="+format, body)
+		if err != nil {
+			t.Fatalf("http.NewRequest failed with error %v", err)
+		}
+		return do(t, req)
+	}
+	for _, format := range []string{"bw", "binary", "jsonl", "ntriples", "turtle"} {
+		var buf bytes.Buffer
+		write, flush, _, err := tripleWriter(&buf, format, "?schema")
+		if err != nil {
+			t.Fatalf("tripleWriter(_, %q, _) failed with error %v", format, err)
+		}
+		if err := write(ts[1]); err != nil {
+			t.Fatalf("writing %s in %q failed with error %v", ts[1], format, err)
+		}
+		if err := flush(); err != nil {
+			t.Fatalf("flushing %q failed with error %v", format, err)
+		}
+		if status, b := upload("schema", format, &buf); status != http.StatusUnprocessableEntity || !strings.Contains(string(b), `"schema_violation"`) {
+			t.Errorf("uploading a %q schema violation returned %d %s; want %d schema_violation", format, status, b, http.StatusUnprocessableEntity)
+		}
+	}
+	if status, b := upload("schema", "bw", strings.NewReader("not a triple\n")); status != http.StatusBadRequest || !strings.Contains(string(b), `"parse_error"`) {
+		t.Errorf("uploading an invalid triple returned %d %s; want %d parse_error", status, b, http.StatusBadRequest)
+	}
+	for _, format := range []string{"bw", "ntriples"} {
+		var buf bytes.Buffer
+		write, flush, _, err := tripleWriter(&buf, format, "?failing")
+		if err != nil {
+			t.Fatalf("tripleWriter(_, %q, _) failed with error %v", format, err)
+		}
+		if err := write(ts[0]); err != nil {
+			t.Fatalf("writing %s in %q failed with error %v", ts[0], format, err)
+		}
+		if err := flush(); err != nil {
+			t.Fatalf("flushing %q failed with error %v", format, err)
+		}
+		if status, b := upload("failing", format, &buf); status != http.StatusInternalServerError || !strings.Contains(string(b), `"internal"`) {
+			t.Errorf("uploading %q triples to a failing graph returned %d %s; want %d internal", format, status, b, http.StatusInternalServerError)
+		}
+	}
+}
+
+func TestDeleteGraphDropsItsSchema(t *testing.T) {
+	ctx := context.Background()
+	store := schema.NewStore(memory.NewStore())
+	if _, err := store.NewGraph(ctx, "?g"); err != nil {
+		t.Fatalf("store.NewGraph failed with error %v", err)
+	}
+	if err := store.Register("?g", &schema.Schema{
+		Predicates: []*schema.Predicate{{ID: "knows", Cardinality: schema.SingleValued}},
+	}); err != nil {
+		t.Fatalf("schema.Store.Register failed with error %v", err)
+	}
+	_, srv := newTestServerWithStore(t, store, nil)
+
+	upload := func() (int, []byte) {
+		body := "/u<a>\t\"knows\"@[]\t/u<b>\n/u<a>\t\"knows\"@[]\t/u<c>\n"
+		req, err := http.NewRequest(http.MethodPost, srv.URL+graphsPath+"/g/triples", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("http.NewRequest failed with error %v", err)
+		}
+		return do(t, req)
+	}
+	if status, b := upload(); status != http.StatusUnprocessableEntity {
+		t.Fatalf("uploading a schema violation returned %d %s; want %d", status, b, http.StatusUnprocessableEntity)
+	}
+	req, err := http.NewRequest(http.MethodDelete, srv.URL+graphsPath+"/g", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest failed with error %v", err)
+	}
+	if status, b := do(t, req); status != http.StatusNoContent {
+		t.Fatalf("DELETE %s/g returned %d %s; want %d", graphsPath, status, b, http.StatusNoContent)
+	}
+	if status, b := do(t, newFormRequest(t, srv.URL+graphsPath, url.Values{"name": {"g"}}, "")); status != http.StatusCreated {
+		t.Fatalf("POST %s returned %d %s; want %d", graphsPath, status, b, http.StatusCreated)
+	}
+	if status, b := upload(); status != http.StatusOK {
+		t.Errorf("uploading to a recreated graph returned %d %s; want %d", status, b, http.StatusOK)
+	}
+}
+
+func TestUploadTriplesLimits(t *testing.T) {
+	s, ts := newTestServer(t, map[string]string{"max_upload": "256", "max_queries": "1", "queue_timeout": "10ms"})
+	if _, err := s.store.NewGraph(context.Background(), "?g"); err != nil {
+		t.Fatalf("s.store.NewGraph failed with error %v", err)
+	}
+	line := `/u<a> "knows"@[] /u<b>` + "\n"
+	gzipped := func(s string) io.Reader {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatalf("gzip.Writer.Write failed with error %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("gzip.Writer.Close failed with error %v", err)
+		}
+		return &buf
+	}
+	upload := func(format string, body io.Reader) (int, []byte) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+graphsPath+"/g/triples?format="+format, body)
+		if err != nil {
+			t.Fatalf("http.NewRequest failed with error %v", err)
+		}
+		return do(t, req)
+	}
+	if status, b := upload("bw", strings.NewReader(line)); status != http.StatusOK {
+		t.Errorf("uploading a small body returned %d %s; want %d", status, b, http.StatusOK)
+	}
+	nt := "<http://example.org/a> <http://example.org/knows> <http://example.org/b> .\n"
+	for _, entry := range []struct {
+		name, format string
+		body         io.Reader
+	}{
+		{"large body", "bw", strings.NewReader(strings.Repeat(line, 20))},
+		{"large decompressed body", "bw", gzipped(strings.Repeat(line, 1000))},
+		{"large doubly compressed body", "bw", gzipped(gzipped(strings.Repeat(line, 100000)).(*bytes.Buffer).String())},
+		{"large decompressed RDF body", "ntriples", gzipped(strings.Repeat(nt, 1000))},
+	} {
+		if status, b := upload(entry.format, entry.body); status != http.StatusRequestEntityTooLarge || !strings.Contains(string(b), `"too_large"`) {
+			t.Errorf("uploading a %s returned %d %s; want %d too_large", entry.name, status, b, http.StatusRequestEntityTooLarge)
+		}
+	}
+
+	// Uploads wait for a free slot as any other statement.
+	s.admission.slots <- true
+	defer func() { <-s.admission.slots }()
+	if status, b := upload("bw", strings.NewReader(line)); status != http.StatusServiceUnavailable || !strings.Contains(string(b), `"overloaded"`) {
+		t.Errorf("uploading without a free slot returned %d %s; want %d overloaded", status, b, http.StatusServiceUnavailable)
+	}
+}
+
+func TestAdmissionRowLimit(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"max_rows": "1"})
+	postBQL(t, ts, `CREATE GRAPH ?g; INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b> . /u<a> "knows"@[] /u<c>};`, "")
+	res := postBQL(t, ts, `SELECT ?o FROM ?g WHERE {/u<a> "knows"@[] ?o};`, "")
+	if got, want := statuses(res), []string{"Unprocessable Entity/row_limit_exceeded"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/bql returned statuses %v; want %v", got, want)
+	}
+}
+
+func TestQueryRegistryListWhileCounting(t *testing.T) {
+	qr, a := newQueryRegistry(), &admission{maxResultBytes: 1 << 30}
+	rq, _ := qr.add(context.Background(), "SELECT ?s FROM ?g WHERE {?s ?p ?o};", "alice")
+	defer qr.remove(rq)
+	const rows = 1000
+	done := make(chan error)
+	go func() {
+		for i := 0; i < rows; i++ {
+			if err := rq.count(a, table.Row{"?s": &table.Cell{S: table.CellString("x")}}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	all := func(*runningQuery) bool { return true }
+	for last, running := int64(0), true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("count failed with error %v", err)
+			}
+			running = false
+		default:
+		}
+		l := qr.list(all)
+		if len(l) != 1 || l[0].Rows < last {
+			t.Fatalf("list returned %+v; want a single query with at least %d rows", l, last)
+		}
+		last = l[0].Rows
+	}
+	if got := qr.list(all)[0]; got.Rows != rows || got.ID != rq.ID || got.User != "alice" {
+		t.Errorf("list returned %+v; want query %s of alice with %d rows", got, rq.ID, rows)
+	}
+}
+
+func TestStreamTrailers(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	postBQL(t, ts, `CREATE GRAPH ?g; INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b>};`, "")
+	form := url.Values{
+		"bqlQuery": {`SELECT ?o FROM ?g WHERE {/u<a> "knows"@[] ?o}; SELECT bad;`},
+		"stream":   {streamNDJSON},
+	}
+	resp, err := http.DefaultClient.Do(newFormRequest(t, ts.URL+"/bql", form, ""))
+	if err != nil {
+		t.Fatalf("POST /bql failed with error %v", err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.Header.Get("Content-Type"), "application/x-ndjson"; got != want {
+		t.Errorf("streamed /bql returned content type %q; want %q", got, want)
+	}
+	var got []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		rec := &struct {
+			Type   string       `json:"type"`
+			Status int          `json:"status"`
+			Error  *errorObject `json:"error"`
+			Rows   int          `json:"rows"`
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			t.Fatalf("streamed /bql returned invalid record %s; %v", scanner.Bytes(), err)
+		}
+		switch rec.Type {
+		case "trailer":
+			code := ""
+			if rec.Error != nil {
+				code = rec.Error.Code
+			}
+			got = append(got, http.StatusText(rec.Status)+"/"+code+"/"+strconv.Itoa(rec.Rows))
+		default:
+			got = append(got, rec.Type)
+		}
+	}
+	want := []string{"header", "row", "OK//1", "header", "Bad Request/parse_error/0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("streamed /bql returned records %v; want %v", got, want)
+	}
+}
+
+// readEvents reads n server-sent events and returns their IDs and names.
+func readEvents(t *testing.T, scanner *bufio.Scanner, n int) []string {
+	var res []string
+	id, name := "", ""
+	for len(res) < n && scanner.Scan() {
+		l := scanner.Text()
+		switch {
+		case strings.HasPrefix(l, "id: "):
+			id = strings.TrimPrefix(l, "id: ")
+		case strings.HasPrefix(l, "event: "):
+			name = strings.TrimPrefix(l, "event: ")
+		case l == "" && name != "":
+			res = append(res, id+"/"+name)
+			id, name = "", ""
+		}
+	}
+	if len(res) < n {
+		t.Fatalf("the stream ended after events %v with error %v; want %d events", res, scanner.Err(), n)
+	}
+	return res
+}
+
+func TestChangesStream(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	postBQL(t, ts, `CREATE GRAPH ?g; INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b>};`, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	changes := func(query string, header http.Header) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/changes"+query, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest failed with error %v", err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatalf("GET /changes%s failed with error %v", query, err)
+		}
+		return resp
+	}
+
+	resp := changes("?from=1", nil)
+	defer resp.Body.Close()
+	if got, want := resp.Header.Get("Content-Type"), "text/event-stream"; got != want {
+		t.Errorf("/changes returned content type %q; want %q", got, want)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	if got, want := readEvents(t, scanner, 2), []string{"1/create", "2/add"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/changes?from=1 returned events %v; want %v", got, want)
+	}
+	// Mutations are streamed as they happen.
+	postBQL(t, ts, `DELETE DATA FROM ?g {/u<a> "knows"@[] /u<b>};`, "")
+	if got, want := readEvents(t, scanner, 1), []string{"3/remove"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/changes returned events %v after deleting a triple; want %v", got, want)
+	}
+
+	resumed := changes("", http.Header{"Last-Event-ID": {"1"}})
+	defer resumed.Body.Close()
+	if got, want := readEvents(t, bufio.NewScanner(resumed.Body), 2), []string{"2/add", "3/remove"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/changes with Last-Event-ID 1 returned events %v; want %v", got, want)
+	}
+
+	for _, entry := range []struct {
+		query  string
+		header http.Header
+		want   int
+	}{
+		{"?from=100", nil, http.StatusGone},
+		{"?from=x", nil, http.StatusBadRequest},
+		{"", http.Header{"Last-Event-ID": {"x"}}, http.StatusBadRequest},
+	} {
+		resp := changes(entry.query, entry.header)
+		resp.Body.Close()
+		if resp.StatusCode != entry.want {
+			t.Errorf("GET /changes%s with headers %v returned %d; want %d", entry.query, entry.header, resp.StatusCode, entry.want)
+		}
+	}
+}
+
+func TestStandingAdmission(t *testing.T) {
+	s, ts := newTestServer(t, map[string]string{"max_standing": "1", "max_queries": "1", "queue_timeout": "10ms"})
+	postBQL(t, ts, `CREATE GRAPH ?g; INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b>};`, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	bql := `SELECT ?o FROM ?g WHERE {/u<a> "knows"@[] ?o};`
+	standing := func() *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/standing?"+url.Values{"bqlQuery": {bql}}.Encode(), nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest failed with error %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatalf("GET /standing failed with error %v", err)
+		}
+		return resp
+	}
+	overloaded := func(resp *http.Response, reason string) {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(b), `"overloaded"`) {
+			t.Errorf("GET /standing %s returned %d %s; want %d overloaded", reason, resp.StatusCode, b, http.StatusServiceUnavailable)
+		}
+	}
+
+	// The initial evaluation waits for a free slot as any other statement.
+	s.admission.slots <- true
+	overloaded(standing(), "without a free slot")
+	<-s.admission.slots
+
+	resp := standing()
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	if got, want := readEvents(t, scanner, 1), []string{"2/change"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/standing returned events %v; want %v", got, want)
+	}
+	if qs := s.queries.list(func(*runningQuery) bool { return true }); len(qs) != 1 || qs[0].Query != bql {
+		t.Errorf("the query registry listed %v; want the standing query %q", qs, bql)
+	}
+	overloaded(standing(), "beyond --max_standing")
+
+	// Later evaluations wait for a slot longer than --queue_timeout instead
+	// of failing the query.
+	g, err := s.store.Graph(ctx, "?g")
+	if err != nil {
+		t.Fatalf("s.store.Graph failed with error %v", err)
+	}
+	trpl, err := triple.Parse(`/u<a>	"knows"@[]	/u<c>`, literal.DefaultBuilder())
+	if err != nil {
+		t.Fatalf("triple.Parse failed with error %v", err)
+	}
+	s.admission.slots <- true
+	if err := g.AddTriples(ctx, []*triple.Triple{trpl}); err != nil {
+		t.Fatalf("g.AddTriples failed with error %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	<-s.admission.slots
+	if got, want := readEvents(t, scanner, 1), []string{"3/change"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("/standing returned events %v after inserting a triple; want %v", got, want)
+	}
+}
+
+func TestReplicaRejectsWrites(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	if _, err := s.store.NewGraph(context.Background(), "?g"); err != nil {
+		t.Fatalf("s.store.NewGraph failed with error %v", err)
+	}
+	// The replica is not started, so it never becomes ready.
+	s.replica = &replica{primary: "http://127.0.0.1:1", client: &http.Client{}}
+
+	res := postBQL(t, ts, `SELECT ?o FROM ?g WHERE {?s ?p ?o}; INSERT DATA INTO ?g {/u<a> "knows"@[] /u<b>}; DROP GRAPH ?g;`, "")
+	if got, want := statuses(res), []string{"OK/", "Forbidden/read_only", "Forbidden/read_only"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replica returned statuses %v; want %v", got, want)
+	}
+	req := newFormRequest(t, ts.URL+graphsPath, url.Values{"name": {"h"}}, "")
+	if status, b := do(t, req); status != http.StatusForbidden || !strings.Contains(string(b), `"read_only"`) {
+		t.Errorf("replica creating a graph returned %d %s; want %d read_only", status, b, http.StatusForbidden)
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/readyz", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest failed with error %v", err)
+	}
+	if status, _ := do(t, req); status != http.StatusServiceUnavailable {
+		t.Errorf("/readyz returned %d for a replica without snapshot; want %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+// dumpStore returns the JSON representation of the triples of every graph of
+// the store, sorted.
+func dumpStore(t *testing.T, s storage.Store) map[string][]string {
+	ctx := context.Background()
+	names, errc := make(chan string), make(chan error, 1)
+	go func() {
+		errc <- s.GraphNames(ctx, names)
+	}()
+	var gns []string
+	for n := range names {
+		gns = append(gns, n)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("GraphNames failed with error %v", err)
+	}
+	res := make(map[string][]string)
+	for _, gn := range gns {
+		g, err := s.Graph(ctx, gn)
+		if err != nil {
+			t.Fatalf("Graph(%q) failed with error %v", gn, err)
+		}
+		ts, errc := make(chan *triple.Triple), make(chan error, 1)
+		go func() {
+			errc <- g.Triples(ctx, storage.DefaultLookup, ts)
+		}()
+		res[gn] = []string{}
+		for trpl := range ts {
+			b, err := bwio.TripleToJSON(trpl)
+			if err != nil {
+				t.Fatalf("bwio.TripleToJSON(%s) failed with error %v", trpl, err)
+			}
+			res[gn] = append(res[gn], string(b))
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("Triples of %q failed with error %v", gn, err)
+		}
+		sort.Strings(res[gn])
+	}
+	return res
+}
+
+func TestReplicaConverges(t *testing.T) {
+	ctx := context.Background()
+	primary, ts := newTestServer(t, nil)
+	b := literal.DefaultBuilder()
+	mustLiteral := func(lt literal.Type, v interface{}) *triple.Object {
+		l, err := b.Build(lt, v)
+		if err != nil {
+			t.Fatalf("literal.Builder.Build(%v, %v) failed with error %v", lt, v, err)
+		}
+		return triple.NewLiteralObject(l)
+	}
+	s, err := node.Parse("/u<a>")
+	if err != nil {
+		t.Fatalf("node.Parse failed with error %v", err)
+	}
+	anchored, err := predicate.NewTemporal("met", time.Date(2016, 4, 10, 4, 21, 0, 123456789, time.UTC))
+	if err != nil {
+		t.Fatalf("predicate.NewTemporal failed with error %v", err)
+	}
+	var trpls []*triple.Triple
+	for _, o := range []*triple.Object{
+		mustLiteral(literal.Text, "a \"quoted\"\nmulti-line text"),
+		// The text representation of the triple cannot tell where this
+		// literal ends.
+		mustLiteral(literal.Text, `ends with "^^type:text`),
+		mustLiteral(literal.Blob, []byte{0, 1, 2, 255}),
+		mustLiteral(literal.Float64, 0.1),
+		mustLiteral(literal.Int64, int64(-42)),
+		triple.NewNodeObject(s),
+	} {
+		for _, p := range []string{"says", "met"} {
+			pr, err := predicate.NewImmutable(p)
+			if err != nil {
+				t.Fatalf("predicate.NewImmutable failed with error %v", err)
+			}
+			if p == "met" {
+				pr = anchored
+			}
+			trpl, err := triple.New(s, pr, o)
+			if err != nil {
+				t.Fatalf("triple.New failed with error %v", err)
+			}
+			trpls = append(trpls, trpl)
+		}
+	}
+	graph := func(store storage.Store, id string) storage.Graph {
+		g, err := store.NewGraph(ctx, id)
+		if err != nil {
+			t.Fatalf("NewGraph(%q) failed with error %v", id, err)
+		}
+		return g
+	}
+
+	// Mutations before the replica starts reach it through the snapshot.
+	g := graph(primary.store, "?g")
+	if err := g.AddTriples(ctx, trpls[:6]); err != nil {
+		t.Fatalf("AddTriples failed with error %v", err)
+	}
+	graph(primary.store, "?dropped")
+
+	store := memory.NewStore()
+	graph(store, "?stale")
+	replica, err := newServerConfig(ctx, store, map[string]string{"primary": ts.URL}, 0, 1000)
+	if err != nil {
+		t.Fatalf("newServerConfig failed with error %v", err)
+	}
+	defer replica.stopStreams()
+	go replica.replicate(replica.streams)
+
+	// Mutations after the snapshot reach it through the log.
+	for !replica.replica.isReady() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := g.AddTriples(ctx, trpls[6:]); err != nil {
+		t.Fatalf("AddTriples failed with error %v", err)
+	}
+	if err := g.RemoveTriples(ctx, trpls[:1]); err != nil {
+		t.Fatalf("RemoveTriples failed with error %v", err)
+	}
+	if err := primary.store.DeleteGraph(ctx, "?dropped"); err != nil {
+		t.Fatalf("DeleteGraph failed with error %v", err)
+	}
+	if err := graph(primary.store, "?new").AddTriples(ctx, trpls[1:2]); err != nil {
+		t.Fatalf("AddTriples failed with error %v", err)
+	}
+
+	want := dumpStore(t, primary.backend)
+	var got map[string][]string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if got = dumpStore(t, store); reflect.DeepEqual(got, want) {
+			return
+		}
+	}
+	t.Errorf("the replica did not converge with the primary; got %v, want %v", got, want)
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/google/badwolf/bql/table"
+)
+
+// Supported streaming modes.
+const (
+	streamNDJSON = "ndjson"
+	streamSSE    = "sse"
+)
+
+// streamHeader is the first record sent for each streamed statement.
+type streamHeader struct {
+	Type     string   `json:"type"`
+	Version  string   `json:"version"`
+	Query    string   `json:"query"`
+	Bindings []string `json:"bindings"`
+}
+
+// streamRow contains one of the rows of a streamed statement.
+type streamRow struct {
+	Type string                     `json:"type"`
+	Row  map[string]json.RawMessage `json:"row"`
+}
+
+// streamTrailer is the last record sent for each streamed statement. It
+// contains the outcome of the statement and the number of rows sent.
+type streamTrailer struct {
+	Type   string       `json:"type"`
+	Query  string       `json:"query"`
+	Status int          `json:"status"`
+	Msg    string       `json:"msg"`
+	Error  *errorObject `json:"error,omitempty"`
+	Rows   int          `json:"rows"`
+}
+
+// streamWriter writes the records of streamed statements either as newline
+// delimited JSON or as server-sent events, flushing after each record.
+type streamWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	mode    string
+}
+
+// newStreamWriter returns a writer for the provided streaming mode.
+func newStreamWriter(w http.ResponseWriter, mode string) (*streamWriter, error) {
+	if mode != streamNDJSON && mode != streamSSE {
+		return nil, fmt.Errorf("unknown stream mode %q; valid modes are %q and %q", mode, streamNDJSON, streamSSE)
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming is not supported by the connection")
+	}
+	return &streamWriter{w: w, flusher: flusher, mode: mode}, nil
+}
+
+// start writes the response headers.
+func (sw *streamWriter) start() {
+	if sw.mode == streamSSE {
+		sw.w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		sw.w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	sw.w.Header().Set("Cache-Control", "no-cache")
+	sw.w.WriteHeader(http.StatusOK)
+	sw.flusher.Flush()
+}
+
+// write sends the record of the provided type and flushes it.
+func (sw *streamWriter) write(typ string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if sw.mode == streamSSE {
+		_, err = fmt.Fprintf(sw.w, "event: %s\ndata: %s\n\n", typ, data)
+	} else {
+		_, err = fmt.Fprintf(sw.w, "%s\n", data)
+	}
+	if err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+// streamStatements runs the provided statements in order and streams their
+// results.
+func (s *serverConfig) streamStatements(ctx context.Context, sw *streamWriter, r *http.Request, qs []string) {
+	sw.start()
+	for _, q := range qs {
+		if err := s.streamStatement(ctx, sw, r, q); err != nil {
+			log.Printf("[%s] Failed to stream the result of %q; %v", time.Now(), q, err)
+			return
+		}
+	}
+}
+
+// statementStream writes the result of a streamed statement as records. It
+// implements table.Writer.
+type statementStream struct {
+	sw      *streamWriter
+	hdr     *streamHeader
+	hdrSent bool
+	tr      *streamTrailer
+	user    string
+}
+
+// WriteHeader writes the header record with the provided bindings.
+func (ss *statementStream) WriteHeader(bs []string) error {
+	if bs == nil {
+		bs = []string{}
+	}
+	ss.hdr.Bindings = bs
+	ss.hdrSent = true
+	return ss.sw.write(ss.hdr.Type, ss.hdr)
+}
+
+// WriteRow writes the row record and counts it in the trailer.
+func (ss *statementStream) WriteRow(r table.Row) error {
+	jr, err := table.RowToJSON(ss.hdr.Bindings, r)
+	if err != nil {
+		return &statementError{http.StatusInternalServerError, "invalid_result", err}
+	}
+	if err := ss.sw.write("row", &streamRow{Type: "row", Row: jr}); err != nil {
+		return err
+	}
+	ss.tr.Rows++
+	return nil
+}
+
+// Flush does nothing since records are flushed as they are written. The
+// trailer is written by streamStatement once the outcome is known.
+func (ss *statementStream) Flush() error {
+	return nil
+}
+
+// streamStatement runs the statement and writes its header, its rows as the
+// executor produces them, and its trailer. It only returns an error if the
+// records could not be written.
+func (s *serverConfig) streamStatement(ctx context.Context, sw *streamWriter, r *http.Request, q string) error {
+	ss := &statementStream{
+		sw:   sw,
+		user: userOf(r),
+		hdr: &streamHeader{
+			Type:    "header",
+			Version: apiVersion,
+			Query:   q,
+		},
+		tr: &streamTrailer{
+			Type:   "trailer",
+			Query:  q,
+			Status: http.StatusOK,
+			Msg:    "[OK]",
+		},
+	}
+	pln, err := newPlan(ctx, q, s.store, s.chanSize, s.bulkSize, s.authorizer(r))
+	if err == nil {
+		err = s.execute(ctx, q, ss.user, pln, ss)
+	}
+	if err != nil {
+		if _, ok := err.(*statementError); !ok {
+			return err
+		}
+		log.Printf("[%s] %q failed; %v", time.Now(), q, err.Error())
+		if !ss.hdrSent {
+			if err := ss.WriteHeader(nil); err != nil {
+				return err
+			}
+		}
+		ss.tr.Msg = err.Error()
+		ss.tr.Status, ss.tr.Error = statusOf(err)
+	}
+	return sw.write(ss.tr.Type, ss.tr)
+}
@@ -0,0 +1,481 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triple
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/badwolf/triple/literal"
+	"github.com/google/badwolf/triple/node"
+	"github.com/google/badwolf/triple/predicate"
+)
+
+// The binary encoding of a triple is laid out as follows, where uvarint and
+// varint are the encodings provided by encoding/binary and strings are
+// encoded as their uvarint length followed by their bytes:
+//
+//   subject:   uvarint type dictionary ID, string node ID
+//   predicate: uvarint predicate ID dictionary ID, byte predicate type, and
+//              for temporal predicates varint Unix seconds, uvarint
+//              nanoseconds, and varint zone offset in seconds
+//   object:    byte object kind followed by a node, a predicate, or a byte
+//              literal type and its value: a byte for bools, a varint for
+//              int64, 8 little endian bytes for float64, and a string for
+//              text and blobs
+//
+// Node types and predicate IDs are replaced by their ID in a Dictionary.
+
+const (
+	binaryNode byte = iota
+	binaryPredicate
+	binaryLiteral
+)
+
+// binaryMagic is the header of binary triple streams.
+var binaryMagic = []byte("BWT\x01")
+
+// maxBinaryLen is the maximum length accepted for encoded strings.
+const maxBinaryLen = 1 << 30
+
+// Dictionary assigns compact IDs to node types and predicate IDs. IDs are
+// assigned sequentially starting at 0. It is safe for concurrent use. Storage
+// drivers using binary records need to persist the dictionary entries, in
+// order, along the records.
+type Dictionary struct {
+	mu     sync.RWMutex
+	ids    map[string]uint64
+	values []string
+}
+
+// NewDictionary returns a new empty dictionary.
+func NewDictionary() *Dictionary {
+	return &Dictionary{ids: make(map[string]uint64)}
+}
+
+// ID returns the ID for the provided value, adding it to the dictionary if
+// needed.
+func (d *Dictionary) ID(v string) uint64 {
+	d.mu.RLock()
+	id, ok := d.ids[v]
+	d.mu.RUnlock()
+	if ok {
+		return id
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id, ok := d.ids[v]; ok {
+		return id
+	}
+	id = uint64(len(d.values))
+	d.ids[v] = id
+	d.values = append(d.values, v)
+	return id
+}
+
+// Value returns the value for the provided ID.
+func (d *Dictionary) Value(id uint64) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if id >= uint64(len(d.values)) {
+		return "", false
+	}
+	return d.values[id], true
+}
+
+// Len returns the number of entries in the dictionary.
+func (d *Dictionary) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.values)
+}
+
+// AppendBinary appends the binary encoding of the triple to the provided
+// buffer and returns the extended buffer. New node types and predicate IDs
+// are added to the dictionary.
+func AppendBinary(buf []byte, t *Triple, d *Dictionary) ([]byte, error) {
+	buf = appendNode(buf, t.s, d)
+	buf = appendPredicate(buf, t.p, d)
+	switch {
+	case t.o.n != nil:
+		buf = append(buf, binaryNode)
+		buf = appendNode(buf, t.o.n, d)
+	case t.o.p != nil:
+		buf = append(buf, binaryPredicate)
+		buf = appendPredicate(buf, t.o.p, d)
+	case t.o.l != nil:
+		buf = append(buf, binaryLiteral, byte(t.o.l.Type()))
+		switch v := t.o.l.Interface().(type) {
+		case bool:
+			b := byte(0)
+			if v {
+				b = 1
+			}
+			buf = append(buf, b)
+		case int64:
+			buf = binary.AppendVarint(buf, v)
+		case float64:
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+		case string:
+			buf = appendString(buf, v)
+		case []byte:
+			buf = appendString(buf, string(v))
+		default:
+			return nil, fmt.Errorf("triple.AppendBinary: unsupported literal type %v", t.o.l.Type())
+		}
+	default:
+		return nil, fmt.Errorf("triple.AppendBinary: invalid object in triple %s", t)
+	}
+	return buf, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendNode(buf []byte, n *node.Node, d *Dictionary) []byte {
+	buf = binary.AppendUvarint(buf, d.ID(n.Type().String()))
+	return appendString(buf, n.ID().String())
+}
+
+func appendPredicate(buf []byte, p *predicate.Predicate, d *Dictionary) []byte {
+	buf = binary.AppendUvarint(buf, d.ID(string(p.ID())))
+	ta, err := p.TimeAnchor()
+	if err != nil {
+		return append(buf, byte(predicate.Immutable))
+	}
+	buf = append(buf, byte(predicate.Temporal))
+	_, offset := ta.Zone()
+	buf = binary.AppendVarint(buf, ta.Unix())
+	buf = binary.AppendUvarint(buf, uint64(ta.Nanosecond()))
+	return binary.AppendVarint(buf, int64(offset))
+}
+
+// ParseBinary decodes a triple encoded by AppendBinary using the same
+// dictionary. It returns the number of bytes consumed.
+func ParseBinary(data []byte, d *Dictionary, b literal.Builder) (*Triple, int, error) {
+	r := bytes.NewReader(data)
+	t, err := readBinary(r, d, b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("triple.ParseBinary: %v", noEOF(err))
+	}
+	return t, len(data) - r.Len(), nil
+}
+
+// binaryReader is the reader used to decode binary triples.
+type binaryReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func readString(r binaryReader) (string, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if l > maxBinaryLen {
+		return "", fmt.Errorf("invalid string length %d", l)
+	}
+	// The length is not trusted. The buffer grows as the bytes are read, so
+	// truncated or corrupted inputs cannot allocate more than they contain.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(l)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func readDictionary(r binaryReader, d *Dictionary) (string, error) {
+	id, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	v, ok := d.Value(id)
+	if !ok {
+		return "", fmt.Errorf("unknown dictionary ID %d", id)
+	}
+	return v, nil
+}
+
+func readNode(r binaryReader, d *Dictionary) (*node.Node, error) {
+	t, err := readDictionary(r, d)
+	if err != nil {
+		return nil, err
+	}
+	id, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	return node.NewNodeFromStrings(t, id)
+}
+
+func readPredicate(r binaryReader, d *Dictionary) (*predicate.Predicate, error) {
+	id, err := readDictionary(r, d)
+	if err != nil {
+		return nil, err
+	}
+	pt, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch predicate.Type(pt) {
+	case predicate.Immutable:
+		return predicate.NewImmutable(id)
+	case predicate.Temporal:
+		sec, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		nsec, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		loc := time.UTC
+		if offset != 0 {
+			loc = time.FixedZone("", int(offset))
+		}
+		return predicate.NewTemporal(id, time.Unix(sec, int64(nsec)).In(loc))
+	default:
+		return nil, fmt.Errorf("unknown predicate type %d", pt)
+	}
+}
+
+func readBinary(r binaryReader, d *Dictionary, b literal.Builder) (*Triple, error) {
+	s, err := readNode(r, d)
+	if err != nil {
+		return nil, err
+	}
+	p, err := readPredicate(r, d)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	k, err := r.ReadByte()
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	var o *Object
+	switch k {
+	case binaryNode:
+		n, err := readNode(r, d)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		o = NewNodeObject(n)
+	case binaryPredicate:
+		op, err := readPredicate(r, d)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		o = NewPredicateObject(op)
+	case binaryLiteral:
+		l, err := readLiteral(r, b)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		o = NewLiteralObject(l)
+	default:
+		return nil, fmt.Errorf("unknown object kind %d", k)
+	}
+	return New(s, p, o)
+}
+
+func readLiteral(r binaryReader, b literal.Builder) (*literal.Literal, error) {
+	lt, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	t := literal.Type(lt)
+	var v interface{}
+	switch t {
+	case literal.Bool:
+		bt, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		v = bt != 0
+	case literal.Int64:
+		if v, err = binary.ReadVarint(r); err != nil {
+			return nil, err
+		}
+	case literal.Float64:
+		var bs [8]byte
+		if _, err := io.ReadFull(r, bs[:]); err != nil {
+			return nil, err
+		}
+		v = math.Float64frombits(binary.LittleEndian.Uint64(bs[:]))
+	case literal.Text:
+		if v, err = readString(r); err != nil {
+			return nil, err
+		}
+	case literal.Blob:
+		s, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		v = []byte(s)
+	default:
+		return nil, fmt.Errorf("unknown literal type %d", lt)
+	}
+	return b.Build(t, v)
+}
+
+// noEOF converts EOF errors found in the middle of a triple into unexpected
+// EOF errors.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Binary stream records.
+const (
+	binaryDefinition byte = iota + 1
+	binaryTriple
+)
+
+// Encoder writes triples as a self contained binary stream. The stream
+// starts with a header followed by records. Dictionary entries are written
+// as definition records right before the first triple using them.
+type Encoder struct {
+	w       *bufio.Writer
+	d       *Dictionary
+	defined int
+	buf     []byte
+	started bool
+}
+
+// NewEncoder returns a new encoder writing to the provided writer. Flush
+// needs to be called once all triples have been encoded.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w), d: NewDictionary()}
+}
+
+// Encode writes the provided triple to the stream.
+func (e *Encoder) Encode(t *Triple) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	buf, err := AppendBinary(e.buf[:0], t, e.d)
+	if err != nil {
+		return err
+	}
+	e.buf = buf
+	for ; e.defined < e.d.Len(); e.defined++ {
+		v, _ := e.d.Value(uint64(e.defined))
+		if err := e.w.WriteByte(binaryDefinition); err != nil {
+			return err
+		}
+		if _, err := e.w.Write(appendString(nil, v)); err != nil {
+			return err
+		}
+	}
+	if err := e.w.WriteByte(binaryTriple); err != nil {
+		return err
+	}
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// start writes the stream header if needed.
+func (e *Encoder) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	_, err := e.w.Write(binaryMagic)
+	return err
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (e *Encoder) Flush() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// Decoder reads triples from a binary stream written by an Encoder.
+type Decoder struct {
+	r       *bufio.Reader
+	d       *Dictionary
+	b       literal.Builder
+	started bool
+	cnt     int
+}
+
+// NewDecoder returns a new decoder reading from the provided reader and
+// building literals with the provided builder.
+func NewDecoder(r io.Reader, b literal.Builder) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), d: NewDictionary(), b: b}
+}
+
+// IsBinary returns true if the provided data starts with the header of a
+// binary triple stream.
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, binaryMagic)
+}
+
+// Decode returns the next triple on the stream. It returns io.EOF once the
+// end of the stream is reached.
+func (d *Decoder) Decode() (*Triple, error) {
+	if !d.started {
+		d.started = true
+		h := make([]byte, len(binaryMagic))
+		if n, err := io.ReadFull(d.r, h); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("triple.Decoder: invalid header %q; %v", h[:n], err)
+		}
+		if !IsBinary(h) {
+			return nil, fmt.Errorf("triple.Decoder: invalid header %q", h)
+		}
+	}
+	for {
+		rt, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch rt {
+		case binaryDefinition:
+			v, err := readString(d.r)
+			if err != nil {
+				return nil, fmt.Errorf("triple.Decoder: invalid dictionary entry; %v", noEOF(err))
+			}
+			d.d.ID(v)
+		case binaryTriple:
+			d.cnt++
+			t, err := readBinary(d.r, d.d, d.b)
+			if err != nil {
+				return nil, fmt.Errorf("triple.Decoder: record %d: %v", d.cnt, noEOF(err))
+			}
+			return t, nil
+		default:
+			return nil, fmt.Errorf("triple.Decoder: unknown record type %d", rt)
+		}
+	}
+}
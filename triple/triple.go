@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/google/badwolf/triple/literal"
@@ -251,6 +252,76 @@ func (t *Triple) Reify() ([]*Triple, *node.Node, error) {
 	return []*Triple{t, ts, tp, to}, b, nil
 }
 
+// Unreify reconstructs the triples reified by Reify. The _subject, _predicate,
+// and _object triples are grouped by their subject, and a triple is rebuilt
+// for each subject that has all three of them. Any other triple is ignored.
+// It returns the reconstructed triples and the nodes identifying the reified
+// statements, both sorted by node.
+func Unreify(ts []*Triple) ([]*Triple, []*node.Node, error) {
+	type parts struct {
+		n *node.Node
+		s *node.Node
+		p *predicate.Predicate
+		o *Object
+	}
+	stmts, keys := make(map[string]*parts), []string{}
+	for _, t := range ts {
+		id := string(t.p.ID())
+		if id != "_subject" && id != "_predicate" && id != "_object" {
+			continue
+		}
+		k := t.s.String()
+		ps, ok := stmts[k]
+		if !ok {
+			ps = &parts{n: t.s}
+			stmts[k] = ps
+			keys = append(keys, k)
+		}
+		switch id {
+		case "_subject":
+			n, err := t.o.Node()
+			if err != nil {
+				return nil, nil, fmt.Errorf("triple.Unreify: invalid _subject in %s; %v", t, err)
+			}
+			if ps.s != nil && ps.s.String() != n.String() {
+				return nil, nil, fmt.Errorf("triple.Unreify: statement %s has conflicting subjects %s and %s", k, ps.s, n)
+			}
+			ps.s = n
+		case "_predicate":
+			p, err := t.o.Predicate()
+			if err != nil {
+				return nil, nil, fmt.Errorf("triple.Unreify: invalid _predicate in %s; %v", t, err)
+			}
+			if ps.p != nil && ps.p.String() != p.String() {
+				return nil, nil, fmt.Errorf("triple.Unreify: statement %s has conflicting predicates %s and %s", k, ps.p, p)
+			}
+			ps.p = p
+		case "_object":
+			if ps.o != nil && ps.o.String() != t.o.String() {
+				return nil, nil, fmt.Errorf("triple.Unreify: statement %s has conflicting objects %s and %s", k, ps.o, t.o)
+			}
+			ps.o = t.o
+		}
+	}
+	sort.Strings(keys)
+	var (
+		res []*Triple
+		ns  []*node.Node
+	)
+	for _, k := range keys {
+		ps := stmts[k]
+		if ps.s == nil || ps.p == nil || ps.o == nil {
+			continue
+		}
+		t, err := New(ps.s, ps.p, ps.o)
+		if err != nil {
+			return nil, nil, fmt.Errorf("triple.Unreify: %v", err)
+		}
+		res, ns = append(res, t), append(ns, ps.n)
+	}
+	return res, ns, nil
+}
+
 // UUID returns a global unique identifier for the given triple. It is
 // implemented as the SHA1 UUID of the concatenated UUIDs of the subject,
 // predicate, and object.
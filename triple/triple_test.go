@@ -15,6 +15,11 @@
 package triple
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"runtime"
 	"testing"
 
 	"github.com/google/badwolf/triple/literal"
@@ -120,6 +125,62 @@ func TestReifyTemporal(t *testing.T) {
 	}
 }
 
+func TestUnreify(t *testing.T) {
+	var (
+		ts   []*Triple
+		want = map[string]bool{}
+	)
+	for _, s := range []string{
+		"/some/type<some id>\t\"foo\"@[]\t\"bar\"@[]",
+		"/some/type<some id>\t\"foo\"@[2015-01-01T00:00:00-09:00]\t/some/type<other id>",
+		"/some/type<some id>\t\"foo\"@[]\t\"bar\"^^type:text",
+	} {
+		tr, err := Parse(s, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple with error %v", err)
+		}
+		rts, _, err := tr.Reify()
+		if err != nil {
+			t.Fatalf("triple.Reify failed to reify %v with error %v", tr, err)
+		}
+		// Drop the _object triple of the last one to make it incomplete.
+		if len(want) == 2 {
+			rts = rts[:3]
+		} else {
+			want[tr.String()] = true
+		}
+		ts = append(ts, rts[1:]...)
+	}
+	uts, ns, err := Unreify(ts)
+	if err != nil {
+		t.Fatalf("triple.Unreify failed with error %v", err)
+	}
+	if len(uts) != 2 || len(ns) != 2 {
+		t.Fatalf("triple.Unreify returned %d triples and %d nodes; want 2 and 2", len(uts), len(ns))
+	}
+	for i, ut := range uts {
+		if !want[ut.String()] {
+			t.Errorf("triple.Unreify returned unexpected triple %s", ut)
+		}
+		if ns[i].Type().String() != "/_" {
+			t.Errorf("triple.Unreify returned invalid statement node %s", ns[i])
+		}
+	}
+
+	// Conflicting reifications should fail.
+	o, err := ParseObject("/some/type<third id>", literal.DefaultBuilder())
+	if err != nil {
+		t.Fatalf("triple.ParseObject failed with error %v", err)
+	}
+	ct, err := New(ts[0].Subject(), ts[0].Predicate(), o)
+	if err != nil {
+		t.Fatalf("triple.New failed with error %v", err)
+	}
+	if _, _, err := Unreify(append(ts, ct)); err == nil {
+		t.Errorf("triple.Unreify should have failed for conflicting subjects")
+	}
+}
+
 func TestUUID(t *testing.T) {
 	testTable := []struct {
 		t1 string
@@ -181,3 +242,141 @@ func TestUUID(t *testing.T) {
 		}
 	}
 }
+
+func getBinaryTestTriples(t *testing.T) []*Triple {
+	var ts []*Triple
+	for _, s := range []string{
+		"/u<john>\t\"knows\"@[]\t/u<mary>",
+		"/u<john doe>\t\"met\"@[2016-04-10T04:21:00.000000123+02:00]\t/u<peter>",
+		"/u<john>\t\"met\"@[1901-04-10T04:21:00Z]\t/u<peter>",
+		"/u<john>\t\"name\"@[]\t\"John \"J\" Doe\"^^type:text",
+		"/u<john>\t\"name\"@[]\t\"\"^^type:text",
+		"/u<john>\t\"age\"@[]\t\"-42\"^^type:int64",
+		"/u<john>\t\"alive\"@[]\t\"true\"^^type:bool",
+		"/u<john>\t\"data\"@[]\t\"[0 1 255]\"^^type:blob",
+		"/u<john>\t\"refers\"@[]\t\"met\"@[2016-04-10T04:21:00Z]",
+		"/_<b0>\t\"knows\"@[]\t/u<john>",
+	} {
+		trpl, err := Parse(s, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.Parse failed to parse valid triple %s with error %v", s, err)
+		}
+		ts = append(ts, trpl)
+	}
+	for _, f := range []float64{0.1 + 0.2, math.NaN(), math.Inf(-1)} {
+		l, err := literal.DefaultBuilder().Build(literal.Float64, f)
+		if err != nil {
+			t.Fatalf("literal.Build failed with error %v", err)
+		}
+		trpl, err := New(ts[0].Subject(), ts[0].Predicate(), NewLiteralObject(l))
+		if err != nil {
+			t.Fatalf("triple.New failed with error %v", err)
+		}
+		ts = append(ts, trpl)
+	}
+	return ts
+}
+
+func TestBinaryRecords(t *testing.T) {
+	ts, d := getBinaryTestTriples(t), NewDictionary()
+	var buf []byte
+	for _, trpl := range ts {
+		var err error
+		if buf, err = AppendBinary(buf, trpl, d); err != nil {
+			t.Fatalf("triple.AppendBinary(%s) failed with error %v", trpl, err)
+		}
+	}
+	if got, want := d.Len(), 9; got != want {
+		t.Errorf("triple.AppendBinary created the wrong number of dictionary entries; got %d, want %d", got, want)
+	}
+	for _, want := range ts {
+		got, n, err := ParseBinary(buf, d, literal.DefaultBuilder())
+		if err != nil {
+			t.Fatalf("triple.ParseBinary failed with error %v", err)
+		}
+		if got.String() != want.String() || got.UUID().String() != want.UUID().String() {
+			t.Errorf("triple.ParseBinary returned %s; want %s", got, want)
+		}
+		buf = buf[n:]
+	}
+	if len(buf) != 0 {
+		t.Errorf("triple.ParseBinary left %d bytes unread", len(buf))
+	}
+	rec, err := AppendBinary(nil, ts[1], d)
+	if err != nil {
+		t.Fatalf("triple.AppendBinary failed with error %v", err)
+	}
+	for i := 0; i < len(rec); i++ {
+		if _, _, err := ParseBinary(rec[:i], d, literal.DefaultBuilder()); err == nil {
+			t.Errorf("triple.ParseBinary should have failed on truncated record %v", rec[:i])
+		}
+	}
+	if _, _, err := ParseBinary(rec, NewDictionary(), literal.DefaultBuilder()); err == nil {
+		t.Errorf("triple.ParseBinary should have failed with an empty dictionary")
+	}
+}
+
+func TestBinaryStream(t *testing.T) {
+	ts := getBinaryTestTriples(t)
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, trpl := range ts {
+		if err := e.Encode(trpl); err != nil {
+			t.Fatalf("triple.Encoder.Encode(%s) failed with error %v", trpl, err)
+		}
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatalf("triple.Encoder.Flush failed with error %v", err)
+	}
+	if !IsBinary(buf.Bytes()) {
+		t.Errorf("triple.IsBinary should have recognized the encoded stream")
+	}
+	data := buf.Bytes()
+	d := NewDecoder(bytes.NewReader(data), literal.DefaultBuilder())
+	for _, want := range ts {
+		got, err := d.Decode()
+		if err != nil {
+			t.Fatalf("triple.Decoder.Decode failed with error %v", err)
+		}
+		if got.String() != want.String() || got.UUID().String() != want.UUID().String() {
+			t.Errorf("triple.Decoder.Decode returned %s; want %s", got, want)
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("triple.Decoder.Decode should have returned io.EOF at the end of the stream; got %v", err)
+	}
+	d = NewDecoder(bytes.NewReader(data[:len(data)-1]), literal.DefaultBuilder())
+	for i := 0; i < len(ts)-1; i++ {
+		if _, err := d.Decode(); err != nil {
+			t.Fatalf("triple.Decoder.Decode failed with error %v", err)
+		}
+	}
+	if _, err := d.Decode(); err == nil || err == io.EOF {
+		t.Errorf("triple.Decoder.Decode should have failed on a truncated stream; got %v", err)
+	}
+	if _, err := NewDecoder(bytes.NewBufferString("/u<john>"), literal.DefaultBuilder()).Decode(); err == nil {
+		t.Errorf("triple.Decoder.Decode should have rejected a text stream")
+	}
+}
+
+func TestBinaryHugeDeclaredLength(t *testing.T) {
+	// A dictionary entry declaring the maximum length followed by a few bytes.
+	data := append([]byte{}, binaryMagic...)
+	data = append(data, binaryDefinition)
+	data = binary.AppendUvarint(data, maxBinaryLen)
+	data = append(data, "/u"...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := NewDecoder(bytes.NewReader(data), literal.DefaultBuilder()).Decode()
+	runtime.ReadMemStats(&after)
+	if err == nil || err == io.EOF {
+		t.Errorf("triple.Decoder.Decode should have failed on a truncated string; got %v", err)
+	}
+	if got := after.TotalAlloc - before.TotalAlloc; got > 1<<20 {
+		t.Errorf("triple.Decoder.Decode allocated %d bytes for a %d bytes stream", got, len(data))
+	}
+	if _, err := readString(bytes.NewReader(binary.AppendUvarint(nil, maxBinaryLen+1))); err == nil {
+		t.Errorf("readString should have rejected a length over %d", maxBinaryLen)
+	}
+}